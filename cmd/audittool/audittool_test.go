@@ -0,0 +1,62 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/JorgeLNJunior/cacher/pkg/audit"
+)
+
+// newTestLog returns the content of a log with n entries and the hash of every entry.
+func newTestLog(t *testing.T, n int) ([]byte, []string) {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "audit.log")
+	log, err := audit.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hashes := make([]string, 0, n)
+	for i := range n {
+		if err := log.Record(audit.Entry{Time: time.Unix(1_700_000_000, 0), Addr: "127.0.0.1:50000", Operation: "SET", Key: strings.Repeat("k", i+1)}); err != nil {
+			t.Fatal(err)
+		}
+		hashes = append(hashes, log.Head())
+	}
+	log.Close()
+
+	payload, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return payload, hashes
+}
+
+func TestVerifyLog(t *testing.T) {
+	t.Run("should print the entries and the head of an intact log", func(tt *testing.T) {
+		payload, hashes := newTestLog(tt, 3)
+
+		out := &bytes.Buffer{}
+		if err := verifyLog(bytes.NewReader(payload), out, hashes[1]); err != nil {
+			tt.Fatal(err)
+		}
+		if expected := "the log is intact: 3 entries, head " + hashes[2] + "\n"; out.String() != expected {
+			tt.Errorf("expected '%s' but got '%s'", expected, out)
+		}
+	})
+
+	t.Run("should return an error if the last entries have been removed", func(tt *testing.T) {
+		payload, hashes := newTestLog(tt, 3)
+		lines := strings.SplitAfter(string(payload), "\n")
+
+		err := verifyLog(strings.NewReader(lines[0]+lines[1]), &bytes.Buffer{}, hashes[2])
+		if !errors.Is(err, ErrMissingHead) {
+			tt.Errorf("expected ErrMissingHead but got %v", err)
+		}
+	})
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/JorgeLNJunior/cacher/pkg/audit"
+)
+
+const usage = `audittool checks the audit logs written by cacher servers.
+
+Usage:
+  audittool verify [flags] FILE
+
+Run audittool COMMAND -h to see the flags of a command.
+`
+
+var ErrMissingHead = errors.New("the log has no entry with the expected hash, entries have been removed")
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	var err error
+	switch command, args := os.Args[1], os.Args[2:]; command {
+	case "verify":
+		err = runVerify(args)
+	default:
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func runVerify(args []string) error {
+	flags := flag.NewFlagSet("verify", flag.ExitOnError)
+	head := flags.String("head", "", "hash of an entry the log must have, e.g. the head printed by a previous verification. Detects the removal of the last entries")
+	flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return fmt.Errorf("expected 1 argument but got %d", flags.NArg())
+	}
+
+	file, err := os.Open(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	return verifyLog(file, os.Stdout, *head)
+}
+
+// verifyLog checks the chain of an audit log and prints how many entries it has and
+// the hash of the last one. The log must have an entry with the hash head if it's
+// not empty.
+func verifyLog(r io.Reader, out io.Writer, head string) error {
+	found := head == ""
+	entries, last, err := audit.Verify(r, func(e audit.Entry) {
+		found = found || e.Hash == head
+	})
+	if err != nil {
+		return err
+	}
+	if !found {
+		return ErrMissingHead
+	}
+
+	_, err = fmt.Fprintf(out, "the log is intact: %d entries, head %s\n", entries, last)
+	return err
+}
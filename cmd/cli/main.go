@@ -1,11 +1,21 @@
 package main
 
 import (
+	"context"
+	"crypto/tls"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"net"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"text/tabwriter"
 	"time"
 
+	"github.com/JorgeLNJunior/cacher/pkg/client"
 	"github.com/JorgeLNJunior/cacher/pkg/data"
 )
 
@@ -15,28 +25,88 @@ func main() {
 	var value string
 	var expiry int64
 	var url string
+	var file string
+	var sentinels string
+	var useTLS bool
+	var tlsCA, tlsCert, tlsKey string
+	var user, password string
+	var name string
+	var monitor bool
 
-	flag.StringVar(&operation, "operation", data.OperationGet.String(), "the operation to be done. GET, SET, DEL or EXP")
+	flag.StringVar(&operation, "operation", data.OperationGet.String(), "the operation to be done. GET, SET, DEL, EXP, BACKUP, RESTORE, ROLE, INFO, SLOWLOG, whose key is GET, LEN or RESET and value the number of entries to GET, CLIENT, whose key is LIST, KILL or SETNAME and value the id or the address to KILL or the name to SETNAME, or AUTH, whose key is the user and value the password")
 	flag.StringVar(&key, "key", "", "the key to send in the request")
 	flag.StringVar(&value, "value", "", "the value to send in the request")
 	flag.Int64Var(&expiry, "expiry", 0, "when to expire the key in unix time")
 	flag.StringVar(&url, "url", ":8595", "the server's url in host:port format")
+	flag.StringVar(&file, "file", "", "the file a BACKUP is written to or a RESTORE is read from when the key is -")
+	flag.StringVar(&sentinels, "sentinel", "", "addresses of sentinels, separated by commas, asked for the primary instead of using -url")
+	flag.BoolVar(&useTLS, "tls", false, "connect to the server over TLS. Implied by the other -tls flags")
+	flag.StringVar(&tlsCA, "tls-ca", "", "PEM encoded CA that verifies the certificate of the server. Defaults to the CAs of the system")
+	flag.StringVar(&tlsCert, "tls-cert", "", "PEM encoded client certificate, sent to servers that require mutual TLS")
+	flag.StringVar(&tlsKey, "tls-key", "", "PEM encoded private key of the client certificate")
+	flag.StringVar(&user, "user", os.Getenv("CACHER_USER"), "user the request is made as, on servers with a users file")
+	flag.StringVar(&password, "password", os.Getenv("CACHER_PASSWORD"), "password of the user")
+	flag.StringVar(&name, "name", "", "name of the connection, shown by CLIENT LIST")
+	flag.BoolVar(&monitor, "monitor", false, "print the requests made to the server until interrupted, only the ones whose key matches the glob pattern in -key if set")
 	flag.Parse()
 
-	conn, err := net.Dial("tcp", url)
+	if sentinels != "" {
+		primary, err := client.DiscoverPrimary(strings.Split(sentinels, ","), client.DefaultTimeout)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		url = primary
+	}
+
+	var credentials *data.Credentials
+	if user != "" {
+		credentials = &data.Credentials{User: user, Password: password}
+	}
+
+	var tlsConfig *tls.Config
+	if useTLS || tlsCA != "" || tlsCert != "" || tlsKey != "" {
+		config, err := client.LoadTLSConfig(tlsCA, tlsCert, tlsKey)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		tlsConfig = config
+	}
+
+	if monitor {
+		pattern := key
+		if pattern == "" {
+			pattern = "*"
+		}
+
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+
+		c := &client.Client{Addr: url, Timeout: client.DefaultTimeout, TLSConfig: tlsConfig, Credentials: credentials, Name: name}
+		err := c.Monitor(ctx, pattern, func(entry data.MonitorEntry) {
+			printMonitorEntry(os.Stdout, entry)
+		})
+		if err != nil && !errors.Is(err, context.Canceled) {
+			fmt.Println(err)
+		}
+		return
+	}
+
+	conn, err := client.Dial(context.Background(), url, client.DefaultTimeout, tlsConfig)
 	if err != nil {
 		fmt.Println(err)
 		return
 	}
 
 	switch {
-	case operation == data.OperationGet.String() || operation == data.OperationDel.String():
+	case operation == data.OperationGet.String() || operation == data.OperationDel.String() || operation == data.OperationRole.String():
 		req := data.Request{
 			Operation: data.Operation(operation),
 			Key:       key,
 		}
 
-		if err := writeRequest(conn, req); err != nil {
+		if err := writeRequest(conn, req, credentials, name); err != nil {
 			fmt.Println(err)
 			return
 		}
@@ -48,14 +118,34 @@ func main() {
 		}
 
 		fmt.Println(res)
-	case operation == data.OperationSet.String():
+	case operation == data.OperationInfo.String():
+		if err := writeRequest(conn, data.Request{Operation: data.OperationInfo}, credentials, name); err != nil {
+			fmt.Println(err)
+			return
+		}
+
+		res, err := readResponse(conn)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+
+		if res.Status != data.ResponseStatusOK {
+			fmt.Println(res)
+			return
+		}
+		// a field per line, the response has dozens of them
+		for field := range strings.FieldsSeq(res.Message) {
+			fmt.Println(field)
+		}
+	case operation == data.OperationSet.String() || operation == data.OperationAuth.String():
 		req := data.Request{
-			Operation: data.OperationSet,
+			Operation: data.Operation(operation),
 			Key:       key,
 			Value:     value,
 		}
 
-		if err := writeRequest(conn, req); err != nil {
+		if err := writeRequest(conn, req, credentials, name); err != nil {
 			fmt.Println(err)
 			return
 		}
@@ -67,6 +157,62 @@ func main() {
 		}
 
 		fmt.Println(res)
+	case operation == data.OperationSlowLog.String():
+		req := data.Request{
+			Operation: data.OperationSlowLog,
+			Key:       strings.ToUpper(key),
+			Value:     value,
+		}
+
+		if err := writeRequest(conn, req, credentials, name); err != nil {
+			fmt.Println(err)
+			return
+		}
+
+		res, err := readResponse(conn)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+
+		if req.Key != data.SlowLogGet || res.Status != data.ResponseStatusOK {
+			fmt.Println(res)
+			return
+		}
+		entries, err := data.ParseSlowLog(res.Message)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		printSlowLog(os.Stdout, entries)
+	case operation == data.OperationClient.String():
+		req := data.Request{
+			Operation: data.OperationClient,
+			Key:       strings.ToUpper(key),
+			Value:     value,
+		}
+
+		if err := writeRequest(conn, req, credentials, name); err != nil {
+			fmt.Println(err)
+			return
+		}
+
+		res, err := readResponse(conn)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+
+		if req.Key != data.ClientList || res.Status != data.ResponseStatusOK {
+			fmt.Println(res)
+			return
+		}
+		clients, err := data.ParseClientList(res.Message)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		printClientList(os.Stdout, clients)
 	case operation == data.OperationExp.String():
 		exp := time.Unix(expiry, 0)
 		req := data.Request{
@@ -75,7 +221,7 @@ func main() {
 			Expiry:    exp,
 		}
 
-		if err := writeRequest(conn, req); err != nil {
+		if err := writeRequest(conn, req, credentials, name); err != nil {
 			fmt.Println(err)
 			return
 		}
@@ -87,10 +233,107 @@ func main() {
 		}
 
 		fmt.Println(res)
+	case operation == data.OperationBackup.String():
+		req := data.Request{
+			Operation: data.OperationBackup,
+			Key:       key,
+		}
+
+		if err := writeRequest(conn, req, credentials, name); err != nil {
+			fmt.Println(err)
+			return
+		}
+
+		res, err := readResponse(conn)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+
+		if key != data.StreamKey || res.Status != data.ResponseStatusOK {
+			fmt.Println(res)
+			return
+		}
+		if err := os.WriteFile(file, []byte(res.Message), 0o600); err != nil {
+			fmt.Println(err)
+			return
+		}
+		fmt.Println(data.ResponseStatusOK, "the backup has been written to", file)
+	case operation == data.OperationRestore.String():
+		req := data.Request{
+			Operation: data.OperationRestore,
+			Key:       key,
+		}
+
+		if key == data.StreamKey {
+			snapshot, err := os.ReadFile(file)
+			if err != nil {
+				fmt.Println(err)
+				return
+			}
+			req.Value = string(snapshot)
+		}
+
+		if err := writeRequest(conn, req, credentials, name); err != nil {
+			fmt.Println(err)
+			return
+		}
+		// the server reads a snapshot until the connection is closed for writing
+		if err := client.CloseWrite(conn); err != nil {
+			fmt.Println(err)
+			return
+		}
+
+		res, err := readResponse(conn)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+
+		fmt.Println(res)
+	}
+}
+
+// printMonitorEntry prints a request streamed by MONITOR. The key and the value are
+// quoted and a truncated value is followed by its size.
+func printMonitorEntry(w io.Writer, entry data.MonitorEntry) {
+	line := fmt.Sprintf("%s %s %s %q", entry.Time.Local().Format("2006-01-02 15:04:05.000000"), entry.Addr, entry.Operation, entry.Key)
+	if entry.Size > 0 {
+		line += fmt.Sprintf(" %q", entry.Value)
 	}
+	if entry.Truncated() {
+		line += fmt.Sprintf("... (%d bytes)", entry.Size)
+	}
+	fmt.Fprintln(w, line)
 }
 
-func writeRequest(conn net.Conn, req data.Request) error {
+// printSlowLog prints the entries of a slow log as a table.
+func printSlowLog(w io.Writer, entries []data.SlowLogEntry) {
+	table := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(table, "ID\tTIME\tDURATION\tCLIENT\tOPERATION\tKEY")
+	for _, entry := range entries {
+		fmt.Fprintf(table, "%d\t%s\t%s\t%s\t%s\t%q\n",
+			entry.ID, entry.Time.Local().Format(time.DateTime), entry.Duration, entry.Addr, entry.Operation, entry.Key)
+	}
+	table.Flush()
+}
+
+// printClientList prints the connections open on a server as a table.
+func printClientList(w io.Writer, clients []data.ClientInfo) {
+	table := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(table, "ID\tADDR\tNAME\tAGE\tIDLE\tCOMMAND\tIN\tOUT")
+	for _, c := range clients {
+		fmt.Fprintf(table, "%d\t%s\t%s\t%s\t%s\t%s\t%d\t%d\n",
+			c.ID, c.Addr, c.Name, c.Age, c.Idle, c.LastCommand, c.BytesIn, c.BytesOut)
+	}
+	table.Flush()
+}
+
+func writeRequest(conn net.Conn, req data.Request, credentials *data.Credentials, name string) error {
+	if req.Operation != data.OperationAuth {
+		req.Credentials = credentials
+	}
+	req.ClientName = name
 	reqData, err := req.Marshal()
 	if err != nil {
 		return err
@@ -105,8 +348,9 @@ func writeRequest(conn net.Conn, req data.Request) error {
 
 func readResponse(conn net.Conn) (*data.Response, error) {
 	res := data.Response{}
-	payload := make([]byte, 1024)
-	if _, err := conn.Read(payload); err != nil {
+	// the server closes the connection after writing the response
+	payload, err := io.ReadAll(conn)
+	if err != nil {
 		return nil, err
 	}
 
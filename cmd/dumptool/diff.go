@@ -0,0 +1,110 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"slices"
+
+	"github.com/JorgeLNJunior/cacher/pkg/dumpfile"
+)
+
+// ChangeKind describes how a key differs between two dumps.
+type ChangeKind string
+
+const (
+	ChangeAdded   ChangeKind = "+" // only in the second dump
+	ChangeRemoved ChangeKind = "-" // only in the first dump
+	ChangeValue   ChangeKind = "~" // the value is different
+	ChangeExpiry  ChangeKind = "@" // only the expiry is different
+)
+
+// Change is a key that differs between two dumps.
+type Change struct {
+	Kind ChangeKind
+	Key  string
+	Old  dumpfile.Item
+	New  dumpfile.Item
+}
+
+// diffDumps returns the keys that differ between two dumps, sorted by key.
+func diffDumps(before, after map[string]dumpfile.Item) []Change {
+	changes := make([]Change, 0)
+
+	keys := slices.Collect(maps.Keys(before))
+	for key := range after {
+		if _, found := before[key]; !found {
+			keys = append(keys, key)
+		}
+	}
+	slices.Sort(keys)
+
+	for _, key := range keys {
+		oldItem, inOld := before[key]
+		newItem, inNew := after[key]
+
+		switch {
+		case !inOld:
+			changes = append(changes, Change{Kind: ChangeAdded, Key: key, New: newItem})
+		case !inNew:
+			changes = append(changes, Change{Kind: ChangeRemoved, Key: key, Old: oldItem})
+		case oldItem.Value != newItem.Value:
+			changes = append(changes, Change{Kind: ChangeValue, Key: key, Old: oldItem, New: newItem})
+		case !oldItem.Expiry.Equal(newItem.Expiry):
+			changes = append(changes, Change{Kind: ChangeExpiry, Key: key, Old: oldItem, New: newItem})
+		}
+	}
+
+	return changes
+}
+
+// printChanges writes one line per change followed by a summary. Values are not
+// printed because they may be big or binary.
+func printChanges(w io.Writer, changes []Change) error {
+	counts := make(map[ChangeKind]int)
+
+	for _, c := range changes {
+		counts[c.Kind]++
+
+		var err error
+		switch c.Kind {
+		case ChangeValue:
+			_, err = fmt.Fprintf(w, "%s %q %d B -> %d B\n", c.Kind, c.Key, len(c.Old.Value), len(c.New.Value))
+		case ChangeExpiry:
+			_, err = fmt.Fprintf(w, "%s %q %s -> %s\n", c.Kind, c.Key, c.Old.Expiry, c.New.Expiry)
+		default:
+			_, err = fmt.Fprintf(w, "%s %q\n", c.Kind, c.Key)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err := fmt.Fprintf(w, "%d added, %d removed, %d changed values, %d changed expiries\n",
+		counts[ChangeAdded], counts[ChangeRemoved], counts[ChangeValue], counts[ChangeExpiry])
+	return err
+}
+
+func runDiff(args []string) error {
+	flags := flag.NewFlagSet("diff", flag.ExitOnError)
+	dumpFlags := dumpFlags{}
+	dumpFlags.register(flags)
+
+	args, err := parseArgs(flags, args, 2)
+	if err != nil {
+		return err
+	}
+
+	before, err := dumpFlags.readDumpFile(args[0])
+	if err != nil {
+		return err
+	}
+	after, err := dumpFlags.readDumpFile(args[1])
+	if err != nil {
+		return err
+	}
+
+	return printChanges(os.Stdout, diffDumps(before, after))
+}
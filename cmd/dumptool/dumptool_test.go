@@ -0,0 +1,117 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/JorgeLNJunior/cacher/pkg/dumpfile"
+)
+
+var testEpoch = time.Unix(1_700_000_000, 0)
+
+func newTestDump() map[string]dumpfile.Item {
+	return map[string]dumpfile.Item{
+		"user:1":     {Value: "alice", Expiry: testEpoch.Add(time.Hour * 2)},
+		"user:2":     {Value: strings.Repeat("b", 2000), Expiry: testEpoch.Add(time.Second * 30)},
+		"session:1":  {Value: "", Expiry: testEpoch.Add(-time.Second)},
+		"binary\xff": {Value: "\x00\xfe,\"\n", Expiry: testEpoch.Add(time.Hour * 24 * 400)},
+		"no expiry":  {Value: "foo"},
+	}
+}
+
+func TestStats(t *testing.T) {
+	t.Run("should summarize the keys of a dump", func(tt *testing.T) {
+		stats := computeStats(newTestDump(), testEpoch, 2)
+
+		if stats.Keys != 5 {
+			tt.Fatalf("expected 5 keys but got %d", stats.Keys)
+		}
+		if stats.ValueBytes != 2013 {
+			tt.Fatalf("expected 2013 value bytes but got %d", stats.ValueBytes)
+		}
+		if stats.Expired != 1 || stats.NoExpiry != 1 {
+			tt.Fatalf("expected 1 expired key and 1 key without expiry but got %d and %d", stats.Expired, stats.NoExpiry)
+		}
+
+		expected := []int{1, 0, 1, 0, 0, 0, 1}
+		if fmt.Sprint(stats.TTLHist) != fmt.Sprint(expected) {
+			tt.Fatalf("expected the ttl histogram %v but got %v", expected, stats.TTLHist)
+		}
+		if stats.SizeHist[0] != 1 || stats.SizeHist[11] != 1 {
+			tt.Fatalf("expected an empty and a 2000 bytes value in the size histogram but got %v", stats.SizeHist)
+		}
+
+		if len(stats.Largest) != 2 || stats.Largest[0].Key != "user:2" {
+			tt.Fatalf("expected 'user:2' to be the largest of 2 keys but got %v", stats.Largest)
+		}
+	})
+}
+
+func TestExportAndImport(t *testing.T) {
+	for _, format := range []ExportFormat{ExportFormatJSONL, ExportFormatCSV} {
+		t.Run(fmt.Sprintf("should round trip a %s export", format), func(tt *testing.T) {
+			dump := newTestDump()
+
+			out := &bytes.Buffer{}
+			if err := exportDump(out, dump, format); err != nil {
+				tt.Fatal(err)
+			}
+			imported, err := importDump(out, format)
+			if err != nil {
+				tt.Fatal(err)
+			}
+
+			if changes := diffDumps(dump, imported); len(changes) != 0 {
+				tt.Fatalf("expected no changes but got %v", changes)
+			}
+		})
+	}
+
+	t.Run("should report the line of an invalid record", func(tt *testing.T) {
+		input := `{"key":"foo","value":"bar"}` + "\n" + `{"key":"baz","value":"%","encoding":"base64"}` + "\n"
+
+		_, err := importDump(strings.NewReader(input), ExportFormatJSONL)
+		if err == nil || !strings.HasPrefix(err.Error(), "line 2:") {
+			tt.Fatalf("expected an error on line 2 but got '%v'", err)
+		}
+	})
+}
+
+func TestFilterKeys(t *testing.T) {
+	t.Run("should only keep the keys matching the pattern", func(tt *testing.T) {
+		dump := filterKeys(newTestDump(), "user:*")
+
+		if len(dump) != 2 {
+			tt.Fatalf("expected 2 keys but got %d", len(dump))
+		}
+		if _, found := dump["session:1"]; found {
+			tt.Fatal("a key that doesn't match the pattern has been kept")
+		}
+	})
+}
+
+func TestDiff(t *testing.T) {
+	t.Run("should report added, removed and changed keys", func(tt *testing.T) {
+		before := newTestDump()
+		after := newTestDump()
+		delete(after, "user:1")
+		after["user:3"] = dumpfile.Item{Value: "carol"}
+		after["no expiry"] = dumpfile.Item{Value: "bar"}
+		after["session:1"] = dumpfile.Item{Expiry: testEpoch}
+
+		changes := diffDumps(before, after)
+
+		expected := []string{`~ "no expiry"`, `@ "session:1"`, `- "user:1"`, `+ "user:3"`}
+		if len(changes) != len(expected) {
+			tt.Fatalf("expected %d changes but got %v", len(expected), changes)
+		}
+		for i, c := range changes {
+			if got := fmt.Sprintf("%s %q", c.Kind, c.Key); got != expected[i] {
+				tt.Errorf("expected '%s' but got '%s'", expected[i], got)
+			}
+		}
+	})
+}
@@ -0,0 +1,281 @@
+package main
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"slices"
+	"time"
+	"unicode/utf8"
+
+	"github.com/JorgeLNJunior/cacher/pkg/dumpfile"
+)
+
+// ExportFormat is the format keys are exported to and imported from.
+type ExportFormat string
+
+const (
+	ExportFormatJSONL ExportFormat = "jsonl"
+	ExportFormatCSV   ExportFormat = "csv"
+)
+
+func (f ExportFormat) Valid() bool {
+	switch {
+	case f == ExportFormatJSONL:
+		return true
+	case f == ExportFormatCSV:
+		return true
+	default:
+		return false
+	}
+}
+
+const encodingBase64 = "base64"
+
+var (
+	ErrInvalidExportFormat = errors.New("export format must be jsonl or csv")
+	ErrInvalidEncoding     = errors.New("record encoding must be empty or base64")
+	csvHeader              = []string{"key", "value", "expiry", "encoding"}
+)
+
+// record is an exported key. Keys and values that are not valid UTF-8 can't be
+// represented in JSON or CSV, so both are base64 encoded and Encoding is set.
+type record struct {
+	Key      string     `json:"key"`
+	Value    string     `json:"value"`
+	Expiry   *time.Time `json:"expiry,omitempty"`
+	Encoding string     `json:"encoding,omitempty"`
+}
+
+func newRecord(key string, item dumpfile.Item) record {
+	r := record{Key: key, Value: item.Value}
+	if !item.Expiry.IsZero() {
+		r.Expiry = &item.Expiry
+	}
+	if !utf8.ValidString(key) || !utf8.ValidString(item.Value) {
+		r.Key = base64.StdEncoding.EncodeToString([]byte(key))
+		r.Value = base64.StdEncoding.EncodeToString([]byte(item.Value))
+		r.Encoding = encodingBase64
+	}
+	return r
+}
+
+func (r record) item() (string, dumpfile.Item, error) {
+	item := dumpfile.Item{Value: r.Value}
+	if r.Expiry != nil {
+		item.Expiry = *r.Expiry
+	}
+
+	switch r.Encoding {
+	case "":
+		return r.Key, item, nil
+	case encodingBase64:
+		key, err := base64.StdEncoding.DecodeString(r.Key)
+		if err != nil {
+			return "", item, err
+		}
+		value, err := base64.StdEncoding.DecodeString(r.Value)
+		if err != nil {
+			return "", item, err
+		}
+		item.Value = string(value)
+		return string(key), item, nil
+	default:
+		return "", item, ErrInvalidEncoding
+	}
+}
+
+// exportDump writes every key of a dump to w, sorted by key so exports can be diffed.
+func exportDump(w io.Writer, dump map[string]dumpfile.Item, format ExportFormat) error {
+	out := bufio.NewWriter(w)
+	keys := slices.Sorted(maps.Keys(dump))
+
+	switch format {
+	case ExportFormatJSONL:
+		encoder := json.NewEncoder(out)
+		for _, key := range keys {
+			if err := encoder.Encode(newRecord(key, dump[key])); err != nil {
+				return err
+			}
+		}
+	case ExportFormatCSV:
+		writer := csv.NewWriter(out)
+		if err := writer.Write(csvHeader); err != nil {
+			return err
+		}
+		for _, key := range keys {
+			r := newRecord(key, dump[key])
+			expiry := ""
+			if r.Expiry != nil {
+				expiry = r.Expiry.Format(time.RFC3339Nano)
+			}
+			if err := writer.Write([]string{r.Key, r.Value, expiry, r.Encoding}); err != nil {
+				return err
+			}
+		}
+		writer.Flush()
+		if err := writer.Error(); err != nil {
+			return err
+		}
+	default:
+		return ErrInvalidExportFormat
+	}
+
+	return out.Flush()
+}
+
+// importDump reads the keys exported by exportDump.
+func importDump(r io.Reader, format ExportFormat) (map[string]dumpfile.Item, error) {
+	dump := make(map[string]dumpfile.Item)
+
+	add := func(line int, rec record) error {
+		key, item, err := rec.item()
+		if err != nil {
+			return fmt.Errorf("line %d: %w", line, err)
+		}
+		dump[key] = item
+		return nil
+	}
+
+	switch format {
+	case ExportFormatJSONL:
+		decoder := json.NewDecoder(r)
+		for line := 1; ; line++ {
+			rec := record{}
+			if err := decoder.Decode(&rec); errors.Is(err, io.EOF) {
+				return dump, nil
+			} else if err != nil {
+				return nil, fmt.Errorf("line %d: %w", line, err)
+			}
+			if err := add(line, rec); err != nil {
+				return nil, err
+			}
+		}
+	case ExportFormatCSV:
+		reader := csv.NewReader(r)
+		reader.FieldsPerRecord = len(csvHeader)
+		if _, err := reader.Read(); err != nil && !errors.Is(err, io.EOF) {
+			return nil, err // the header
+		}
+		for line := 2; ; line++ {
+			fields, err := reader.Read()
+			if errors.Is(err, io.EOF) {
+				return dump, nil
+			}
+			if err != nil {
+				return nil, err
+			}
+
+			rec := record{Key: fields[0], Value: fields[1], Encoding: fields[3]}
+			if fields[2] != "" {
+				expiry, err := time.Parse(time.RFC3339Nano, fields[2])
+				if err != nil {
+					return nil, fmt.Errorf("line %d: %w", line, err)
+				}
+				rec.Expiry = &expiry
+			}
+			if err := add(line, rec); err != nil {
+				return nil, err
+			}
+		}
+	default:
+		return nil, ErrInvalidExportFormat
+	}
+}
+
+func runExport(args []string) error {
+	flags := flag.NewFlagSet("export", flag.ExitOnError)
+	dumpFlags := dumpFlags{}
+	dumpFlags.register(flags)
+	format := flags.String("format", string(ExportFormatJSONL), "format of the export. jsonl or csv")
+	output := flags.String("output", "", "file the keys are exported to. Defaults to the standard output")
+
+	args, err := parseArgs(flags, args, 1)
+	if err != nil {
+		return err
+	}
+	if !ExportFormat(*format).Valid() {
+		return ErrInvalidExportFormat
+	}
+
+	dump, err := dumpFlags.readDumpFile(args[0])
+	if err != nil {
+		return err
+	}
+
+	if *output == "" {
+		return exportDump(os.Stdout, dump, ExportFormat(*format))
+	}
+
+	file, err := os.OpenFile(*output, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	if err := exportDump(file, dump, ExportFormat(*format)); err != nil {
+		return err
+	}
+	return file.Sync()
+}
+
+func runImport(args []string) error {
+	flags := flag.NewFlagSet("import", flag.ExitOnError)
+	dumpFlags := dumpFlags{}
+	dumpFlags.register(flags)
+	format := flags.String("format", string(ExportFormatJSONL), "format of the input. jsonl or csv")
+	dumpFormat := flags.String("dump-format", string(dumpfile.FormatBinary), "format of the dump. binary or json")
+	compress := flags.Bool("dump-compression", false, "compress the values of a binary dump")
+
+	args, err := parseArgs(flags, args, 2)
+	if err != nil {
+		return err
+	}
+	if !ExportFormat(*format).Valid() {
+		return ErrInvalidExportFormat
+	}
+	if !dumpfile.Format(*dumpFormat).Valid() {
+		return dumpfile.ErrInvalidFormat
+	}
+
+	keyring, err := dumpFlags.keyring()
+	if err != nil {
+		return err
+	}
+
+	input, err := os.Open(args[0])
+	if err != nil {
+		return err
+	}
+	defer input.Close()
+
+	dump, err := importDump(input, ExportFormat(*format))
+	if err != nil {
+		return fmt.Errorf("%s: %w", args[0], err)
+	}
+	dump = filterKeys(dump, dumpFlags.match)
+
+	// never replace a dump, it may be in use by a server
+	output, err := os.OpenFile(args[1], os.O_RDWR|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		return err
+	}
+	defer output.Close()
+
+	if keyring != nil {
+		err = dumpfile.WriteEncrypted(output, dump, dumpfile.Format(*dumpFormat), *compress, keyring)
+	} else {
+		err = dumpfile.Write(output, dump, dumpfile.Format(*dumpFormat), *compress)
+	}
+	if err != nil {
+		return err
+	}
+	return output.Sync()
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path"
+
+	"github.com/JorgeLNJunior/cacher/pkg/dumpfile"
+	"github.com/JorgeLNJunior/cacher/pkg/encryption"
+)
+
+const usage = `dumptool inspects and converts cacher dumps without a server.
+
+Usage:
+  dumptool stats  [flags] DUMP
+  dumptool export [flags] DUMP
+  dumptool import [flags] INPUT DUMP
+  dumptool diff   [flags] DUMP DUMP
+
+Run dumptool COMMAND -h to see the flags of a command.
+`
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	var err error
+	switch command, args := os.Args[1], os.Args[2:]; command {
+	case "stats":
+		err = runStats(args)
+	case "export":
+		err = runExport(args)
+	case "import":
+		err = runImport(args)
+	case "diff":
+		err = runDiff(args)
+	default:
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+// dumpFlags are the flags shared by every command that reads a dump.
+type dumpFlags struct {
+	match             string
+	encryptionKeyFile string
+}
+
+func (f *dumpFlags) register(flags *flag.FlagSet) {
+	flags.StringVar(&f.match, "match", "", "only include the keys matching a glob pattern, e.g. 'user:*'")
+	flags.StringVar(&f.encryptionKeyFile, "encryption-key-file", "", "file with the keys of encrypted dumps. Defaults to the keys in CACHER_ENCRYPTION_KEYS")
+}
+
+func (f *dumpFlags) keyring() (*encryption.Keyring, error) {
+	return encryption.LoadKeyring(f.encryptionKeyFile, "CACHER_ENCRYPTION_KEYS")
+}
+
+// readDumpFile reads a dump in any format, encrypted or not, keeping only the
+// keys that match the pattern.
+func (f *dumpFlags) readDumpFile(name string) (map[string]dumpfile.Item, error) {
+	if _, err := path.Match(f.match, ""); err != nil {
+		return nil, fmt.Errorf("invalid pattern '%s': %w", f.match, err)
+	}
+
+	keyring, err := f.keyring()
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	dump, err := dumpfile.Read(file, keyring, 0) // the dumps are local files of the operator
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+
+	return filterKeys(dump, f.match), nil
+}
+
+// filterKeys removes the keys that don't match a glob pattern. An empty pattern matches every key.
+func filterKeys(dump map[string]dumpfile.Item, pattern string) map[string]dumpfile.Item {
+	if pattern == "" {
+		return dump
+	}
+
+	for key := range dump {
+		if matched, _ := path.Match(pattern, key); !matched {
+			delete(dump, key)
+		}
+	}
+	return dump
+}
+
+// parseArgs parses the flags of a command and checks it received n arguments.
+func parseArgs(flags *flag.FlagSet, args []string, n int) ([]string, error) {
+	if err := flags.Parse(args); err != nil {
+		return nil, err
+	}
+	if flags.NArg() != n {
+		flags.Usage()
+		return nil, fmt.Errorf("%s expects %d arguments but got %d", flags.Name(), n, flags.NArg())
+	}
+	return flags.Args(), nil
+}
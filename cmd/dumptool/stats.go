@@ -0,0 +1,163 @@
+package main
+
+import (
+	"cmp"
+	"flag"
+	"fmt"
+	"io"
+	"math/bits"
+	"os"
+	"slices"
+	"text/tabwriter"
+	"time"
+
+	"github.com/JorgeLNJunior/cacher/pkg/dumpfile"
+)
+
+// ttlBuckets are the upper bounds of the TTL histogram. Keys without an explicit
+// expiry live for one year, so most of them fall in the last buckets.
+var ttlBuckets = []ttlBucket{
+	{"< 1m", time.Minute},
+	{"< 1h", time.Hour},
+	{"< 1d", time.Hour * 24},
+	{"< 7d", time.Hour * 24 * 7},
+	{"< 30d", time.Hour * 24 * 30},
+	{"< 365d", time.Hour * 24 * 365},
+}
+
+type ttlBucket struct {
+	Label string
+	Max   time.Duration // exclusive
+}
+
+// Stats summarizes the keys of a dump.
+type Stats struct {
+	Keys       int
+	KeyBytes   int
+	ValueBytes int
+	SizeHist   []int // keys per value size, bucket i holds sizes in [2^(i-1), 2^i)
+	Expired    int
+	NoExpiry   int
+	TTLHist    []int // keys per TTL bucket, the last one holds TTLs beyond every bucket
+	Largest    []KeySize
+}
+
+// KeySize is the size in bytes of a key and its value.
+type KeySize struct {
+	Key  string
+	Size int
+}
+
+// computeStats summarizes a dump, keeping the top largest keys. TTLs are relative to now.
+func computeStats(dump map[string]dumpfile.Item, now time.Time, top int) Stats {
+	stats := Stats{
+		Keys:    len(dump),
+		TTLHist: make([]int, len(ttlBuckets)+1),
+		Largest: make([]KeySize, 0, len(dump)),
+	}
+
+	for key, item := range dump {
+		stats.KeyBytes += len(key)
+		stats.ValueBytes += len(item.Value)
+
+		bucket := bits.Len(uint(len(item.Value)))
+		if bucket >= len(stats.SizeHist) {
+			stats.SizeHist = append(stats.SizeHist, make([]int, bucket-len(stats.SizeHist)+1)...)
+		}
+		stats.SizeHist[bucket]++
+
+		switch {
+		case item.Expiry.IsZero():
+			stats.NoExpiry++
+		case item.ExpiredAt(now):
+			stats.Expired++
+		default:
+			ttl := item.Expiry.Sub(now)
+			i := slices.IndexFunc(ttlBuckets, func(b ttlBucket) bool { return ttl < b.Max })
+			if i < 0 {
+				i = len(ttlBuckets)
+			}
+			stats.TTLHist[i]++
+		}
+
+		stats.Largest = append(stats.Largest, KeySize{Key: key, Size: len(key) + len(item.Value)})
+	}
+
+	slices.SortFunc(stats.Largest, func(a, b KeySize) int {
+		return cmp.Or(cmp.Compare(b.Size, a.Size), cmp.Compare(a.Key, b.Key))
+	})
+	stats.Largest = stats.Largest[:min(top, len(stats.Largest))]
+
+	return stats
+}
+
+// print writes the stats as a human readable report.
+func (s Stats) print(w io.Writer) error {
+	out := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+
+	fmt.Fprintf(out, "keys\t%d\n", s.Keys)
+	fmt.Fprintf(out, "key bytes\t%d\n", s.KeyBytes)
+	fmt.Fprintf(out, "value bytes\t%d\n", s.ValueBytes)
+
+	fmt.Fprintln(out, "\nvalue size\tkeys")
+	for i, count := range s.SizeHist {
+		if count == 0 {
+			continue
+		}
+		if i == 0 {
+			fmt.Fprintf(out, "0 B\t%d\n", count)
+			continue
+		}
+		fmt.Fprintf(out, "%s - %s\t%d\n", formatBytes(1<<(i-1)), formatBytes(1<<i-1), count)
+	}
+
+	fmt.Fprintln(out, "\nttl\tkeys")
+	fmt.Fprintf(out, "expired\t%d\n", s.Expired)
+	for i, bucket := range ttlBuckets {
+		fmt.Fprintf(out, "%s\t%d\n", bucket.Label, s.TTLHist[i])
+	}
+	fmt.Fprintf(out, ">= 365d\t%d\n", s.TTLHist[len(ttlBuckets)])
+	fmt.Fprintf(out, "no expiry\t%d\n", s.NoExpiry)
+
+	if len(s.Largest) > 0 {
+		fmt.Fprintln(out, "\nlargest keys\tsize")
+		for _, k := range s.Largest {
+			fmt.Fprintf(out, "%q\t%s\n", k.Key, formatBytes(k.Size))
+		}
+	}
+
+	return out.Flush()
+}
+
+func formatBytes(n int) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+
+	div, exp := unit, 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+func runStats(args []string) error {
+	flags := flag.NewFlagSet("stats", flag.ExitOnError)
+	dumpFlags := dumpFlags{}
+	dumpFlags.register(flags)
+	top := flags.Int("top", 10, "how many of the largest keys to print")
+
+	args, err := parseArgs(flags, args, 1)
+	if err != nil {
+		return err
+	}
+
+	dump, err := dumpFlags.readDumpFile(args[0])
+	if err != nil {
+		return err
+	}
+
+	return computeStats(dump, time.Now(), *top).print(os.Stdout)
+}
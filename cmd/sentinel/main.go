@@ -0,0 +1,148 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"net"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/JorgeLNJunior/cacher/pkg/client"
+	"github.com/JorgeLNJunior/cacher/pkg/clock"
+	"github.com/JorgeLNJunior/cacher/pkg/data"
+	levellog "github.com/JorgeLNJunior/cacher/pkg/logger"
+)
+
+const maxRequestSize = 4096
+
+var ErrOnlyRole = errors.New("a sentinel only answers ROLE")
+
+func main() {
+	cfg := Config{}
+	var peers, replicas string
+	var checkInterval time.Duration
+	var tlsCA, tlsCert, tlsKey string
+	var user, password string
+
+	flag.StringVar(&cfg.Address, "address", ":28595", "address the sentinel listens, also used to identify it among its peers")
+	flag.StringVar(&cfg.Primary, "primary", "", "address of the primary in host:port format")
+	flag.StringVar(&cfg.Standby, "standby", "", "address of the replica promoted when the primary is down")
+	flag.StringVar(&replicas, "replicas", "", "addresses of the other replicas, separated by commas")
+	flag.StringVar(&peers, "peers", "", "addresses of the other sentinels, separated by commas")
+	flag.IntVar(&cfg.Quorum, "quorum", 1, "how many sentinels must see the primary down before a failover")
+	flag.DurationVar(&cfg.DownAfter, "down-after", time.Second*5, "how long the primary must fail the health checks to be considered down")
+	flag.DurationVar(&cfg.Timeout, "timeout", time.Second, "max duration of a health check")
+	flag.DurationVar(&checkInterval, "check-interval", time.Second, "how often the servers are checked")
+	flag.StringVar(&tlsCA, "tls-ca", "", "PEM encoded CA that verifies the certificates of the servers. Enables TLS")
+	flag.StringVar(&tlsCert, "tls-cert", "", "PEM encoded client certificate, sent to servers that require mutual TLS. Enables TLS")
+	flag.StringVar(&tlsKey, "tls-key", "", "PEM encoded private key of the client certificate")
+	flag.StringVar(&user, "user", os.Getenv("CACHER_USER"), "user of the requests to the servers, on servers with a users file. It needs ROLE and REPLICAOF")
+	flag.StringVar(&password, "password", os.Getenv("CACHER_PASSWORD"), "password of the user")
+	flag.Parse()
+
+	cfg.Peers = splitAddresses(peers)
+	cfg.Replicas = splitAddresses(replicas)
+
+	logger := levellog.NewLogger(levellog.LevelInfo, os.Stdout)
+	if cfg.Primary == "" || cfg.Standby == "" {
+		logger.Fatal("the primary and the standby must be provided", nil)
+	}
+	if cfg.Quorum < 1 || cfg.Quorum > len(cfg.Peers)+1 {
+		logger.Fatal("the quorum must be between 1 and the number of sentinels", nil)
+	}
+
+	if tlsCA != "" || tlsCert != "" || tlsKey != "" {
+		tlsConfig, err := client.LoadTLSConfig(tlsCA, tlsCert, tlsKey)
+		if err != nil {
+			logger.Fatal("error loading the TLS certificates", levellog.Args{"err": err.Error()})
+		}
+		cfg.TLS = tlsConfig
+	}
+
+	if user != "" {
+		cfg.Credentials = &data.Credentials{User: user, Password: password}
+	}
+
+	systemClock := clock.New()
+	sentinel := NewSentinel(cfg, systemClock, logger)
+
+	listener, err := net.Listen("tcp", cfg.Address)
+	if err != nil {
+		logger.Fatal("error listening the sentinel", levellog.Args{"addr": cfg.Address, "err": err.Error()})
+	}
+	defer listener.Close()
+	logger.Info("the sentinel is listening", levellog.Args{"addr": cfg.Address, "primary": cfg.Primary})
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				if errors.Is(err, net.ErrClosed) {
+					return
+				}
+				logger.Error("error accepting a tcp connection", levellog.Args{"err": err.Error()})
+				continue
+			}
+			go handleConnection(conn, sentinel, logger)
+		}
+	}()
+
+	exitChan := make(chan os.Signal, 1)
+	signal.Notify(exitChan, syscall.SIGINT, syscall.SIGTERM)
+
+	ticker := systemClock.NewTicker(checkInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-exitChan:
+			logger.Info("the sentinel has shutdown", nil)
+			return
+		case <-ticker.C():
+			sentinel.Check()
+		}
+	}
+}
+
+// handleConnection answers a ROLE request with the view of the sentinel.
+func handleConnection(conn net.Conn, sentinel *Sentinel, logger *levellog.Logger) {
+	defer conn.Close()
+
+	if err := conn.SetDeadline(time.Now().Add(time.Second * 5)); err != nil {
+		return
+	}
+
+	buffer := make([]byte, maxRequestSize)
+	read, err := conn.Read(buffer)
+	if err != nil {
+		return
+	}
+
+	res := data.NewResponse(data.ResponseStatusOK, sentinel.View().String())
+	req := data.Request{}
+	if err := req.Unmarshal(buffer[:read]); err != nil {
+		res = data.NewResponse(data.ResponseStatusError, err.Error())
+	} else if req.Operation != data.OperationRole {
+		res = data.NewResponse(data.ResponseStatusError, ErrOnlyRole.Error())
+	}
+
+	payload, err := res.Marshal()
+	if err != nil {
+		return
+	}
+	if _, err := conn.Write(payload); err != nil {
+		logger.Error("error writing data to a connection", levellog.Args{"err": err.Error()})
+	}
+}
+
+func splitAddresses(value string) []string {
+	addresses := make([]string, 0)
+	for address := range strings.SplitSeq(value, ",") {
+		if address = strings.TrimSpace(address); address != "" {
+			addresses = append(addresses, address)
+		}
+	}
+	return addresses
+}
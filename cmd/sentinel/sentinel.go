@@ -0,0 +1,228 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/JorgeLNJunior/cacher/pkg/client"
+	"github.com/JorgeLNJunior/cacher/pkg/clock"
+	"github.com/JorgeLNJunior/cacher/pkg/data"
+	levellog "github.com/JorgeLNJunior/cacher/pkg/logger"
+)
+
+// Config configures which servers a Sentinel monitors and how it agrees with its peers.
+type Config struct {
+	Address   string   // address of the sentinel, identifies it among its peers
+	Peers     []string // addresses of the other sentinels
+	Quorum    int      // sentinels that must see the primary down before a failover
+	Primary   string
+	Standby   string   // replica promoted when the primary is down
+	Replicas  []string // other replicas, pointed to the new primary after a failover
+	DownAfter time.Duration
+	Timeout   time.Duration // max duration of a health check
+	TLS       *tls.Config   // connects to the servers over TLS if not nil, the peers always use plain tcp
+
+	// Credentials authenticate the requests to the servers if not nil.
+	Credentials *data.Credentials
+}
+
+// View is what a sentinel knows about the servers. The epoch is incremented on every
+// failover, so the view with the highest epoch is the most recent one.
+type View struct {
+	Primary string
+	Standby string
+	Epoch   int64
+	Down    bool // whether the primary is down for this sentinel
+}
+
+func (v View) String() string {
+	return fmt.Sprintf(
+		"%s primary=%s standby=%s epoch=%d down=%t",
+		data.RoleSentinel, v.Primary, v.Standby, v.Epoch, v.Down,
+	)
+}
+
+func parseView(role data.Role) (View, error) {
+	if role.Name != data.RoleSentinel {
+		return View{}, fmt.Errorf("expected a sentinel but got a %s", role.Name)
+	}
+
+	epoch, err := strconv.ParseInt(role.Fields["epoch"], 10, 64)
+	if err != nil {
+		return View{}, data.ErrInvalidRole
+	}
+	down, err := strconv.ParseBool(role.Fields["down"])
+	if err != nil {
+		return View{}, data.ErrInvalidRole
+	}
+
+	return View{
+		Primary: role.Fields["primary"],
+		Standby: role.Fields["standby"],
+		Epoch:   epoch,
+		Down:    down,
+	}, nil
+}
+
+// Sentinel health checks the servers, fails over to the standby once a quorum
+// of sentinels agree the primary is down and keeps the replicas pointed to the
+// primary, including a former primary that comes back.
+type Sentinel struct {
+	cfg      Config
+	clock    clock.Clock
+	logger   *levellog.Logger
+	view     View
+	lastSeen map[string]time.Time // last successful health check of each server
+	mu       sync.Mutex           // guards view
+}
+
+// NewSentinel returns a Sentinel. Every server is considered up when it starts.
+func NewSentinel(cfg Config, c clock.Clock, logger *levellog.Logger) *Sentinel {
+	s := &Sentinel{
+		cfg:      cfg,
+		clock:    c,
+		logger:   logger,
+		view:     View{Primary: cfg.Primary, Standby: cfg.Standby},
+		lastSeen: make(map[string]time.Time),
+	}
+	for _, server := range s.servers() {
+		s.lastSeen[server] = c.Now()
+	}
+	return s
+}
+
+// View returns what the sentinel knows about the servers.
+func (s *Sentinel) View() View {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.view
+}
+
+func (s *Sentinel) servers() []string {
+	return append([]string{s.cfg.Primary, s.cfg.Standby}, s.cfg.Replicas...)
+}
+
+// Check runs a round of health checks, failing over if needed. It's not safe to
+// call Check concurrently.
+func (s *Sentinel) Check() {
+	roles := make(map[string]data.Role)
+	for _, server := range s.servers() {
+		role, err := (&client.Client{Addr: server, Timeout: s.cfg.Timeout, TLSConfig: s.cfg.TLS, Credentials: s.cfg.Credentials}).Role()
+		if err != nil {
+			continue
+		}
+		roles[server] = role
+		s.lastSeen[server] = s.clock.Now()
+	}
+
+	peers := make(map[string]View)
+	for _, peer := range s.cfg.Peers {
+		role, err := (&client.Client{Addr: peer, Timeout: s.cfg.Timeout}).Role()
+		if err != nil {
+			continue
+		}
+		view, err := parseView(role)
+		if err != nil {
+			s.logger.Warn("invalid answer from a sentinel", levellog.Args{"sentinel": peer, "err": err.Error()})
+			continue
+		}
+		peers[peer] = view
+	}
+
+	s.mu.Lock()
+	for peer, view := range peers {
+		if view.Epoch > s.view.Epoch {
+			s.logger.Info("a failover has been learned from a sentinel", levellog.Args{"sentinel": peer, "primary": view.Primary})
+			s.view.Primary, s.view.Standby, s.view.Epoch = view.Primary, view.Standby, view.Epoch
+		}
+	}
+	s.view.Down = s.clock.Now().Sub(s.lastSeen[s.view.Primary]) >= s.cfg.DownAfter
+	view := s.view
+	s.mu.Unlock()
+
+	if view.Down && s.leads(view, peers) {
+		if _, up := roles[view.Standby]; up {
+			s.failover(view)
+			return
+		}
+		s.logger.Error("the primary is down but the standby can't be promoted", levellog.Args{"standby": view.Standby})
+	}
+
+	s.reconfigure(view, roles)
+}
+
+// leads returns whether a quorum agrees that the primary is down and the sentinel
+// is the one that must fail over: the agreeing sentinel with the lowest address.
+func (s *Sentinel) leads(view View, peers map[string]View) bool {
+	agreeing := []string{s.cfg.Address}
+	for peer, peerView := range peers {
+		if peerView.Down && peerView.Primary == view.Primary && peerView.Epoch == view.Epoch {
+			agreeing = append(agreeing, peer)
+		}
+	}
+
+	return len(agreeing) >= s.cfg.Quorum && slices.Min(agreeing) == s.cfg.Address
+}
+
+// failover promotes the standby. The former primary becomes the standby once it
+// comes back, so the roles swap on every failover.
+func (s *Sentinel) failover(view View) {
+	args := levellog.Args{"primary": view.Primary, "standby": view.Standby}
+	s.logger.Warn("the primary is down, promoting the standby", args)
+
+	if err := s.replicaOf(view.Standby, data.NoPrimary); err != nil {
+		args["err"] = err.Error()
+		s.logger.Error("error promoting the standby", args)
+		return
+	}
+
+	s.mu.Lock()
+	s.view.Primary, s.view.Standby = view.Standby, view.Primary
+	s.view.Epoch++
+	s.view.Down = false
+	s.mu.Unlock()
+
+	s.lastSeen[view.Standby] = s.clock.Now()
+	s.logger.Info("the standby has been promoted", args)
+}
+
+// reconfigure points every server that is up to the primary.
+func (s *Sentinel) reconfigure(view View, roles map[string]data.Role) {
+	for server, role := range roles {
+		target := view.Primary
+		if server == view.Primary {
+			target = data.NoPrimary
+		}
+		if server == view.Primary && role.Name == data.RolePrimary {
+			continue
+		}
+		if server != view.Primary && role.Name == data.RoleReplica && role.Fields["primary"] == view.Primary {
+			continue
+		}
+
+		args := levellog.Args{"server": server, "primary": view.Primary}
+		if err := s.replicaOf(server, target); err != nil {
+			args["err"] = err.Error()
+			s.logger.Error("error reconfiguring a server", args)
+			continue
+		}
+		s.logger.Info("a server has been reconfigured", args)
+	}
+}
+
+func (s *Sentinel) replicaOf(server string, primary string) error {
+	c := &client.Client{Addr: server, Timeout: s.cfg.Timeout, TLSConfig: s.cfg.TLS, Credentials: s.cfg.Credentials}
+	res, err := c.Do(data.Request{Operation: data.OperationReplicaOf, Key: primary})
+	if err != nil {
+		return err
+	}
+	if res.Status != data.ResponseStatusOK {
+		return fmt.Errorf("%s", res.Message)
+	}
+	return nil
+}
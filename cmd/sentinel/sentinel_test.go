@@ -0,0 +1,296 @@
+package main
+
+import (
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/JorgeLNJunior/cacher/pkg/client"
+	"github.com/JorgeLNJunior/cacher/pkg/clock"
+	"github.com/JorgeLNJunior/cacher/pkg/data"
+	levellog "github.com/JorgeLNJunior/cacher/pkg/logger"
+)
+
+var testEpoch = time.Unix(1_700_000_000, 0)
+
+// fakeServer answers ROLE and REPLICAOF like a cacher server.
+type fakeServer struct {
+	addr     string
+	listener net.Listener
+	mu       sync.Mutex
+	primary  string // data.NoPrimary when the server is a primary
+}
+
+func newFakeServer(t *testing.T, primary string) *fakeServer {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &fakeServer{addr: listener.Addr().String(), primary: primary}
+	s.serve(t, listener)
+
+	return s
+}
+
+func (s *fakeServer) serve(t *testing.T, listener net.Listener) {
+	s.listener = listener
+	t.Cleanup(s.stop)
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go s.handle(conn)
+		}
+	}()
+}
+
+func (s *fakeServer) handle(conn net.Conn) {
+	defer conn.Close()
+
+	payload, err := io.ReadAll(conn)
+	if err != nil {
+		return
+	}
+	req := data.Request{}
+	if err := req.Unmarshal(payload); err != nil {
+		return
+	}
+
+	s.mu.Lock()
+	if req.Operation == data.OperationReplicaOf {
+		s.primary = req.Key
+	}
+	message := data.RolePrimary + " offset=0 replicas=0"
+	if s.primary != data.NoPrimary {
+		message = data.RoleReplica + " primary=" + s.primary + " state=streaming offset=0 behind=0 lag=0s"
+	}
+	s.mu.Unlock()
+
+	res, _ := data.NewResponse(data.ResponseStatusOK, message).Marshal()
+	conn.Write(res)
+}
+
+func (s *fakeServer) Primary() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.primary
+}
+
+func (s *fakeServer) stop() {
+	s.listener.Close()
+}
+
+// restart listens again on the address of the server.
+func (s *fakeServer) restart(t *testing.T) {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", s.addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.serve(t, listener)
+}
+
+// serveSentinel answers ROLE requests with the view of s on listener.
+func serveSentinel(t *testing.T, listener net.Listener, s *Sentinel) {
+	t.Helper()
+
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go handleConnection(conn, s, s.logger)
+		}
+	}()
+}
+
+func newTestSentinel(cfg Config, c clock.Clock) *Sentinel {
+	cfg.DownAfter = time.Second * 5
+	cfg.Timeout = time.Second
+	return NewSentinel(cfg, c, levellog.NewLogger(levellog.LevelFatal, io.Discard))
+}
+
+func TestSentinel(t *testing.T) {
+	t.Run("should promote the standby once the primary is down", func(tt *testing.T) {
+		primary := newFakeServer(tt, data.NoPrimary)
+		standby := newFakeServer(tt, primary.addr)
+		replica := newFakeServer(tt, primary.addr)
+
+		c := clock.NewFake(testEpoch)
+		s := newTestSentinel(Config{
+			Address:  "127.0.0.1:1",
+			Quorum:   1,
+			Primary:  primary.addr,
+			Standby:  standby.addr,
+			Replicas: []string{replica.addr},
+		}, c)
+
+		primary.stop()
+		s.Check()
+		if s.View().Down {
+			tt.Fatal("expected the primary to be up until down-after has passed")
+		}
+
+		c.Advance(time.Second * 5)
+		s.Check()
+
+		view := s.View()
+		if view.Primary != standby.addr {
+			tt.Errorf("expected the primary to be %s but got %s", standby.addr, view.Primary)
+		}
+		if view.Epoch != 1 {
+			tt.Errorf("expected the epoch to be 1 but got %d", view.Epoch)
+		}
+		if standby.Primary() != data.NoPrimary {
+			tt.Errorf("expected the standby to be promoted but it's a replica of %s", standby.Primary())
+		}
+
+		s.Check()
+		if replica.Primary() != standby.addr {
+			tt.Errorf("expected the replica to follow %s but got %s", standby.addr, replica.Primary())
+		}
+	})
+
+	t.Run("should turn a former primary that comes back into a replica", func(tt *testing.T) {
+		primary := newFakeServer(tt, data.NoPrimary)
+		standby := newFakeServer(tt, primary.addr)
+
+		c := clock.NewFake(testEpoch)
+		s := newTestSentinel(Config{
+			Address: "127.0.0.1:1",
+			Quorum:  1,
+			Primary: primary.addr,
+			Standby: standby.addr,
+		}, c)
+
+		primary.stop()
+		c.Advance(time.Second * 5)
+		s.Check()
+
+		primary.restart(tt)
+		s.Check()
+
+		if primary.Primary() != standby.addr {
+			tt.Errorf("expected the former primary to follow %s but got %s", standby.addr, primary.Primary())
+		}
+		if view := s.View(); view.Standby != primary.addr {
+			tt.Errorf("expected the standby to be %s but got %s", primary.addr, view.Standby)
+		}
+	})
+
+	t.Run("should fail over only once a quorum agrees", func(tt *testing.T) {
+		primary := newFakeServer(tt, data.NoPrimary)
+		standby := newFakeServer(tt, primary.addr)
+
+		first, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			tt.Fatal(err)
+		}
+		second, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			tt.Fatal(err)
+		}
+		firstAddr, secondAddr := first.Addr().String(), second.Addr().String()
+		if secondAddr < firstAddr {
+			first, second = second, first
+			firstAddr, secondAddr = secondAddr, firstAddr
+		}
+
+		c := clock.NewFake(testEpoch)
+		leader := newTestSentinel(Config{
+			Address: firstAddr,
+			Peers:   []string{secondAddr},
+			Quorum:  2,
+			Primary: primary.addr,
+			Standby: standby.addr,
+		}, c)
+		follower := newTestSentinel(Config{
+			Address: secondAddr,
+			Peers:   []string{firstAddr},
+			Quorum:  2,
+			Primary: primary.addr,
+			Standby: standby.addr,
+		}, c)
+		serveSentinel(tt, first, leader)
+		serveSentinel(tt, second, follower)
+
+		primary.stop()
+		c.Advance(time.Second * 5)
+		leader.Check()
+
+		if leader.View().Epoch != 0 {
+			tt.Fatal("expected no failover while a single sentinel sees the primary down")
+		}
+
+		follower.Check()
+		if follower.View().Epoch != 0 {
+			tt.Fatal("expected only the sentinel with the lowest address to fail over")
+		}
+
+		leader.Check()
+		if view := leader.View(); view.Primary != standby.addr || view.Epoch != 1 {
+			tt.Fatalf("expected the primary to be %s on epoch 1 but got %s", standby.addr, view)
+		}
+
+		follower.Check()
+		if view := follower.View(); view.Primary != standby.addr || view.Epoch != 1 {
+			tt.Errorf("expected the follower to learn %s on epoch 1 but got %s", standby.addr, view)
+		}
+	})
+
+	t.Run("should not promote the standby when it is down", func(tt *testing.T) {
+		primary := newFakeServer(tt, data.NoPrimary)
+		standby := newFakeServer(tt, primary.addr)
+
+		c := clock.NewFake(testEpoch)
+		s := newTestSentinel(Config{
+			Address: "127.0.0.1:1",
+			Quorum:  1,
+			Primary: primary.addr,
+			Standby: standby.addr,
+		}, c)
+
+		primary.stop()
+		standby.stop()
+		c.Advance(time.Second * 5)
+		s.Check()
+
+		if view := s.View(); view.Primary != primary.addr || !view.Down {
+			tt.Errorf("expected %s to remain the primary while down but got %s", primary.addr, view)
+		}
+	})
+}
+
+func TestHandleConnection(t *testing.T) {
+	t.Run("should answer ROLE with the view", func(tt *testing.T) {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			tt.Fatal(err)
+		}
+		s := newTestSentinel(Config{Primary: "127.0.0.1:2", Standby: "127.0.0.1:3"}, clock.NewFake(testEpoch))
+		serveSentinel(tt, listener, s)
+
+		role, err := client.New(listener.Addr().String()).Role()
+		if err != nil {
+			tt.Fatal(err)
+		}
+		view, err := parseView(role)
+		if err != nil {
+			tt.Fatal(err)
+		}
+		if view.Primary != "127.0.0.1:2" || view.Standby != "127.0.0.1:3" {
+			tt.Errorf("expected the configured servers but got %s", view)
+		}
+	})
+}
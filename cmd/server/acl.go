@@ -0,0 +1,325 @@
+package main
+
+import (
+	"bufio"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/JorgeLNJunior/cacher/pkg/data"
+	levellog "github.com/JorgeLNJunior/cacher/pkg/logger"
+)
+
+const (
+	passwordHashScheme     = "pbkdf2-sha256"
+	defaultHashIterations  = 600_000
+	passwordSaltBytes      = 16
+	passwordHashBytes      = 32
+	allowAll               = "*"
+	keyPatternPrefixSuffix = "*" // a pattern ending with it matches the keys starting with the rest
+)
+
+var (
+	ErrAuthRequired        = errors.New("authentication required, send AUTH USER PASSWORD before the request")
+	ErrAuthDisabled        = errors.New("the server has no users file, authentication is disabled")
+	ErrInvalidPassword     = errors.New("invalid user or password")
+	ErrOperationDenied     = errors.New("the user is not allowed to run the operation")
+	ErrKeyDenied           = errors.New("the user is not allowed to access the key")
+	ErrKeyspaceDenied      = errors.New("the user is limited to some keys and the operation accesses every key")
+	ErrInvalidUsersFile    = errors.New("every line of the users file must be USER HASH OPERATIONS KEYS")
+	ErrInvalidPasswordHash = errors.New("the password hash must be pbkdf2-sha256$ITERATIONS$SALT$HASH")
+)
+
+// keyOperations are the operations whose key is the key of an item, the only ones
+// restricted by the key patterns of a user.
+var keyOperations = map[data.Operation]bool{
+	data.OperationGet: true,
+	data.OperationSet: true,
+	data.OperationDel: true,
+	data.OperationExp: true,
+}
+
+// keyspaceOperations are the operations that read or overwrite every key at once, through
+// a dump, the replication stream or the cluster log. They are denied to the users limited
+// to some keys, since their key patterns can't restrict them.
+var keyspaceOperations = map[data.Operation]bool{
+	data.OperationBackup:    true,
+	data.OperationRestore:   true,
+	data.OperationSync:      true,
+	data.OperationRaft:      true,
+	data.OperationReplicaOf: true,
+}
+
+// User is an account of the users file.
+type User struct {
+	Name       string
+	hash       passwordHash
+	operations map[data.Operation]bool // nil allows every operation
+	keys       []string                // nil allows every key
+}
+
+// Allows returns ErrOperationDenied, ErrKeyDenied or ErrKeyspaceDenied if the user
+// can't make the request.
+func (u *User) Allows(req data.Request) error {
+	if req.Operation == data.OperationAuth {
+		return nil
+	}
+	if u.operations != nil && !u.operations[req.Operation] {
+		return ErrOperationDenied
+	}
+	if u.keys != nil && keyspaceOperations[req.Operation] {
+		return ErrKeyspaceDenied
+	}
+	if !keyOperations[req.Operation] || u.allowsKey(req.Key) {
+		return nil
+	}
+	return ErrKeyDenied
+}
+
+// Sees returns whether the user may see a request made by someone else, e.g. streamed
+// to its MONITOR. A user limited to some keys only sees the requests of keyOperations
+// on them, the keys of the other operations are users, backups or addresses. A nil
+// user, when authentication is disabled, sees every request.
+func (u *User) Sees(req data.Request) bool {
+	if u == nil || u.keys == nil {
+		return true
+	}
+	return keyOperations[req.Operation] && u.allowsKey(req.Key)
+}
+
+// allowsKey returns whether the key patterns of the user match a key.
+func (u *User) allowsKey(key string) bool {
+	if u.keys == nil {
+		return true
+	}
+
+	for _, pattern := range u.keys {
+		if prefix, found := strings.CutSuffix(pattern, keyPatternPrefixSuffix); found && strings.HasPrefix(key, prefix) {
+			return true
+		}
+		if pattern == key {
+			return true
+		}
+	}
+	return false
+}
+
+// Users are the accounts allowed to make requests. The passwords are hashed with
+// PBKDF2, which is slow on purpose, so the last password verified for every user
+// is remembered as a fast hash.
+type Users struct {
+	users    map[string]*User
+	verified map[string][sha256.Size]byte
+	mu       sync.Mutex // guards verified
+	dummy    passwordHash
+	once     sync.Once // creates dummy
+}
+
+// LoadUsers reads the users file at path.
+func LoadUsers(path string) (*Users, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return ParseUsers(file)
+}
+
+// ParseUsers parses a user per line as USER HASH OPERATIONS KEYS, where OPERATIONS
+// and KEYS are comma separated lists or * to allow all of them. A key ending with *
+// allows the keys starting with it. Empty lines and lines starting with # are skipped.
+func ParseUsers(r io.Reader) (*Users, error) {
+	users := &Users{users: make(map[string]*User), verified: make(map[string][sha256.Size]byte)}
+
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		fields := strings.Fields(text)
+		if len(fields) != 4 {
+			return nil, fmt.Errorf("line %d: %w", line, ErrInvalidUsersFile)
+		}
+
+		user := &User{Name: fields[0]}
+		hash, err := parsePasswordHash(fields[1])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		user.hash = hash
+
+		if fields[2] != allowAll {
+			user.operations = make(map[data.Operation]bool)
+			for op := range strings.SplitSeq(fields[2], ",") {
+				operation := data.Operation(strings.ToUpper(op))
+				if !operation.Valid() {
+					return nil, fmt.Errorf("line %d: %w", line, data.ErrInvalidOperation)
+				}
+				user.operations[operation] = true
+			}
+		}
+		if fields[3] != allowAll {
+			user.keys = strings.Split(fields[3], ",")
+		}
+
+		users.users[user.Name] = user
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return users, nil
+}
+
+// Authenticate returns the user if the password is right, ErrInvalidPassword otherwise.
+func (u *Users) Authenticate(name string, password string) (*User, error) {
+	sum := sha256.Sum256([]byte(password))
+
+	user, found := u.users[name]
+	if !found {
+		// hashes a password anyway, so unknown users can't be told apart by the response time
+		u.once.Do(func() { u.dummy, _ = newPasswordHash(rand.Text(), defaultHashIterations) })
+		u.dummy.matches(password)
+		return nil, ErrInvalidPassword
+	}
+
+	u.mu.Lock()
+	verified, cached := u.verified[name]
+	u.mu.Unlock()
+	if cached && subtle.ConstantTimeCompare(verified[:], sum[:]) == 1 {
+		return user, nil
+	}
+
+	if !user.hash.matches(password) {
+		return nil, ErrInvalidPassword
+	}
+
+	u.mu.Lock()
+	u.verified[name] = sum
+	u.mu.Unlock()
+	return user, nil
+}
+
+// passwordHash is a PBKDF2 hash of a password.
+type passwordHash struct {
+	iterations int
+	salt       []byte
+	hash       []byte
+}
+
+// HashPassword returns the hash of a password in the format of the users file.
+func HashPassword(password string) (string, error) {
+	hash, err := newPasswordHash(password, defaultHashIterations)
+	if err != nil {
+		return "", err
+	}
+	return hash.String(), nil
+}
+
+func newPasswordHash(password string, iterations int) (passwordHash, error) {
+	salt := make([]byte, passwordSaltBytes)
+	rand.Read(salt)
+
+	hash, err := pbkdf2.Key(sha256.New, password, salt, iterations, passwordHashBytes)
+	if err != nil {
+		return passwordHash{}, err
+	}
+	return passwordHash{iterations: iterations, salt: salt, hash: hash}, nil
+}
+
+func parsePasswordHash(text string) (passwordHash, error) {
+	parts := strings.Split(text, "$")
+	if len(parts) != 4 || parts[0] != passwordHashScheme {
+		return passwordHash{}, ErrInvalidPasswordHash
+	}
+
+	iterations, err := strconv.Atoi(parts[1])
+	if err != nil || iterations < 1 {
+		return passwordHash{}, ErrInvalidPasswordHash
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(parts[2])
+	if err != nil {
+		return passwordHash{}, ErrInvalidPasswordHash
+	}
+	hash, err := base64.RawStdEncoding.DecodeString(parts[3])
+	if err != nil || len(hash) == 0 {
+		return passwordHash{}, ErrInvalidPasswordHash
+	}
+
+	return passwordHash{iterations: iterations, salt: salt, hash: hash}, nil
+}
+
+func (h passwordHash) matches(password string) bool {
+	hash, err := pbkdf2.Key(sha256.New, password, h.salt, h.iterations, len(h.hash))
+	return err == nil && subtle.ConstantTimeCompare(hash, h.hash) == 1
+}
+
+func (h passwordHash) String() string {
+	return strings.Join([]string{
+		passwordHashScheme,
+		strconv.Itoa(h.iterations),
+		base64.RawStdEncoding.EncodeToString(h.salt),
+		base64.RawStdEncoding.EncodeToString(h.hash),
+	}, "$")
+}
+
+// authorize checks the credentials of a request and whether the user can make it,
+// returning the user. The denied requests are logged.
+func (app *application) authorize(conn net.Conn, req data.Request) (*User, error) {
+	var user *User
+	var err error
+	switch {
+	case req.Operation == data.OperationAuth:
+		user, err = app.users.Authenticate(req.Key, req.Value)
+	case req.Credentials != nil:
+		user, err = app.users.Authenticate(req.Credentials.User, req.Credentials.Password)
+	default:
+		err = ErrAuthRequired
+	}
+	if err == nil {
+		err = user.Allows(req)
+	}
+	if err == nil {
+		return user, nil
+	}
+
+	args := levellog.Args{"addr": conn.RemoteAddr().String(), "operation": req.Operation.String(), "err": err.Error()}
+	if user != nil {
+		args["user"] = user.Name
+	}
+	if keyOperations[req.Operation] {
+		args["key"] = req.Key
+	}
+	app.logger.Warn("a request has been denied", args)
+	return nil, err
+}
+
+// printPasswordHash prints the hash of the password in the first line of the standard
+// input, for the users file.
+func printPasswordHash() {
+	password, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && !errors.Is(err, io.EOF) {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	hash, err := HashPassword(strings.TrimRight(password, "\r\n"))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	fmt.Println(hash)
+}
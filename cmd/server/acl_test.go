@@ -0,0 +1,249 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/JorgeLNJunior/cacher/pkg/client"
+	"github.com/JorgeLNJunior/cacher/pkg/data"
+)
+
+// testHash returns a password hash cheap enough for the tests.
+func testHash(t *testing.T, password string) string {
+	t.Helper()
+
+	hash, err := newPasswordHash(password, 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return hash.String()
+}
+
+// writeUsersFile writes a users file with lines formatted as USER PASSWORD OPERATIONS KEYS,
+// replacing the passwords with their hashes.
+func writeUsersFile(t *testing.T, lines ...string) string {
+	t.Helper()
+
+	for i, line := range lines {
+		fields := strings.Fields(line)
+		fields[1] = testHash(t, fields[1])
+		lines[i] = strings.Join(fields, " ")
+	}
+
+	file := filepath.Join(t.TempDir(), "users")
+	if err := os.WriteFile(file, []byte(strings.Join(lines, "\n")), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return file
+}
+
+func TestParseUsers(t *testing.T) {
+	t.Run("should parse the users skipping comments", func(tt *testing.T) {
+		text := "# admins\n\nalice " + testHash(tt, "s3cret") + " * *\nbob " + testHash(tt, "hunter2") + " get,set session:*,config\n"
+
+		users, err := ParseUsers(strings.NewReader(text))
+		if err != nil {
+			tt.Fatal(err)
+		}
+
+		bob, err := users.Authenticate("bob", "hunter2")
+		if err != nil {
+			tt.Fatal(err)
+		}
+		if !bob.operations[data.OperationGet] || bob.operations[data.OperationDel] || len(bob.keys) != 2 {
+			tt.Errorf("expected bob to be allowed GET and SET on 2 patterns but got %+v", bob)
+		}
+		if _, err := users.Authenticate("alice", "s3cret"); err != nil {
+			tt.Errorf("expected alice to be authenticated but got %v", err)
+		}
+	})
+
+	t.Run("should return an error if a line is incomplete", func(tt *testing.T) {
+		if _, err := ParseUsers(strings.NewReader("alice " + testHash(tt, "s3cret") + " *")); !errors.Is(err, ErrInvalidUsersFile) {
+			tt.Errorf("expected ErrInvalidUsersFile but got %v", err)
+		}
+	})
+
+	t.Run("should return an error if the password is not hashed", func(tt *testing.T) {
+		if _, err := ParseUsers(strings.NewReader("alice s3cret * *")); !errors.Is(err, ErrInvalidPasswordHash) {
+			tt.Errorf("expected ErrInvalidPasswordHash but got %v", err)
+		}
+	})
+
+	t.Run("should return an error if an operation is invalid", func(tt *testing.T) {
+		if _, err := ParseUsers(strings.NewReader("alice " + testHash(tt, "s3cret") + " GET,PUT *")); !errors.Is(err, data.ErrInvalidOperation) {
+			tt.Errorf("expected ErrInvalidOperation but got %v", err)
+		}
+	})
+}
+
+func TestUsersAuthenticate(t *testing.T) {
+	users, err := ParseUsers(strings.NewReader("alice " + testHash(t, "s3cret") + " * *"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("should reject a wrong password", func(tt *testing.T) {
+		if _, err := users.Authenticate("alice", "s3cret!"); !errors.Is(err, ErrInvalidPassword) {
+			tt.Errorf("expected ErrInvalidPassword but got %v", err)
+		}
+	})
+
+	t.Run("should reject an unknown user", func(tt *testing.T) {
+		if _, err := users.Authenticate("mallory", "s3cret"); !errors.Is(err, ErrInvalidPassword) {
+			tt.Errorf("expected ErrInvalidPassword but got %v", err)
+		}
+	})
+
+	t.Run("should reject a wrong password once the right one is cached", func(tt *testing.T) {
+		if _, err := users.Authenticate("alice", "s3cret"); err != nil {
+			tt.Fatal(err)
+		}
+		if _, err := users.Authenticate("alice", "wrong"); !errors.Is(err, ErrInvalidPassword) {
+			tt.Errorf("expected ErrInvalidPassword but got %v", err)
+		}
+	})
+}
+
+func TestHashPassword(t *testing.T) {
+	t.Run("should hash the password with a random salt", func(tt *testing.T) {
+		first, err := HashPassword("s3cret")
+		if err != nil {
+			tt.Fatal(err)
+		}
+		second, err := HashPassword("s3cret")
+		if err != nil {
+			tt.Fatal(err)
+		}
+		if first == second {
+			tt.Error("expected the hashes to be different")
+		}
+
+		hash, err := parsePasswordHash(first)
+		if err != nil {
+			tt.Fatal(err)
+		}
+		if !hash.matches("s3cret") || hash.matches("s3cret!") {
+			tt.Error("expected the hash to only match the password")
+		}
+	})
+}
+
+func TestAuthorization(t *testing.T) {
+	usersFile := writeUsersFile(t,
+		"admin s3cret * *",
+		"reader hunter2 GET,ROLE session:*,config",
+		"web hunter2 * session:*",
+	)
+
+	t.Run("should deny the requests without credentials", func(tt *testing.T) {
+		_, addr := newTestApplication(tt, config{usersFile: usersFile})
+
+		if _, err := client.New(addr).Get("foo"); err == nil || err.Error() != ErrAuthRequired.Error() {
+			tt.Errorf("expected '%s' but got %v", ErrAuthRequired, err)
+		}
+	})
+
+	t.Run("should deny the requests with a wrong password", func(tt *testing.T) {
+		_, addr := newTestApplication(tt, config{usersFile: usersFile})
+
+		c := client.New(addr)
+		c.Credentials = &data.Credentials{User: "admin", Password: "wrong"}
+		if err := c.Set("foo", "bar"); err == nil || err.Error() != ErrInvalidPassword.Error() {
+			tt.Errorf("expected '%s' but got %v", ErrInvalidPassword, err)
+		}
+		if err := c.Auth("admin", "wrong"); err == nil || err.Error() != ErrInvalidPassword.Error() {
+			tt.Errorf("expected '%s' but got %v", ErrInvalidPassword, err)
+		}
+	})
+
+	t.Run("should allow the operations and keys of the user", func(tt *testing.T) {
+		app, addr := newTestApplication(tt, config{usersFile: usersFile})
+		app.storage.Set("session:1", "alice")
+		app.storage.Set("config", "{}")
+		app.storage.Set("secret", "42")
+
+		c := client.New(addr)
+		c.Credentials = &data.Credentials{User: "reader", Password: "hunter2"}
+		if value, err := c.Get("session:1"); err != nil || value != "alice" {
+			tt.Errorf("expected 'alice' but got '%s' and %v", value, err)
+		}
+		if value, err := c.Get("config"); err != nil || value != "{}" {
+			tt.Errorf("expected '{}' but got '%s' and %v", value, err)
+		}
+		if _, err := c.Get("secret"); err == nil || err.Error() != ErrKeyDenied.Error() {
+			tt.Errorf("expected '%s' but got %v", ErrKeyDenied, err)
+		}
+		if err := c.Set("session:2", "bob"); err == nil || err.Error() != ErrOperationDenied.Error() {
+			tt.Errorf("expected '%s' but got %v", ErrOperationDenied, err)
+		}
+		if err := c.Auth("reader", "hunter2"); err != nil {
+			tt.Errorf("expected the user to be authenticated but got %v", err)
+		}
+	})
+
+	t.Run("should deny the operations on every key to a user limited to some keys", func(tt *testing.T) {
+		_, addr := newTestApplication(tt, config{usersFile: usersFile})
+
+		c := client.New(addr)
+		c.Credentials = &data.Credentials{User: "web", Password: "hunter2"}
+		if err := c.Set("session:1", "alice"); err != nil {
+			tt.Errorf("expected the key of the user to be allowed but got %v", err)
+		}
+		for _, op := range []data.Operation{data.OperationBackup, data.OperationRestore, data.OperationSync, data.OperationRaft, data.OperationReplicaOf} {
+			res, err := c.Do(data.Request{Operation: op, Key: data.StreamKey})
+			if err != nil {
+				tt.Fatal(err)
+			}
+			if res.Status != data.ResponseStatusError || res.Message != ErrKeyspaceDenied.Error() {
+				tt.Errorf("expected '%s' for %s but got %s", ErrKeyspaceDenied, op, res)
+			}
+		}
+	})
+
+	t.Run("should restore a streamed snapshot sent with credentials", func(tt *testing.T) {
+		source, sourceAddr := newTestApplication(tt, config{usersFile: usersFile})
+		source.storage.Set("foo", "bar")
+		target, targetAddr := newTestApplication(tt, config{usersFile: usersFile})
+
+		credentials := &data.Credentials{User: "admin", Password: "s3cret"}
+		backup, err := (&client.Client{Addr: sourceAddr, Timeout: client.DefaultTimeout, Credentials: credentials}).Do(
+			data.Request{Operation: data.OperationBackup, Key: data.StreamKey},
+		)
+		if err != nil || backup.Status != data.ResponseStatusOK {
+			tt.Fatalf("expected a backup but got %+v and %v", backup, err)
+		}
+
+		res, err := (&client.Client{Addr: targetAddr, Timeout: client.DefaultTimeout, Credentials: credentials}).Do(
+			data.Request{Operation: data.OperationRestore, Key: data.StreamKey, Value: backup.Message},
+		)
+		if err != nil || res.Status != data.ResponseStatusOK {
+			tt.Fatalf("expected the snapshot to be restored but got %+v and %v", res, err)
+		}
+		if value, _ := target.storage.Get("foo"); value != "bar" {
+			tt.Errorf("expected 'bar' but got '%s'", value)
+		}
+	})
+
+	t.Run("should replicate with the peer credentials", func(tt *testing.T) {
+		primary, primaryAddr := newTestApplication(tt, config{usersFile: usersFile})
+		primary.storage.Set("foo", "bar")
+
+		replica, _ := newTestApplication(tt, config{replicaOf: primaryAddr, peerUser: "admin", peerPassword: "s3cret"})
+		eventually(tt, "the replica has not synced", func() bool {
+			value, _ := replica.storage.Get("foo")
+			return value == "bar"
+		})
+	})
+
+	t.Run("should answer AUTH with an error if authentication is disabled", func(tt *testing.T) {
+		_, addr := newTestApplication(tt, config{})
+
+		if err := client.New(addr).Auth("admin", "s3cret"); err == nil || err.Error() != ErrAuthDisabled.Error() {
+			tt.Errorf("expected '%s' but got %v", ErrAuthDisabled, err)
+		}
+	})
+}
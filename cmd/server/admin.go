@@ -0,0 +1,131 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"time"
+
+	"github.com/JorgeLNJunior/cacher/pkg/data"
+	"github.com/JorgeLNJunior/cacher/pkg/dumpfile"
+	levellog "github.com/JorgeLNJunior/cacher/pkg/logger"
+)
+
+const adminTimeout = time.Minute // snapshots take longer than regular requests
+
+var ErrPersistenceDisabled = errors.New("the server has no data directory, enable persistence to use named backups")
+
+// handleBackup writes a snapshot of the storage to a named backup or streams it back.
+func (app *application) handleBackup(conn net.Conn, req data.Request) {
+	ctx, cancel := context.WithTimeout(context.Background(), adminTimeout)
+	defer cancel()
+
+	if req.Key == data.StreamKey {
+		if err := conn.SetWriteDeadline(time.Now().Add(adminTimeout)); err != nil {
+			app.errorResponse(conn, err)
+			return
+		}
+
+		// the status is only written once the snapshot is ready, so an error can still be sent
+		out := &prefixWriter{w: conn, prefix: []byte(data.ResponseStatusOK + " ")}
+		err := WriteSnapshot(out, app.storage, dumpfile.Format(app.config.dumpFormat), app.config.dumpCompression, app.keyring)
+		if err != nil {
+			app.logger.Error("error streaming a backup", levellog.Args{"err": err.Error()})
+			if !out.written {
+				app.errorResponse(conn, err)
+			}
+		}
+		return
+	}
+
+	if app.persistanceStorage == nil {
+		app.errorResponse(conn, ErrPersistenceDisabled)
+		return
+	}
+	if err := app.persistanceStorage.Backup(ctx, app.storage, req.Key); err != nil {
+		app.logger.Error("error writing a backup", levellog.Args{"name": req.Key, "err": err.Error()})
+		app.errorResponse(conn, err)
+		return
+	}
+
+	app.logger.Info("a backup has been written", levellog.Args{"name": req.Key})
+	app.okResponse(conn, "the backup has been written successfully")
+}
+
+// handleRestore loads a named backup or the snapshot sent with the request into the storage.
+// A streamed snapshot is read until the client closes its side of the connection.
+func (app *application) handleRestore(conn net.Conn, req data.Request, raw []byte) {
+	ctx, cancel := context.WithTimeout(context.Background(), adminTimeout)
+	defer cancel()
+
+	policy := ConflictPolicy(app.config.restoreConflicts)
+
+	var stats RestoreStats
+	var err error
+	if req.Key == data.StreamKey {
+		// the value is not used because the request parsing may have changed the snapshot bytes
+		snapshot, _ := bytes.CutPrefix(raw, []byte(req.Operation.String()+" "+req.Key+" "))
+		snapshot = bytes.Clone(snapshot)
+
+		if err := conn.SetReadDeadline(time.Now().Add(adminTimeout)); err != nil {
+			app.errorResponse(conn, err)
+			return
+		}
+		rest, readErr := app.readRest(conn, len(raw))
+		if errors.Is(readErr, data.ErrTooLarge) {
+			app.rejectTooLarge(conn, readErr)
+			return
+		}
+		if readErr != nil {
+			app.errorResponse(conn, readErr)
+			return
+		}
+
+		stats, err = ReadSnapshot(append(snapshot, rest...), app.storage, policy, app.keyring, app.config.maxValueBytes)
+	} else {
+		if app.persistanceStorage == nil {
+			app.errorResponse(conn, ErrPersistenceDisabled)
+			return
+		}
+		stats, err = app.persistanceStorage.RestoreBackup(ctx, app.storage, req.Key, policy)
+	}
+
+	args := levellog.Args{
+		"name":        req.Key,
+		"loaded":      fmt.Sprint(stats.Loaded),
+		"skipped":     fmt.Sprint(stats.Skipped),
+		"conflicting": fmt.Sprint(stats.Conflicting),
+	}
+	if err != nil {
+		args["err"] = err.Error()
+		app.logger.Error("error restoring a backup", args)
+		app.errorResponse(conn, err)
+		return
+	}
+
+	app.logger.Info("a backup has been restored", args)
+	app.okResponse(conn, fmt.Sprintf(
+		"%d keys loaded, %d expired keys skipped, %d conflicting keys",
+		stats.Loaded, stats.Skipped, stats.Conflicting,
+	))
+}
+
+// prefixWriter writes a prefix before the first write.
+type prefixWriter struct {
+	w       io.Writer
+	prefix  []byte
+	written bool
+}
+
+func (w *prefixWriter) Write(p []byte) (int, error) {
+	if !w.written {
+		w.written = true
+		if _, err := w.w.Write(w.prefix); err != nil {
+			return 0, err
+		}
+	}
+	return w.w.Write(p)
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/JorgeLNJunior/cacher/pkg/data"
+	"github.com/JorgeLNJunior/cacher/pkg/dumpfile"
+)
+
+func TestBackupAndRestore(t *testing.T) {
+	t.Run("should stream a backup and restore it on another server", func(tt *testing.T) {
+		source, sourceAddr := newTestApplication(tt, config{})
+		for range 100 {
+			source.storage.Set(randomString(), strings.Repeat(randomString(), 10))
+		}
+		source.storage.Set("foo", "bar")
+		source.storage.ExpireAt("foo", testEpoch.Add(time.Hour))
+
+		res := sendRequest(tt, sourceAddr, []byte("BACKUP "+data.StreamKey))
+		if res.Status != data.ResponseStatusOK {
+			tt.Fatalf("expected an OK response but got '%s'", res)
+		}
+
+		target, targetAddr := newTestApplication(tt, config{})
+		res = sendRequest(tt, targetAddr, []byte("RESTORE "+data.StreamKey+" "+res.Message))
+		if res.Status != data.ResponseStatusOK {
+			tt.Fatalf("expected an OK response but got '%s'", res)
+		}
+
+		if len(target.storage.data) != len(source.storage.data) {
+			tt.Fatalf("expected %d restored keys but got %d", len(source.storage.data), len(target.storage.data))
+		}
+		if item := target.storage.data["foo"]; item.Value != "bar" || !item.Expiry.Equal(testEpoch.Add(time.Hour)) {
+			tt.Fatalf("the key has not been restored with its value and expiry, got %+v", item)
+		}
+	})
+
+	t.Run("should write a named backup and restore it", func(tt *testing.T) {
+		app, addr := newTestApplication(tt, config{})
+		app.persistanceStorage = newTestOnDiskStorage(tt)
+		app.storage.Set("foo", "bar")
+
+		if res := sendRequest(tt, addr, []byte("BACKUP nightly")); res.Status != data.ResponseStatusOK {
+			tt.Fatalf("expected an OK response but got '%s'", res)
+		}
+		app.storage.Delete("foo")
+
+		if res := sendRequest(tt, addr, []byte("RESTORE nightly")); res.Status != data.ResponseStatusOK {
+			tt.Fatalf("expected an OK response but got '%s'", res)
+		}
+		if value, _ := app.storage.Get("foo"); value != "bar" {
+			tt.Fatalf("expected 'bar' but got '%s'", value)
+		}
+	})
+
+	t.Run("should refuse a backup name with a path", func(tt *testing.T) {
+		app, addr := newTestApplication(tt, config{})
+		app.persistanceStorage = newTestOnDiskStorage(tt)
+
+		res := sendRequest(tt, addr, []byte("BACKUP ../dump"))
+		if res.Status != data.ResponseStatusError || res.Message != ErrInvalidBackupName.Error() {
+			tt.Fatalf("expected an invalid backup name error but got '%s'", res)
+		}
+	})
+
+	t.Run("should refuse a corrupted snapshot", func(tt *testing.T) {
+		_, addr := newTestApplication(tt, config{})
+
+		res := sendRequest(tt, addr, []byte("RESTORE "+data.StreamKey+" "+dumpfile.Magic+"corrupted"))
+		if res.Status != data.ResponseStatusError {
+			tt.Fatalf("expected an ERROR response but got '%s'", res)
+		}
+	})
+}
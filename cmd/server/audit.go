@@ -0,0 +1,73 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+
+	"github.com/JorgeLNJunior/cacher/pkg/audit"
+	"github.com/JorgeLNJunior/cacher/pkg/data"
+	levellog "github.com/JorgeLNJunior/cacher/pkg/logger"
+)
+
+// auditedOperations are the operations recorded in the audit log: the writes and the
+// administrative operations.
+var auditedOperations = map[data.Operation]bool{
+	data.OperationSet:       true,
+	data.OperationDel:       true,
+	data.OperationExp:       true,
+	data.OperationBackup:    true,
+	data.OperationRestore:   true,
+	data.OperationSync:      true,
+	data.OperationReplicaOf: true,
+}
+
+// auditedCommands are the administrative commands of the operations whose other
+// commands only read, e.g. CLIENT KILL but not CLIENT LIST.
+var auditedCommands = map[data.Operation]map[string]bool{
+	data.OperationClient:  {data.ClientKill: true},
+	data.OperationSlowLog: {data.SlowLogReset: true},
+}
+
+// audited returns whether a request is recorded in the audit log.
+func audited(req data.Request) bool {
+	return auditedOperations[req.Operation] || auditedCommands[req.Operation][req.Key]
+}
+
+var ErrAuditFailed = errors.New("the request could not be recorded in the audit log")
+
+// openAuditLog opens the audit log at path, warning if the last entry had been torn
+// by a crash and has been truncated.
+func openAuditLog(path string, logger *levellog.Logger) (*audit.Log, error) {
+	log, err := audit.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	if log.Truncated() > 0 {
+		logger.Warn("the partially written last entry of the audit log has been truncated", levellog.Args{
+			"path":  path,
+			"bytes": fmt.Sprint(log.Truncated()),
+		})
+	}
+	return log, nil
+}
+
+// recordAudit records a request in the audit log before it's made. user is nil if the
+// server doesn't authenticate the requests.
+func (app *application) recordAudit(conn net.Conn, req data.Request, user *User) error {
+	entry := audit.Entry{
+		Time:      app.clock.Now(),
+		Addr:      conn.RemoteAddr().String(),
+		Operation: req.Operation.String(),
+		Key:       req.Key,
+	}
+	if user != nil {
+		entry.User = user.Name
+	}
+
+	if err := app.audit.Record(entry); err != nil {
+		app.logger.Error("error writing to the audit log", levellog.Args{"err": err.Error()})
+		return ErrAuditFailed
+	}
+	return nil
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/JorgeLNJunior/cacher/pkg/audit"
+	"github.com/JorgeLNJunior/cacher/pkg/client"
+	"github.com/JorgeLNJunior/cacher/pkg/data"
+)
+
+func TestAudit(t *testing.T) {
+	t.Run("should record the writes and admin operations without their values", func(tt *testing.T) {
+		auditFile := filepath.Join(tt.TempDir(), "audit.log")
+		usersFile := writeUsersFile(tt, "admin s3cret * *")
+		app, addr := newTestApplication(tt, config{auditFile: auditFile, usersFile: usersFile})
+
+		c := client.New(addr)
+		c.Credentials = &data.Credentials{User: "admin", Password: "s3cret"}
+		if err := c.Set("foo", "top secret value"); err != nil {
+			tt.Fatal(err)
+		}
+		if _, err := c.Get("foo"); err != nil {
+			tt.Fatal(err)
+		}
+		if err := c.Del("foo"); err != nil {
+			tt.Fatal(err)
+		}
+		if _, err := c.Do(data.Request{Operation: data.OperationBackup, Key: data.StreamKey}); err != nil {
+			tt.Fatal(err)
+		}
+		if _, err := c.ClientList(); err != nil {
+			tt.Fatal(err)
+		}
+		if err := c.ClientKill("999"); err == nil {
+			tt.Fatal("expected an unknown connection not to be killed")
+		}
+		if _, err := c.SlowLogLen(); err != nil {
+			tt.Fatal(err)
+		}
+		if err := c.SlowLogReset(); err != nil {
+			tt.Fatal(err)
+		}
+		// a denied request is not recorded
+		if _, err := client.New(addr).Do(data.Request{Operation: data.OperationDel, Key: "bar"}); err != nil {
+			tt.Fatal(err)
+		}
+
+		file, err := os.Open(auditFile)
+		if err != nil {
+			tt.Fatal(err)
+		}
+		defer file.Close()
+
+		entries := make([]audit.Entry, 0)
+		if _, _, err := audit.Verify(file, func(e audit.Entry) { entries = append(entries, e) }); err != nil {
+			tt.Fatal(err)
+		}
+
+		operations := make([]string, 0)
+		for _, e := range entries {
+			operations = append(operations, e.Operation+" "+e.Key)
+			if e.User != "admin" || !e.Time.Equal(app.clock.Now()) || !strings.HasPrefix(e.Addr, "127.0.0.1:") {
+				tt.Errorf("expected the user, time and address of the request but got %+v", e)
+			}
+		}
+		expected := "SET foo,DEL foo,BACKUP -,CLIENT KILL,SLOWLOG RESET"
+		if strings.Join(operations, ",") != expected {
+			tt.Errorf("expected '%s' but got '%s'", expected, strings.Join(operations, ","))
+		}
+
+		payload, err := os.ReadFile(auditFile)
+		if err != nil {
+			tt.Fatal(err)
+		}
+		if strings.Contains(string(payload), "top secret value") {
+			tt.Error("expected the value not to be recorded")
+		}
+	})
+
+	t.Run("should restart after a write of the audit log torn by a crash", func(tt *testing.T) {
+		auditFile := filepath.Join(tt.TempDir(), "audit.log")
+		_, addr := newTestApplication(tt, config{auditFile: auditFile})
+		if err := client.New(addr).Set("foo", "bar"); err != nil {
+			tt.Fatal(err)
+		}
+
+		file, err := os.OpenFile(auditFile, os.O_WRONLY|os.O_APPEND, 0o600)
+		if err != nil {
+			tt.Fatal(err)
+		}
+		if _, err := file.WriteString(`{"time":"2023-11-14T22:13:20Z","addr":"127.`); err != nil {
+			tt.Fatal(err)
+		}
+		file.Close()
+
+		_, addr = newTestApplication(tt, config{auditFile: auditFile})
+		if err := client.New(addr).Del("foo"); err != nil {
+			tt.Fatal(err)
+		}
+
+		file, err = os.Open(auditFile)
+		if err != nil {
+			tt.Fatal(err)
+		}
+		defer file.Close()
+		operations := make([]string, 0)
+		if _, _, err := audit.Verify(file, func(e audit.Entry) { operations = append(operations, e.Operation) }); err != nil {
+			tt.Fatal(err)
+		}
+		if strings.Join(operations, ",") != "SET,DEL" {
+			tt.Errorf("expected 'SET,DEL' but got '%s'", strings.Join(operations, ","))
+		}
+	})
+}
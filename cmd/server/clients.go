@@ -0,0 +1,197 @@
+package main
+
+import (
+	"cmp"
+	"errors"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/JorgeLNJunior/cacher/pkg/clock"
+	"github.com/JorgeLNJunior/cacher/pkg/data"
+	levellog "github.com/JorgeLNJunior/cacher/pkg/logger"
+)
+
+var ErrNoSuchClient = errors.New("no connection has the id or the address")
+
+// Clients is the registry of the open connections.
+type Clients struct {
+	clock  clock.Clock
+	conns  map[int64]*clientConn
+	nextID int64
+	mu     sync.Mutex // guards conns and nextID
+}
+
+// clientConn is a connection registered in Clients. It counts the bytes read and
+// written and when the last ones were.
+type clientConn struct {
+	net.Conn
+	id          int64
+	clock       clock.Clock
+	created     time.Time
+	lastActive  atomic.Int64 // unix nanoseconds
+	bytesIn     atomic.Int64
+	bytesOut    atomic.Int64
+	name        string
+	lastCommand data.Operation
+	mu          sync.Mutex // guards name and lastCommand
+}
+
+// NewClients returns an empty registry.
+func NewClients(c clock.Clock) *Clients {
+	return &Clients{clock: c, conns: make(map[int64]*clientConn)}
+}
+
+// Register adds a connection to the registry. The returned connection must be used
+// instead of conn, so its bytes are counted, and unregistered once it's closed.
+func (c *Clients) Register(conn net.Conn) *clientConn {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.nextID++
+	client := &clientConn{Conn: conn, id: c.nextID, clock: c.clock, created: c.clock.Now()}
+	client.lastActive.Store(client.created.UnixNano())
+	c.conns[client.id] = client
+	return client
+}
+
+// Unregister removes a connection from the registry.
+func (c *Clients) Unregister(client *clientConn) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.conns, client.id)
+}
+
+// List describes the open connections, sorted by id.
+func (c *Clients) List() []data.ClientInfo {
+	c.mu.Lock()
+	clients := make([]*clientConn, 0, len(c.conns))
+	for _, client := range c.conns {
+		clients = append(clients, client)
+	}
+	c.mu.Unlock()
+
+	slices.SortFunc(clients, func(a, b *clientConn) int { return cmp.Compare(a.id, b.id) })
+
+	now := c.clock.Now()
+	infos := make([]data.ClientInfo, 0, len(clients))
+	for _, client := range clients {
+		infos = append(infos, client.info(now))
+	}
+	return infos
+}
+
+// Kill closes the connection whose id or address is target, or returns ErrNoSuchClient.
+func (c *Clients) Kill(target string) (data.ClientInfo, error) {
+	id, err := strconv.ParseInt(target, 10, 64)
+	byID := err == nil
+
+	c.mu.Lock()
+	var killed *clientConn
+	for _, client := range c.conns {
+		if byID && client.id == id || !byID && client.RemoteAddr().String() == target {
+			killed = client
+			break
+		}
+	}
+	c.mu.Unlock()
+
+	if killed == nil {
+		return data.ClientInfo{}, ErrNoSuchClient
+	}
+	info := killed.info(c.clock.Now())
+	killed.Conn.Close() // the handler of the connection fails and unregisters it
+	return info, nil
+}
+
+func (c *clientConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	if n > 0 {
+		c.bytesIn.Add(int64(n))
+		c.lastActive.Store(c.clock.Now().UnixNano())
+	}
+	return n, err
+}
+
+func (c *clientConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	if n > 0 {
+		c.bytesOut.Add(int64(n))
+		c.lastActive.Store(c.clock.Now().UnixNano())
+	}
+	return n, err
+}
+
+// request records an authorized request read from the connection.
+func (c *clientConn) request(req data.Request) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.lastCommand = req.Operation
+	if req.ClientName != "" {
+		c.name = req.ClientName
+	}
+}
+
+// setName names the connection.
+func (c *clientConn) setName(name string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.name = name
+}
+
+func (c *clientConn) info(now time.Time) data.ClientInfo {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return data.ClientInfo{
+		ID:          c.id,
+		Addr:        c.RemoteAddr().String(),
+		Name:        c.name,
+		Age:         now.Sub(c.created),
+		Idle:        now.Sub(time.Unix(0, c.lastActive.Load())),
+		LastCommand: c.lastCommand,
+		BytesIn:     c.bytesIn.Load(),
+		BytesOut:    c.bytesOut.Load(),
+	}
+}
+
+// handleClient answers the CLIENT requests.
+func (app *application) handleClient(conn net.Conn, req data.Request) {
+	switch req.Key {
+	case data.ClientList:
+		lines := make([]string, 0)
+		for _, info := range app.clients.List() {
+			lines = append(lines, info.String())
+		}
+		app.okResponse(conn, strings.Join(lines, "\n"))
+	case data.ClientKill:
+		info, err := app.clients.Kill(req.Value)
+		if err != nil {
+			app.errorResponse(conn, err)
+			return
+		}
+		app.logger.Warn("a connection has been killed", levellog.Args{
+			"addr":   conn.RemoteAddr().String(),
+			"killed": info.String(),
+		})
+		app.okResponse(conn, "the connection has been killed")
+	case data.ClientSetName:
+		if !data.ValidClientName(req.Value) {
+			app.errorResponse(conn, data.ErrInvalidClientName)
+			return
+		}
+		if client, ok := conn.(*clientConn); ok {
+			client.setName(req.Value)
+		}
+		app.okResponse(conn, "the connection has been named")
+	default:
+		app.errorResponse(conn, data.ErrInvalidClientCommand)
+	}
+}
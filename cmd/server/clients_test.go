@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/JorgeLNJunior/cacher/pkg/client"
+	"github.com/JorgeLNJunior/cacher/pkg/clock"
+	"github.com/JorgeLNJunior/cacher/pkg/data"
+)
+
+// startMonitor connects a monitor named name and waits until the server streams to it.
+// The returned channel receives the error of the monitor once it disconnects.
+func startMonitor(t *testing.T, app *application, addr string, name string) (chan error, context.CancelFunc) {
+	t.Helper()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	c := &client.Client{Addr: addr, Timeout: client.DefaultTimeout, Name: name}
+	go func() {
+		done <- c.Monitor(ctx, "*", func(data.MonitorEntry) {})
+	}()
+	eventually(t, "the monitor has not connected", func() bool { return app.monitor.watching() == 1 })
+	t.Cleanup(cancel)
+
+	return done, cancel
+}
+
+// findClient returns the connection named name in a CLIENT LIST.
+func findClient(t *testing.T, c *client.Client, name string) data.ClientInfo {
+	t.Helper()
+
+	clients, err := c.ClientList()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, info := range clients {
+		if info.Name == name {
+			return info
+		}
+	}
+	t.Fatalf("expected a connection named %s but got %+v", name, clients)
+	return data.ClientInfo{}
+}
+
+func TestClients(t *testing.T) {
+	t.Run("should list the open connections", func(tt *testing.T) {
+		app, addr := newTestApplication(tt, config{})
+		startMonitor(tt, app, addr, "dashboard")
+		app.clock.(*clock.Fake).Advance(time.Second * 10)
+
+		c := &client.Client{Addr: addr, Timeout: client.DefaultTimeout, Name: "ops"}
+		monitor := findClient(tt, c, "dashboard")
+		if monitor.LastCommand != data.OperationMonitor || monitor.Addr == "" {
+			tt.Errorf("expected the MONITOR connection but got %+v", monitor)
+		}
+		if monitor.Age != time.Second*10 {
+			tt.Errorf("expected an age of 10s but got %s", monitor.Age)
+		}
+		if monitor.BytesIn == 0 || monitor.BytesOut == 0 {
+			tt.Errorf("expected the bytes read and written to be counted but got %+v", monitor)
+		}
+
+		self := findClient(tt, c, "ops")
+		if self.LastCommand != data.OperationClient || self.ID <= monitor.ID {
+			tt.Errorf("expected the CLIENT LIST connection but got %+v", self)
+		}
+	})
+
+	t.Run("should kill a connection by id", func(tt *testing.T) {
+		app, addr := newTestApplication(tt, config{})
+		done, _ := startMonitor(tt, app, addr, "dashboard")
+		c := client.New(addr)
+
+		monitor := findClient(tt, c, "dashboard")
+		if err := c.ClientKill(strconv.FormatInt(monitor.ID, 10)); err != nil {
+			tt.Fatal(err)
+		}
+
+		if err := <-done; err == nil || errors.Is(err, context.Canceled) {
+			tt.Errorf("expected the monitor to be disconnected but got %v", err)
+		}
+		eventually(tt, "the connection has not been unregistered", func() bool {
+			for _, info := range app.clients.List() {
+				if info.ID == monitor.ID {
+					return false
+				}
+			}
+			return true
+		})
+	})
+
+	t.Run("should kill a connection by address", func(tt *testing.T) {
+		app, addr := newTestApplication(tt, config{})
+		done, _ := startMonitor(tt, app, addr, "dashboard")
+		c := client.New(addr)
+
+		monitor := findClient(tt, c, "dashboard")
+		if err := c.ClientKill(monitor.Addr); err != nil {
+			tt.Fatal(err)
+		}
+
+		if err := <-done; err == nil || errors.Is(err, context.Canceled) {
+			tt.Errorf("expected the monitor to be disconnected but got %v", err)
+		}
+	})
+
+	t.Run("should fail to kill an unknown connection", func(tt *testing.T) {
+		_, addr := newTestApplication(tt, config{})
+		c := client.New(addr)
+
+		for _, target := range []string{"999", "127.0.0.1:1"} {
+			if err := c.ClientKill(target); err == nil || err.Error() != ErrNoSuchClient.Error() {
+				tt.Errorf("expected %v but got %v", ErrNoSuchClient, err)
+			}
+		}
+	})
+
+	t.Run("should name a connection with CLIENT SETNAME", func(tt *testing.T) {
+		_, addr := newTestApplication(tt, config{})
+
+		res := sendRequest(tt, addr, []byte("CLIENT SETNAME worker\n"))
+		if res.Status != data.ResponseStatusOK {
+			tt.Errorf("expected status %s but got %s", data.ResponseStatusOK, res)
+		}
+		res = sendRequest(tt, addr, []byte("CLIENT SETNAME two words\n"))
+		if res.Status != data.ResponseStatusError || res.Message != data.ErrInvalidClientName.Error() {
+			tt.Errorf("expected '%s' but got %s", data.ErrInvalidClientName, res)
+		}
+	})
+
+	t.Run("should name a connection with a CLIENT SETNAME line", func(tt *testing.T) {
+		app, addr := newTestApplication(tt, config{})
+
+		res := sendRequest(tt, addr, []byte("CLIENT SETNAME worker\nSET foo bar\n"))
+		if res.Status != data.ResponseStatusOK {
+			tt.Fatalf("expected status %s but got %s", data.ResponseStatusOK, res)
+		}
+		if value, ok := app.storage.Get("foo"); !ok || value != "bar" {
+			tt.Errorf("expected foo to be bar but got '%s'", value)
+		}
+	})
+
+	t.Run("should not name a connection whose request is denied", func(tt *testing.T) {
+		usersFile := writeUsersFile(tt, "admin s3cret * *")
+		app, _ := newTestApplication(tt, config{usersFile: usersFile})
+
+		server, conn := net.Pipe()
+		defer conn.Close()
+		client := app.clients.Register(server)
+		app.connectionGroup.Add(1)
+		go app.handleConnection(client)
+
+		if _, err := conn.Write([]byte("AUTH admin wrong\nCLIENT SETNAME intruder\nGET foo\n")); err != nil {
+			tt.Fatal(err)
+		}
+		res, err := io.ReadAll(conn)
+		if err != nil {
+			tt.Fatal(err)
+		}
+		if !strings.HasPrefix(string(res), data.ResponseStatusError) {
+			tt.Fatalf("expected the request to be denied but got '%s'", res)
+		}
+		if info := client.info(app.clock.Now()); info.Name != "" {
+			tt.Errorf("expected the connection to have no name but got '%s'", info.Name)
+		}
+	})
+}
@@ -0,0 +1,244 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"net"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/JorgeLNJunior/cacher/pkg/client"
+	"github.com/JorgeLNJunior/cacher/pkg/data"
+	"github.com/JorgeLNJunior/cacher/pkg/dumpfile"
+	levellog "github.com/JorgeLNJunior/cacher/pkg/logger"
+)
+
+// Kinds of the RAFT messages, sent as the key of the requests.
+const (
+	raftMessageVote     = "vote"
+	raftMessageAppend   = "append"
+	raftMessageSnapshot = "snapshot"
+)
+
+const (
+	raftDir                = "raft" // directory of the raft log in the data directory
+	defaultSnapshotEntries = 10_000
+)
+
+var (
+	ErrClusterMode           = errors.New("the operation is not supported in cluster mode")
+	ErrInvalidClusterMembers = errors.New("the cluster must be HOST:PORT addresses separated by commas, including the cluster address")
+	ErrInvalidRaftMessage    = errors.New("RAFT expects vote, append or snapshot as the key")
+)
+
+// DefaultRaftConfig returns the configuration of a node of a cluster: the leader sends
+// heartbeats every 100ms and the followers start an election after 1s to 2s without one.
+func DefaultRaftConfig(id string, members []string, snapshotEntries int64) RaftConfig {
+	return RaftConfig{
+		ID:              id,
+		Members:         members,
+		TickInterval:    time.Millisecond * 100,
+		ElectionTicks:   10,
+		HeartbeatTicks:  1,
+		SnapshotEntries: snapshotEntries,
+		CommitTimeout:   time.Second * 5,
+	}
+}
+
+// ParseClusterMembers parses the comma separated addresses of the nodes of a cluster,
+// which must include the address of the node itself.
+func ParseClusterMembers(members string, self string) ([]string, error) {
+	parsed := make([]string, 0)
+	for member := range strings.SplitSeq(members, ",") {
+		member = strings.TrimSpace(member)
+		if _, _, err := net.SplitHostPort(member); err != nil {
+			return nil, ErrInvalidClusterMembers
+		}
+		if !slices.Contains(parsed, member) {
+			parsed = append(parsed, member)
+		}
+	}
+
+	if !slices.Contains(parsed, self) {
+		return nil, ErrInvalidClusterMembers
+	}
+	return parsed, nil
+}
+
+// startCluster makes the server a node of a cluster. Its storage must already have
+// the data of the last snapshot, which is restored from the dump.
+func (app *application) startCluster(cfg RaftConfig, transport RaftTransport) error {
+	log, state, entries, err := OpenRaftLog(app.persistanceStorage.Path(raftDir), app.keyring)
+	if err != nil {
+		return err
+	}
+
+	app.raft = NewRaftNode(cfg, app.clock, log, state, entries, clusterStateMachine{app}, transport)
+	app.raft.Start(func(err error) {
+		app.logger.Error("error persisting the cluster state", levellog.Args{"err": err.Error()})
+	})
+
+	app.logger.Info("the server has joined the cluster", levellog.Args{
+		"addr":    cfg.ID,
+		"members": strings.Join(cfg.Members, ","),
+	})
+	return nil
+}
+
+// handleClusterWrite commits a write through the cluster before answering it, so an
+// acknowledged write is on the disk of a majority of the nodes.
+func (app *application) handleClusterWrite(conn net.Conn, req data.Request) {
+	if req.Operation == data.OperationRestore {
+		app.errorResponse(conn, ErrClusterMode) // it would bypass the log
+		return
+	}
+
+	m := Mutation{Operation: req.Operation, Key: req.Key, Value: req.Value, Expiry: req.Expiry}
+	if req.Operation == data.OperationSet {
+		m.Expiry = app.clock.Now().Add(defaultExpiry) // so every node expires the key at the same time
+	}
+
+	if err := app.raft.Propose(m); err != nil {
+		app.errorResponse(conn, err)
+		return
+	}
+	app.okResponse(conn, writeMessages[req.Operation])
+}
+
+// handleRaft answers a message from another node of the cluster. The message is read
+// until the node closes its side of the connection.
+func (app *application) handleRaft(conn net.Conn, req data.Request, raw []byte) {
+	if app.raft == nil {
+		app.errorResponse(conn, ErrClusterMode)
+		return
+	}
+
+	payload, _ := bytes.CutPrefix(raw, []byte(req.Operation.String()+" "+req.Key+" "))
+	payload = bytes.Clone(payload)
+	if err := conn.SetReadDeadline(time.Now().Add(adminTimeout)); err != nil {
+		app.errorResponse(conn, err)
+		return
+	}
+	rest, err := app.readRest(conn, len(raw))
+	if errors.Is(err, data.ErrTooLarge) {
+		app.rejectTooLarge(conn, err)
+		return
+	}
+	if err != nil {
+		app.errorResponse(conn, err)
+		return
+	}
+	payload = append(payload, rest...)
+
+	var res any
+	switch req.Key {
+	case raftMessageVote:
+		message := voteRequest{}
+		if err = json.Unmarshal(payload, &message); err == nil {
+			res = app.raft.HandleVote(message)
+		}
+	case raftMessageAppend:
+		message := appendRequest{}
+		if err = json.Unmarshal(payload, &message); err == nil {
+			res = app.raft.HandleAppend(message)
+		}
+	case raftMessageSnapshot:
+		message := snapshotRequest{}
+		if err = json.Unmarshal(payload, &message); err == nil {
+			res = app.raft.HandleSnapshot(message)
+		}
+	default:
+		err = ErrInvalidRaftMessage
+	}
+	if err != nil {
+		app.errorResponse(conn, err)
+		return
+	}
+
+	message, err := json.Marshal(res)
+	if err != nil {
+		app.errorResponse(conn, err)
+		return
+	}
+	app.okResponse(conn, string(message))
+}
+
+// clusterStateMachine applies the log of a cluster to the storage of a server. The
+// snapshots of the log are the dumps of the server.
+type clusterStateMachine struct {
+	app *application
+}
+
+func (m clusterStateMachine) Apply(mutation Mutation) {
+	applyMutation(m.app.storage, mutation)
+}
+
+func (m clusterStateMachine) Snapshot() error {
+	ctx, cancel := context.WithTimeout(context.Background(), adminTimeout)
+	defer cancel()
+
+	return m.app.persistanceStorage.Persist(ctx, m.app.storage)
+}
+
+func (m clusterStateMachine) ReadSnapshot() ([]byte, error) {
+	snapshot := &bytes.Buffer{}
+	err := WriteSnapshot(snapshot, m.app.storage, dumpfile.FormatBinary, false, nil)
+	return snapshot.Bytes(), err
+}
+
+func (m clusterStateMachine) RestoreSnapshot(snapshot []byte) error {
+	dump, err := dumpfile.Read(bytes.NewReader(snapshot), nil, 0) // the snapshots are not compressed
+	if err != nil {
+		return err
+	}
+	m.app.storage.Replace(dump)
+
+	return m.Snapshot()
+}
+
+// tcpRaftTransport sends the messages of a node as RAFT requests.
+type tcpRaftTransport struct {
+	timeout     time.Duration     // max duration of a vote or an append
+	tls         *tls.Config       // nil for plain tcp
+	credentials *data.Credentials // nil if the nodes don't authenticate the requests
+}
+
+func (t tcpRaftTransport) RequestVote(peer string, req voteRequest) (voteResponse, error) {
+	res := voteResponse{}
+	err := t.call(peer, raftMessageVote, req, &res, t.timeout)
+	return res, err
+}
+
+func (t tcpRaftTransport) AppendEntries(peer string, req appendRequest) (appendResponse, error) {
+	res := appendResponse{}
+	err := t.call(peer, raftMessageAppend, req, &res, t.timeout)
+	return res, err
+}
+
+func (t tcpRaftTransport) InstallSnapshot(peer string, req snapshotRequest) (snapshotResponse, error) {
+	res := snapshotResponse{}
+	err := t.call(peer, raftMessageSnapshot, req, &res, adminTimeout)
+	return res, err
+}
+
+func (t tcpRaftTransport) call(peer string, kind string, req any, res any, timeout time.Duration) error {
+	payload, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+
+	c := &client.Client{Addr: peer, Timeout: timeout, TLSConfig: t.tls, Credentials: t.credentials}
+	message, err := c.Do(data.Request{Operation: data.OperationRaft, Key: kind, Value: string(payload)})
+	if err != nil {
+		return err
+	}
+	if message.Status != data.ResponseStatusOK {
+		return errors.New(message.Message)
+	}
+
+	return json.Unmarshal([]byte(message.Message), res)
+}
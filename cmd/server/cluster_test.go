@@ -0,0 +1,465 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/JorgeLNJunior/cacher/pkg/client"
+	"github.com/JorgeLNJunior/cacher/pkg/clock"
+	"github.com/JorgeLNJunior/cacher/pkg/data"
+	"github.com/JorgeLNJunior/cacher/pkg/dumpfile"
+	levellog "github.com/JorgeLNJunior/cacher/pkg/logger"
+)
+
+// testNetwork connects the nodes of a test cluster and can isolate some of them.
+type testNetwork struct {
+	mu       sync.Mutex
+	isolated map[string]bool
+}
+
+func (n *testNetwork) isolate(addr string, isolated bool) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	n.isolated[addr] = isolated
+}
+
+func (n *testNetwork) isIsolated(addr string) bool {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	return n.isolated[addr]
+}
+
+func (n *testNetwork) reachable(from string, to string) bool {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	return !n.isolated[from] && !n.isolated[to]
+}
+
+var errIsolated = errors.New("the node is isolated")
+
+// testTransport sends the messages of a node through a testNetwork.
+type testTransport struct {
+	tcpRaftTransport
+	network *testNetwork
+	from    string
+}
+
+func (t testTransport) RequestVote(peer string, req voteRequest) (voteResponse, error) {
+	if !t.network.reachable(t.from, peer) {
+		return voteResponse{}, errIsolated
+	}
+	return t.tcpRaftTransport.RequestVote(peer, req)
+}
+
+func (t testTransport) AppendEntries(peer string, req appendRequest) (appendResponse, error) {
+	if !t.network.reachable(t.from, peer) {
+		return appendResponse{}, errIsolated
+	}
+	return t.tcpRaftTransport.AppendEntries(peer, req)
+}
+
+func (t testTransport) InstallSnapshot(peer string, req snapshotRequest) (snapshotResponse, error) {
+	if !t.network.reachable(t.from, peer) {
+		return snapshotResponse{}, errIsolated
+	}
+	return t.tcpRaftTransport.InstallSnapshot(peer, req)
+}
+
+type testNode struct {
+	addr     string
+	dataDir  string
+	app      *application
+	listener net.Listener
+	serving  chan struct{} // closed once the listener stops accepting connections
+}
+
+// testCluster runs the nodes of a cluster in the test process, each with its own
+// listener and data directory.
+type testCluster struct {
+	t               *testing.T
+	nodes           []*testNode
+	members         []string
+	network         *testNetwork
+	snapshotEntries int64
+}
+
+func newTestCluster(t *testing.T, size int, snapshotEntries int64) *testCluster {
+	t.Helper()
+
+	c := &testCluster{
+		t:               t,
+		network:         &testNetwork{isolated: make(map[string]bool)},
+		snapshotEntries: snapshotEntries,
+	}
+	for range size {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		node := &testNode{addr: listener.Addr().String(), dataDir: t.TempDir(), listener: listener}
+		c.nodes = append(c.nodes, node)
+		c.members = append(c.members, node.addr)
+	}
+
+	for i := range c.nodes {
+		c.start(i)
+	}
+	t.Cleanup(func() {
+		for i := range c.nodes {
+			c.stop(i)
+		}
+	})
+
+	return c
+}
+
+// start starts a node, restoring its data directory.
+func (c *testCluster) start(i int) {
+	c.t.Helper()
+
+	node := c.nodes[i]
+	if node.listener == nil {
+		listener, err := net.Listen("tcp", node.addr)
+		if err != nil {
+			c.t.Fatal(err)
+		}
+		node.listener = listener
+	}
+
+	systemClock := clock.New()
+	disk, err := NewOnDiskStorage(OnDiskConfig{DataDir: node.dataDir, Format: dumpfile.FormatBinary})
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	app := &application{
+		config:             config{dumpFormat: string(dumpfile.FormatBinary), restoreConflicts: string(ConflictMemoryWins)},
+		clock:              systemClock,
+		logger:             levellog.NewLogger(levellog.LevelFatal, io.Discard),
+		storage:            NewInMemoryStorageWithClock(systemClock),
+		persistanceStorage: disk,
+	}
+	app.replicator = NewReplicator(app.storage)
+	app.monitor = NewMonitor()
+	app.clients = NewClients(app.clock)
+	if _, err := disk.Restore(context.Background(), app.storage, ConflictMemoryWins); err != nil {
+		c.t.Fatal(err)
+	}
+
+	cfg := RaftConfig{
+		ID:              node.addr,
+		Members:         c.members,
+		TickInterval:    time.Millisecond * 10,
+		ElectionTicks:   10,
+		HeartbeatTicks:  2,
+		SnapshotEntries: c.snapshotEntries,
+		CommitTimeout:   time.Millisecond * 500,
+	}
+	transport := testTransport{tcpRaftTransport{timeout: time.Millisecond * 100}, c.network, node.addr}
+	if err := app.startCluster(cfg, transport); err != nil {
+		c.t.Fatal(err)
+	}
+	node.app = app
+	node.serving = make(chan struct{})
+
+	go func(listener net.Listener, serving chan struct{}) {
+		defer close(serving)
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			app.connectionGroup.Add(1)
+			go app.handleConnection(conn)
+		}
+	}(node.listener, node.serving)
+}
+
+// stop stops a node as if its process had exited.
+func (c *testCluster) stop(i int) {
+	node := c.nodes[i]
+	if node.app == nil {
+		return
+	}
+
+	node.listener.Close()
+	node.listener = nil
+	<-node.serving
+	node.app.raft.Stop()
+	node.app.replicator.Close()
+	node.app.monitor.Close()
+	node.app.connectionGroup.Wait()
+	node.app.storage.Close()
+	node.app.persistanceStorage.Close()
+	node.app = nil
+}
+
+// leader waits until every running node that is not isolated knows the same leader
+// and returns it.
+func (c *testCluster) leader() *testNode {
+	c.t.Helper()
+
+	var leader *testNode
+	waitFor(c.t, "a leader to be elected", func() bool {
+		leader = nil
+		known := ""
+		for _, node := range c.nodes {
+			if node.app == nil || c.network.isIsolated(node.addr) {
+				continue
+			}
+			role, nodeLeader, _ := node.app.raft.Status()
+			if nodeLeader == "" || known != "" && nodeLeader != known {
+				return false
+			}
+			known = nodeLeader
+			if role == RaftLeader {
+				leader = node
+			}
+		}
+		return leader != nil && leader.addr == known
+	})
+	return leader
+}
+
+// followers returns the running nodes except node.
+func (c *testCluster) followers(node *testNode) []*testNode {
+	followers := make([]*testNode, 0)
+	for _, other := range c.nodes {
+		if other != node && other.app != nil {
+			followers = append(followers, other)
+		}
+	}
+	return followers
+}
+
+// waitForValue waits until a key has value on every running node.
+func (c *testCluster) waitForValue(key string, value string) {
+	c.t.Helper()
+
+	for _, node := range c.nodes {
+		if node.app == nil {
+			continue
+		}
+		waitFor(c.t, key+" to be replicated to "+node.addr, func() bool {
+			stored, _ := node.app.storage.Get(key)
+			return stored == value
+		})
+	}
+}
+
+func waitFor(t *testing.T, what string, condition func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(time.Second * 10)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timeout waiting for %s", what)
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+}
+
+func TestCluster(t *testing.T) {
+	t.Run("should replicate the writes of the leader to every node", func(tt *testing.T) {
+		c := newTestCluster(tt, 3, defaultSnapshotEntries)
+		leader := c.leader()
+
+		res := sendRequest(tt, leader.addr, []byte("SET foo bar"))
+		if res.Status != data.ResponseStatusOK {
+			tt.Fatalf("expected status OK but got %s: %s", res.Status, res.Message)
+		}
+		c.waitForValue("foo", "bar")
+
+		res = sendRequest(tt, leader.addr, []byte("DEL foo"))
+		if res.Status != data.ResponseStatusOK {
+			tt.Fatalf("expected status OK but got %s: %s", res.Status, res.Message)
+		}
+		c.waitForValue("foo", "")
+	})
+
+	t.Run("should replicate the values that are not valid UTF-8", func(tt *testing.T) {
+		c := newTestCluster(tt, 3, defaultSnapshotEntries)
+		leader := c.leader()
+
+		res := sendRequest(tt, leader.addr, []byte("SET foo \xff\xfe\x80"))
+		if res.Status != data.ResponseStatusOK {
+			tt.Fatalf("expected status OK but got %s: %s", res.Status, res.Message)
+		}
+		c.waitForValue("foo", "\xff\xfe\x80")
+	})
+
+	t.Run("should redirect writes to the leader", func(tt *testing.T) {
+		c := newTestCluster(tt, 3, defaultSnapshotEntries)
+		leader := c.leader()
+		follower := c.followers(leader)[0]
+
+		res := sendRequest(tt, follower.addr, []byte("SET foo bar"))
+		notLeader, ok := data.ParseNotLeader(res.Message)
+		if res.Status != data.ResponseStatusError || !ok {
+			tt.Fatalf("expected a redirect but got %s: %s", res.Status, res.Message)
+		}
+		if notLeader.Leader != leader.addr {
+			tt.Errorf("expected to be redirected to %s but got %s", leader.addr, notLeader.Leader)
+		}
+
+		if err := client.New(follower.addr).Set("foo", "bar"); err != nil {
+			tt.Fatalf("expected the client to follow the redirect but got %s", err)
+		}
+		c.waitForValue("foo", "bar")
+	})
+
+	t.Run("should elect a new leader when the leader stops", func(tt *testing.T) {
+		c := newTestCluster(tt, 3, defaultSnapshotEntries)
+		leader := c.leader()
+		if err := client.New(leader.addr).Set("foo", "bar"); err != nil {
+			tt.Fatal(err)
+		}
+
+		for i, node := range c.nodes {
+			if node == leader {
+				c.stop(i)
+			}
+		}
+
+		newLeader := c.leader()
+		c.waitForValue("foo", "bar") // the new leader has the acknowledged write in its log
+		if err := client.New(newLeader.addr).Set("baz", "qux"); err != nil {
+			tt.Fatal(err)
+		}
+		c.waitForValue("baz", "qux")
+	})
+
+	t.Run("should not commit writes without a majority", func(tt *testing.T) {
+		c := newTestCluster(tt, 3, defaultSnapshotEntries)
+		leader := c.leader()
+		c.network.isolate(leader.addr, true)
+
+		res := sendRequest(tt, leader.addr, []byte("SET foo bar"))
+		if res.Status != data.ResponseStatusError {
+			tt.Fatalf("expected status ERROR but got %s: %s", res.Status, res.Message)
+		}
+
+		newLeader := c.leader()
+		if err := client.New(newLeader.addr).Set("baz", "qux"); err != nil {
+			tt.Fatal(err)
+		}
+
+		c.network.isolate(leader.addr, false)
+		c.waitForValue("baz", "qux")
+		for _, node := range c.nodes {
+			if value, found := node.app.storage.Get("foo"); found {
+				tt.Errorf("expected the uncommitted write to be discarded but %s has '%s'", node.addr, value)
+			}
+		}
+	})
+
+	t.Run("should catch up a lagging node with a snapshot", func(tt *testing.T) {
+		c := newTestCluster(tt, 3, 5)
+		leader := c.leader()
+		lagging := c.followers(leader)[0]
+		c.network.isolate(lagging.addr, true)
+
+		for i := range 20 {
+			if err := client.New(leader.addr).Set("key:"+strconv.Itoa(i), "value"); err != nil {
+				tt.Fatal(err)
+			}
+		}
+		leader.app.raft.mu.Lock()
+		compacted := leader.app.raft.state.SnapshotIndex
+		leader.app.raft.mu.Unlock()
+		if compacted == 0 {
+			tt.Fatal("expected the log of the leader to be compacted")
+		}
+
+		c.network.isolate(lagging.addr, false)
+		for i := range 20 {
+			c.waitForValue("key:"+strconv.Itoa(i), "value")
+		}
+	})
+
+	t.Run("should restore the nodes from their snapshots and logs", func(tt *testing.T) {
+		c := newTestCluster(tt, 3, 5)
+		leader := c.leader()
+		for i := range 12 {
+			if err := client.New(leader.addr).Set("key:"+strconv.Itoa(i), "value"); err != nil {
+				tt.Fatal(err)
+			}
+		}
+
+		for i := range c.nodes {
+			c.stop(i)
+		}
+		for i := range c.nodes {
+			c.start(i)
+		}
+
+		c.leader()
+		for i := range 12 {
+			c.waitForValue("key:"+strconv.Itoa(i), "value")
+		}
+	})
+
+	t.Run("should reject operations that bypass the log", func(tt *testing.T) {
+		c := newTestCluster(tt, 1, defaultSnapshotEntries)
+		leader := c.leader()
+
+		for _, request := range []string{"REPLICAOF 127.0.0.1:1", "RESTORE backup"} {
+			res := sendRequest(tt, leader.addr, []byte(request))
+			if res.Message != ErrClusterMode.Error() {
+				tt.Errorf("expected '%s' to be rejected but got %s: %s", request, res.Status, res.Message)
+			}
+		}
+	})
+}
+
+func TestClusterStateMachine(t *testing.T) {
+	t.Run("should never write the snapshot to the temporary directory", func(tt *testing.T) {
+		app := &application{storage: NewInMemoryStorage()}
+		defer app.storage.Close()
+		app.storage.Set("secret", "value")
+		// any temporary file would fail the snapshot
+		tt.Setenv("TMPDIR", filepath.Join(tt.TempDir(), "missing"))
+
+		snapshot, err := clusterStateMachine{app}.ReadSnapshot()
+		if err != nil {
+			tt.Fatal(err)
+		}
+		dump, err := dumpfile.Read(bytes.NewReader(snapshot), nil, 0)
+		if err != nil {
+			tt.Fatal(err)
+		}
+		if dump["secret"].Value != "value" {
+			tt.Errorf("expected the snapshot to have the key secret but got %+v", dump)
+		}
+	})
+}
+
+func TestParseClusterMembers(t *testing.T) {
+	t.Run("should parse the members", func(tt *testing.T) {
+		members, err := ParseClusterMembers("127.0.0.1:1, 127.0.0.1:2,127.0.0.1:3", "127.0.0.1:2")
+		if err != nil {
+			tt.Fatal(err)
+		}
+		if len(members) != 3 || members[1] != "127.0.0.1:2" {
+			tt.Errorf("expected 3 members but got %v", members)
+		}
+	})
+
+	t.Run("should return ErrInvalidClusterMembers", func(tt *testing.T) {
+		for _, members := range []string{"127.0.0.1:1,127.0.0.1:2", "127.0.0.1:1,foo", ""} {
+			if _, err := ParseClusterMembers(members, "127.0.0.1:3"); !errors.Is(err, ErrInvalidClusterMembers) {
+				tt.Errorf("expected ErrInvalidClusterMembers for '%s' but got %v", members, err)
+			}
+		}
+	})
+}
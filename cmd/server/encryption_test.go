@@ -0,0 +1,157 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/JorgeLNJunior/cacher/pkg/clock"
+	"github.com/JorgeLNJunior/cacher/pkg/encryption"
+)
+
+const (
+	testEncryptionKey = "000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f"
+	testRotatedKey    = "1f1e1d1c1b1a191817161514131211100f0e0d0c0b0a09080706050403020100"
+)
+
+func newTestKeyring(t *testing.T, keys ...string) *encryption.Keyring {
+	t.Helper()
+
+	keyring, err := encryption.ParseKeyring(strings.Join(keys, "\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return keyring
+}
+
+func TestEncryptedPersistance(t *testing.T) {
+	t.Run("should persist an encrypted dump and restore it", func(tt *testing.T) {
+		disk := persistEncryptedTestDump(tt)
+
+		content, err := os.ReadFile(disk.dumpPath(0))
+		if err != nil {
+			tt.Fatal(err)
+		}
+		if bytes.Contains(content, []byte("bar")) {
+			tt.Fatal("the dump has been persisted in plaintext")
+		}
+
+		storage := NewInMemoryStorage()
+		defer storage.Close()
+		if _, err := disk.Restore(context.Background(), storage, ConflictFail); err != nil {
+			tt.Fatal(err)
+		}
+		if value, _ := storage.Get("foo"); value != "bar" {
+			tt.Fatalf("expected 'bar' but got '%s'", value)
+		}
+	})
+
+	t.Run("should fail to restore an encrypted dump without a key", func(tt *testing.T) {
+		disk := persistEncryptedTestDump(tt)
+		disk.keyring = nil
+
+		storage := NewInMemoryStorage()
+		defer storage.Close()
+		if _, err := disk.Restore(context.Background(), storage, ConflictFail); !errors.Is(err, encryption.ErrEncrypted) {
+			tt.Fatalf("expected ErrEncrypted but got '%v'", err)
+		}
+	})
+
+	t.Run("should fail to restore an encrypted dump with the wrong key", func(tt *testing.T) {
+		disk := persistEncryptedTestDump(tt)
+		disk.keyring = newTestKeyring(tt, testRotatedKey)
+
+		storage := NewInMemoryStorage()
+		defer storage.Close()
+		if _, err := disk.Restore(context.Background(), storage, ConflictFail); !errors.Is(err, encryption.ErrUnknownEncryptionKey) {
+			tt.Fatalf("expected ErrUnknownEncryptionKey but got '%v'", err)
+		}
+		if len(storage.data) != 0 {
+			tt.Fatalf("expected no restored keys but got %d", len(storage.data))
+		}
+	})
+
+	t.Run("should restore a plaintext dump after encryption is enabled", func(tt *testing.T) {
+		disk := persistTestDump(tt)
+		disk.keyring = newTestKeyring(tt, testEncryptionKey)
+
+		storage := NewInMemoryStorage()
+		defer storage.Close()
+		if _, err := disk.Restore(context.Background(), storage, ConflictFail); err != nil {
+			tt.Fatal(err)
+		}
+	})
+
+	t.Run("should replay an encrypted operation log", func(tt *testing.T) {
+		path := filepath.Join(tt.TempDir(), "oplog")
+		clock := clock.NewFake(testEpoch)
+		keyring := newTestKeyring(tt, testEncryptionKey)
+
+		source := newLoggedStorage(tt, clock, path)
+		source.Set("foo", "bar")
+		closeLoggedStorage(tt, source)
+
+		// records appended after the key is configured are encrypted
+		source = newEncryptedLoggedStorage(tt, clock, path, keyring)
+		source.Set("baz", "qux")
+		closeLoggedStorage(tt, source)
+
+		content, err := os.ReadFile(path)
+		if err != nil {
+			tt.Fatal(err)
+		}
+		if bytes.Contains(content, []byte("qux")) {
+			tt.Fatal("the operation has been logged in plaintext")
+		}
+
+		target := newEncryptedLoggedStorage(tt, clock, path, newTestKeyring(tt, testRotatedKey, testEncryptionKey))
+		defer closeLoggedStorage(tt, target)
+		if value, _ := target.Get("foo"); value != "bar" {
+			tt.Fatalf("expected 'bar' but got '%s'", value)
+		}
+		if value, _ := target.Get("baz"); value != "qux" {
+			tt.Fatalf("expected 'qux' but got '%s'", value)
+		}
+	})
+
+	t.Run("should fail to replay an encrypted operation log without a key", func(tt *testing.T) {
+		path := filepath.Join(tt.TempDir(), "oplog")
+		clock := clock.NewFake(testEpoch)
+
+		source := newEncryptedLoggedStorage(tt, clock, path, newTestKeyring(tt, testEncryptionKey))
+		source.Set("foo", "bar")
+		closeLoggedStorage(tt, source)
+
+		oplog, err := OpenOperationLog(path, FsyncAlways, 0, nil)
+		if err != nil {
+			tt.Fatal(err)
+		}
+		defer oplog.Close()
+
+		storage := NewInMemoryStorageWithClock(clock)
+		defer storage.Close()
+		if _, err := oplog.Replay(storage); !errors.Is(err, encryption.ErrEncrypted) {
+			tt.Fatalf("expected ErrEncrypted but got '%v'", err)
+		}
+	})
+}
+
+func persistEncryptedTestDump(t *testing.T) *OnDiskStorage {
+	t.Helper()
+
+	disk := newTestOnDiskStorage(t)
+	disk.keyring = newTestKeyring(t, testEncryptionKey)
+
+	storage := NewInMemoryStorage()
+	defer storage.Close()
+	storage.Set("foo", "bar")
+
+	if err := disk.Persist(context.Background(), storage); err != nil {
+		t.Fatal(err)
+	}
+	return disk
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"container/heap"
+	"time"
+
+	"github.com/JorgeLNJunior/cacher/pkg/clock"
+	"github.com/JorgeLNJunior/cacher/pkg/data"
+)
+
+// expiryEntry is a key tracked by the expiry index.
+type expiryEntry struct {
+	key      string
+	expiry   time.Time
+	index    int
+	volatile bool // whether the expiry has been set explicitly, see StorageItem.TTL
+}
+
+// expiryIndex is a min-heap of keys ordered by their expiration date.
+// It implements heap.Interface and must be used through the container/heap functions.
+type expiryIndex []*expiryEntry
+
+func (e expiryIndex) Len() int { return len(e) }
+
+func (e expiryIndex) Less(i, j int) bool { return e[i].expiry.Before(e[j].expiry) }
+
+func (e expiryIndex) Swap(i, j int) {
+	e[i], e[j] = e[j], e[i]
+	e[i].index = i
+	e[j].index = j
+}
+
+func (e *expiryIndex) Push(x any) {
+	entry := x.(*expiryEntry)
+	entry.index = len(*e)
+	*e = append(*e, entry)
+}
+
+func (e *expiryIndex) Pop() any {
+	old := *e
+	n := len(old)
+	entry := old[n-1]
+	old[n-1] = nil
+	entry.index = -1
+	*e = old[:n-1]
+	return entry
+}
+
+// peek returns the entry closest to expire without removing it.
+func (e expiryIndex) peek() *expiryEntry {
+	if len(e) == 0 {
+		return nil
+	}
+	return e[0]
+}
+
+// track adds a key to the index or updates its expiration date if it's already tracked.
+// volatile is whether the expiry has been set explicitly.
+func (s *InMemoryStorage) track(key string, expiry time.Time, volatile bool) {
+	entry, found := s.expiries[key]
+	if found {
+		entry.expiry = expiry
+		heap.Fix(&s.expiryIndex, entry.index)
+	} else {
+		entry = &expiryEntry{key: key, expiry: expiry}
+		heap.Push(&s.expiryIndex, entry)
+		s.expiries[key] = entry
+	}
+
+	if volatile != entry.volatile {
+		entry.volatile = volatile
+		if volatile {
+			s.volatile++
+		} else {
+			s.volatile--
+		}
+	}
+}
+
+// untrack removes a key from the index.
+func (s *InMemoryStorage) untrack(key string) {
+	entry, found := s.expiries[key]
+	if !found {
+		return
+	}
+
+	heap.Remove(&s.expiryIndex, entry.index)
+	delete(s.expiries, key)
+	if entry.volatile {
+		s.volatile--
+	}
+}
+
+// deleteExpired removes up to limit expired keys, starting from the ones closest
+// to their deadline, and returns how many keys were removed.
+func (s *InMemoryStorage) deleteExpired(limit int) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.clock.Now()
+	removed := 0
+	for removed < limit {
+		entry := s.expiryIndex.peek()
+		if entry == nil || !now.After(entry.expiry) {
+			break
+		}
+
+		s.remove(entry.key)
+		s.expired++
+		s.notify(Mutation{Operation: data.OperationDel, Key: entry.key})
+		removed++
+	}
+
+	return removed
+}
+
+// sweep removes expired keys on every tick until the storage is closed.
+func (s *InMemoryStorage) sweep(ticker clock.Ticker) {
+	defer close(s.sweepDone)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-ticker.C():
+			start := time.Now()
+			s.deleteExpired(maxSweepBatch)
+			duration := time.Since(start)
+
+			s.mu.Lock()
+			observers := s.sweeps
+			s.mu.Unlock()
+			for _, observer := range observers {
+				observer(duration)
+			}
+		}
+	}
+}
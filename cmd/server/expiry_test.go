@@ -0,0 +1,126 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/JorgeLNJunior/cacher/pkg/clock"
+)
+
+var testEpoch = time.Unix(1_700_000_000, 0)
+
+func TestExpiry(t *testing.T) {
+	t.Run("should serve a key until its expiry", func(tt *testing.T) {
+		clock := clock.NewFake(testEpoch)
+		storage := NewInMemoryStorageWithClock(clock)
+		defer storage.Close()
+
+		storage.Set("foo", "bar")
+		storage.ExpireAt("foo", testEpoch.Add(time.Minute))
+
+		clock.Advance(time.Minute)
+		if _, ok := storage.Get("foo"); !ok {
+			tt.Fatal("the key has expired before its expiry")
+		}
+
+		clock.Advance(time.Second)
+		if _, ok := storage.Get("foo"); ok {
+			tt.Fatal("the key has been served after its expiry")
+		}
+		if storage.expiryIndex.Len() != 0 {
+			tt.Fatalf("expected an empty expiry index but got %d entries", storage.expiryIndex.Len())
+		}
+	})
+
+	t.Run("should expire keys without an explicit expiry after the default expiry", func(tt *testing.T) {
+		clock := clock.NewFake(testEpoch)
+		storage := NewInMemoryStorageWithClock(clock)
+		defer storage.Close()
+
+		storage.Set("foo", "bar")
+
+		clock.Advance(defaultExpiry + time.Second)
+		if _, ok := storage.Get("foo"); ok {
+			tt.Fatal("the key has been served after the default expiry")
+		}
+	})
+
+	t.Run("should keep the expiry index in sync when an expiry changes", func(tt *testing.T) {
+		clock := clock.NewFake(testEpoch)
+		storage := NewInMemoryStorageWithClock(clock)
+		defer storage.Close()
+
+		storage.Set("foo", "bar")
+		storage.ExpireAt("foo", testEpoch.Add(time.Second))
+		storage.ExpireAt("foo", testEpoch.Add(time.Hour))
+
+		clock.Advance(time.Minute)
+		if removed := storage.deleteExpired(maxSweepBatch); removed != 0 {
+			tt.Fatalf("expected no removed keys but got %d", removed)
+		}
+		if _, ok := storage.Get("foo"); !ok {
+			tt.Fatal("the key has expired with its previous expiry")
+		}
+	})
+}
+
+func TestSweep(t *testing.T) {
+	t.Run("should remove expired keys on every tick", func(tt *testing.T) {
+		clock := clock.NewFake(testEpoch)
+		storage := NewInMemoryStorageWithClock(clock)
+
+		storage.Set("foo", "bar")
+		storage.Set("baz", "qux")
+		storage.ExpireAt("foo", testEpoch.Add(time.Millisecond*50))
+
+		// Advance returns once the sweeper received the tick and Close waits for
+		// the sweep to finish, so there is no need to sleep.
+		clock.Advance(sweepInterval)
+		storage.Close()
+
+		if _, found := storage.data["foo"]; found {
+			tt.Fatal("the expired key has not been swept")
+		}
+		if _, found := storage.data["baz"]; !found {
+			tt.Fatal("a key that has not expired has been swept")
+		}
+	})
+
+	t.Run("should remove at most the given number of keys per sweep", func(tt *testing.T) {
+		clock := clock.NewFake(testEpoch)
+		storage := NewInMemoryStorageWithClock(clock)
+		storage.Close() // sweep by hand
+
+		for range 10 {
+			key := randomString()
+			storage.Set(key, randomString())
+			storage.ExpireAt(key, testEpoch.Add(time.Second))
+		}
+
+		clock.Advance(time.Second * 2)
+		if removed := storage.deleteExpired(4); removed != 4 {
+			tt.Fatalf("expected 4 removed keys but got %d", removed)
+		}
+		if len(storage.data) != 6 {
+			tt.Fatalf("expected 6 remaining keys but got %d", len(storage.data))
+		}
+	})
+
+	t.Run("should remove keys in expiry order", func(tt *testing.T) {
+		clock := clock.NewFake(testEpoch)
+		storage := NewInMemoryStorageWithClock(clock)
+		storage.Close() // sweep by hand
+
+		storage.Set("late", "bar")
+		storage.Set("early", "bar")
+		storage.ExpireAt("late", testEpoch.Add(time.Second*2))
+		storage.ExpireAt("early", testEpoch.Add(time.Second))
+
+		clock.Advance(time.Second * 3)
+		storage.deleteExpired(1)
+
+		if _, found := storage.data["early"]; found {
+			tt.Fatal("the key closest to its expiry has not been removed first")
+		}
+	})
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/JorgeLNJunior/cacher/pkg/data"
+)
+
+// infoOperations are the operations whose requests are counted, in the order INFO lists them.
+var infoOperations = []data.Operation{
+	data.OperationGet,
+	data.OperationSet,
+	data.OperationDel,
+	data.OperationExp,
+	data.OperationBackup,
+	data.OperationRestore,
+	data.OperationSync,
+	data.OperationRole,
+	data.OperationReplicaOf,
+	data.OperationRaft,
+	data.OperationAuth,
+	data.OperationInfo,
+	data.OperationSlowLog,
+	data.OperationMonitor,
+	data.OperationClient,
+}
+
+// commandStats counts the requests made to the server. The zero value is ready to use.
+type commandStats struct {
+	commands  map[data.Operation]int64
+	durations map[data.Operation]*histogram
+	hits      int64 // GETs of stored keys
+	misses    int64 // GETs of missing keys
+	mu        sync.Mutex
+}
+
+// count counts a request of an operation.
+func (s *commandStats) count(op data.Operation) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.commands == nil {
+		s.commands = make(map[data.Operation]int64)
+	}
+	s.commands[op]++
+}
+
+// lookup counts a GET of a key that is stored if hit is true or missing otherwise.
+func (s *commandStats) lookup(hit bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if hit {
+		s.hits++
+	} else {
+		s.misses++
+	}
+}
+
+// snapshot returns a copy of the counts of the requests by operation and the GET hits and misses.
+func (s *commandStats) snapshot() (map[data.Operation]int64, int64, int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return maps.Clone(s.commands), s.hits, s.misses
+}
+
+// ServerInfo describes the state of the server.
+type ServerInfo struct {
+	Uptime     time.Duration
+	Clients    int // open connections
+	Limits     LimitsStats
+	Commands   map[data.Operation]int64 // requests by operation
+	Hits       int64                    // GETs of stored keys
+	Misses     int64                    // GETs of missing keys
+	Storage    StorageStats
+	Snapshot   *PersistStatus // nil unless the data is persisted on disk
+	Goroutines int
+	Memory     runtime.MemStats
+}
+
+// HitRatio returns the ratio of the GETs of stored keys, or 0 if there were no GETs.
+func (i ServerInfo) HitRatio() float64 {
+	if i.Hits+i.Misses == 0 {
+		return 0
+	}
+	return float64(i.Hits) / float64(i.Hits+i.Misses)
+}
+
+// String returns the info as the KEY=VALUE fields of an INFO response.
+func (i ServerInfo) String() string {
+	fields := []string{
+		fmt.Sprintf("uptime_seconds=%d", int64(i.Uptime.Seconds())),
+		fmt.Sprintf("connected_clients=%d", i.Clients),
+		fmt.Sprintf("rejected_connections=%d", i.Limits.RejectedConnections),
+		fmt.Sprintf("throttled_requests=%d", i.Limits.ThrottledRequests),
+	}
+	for _, op := range infoOperations {
+		fields = append(fields, fmt.Sprintf("commands_%s=%d", strings.ToLower(op.String()), i.Commands[op]))
+	}
+	fields = append(fields,
+		fmt.Sprintf("get_hits=%d", i.Hits),
+		fmt.Sprintf("get_misses=%d", i.Misses),
+		fmt.Sprintf("get_hit_ratio=%.4f", i.HitRatio()),
+		fmt.Sprintf("keys=%d", i.Storage.Keys),
+		fmt.Sprintf("keys_with_ttl=%d", i.Storage.Volatile),
+		fmt.Sprintf("expired_keys=%d", i.Storage.Expired),
+		"evicted_keys=0", // keys are only removed when they expire, never to free memory
+		fmt.Sprintf("used_memory_bytes=%d", i.Storage.Memory),
+	)
+	if i.Snapshot != nil {
+		status := "ok"
+		if i.Snapshot.Failed {
+			status = "failed"
+		} else if i.Snapshot.Time.IsZero() {
+			status = "none"
+		}
+
+		var last int64
+		if !i.Snapshot.Time.IsZero() {
+			last = i.Snapshot.Time.Unix()
+		}
+		fields = append(fields,
+			fmt.Sprintf("last_snapshot_status=%s", status),
+			fmt.Sprintf("last_snapshot_time=%d", last),
+			fmt.Sprintf("last_snapshot_duration=%s", i.Snapshot.Duration),
+		)
+	}
+	fields = append(fields,
+		fmt.Sprintf("go_version=%s", runtime.Version()),
+		fmt.Sprintf("goroutines=%d", i.Goroutines),
+		fmt.Sprintf("heap_alloc_bytes=%d", i.Memory.HeapAlloc),
+		fmt.Sprintf("heap_objects=%d", i.Memory.HeapObjects),
+		fmt.Sprintf("sys_bytes=%d", i.Memory.Sys),
+		fmt.Sprintf("gc_runs=%d", i.Memory.NumGC),
+		fmt.Sprintf("gc_pause_total=%s", time.Duration(i.Memory.PauseTotalNs)),
+	)
+
+	return strings.Join(fields, " ")
+}
+
+// info returns the state of the server.
+func (app *application) info() ServerInfo {
+	info := ServerInfo{
+		Uptime:     app.clock.Now().Sub(app.started),
+		Storage:    app.storage.Stats(),
+		Goroutines: runtime.NumGoroutine(),
+	}
+	if app.limiter != nil {
+		info.Clients = app.limiter.Connections()
+		info.Limits = app.limiter.Stats()
+	}
+	if app.persistanceStorage != nil {
+		status := app.persistanceStorage.Status()
+		info.Snapshot = &status
+	}
+	runtime.ReadMemStats(&info.Memory)
+
+	info.Commands, info.Hits, info.Misses = app.commands.snapshot()
+
+	return info
+}
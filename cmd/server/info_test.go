@@ -0,0 +1,65 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/JorgeLNJunior/cacher/pkg/client"
+	"github.com/JorgeLNJunior/cacher/pkg/clock"
+)
+
+func TestInfo(t *testing.T) {
+	t.Run("should describe the state of the server", func(tt *testing.T) {
+		app, addr := newTestApplication(tt, config{})
+		c := client.New(addr)
+
+		if err := c.Set("foo", "bar"); err != nil {
+			tt.Fatal(err)
+		}
+		if err := c.Set("baz", "qux"); err != nil {
+			tt.Fatal(err)
+		}
+		if err := c.ExpireAt("baz", testEpoch.Add(time.Second)); err != nil {
+			tt.Fatal(err)
+		}
+		app.clock.(*clock.Fake).Advance(time.Minute)
+		if _, err := c.Get("foo"); err != nil {
+			tt.Fatal(err)
+		}
+		if _, err := c.Get("baz"); err == nil {
+			tt.Fatal("expected the key to have expired")
+		}
+
+		info, err := c.Info()
+		if err != nil {
+			tt.Fatal(err)
+		}
+
+		expected := map[string]string{
+			"uptime_seconds":    "60",
+			"connected_clients": "1",
+			"commands_set":      "2",
+			"commands_exp":      "1",
+			"commands_get":      "2",
+			"commands_info":     "1",
+			"get_hits":          "1",
+			"get_misses":        "1",
+			"get_hit_ratio":     "0.5000",
+			"keys":              "1",
+			"keys_with_ttl":     "0",
+			"expired_keys":      "1",
+			"evicted_keys":      "0",
+		}
+		for field, value := range expected {
+			if info[field] != value {
+				tt.Errorf("expected %s to be '%s' but got '%s'", field, value, info[field])
+			}
+		}
+		if _, found := info["last_snapshot_status"]; found {
+			tt.Error("expected no snapshot status without persistance")
+		}
+		if info["go_version"] == "" || info["heap_alloc_bytes"] == "" {
+			tt.Errorf("expected the runtime stats but got %v", info)
+		}
+	})
+}
@@ -0,0 +1,258 @@
+package main
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/JorgeLNJunior/cacher/pkg/clock"
+	"github.com/JorgeLNJunior/cacher/pkg/data"
+	levellog "github.com/JorgeLNJunior/cacher/pkg/logger"
+)
+
+const (
+	rejectTimeout  = time.Second // max duration of answering a rejected request or connection
+	minBucketSweep = 1024        // buckets kept before the full ones are dropped
+)
+
+var (
+	ErrTooManyConnections   = data.ThrottledError{Limit: data.LimitConnections}
+	ErrTooManyIPConnections = data.ThrottledError{Limit: data.LimitIPConnections}
+	ErrTooManyRequests      = data.ThrottledError{Limit: data.LimitRate}
+	ErrInvalidLimits        = errors.New("the connection and rate limits must not be negative")
+)
+
+// LimitsConfig limits the connections and the requests of the clients. A zero limit
+// disables it.
+type LimitsConfig struct {
+	MaxConnections      int
+	MaxConnectionsPerIP int
+	RequestsPerSecond   float64 // requests allowed per second to every address
+	Burst               int     // requests an address can make at once, at least 1
+
+	// Exempt are the addresses of the peers, the nodes of the cluster, the replicas and the
+	// sentinels, whose connections are never limited, so a limit can't starve the heartbeats
+	// and cause elections or failovers. The host names are resolved by NewLimiter.
+	Exempt []string
+}
+
+// LimitsStats counts the rejected connections since the server started.
+type LimitsStats struct {
+	RejectedConnections int64 // rejected by -max-connections or -max-connections-per-ip
+	ThrottledRequests   int64 // rejected by the rate limit
+}
+
+// Limiter enforces the limits of the connections. Every request uses a connection, so
+// the requests are limited when the connections are accepted.
+type Limiter struct {
+	cfg         LimitsConfig
+	clock       clock.Clock
+	connections int
+	perIP       map[string]int
+	buckets     map[string]*tokenBucket
+	exempt      map[string]bool // IP addresses of LimitsConfig.Exempt
+	sweepAt     int             // number of buckets that triggers a sweep
+	mu          sync.Mutex      // guards connections, perIP, buckets and sweepAt
+	rejected    atomic.Int64
+	throttled   atomic.Int64
+}
+
+// tokenBucket holds the requests an address can make right away. It's refilled at
+// the rate limit up to the burst.
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// NewLimiter returns a Limiter of cfg, ErrInvalidLimits or the error resolving an exempt address.
+func NewLimiter(cfg LimitsConfig, c clock.Clock) (*Limiter, error) {
+	if cfg.MaxConnections < 0 || cfg.MaxConnectionsPerIP < 0 || cfg.RequestsPerSecond < 0 || cfg.Burst < 0 {
+		return nil, ErrInvalidLimits
+	}
+	exempt, err := resolveIPs(cfg.Exempt)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg.Burst = max(cfg.Burst, 1)
+	return &Limiter{
+		cfg:     cfg,
+		clock:   c,
+		perIP:   make(map[string]int),
+		buckets: make(map[string]*tokenBucket),
+		exempt:  exempt,
+		sweepAt: minBucketSweep,
+	}, nil
+}
+
+// resolveIPs returns the IP addresses of hosts, with or without a port.
+func resolveIPs(hosts []string) (map[string]bool, error) {
+	ips := make(map[string]bool)
+	for _, host := range hosts {
+		if name, _, err := net.SplitHostPort(host); err == nil {
+			host = name
+		}
+		if ip := net.ParseIP(host); ip != nil {
+			ips[ip.String()] = true
+			continue
+		}
+
+		addrs, err := net.LookupHost(host)
+		if err != nil {
+			return nil, err
+		}
+		for _, addr := range addrs {
+			ips[addr] = true
+		}
+	}
+	return ips, nil
+}
+
+// Acquire counts a connection from ip, or returns a data.ThrottledError if it exceeds a limit.
+// The connections of the exempt addresses are counted but never rejected. Release must be called once an acquired connection is closed.
+func (l *Limiter) Acquire(ip string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.exempt[ip] {
+		l.connections++
+		l.perIP[ip]++
+		return nil
+	}
+	if l.cfg.MaxConnections > 0 && l.connections >= l.cfg.MaxConnections {
+		l.rejected.Add(1)
+		return ErrTooManyConnections
+	}
+	if l.cfg.MaxConnectionsPerIP > 0 && l.perIP[ip] >= l.cfg.MaxConnectionsPerIP {
+		l.rejected.Add(1)
+		return ErrTooManyIPConnections
+	}
+	if l.cfg.RequestsPerSecond > 0 && !l.take(ip) {
+		l.throttled.Add(1)
+		return ErrTooManyRequests
+	}
+
+	l.connections++
+	l.perIP[ip]++
+	return nil
+}
+
+// take takes a token of the bucket of ip if there's one.
+func (l *Limiter) take(ip string) bool {
+	now := l.clock.Now()
+	bucket, found := l.buckets[ip]
+	if !found {
+		if len(l.buckets) >= l.sweepAt {
+			l.sweep(now)
+		}
+		bucket = &tokenBucket{tokens: float64(l.cfg.Burst), last: now}
+		l.buckets[ip] = bucket
+	}
+
+	bucket.tokens = l.refill(bucket, now)
+	bucket.last = now
+	if bucket.tokens < 1 {
+		return false
+	}
+	bucket.tokens--
+	return true
+}
+
+// refill returns the tokens of a bucket at now.
+func (l *Limiter) refill(bucket *tokenBucket, now time.Time) float64 {
+	return min(bucket.tokens+now.Sub(bucket.last).Seconds()*l.cfg.RequestsPerSecond, float64(l.cfg.Burst))
+}
+
+// sweep drops the full buckets, since a new bucket would be the same. The next sweep
+// happens once the number of buckets doubles, so sweeping takes constant time on average.
+func (l *Limiter) sweep(now time.Time) {
+	for ip, bucket := range l.buckets {
+		if l.refill(bucket, now) >= float64(l.cfg.Burst) {
+			delete(l.buckets, ip)
+		}
+	}
+	l.sweepAt = max(len(l.buckets)*2, minBucketSweep)
+}
+
+// Release uncounts a connection from ip.
+func (l *Limiter) Release(ip string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.connections--
+	if l.perIP[ip]--; l.perIP[ip] <= 0 {
+		delete(l.perIP, ip)
+	}
+}
+
+// Connections returns how many connections are open.
+func (l *Limiter) Connections() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.connections
+}
+
+// Stats returns how many connections have been rejected.
+func (l *Limiter) Stats() LimitsStats {
+	return LimitsStats{RejectedConnections: l.rejected.Load(), ThrottledRequests: l.throttled.Load()}
+}
+
+// acceptConnection answers a connection accepted by the listener in a new goroutine,
+// unless it exceeds the limits, then it's rejected right away.
+func (app *application) acceptConnection(conn net.Conn) {
+	ip := remoteIP(conn)
+	if err := app.limiter.Acquire(ip); err != nil {
+		stats := app.limiter.Stats()
+		app.logger.Warn("a connection has been rejected", levellog.Args{
+			"addr":      conn.RemoteAddr().String(),
+			"err":       err.Error(),
+			"rejected":  fmt.Sprint(stats.RejectedConnections),
+			"throttled": fmt.Sprint(stats.ThrottledRequests),
+		})
+
+		if _, ok := conn.(*tls.Conn); ok {
+			go app.rejectConnection(conn, err) // the handshake reads the client hello
+		} else {
+			app.rejectConnection(conn, err)
+		}
+		return
+	}
+
+	client := app.clients.Register(conn)
+	app.connectionGroup.Add(1) // before the goroutine starts, so the shutdown waits for it
+	go func() {
+		defer app.limiter.Release(ip)
+		defer app.clients.Unregister(client)
+		app.handleConnection(client)
+	}()
+}
+
+// rejectConnection answers a connection with an ERROR response of err without reading
+// its request. The response fits in the empty send buffer of the connection, so
+// writing it doesn't block. Only the writes are shut down right away: closing a connection
+// with an unread request resets it, and the client would lose the response.
+func (app *application) rejectConnection(conn net.Conn, err error) {
+	time.AfterFunc(rejectTimeout, func() { conn.Close() })
+
+	if err := conn.SetWriteDeadline(time.Now().Add(rejectTimeout)); err != nil {
+		return
+	}
+	app.errorResponse(conn, err)
+	if closer, ok := conn.(interface{ CloseWrite() error }); ok {
+		closer.CloseWrite()
+	}
+}
+
+// remoteIP returns the IP address of the client of a connection.
+func remoteIP(conn net.Conn) string {
+	host, _, err := net.SplitHostPort(conn.RemoteAddr().String())
+	if err != nil {
+		return conn.RemoteAddr().String()
+	}
+	return host
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/JorgeLNJunior/cacher/pkg/client"
+	"github.com/JorgeLNJunior/cacher/pkg/clock"
+	"github.com/JorgeLNJunior/cacher/pkg/data"
+)
+
+func newTestLimiter(t *testing.T, cfg LimitsConfig) (*Limiter, *clock.Fake) {
+	t.Helper()
+
+	c := clock.NewFake(testEpoch)
+	limiter, err := NewLimiter(cfg, c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return limiter, c
+}
+
+func TestLimiter(t *testing.T) {
+	t.Run("should reject the connections over the global limit", func(tt *testing.T) {
+		limiter, _ := newTestLimiter(tt, LimitsConfig{MaxConnections: 2})
+
+		limiter.Acquire("10.0.0.1")
+		limiter.Acquire("10.0.0.2")
+		if err := limiter.Acquire("10.0.0.3"); !errors.Is(err, ErrTooManyConnections) {
+			tt.Fatalf("expected ErrTooManyConnections but got %v", err)
+		}
+
+		limiter.Release("10.0.0.1")
+		if err := limiter.Acquire("10.0.0.3"); err != nil {
+			tt.Errorf("expected the connection to be accepted after a release but got %v", err)
+		}
+	})
+
+	t.Run("should reject the connections over the limit of an address", func(tt *testing.T) {
+		limiter, _ := newTestLimiter(tt, LimitsConfig{MaxConnectionsPerIP: 1})
+
+		limiter.Acquire("10.0.0.1")
+		if err := limiter.Acquire("10.0.0.1"); !errors.Is(err, ErrTooManyIPConnections) {
+			tt.Fatalf("expected ErrTooManyIPConnections but got %v", err)
+		}
+		if err := limiter.Acquire("10.0.0.2"); err != nil {
+			tt.Errorf("expected another address to be accepted but got %v", err)
+		}
+	})
+
+	t.Run("should never limit the exempt addresses", func(tt *testing.T) {
+		limiter, _ := newTestLimiter(tt, LimitsConfig{
+			MaxConnections:      1,
+			MaxConnectionsPerIP: 1,
+			RequestsPerSecond:   1,
+			Exempt:              []string{"10.0.0.1:8595", "10.0.0.2"},
+		})
+
+		for _, ip := range []string{"10.0.0.1", "10.0.0.1", "10.0.0.2", "10.0.0.2"} {
+			if err := limiter.Acquire(ip); err != nil {
+				tt.Fatalf("expected the peer %s to be accepted but got %v", ip, err)
+			}
+		}
+		if err := limiter.Acquire("10.0.0.3"); !errors.Is(err, ErrTooManyConnections) {
+			tt.Errorf("expected ErrTooManyConnections but got %v", err)
+		}
+		if limiter.Connections() != 4 {
+			tt.Errorf("expected the 4 connections of the peers to be counted but got %d", limiter.Connections())
+		}
+	})
+
+	t.Run("should throttle the requests over the rate of an address", func(tt *testing.T) {
+		limiter, c := newTestLimiter(tt, LimitsConfig{RequestsPerSecond: 2, Burst: 3})
+
+		for range 3 {
+			if err := limiter.Acquire("10.0.0.1"); err != nil {
+				tt.Fatal(err)
+			}
+			limiter.Release("10.0.0.1")
+		}
+		if err := limiter.Acquire("10.0.0.1"); !errors.Is(err, ErrTooManyRequests) {
+			tt.Fatalf("expected ErrTooManyRequests but got %v", err)
+		}
+		if err := limiter.Acquire("10.0.0.2"); err != nil {
+			tt.Fatalf("expected another address to be accepted but got %v", err)
+		}
+
+		c.Advance(time.Millisecond * 500)
+		if err := limiter.Acquire("10.0.0.1"); err != nil {
+			tt.Errorf("expected a token to be refilled but got %v", err)
+		}
+	})
+
+	t.Run("should count the rejections", func(tt *testing.T) {
+		limiter, _ := newTestLimiter(tt, LimitsConfig{MaxConnectionsPerIP: 1, RequestsPerSecond: 1})
+
+		limiter.Acquire("10.0.0.1")
+		limiter.Acquire("10.0.0.1")
+		limiter.Acquire("10.0.0.2")
+		limiter.Release("10.0.0.2")
+		limiter.Acquire("10.0.0.2")
+
+		if stats := limiter.Stats(); stats != (LimitsStats{RejectedConnections: 1, ThrottledRequests: 1}) {
+			tt.Errorf("expected 1 rejected connection and 1 throttled request but got %+v", stats)
+		}
+	})
+
+	t.Run("should drop the full buckets", func(tt *testing.T) {
+		limiter, c := newTestLimiter(tt, LimitsConfig{RequestsPerSecond: 1})
+
+		for i := range minBucketSweep {
+			ip := fmt.Sprintf("10.0.%d.%d", i/256, i%256)
+			limiter.Acquire(ip)
+			limiter.Release(ip)
+		}
+		c.Advance(time.Second)
+		limiter.Acquire("10.1.0.1")
+
+		if len(limiter.buckets) != 1 {
+			tt.Errorf("expected only the new bucket but got %d buckets", len(limiter.buckets))
+		}
+	})
+
+	t.Run("should return an error if a limit is negative", func(tt *testing.T) {
+		if _, err := NewLimiter(LimitsConfig{MaxConnections: -1}, clock.NewFake(testEpoch)); !errors.Is(err, ErrInvalidLimits) {
+			tt.Errorf("expected ErrInvalidLimits but got %v", err)
+		}
+	})
+}
+
+func TestLimits(t *testing.T) {
+	t.Run("should answer the connections over the limit of an address with a throttled error", func(tt *testing.T) {
+		_, addr := newTestApplication(tt, config{limits: LimitsConfig{MaxConnectionsPerIP: 1}})
+
+		idle, err := net.Dial("tcp", addr)
+		if err != nil {
+			tt.Fatal(err)
+		}
+		defer idle.Close()
+
+		c := client.New(addr)
+		c.Timeout = time.Second
+		if _, err := c.Get("foo"); !errors.Is(err, data.ErrThrottled) || !errors.Is(err, ErrTooManyIPConnections) {
+			tt.Errorf("expected '%s' but got %v", ErrTooManyIPConnections, err)
+		}
+
+		idle.Close()
+		eventually(tt, "the connection has not been released", func() bool {
+			_, err := c.Get("foo")
+			return errors.Is(err, data.ErrKeyNotFound)
+		})
+	})
+
+	t.Run("should reject a connection over the limits without reading its request", func(tt *testing.T) {
+		_, addr := newTestApplication(tt, config{limits: LimitsConfig{MaxConnectionsPerIP: 1}})
+
+		idle, err := net.Dial("tcp", addr)
+		if err != nil {
+			tt.Fatal(err)
+		}
+		defer idle.Close()
+
+		rejected, err := net.Dial("tcp", addr)
+		if err != nil {
+			tt.Fatal(err)
+		}
+		defer rejected.Close()
+		// nothing is written, the response must come before the request is read
+		if err := rejected.SetReadDeadline(time.Now().Add(rejectTimeout / 2)); err != nil {
+			tt.Fatal(err)
+		}
+		res, err := io.ReadAll(rejected)
+		if err != nil {
+			tt.Fatal(err)
+		}
+
+		expected := data.ResponseStatusError + " " + ErrTooManyIPConnections.Error()
+		if string(res) != expected {
+			tt.Errorf("expected '%s' but got '%s'", expected, res)
+		}
+	})
+
+	t.Run("should throttle the requests over the rate limit", func(tt *testing.T) {
+		app, addr := newTestApplication(tt, config{limits: LimitsConfig{RequestsPerSecond: 1, Burst: 2}})
+
+		c := client.New(addr)
+		for range 2 {
+			if err := c.Set("foo", "bar"); err != nil {
+				tt.Fatal(err)
+			}
+		}
+		if err := c.Set("foo", "bar"); !errors.Is(err, data.ErrThrottled) {
+			tt.Fatalf("expected a throttled error but got %v", err)
+		}
+
+		app.clock.(*clock.Fake).Advance(time.Second)
+		if err := c.Set("foo", "bar"); err != nil {
+			tt.Errorf("expected the request to be accepted but got %v", err)
+		}
+		if stats := app.limiter.Stats(); stats.ThrottledRequests != 1 {
+			tt.Errorf("expected 1 throttled request but got %d", stats.ThrottledRequests)
+		}
+	})
+}
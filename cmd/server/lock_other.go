@@ -0,0 +1,36 @@
+//go:build !unix
+
+package main
+
+import (
+	"errors"
+	"os"
+)
+
+// lockFile holds an exclusive lock on a file. The lock is the existence of
+// the file, so it must be removed by hand if the process dies.
+type lockFile struct {
+	path string
+	file *os.File
+}
+
+// acquireLock creates the file at path or returns ErrDataDirLocked if it
+// already exists.
+func acquireLock(path string) (*lockFile, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		if errors.Is(err, os.ErrExist) {
+			return nil, ErrDataDirLocked
+		}
+		return nil, err
+	}
+
+	return &lockFile{path: path, file: file}, nil
+}
+
+func (l *lockFile) release() error {
+	if err := l.file.Close(); err != nil {
+		return err
+	}
+	return os.Remove(l.path)
+}
@@ -0,0 +1,38 @@
+//go:build unix
+
+package main
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+// lockFile holds an exclusive lock on a file. The operating system releases
+// the lock if the process dies.
+type lockFile struct {
+	file *os.File
+}
+
+// acquireLock locks the file at path or returns ErrDataDirLocked if another
+// process holds the lock.
+func acquireLock(path string) (*lockFile, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o600)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		file.Close()
+		if errors.Is(err, syscall.EWOULDBLOCK) {
+			return nil, ErrDataDirLocked
+		}
+		return nil, err
+	}
+
+	return &lockFile{file: file}, nil
+}
+
+func (l *lockFile) release() error {
+	return l.file.Close()
+}
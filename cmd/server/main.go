@@ -4,22 +4,81 @@ import (
 	"context"
 	"flag"
 	"os"
+	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/JorgeLNJunior/cacher/pkg/audit"
+	"github.com/JorgeLNJunior/cacher/pkg/clock"
+	"github.com/JorgeLNJunior/cacher/pkg/data"
+	"github.com/JorgeLNJunior/cacher/pkg/dumpfile"
+	"github.com/JorgeLNJunior/cacher/pkg/encryption"
 	levellog "github.com/JorgeLNJunior/cacher/pkg/logger"
 )
 
 type config struct {
-	address string
-	persist bool
+	address                string
+	persist                bool
+	dataDir                string
+	dumpFile               string
+	dumpGenerations        int
+	dumpFormat             string
+	dumpCompression        bool
+	restoreConflicts       string
+	oplog                  bool
+	oplogFsync             string
+	oplogRewriteMinSize    int64
+	snapshotInterval       time.Duration
+	snapshotRules          string
+	encryptionKeyFile      string
+	replicaOf              string
+	cluster                string
+	clusterAddress         string
+	clusterSnapshotEntries int64
+	tlsCert                string
+	tlsKey                 string
+	tlsClientCA            string
+	usersFile              string
+	peerUser               string
+	peerPassword           string
+	auditFile              string
+	limits                 LimitsConfig
+	limitsExempt           string
+	maxKeyBytes            int64
+	maxValueBytes          int64
+	maxRequestBytes        int64
+	metricsAddress         string
+	slowLogThreshold       time.Duration
+	slowLogSize            int
 }
 
 type application struct {
 	config             config
+	clock              clock.Clock
 	logger             *levellog.Logger
 	storage            *InMemoryStorage
 	persistanceStorage *OnDiskStorage
+	keyring            *encryption.Keyring // encrypts the data at rest if not nil
+	oplog              *OperationLog
+	snapshotter        *Snapshotter
+	replicator         *Replicator
+	monitor            *Monitor
+	clients            *Clients
+	replica            atomic.Pointer[Replica] // nil unless the server is a replica
+	replicaMu          sync.Mutex              // serializes the changes of replica
+	raft               *RaftNode               // nil unless the server is a node of a cluster
+	tls                *TLSCertificates        // nil unless the server uses TLS
+	users              *Users                  // nil unless the requests are authenticated
+	peerCredentials    *data.Credentials       // authenticate the requests to other servers if not nil
+	audit              *audit.Log              // nil unless the requests are audited
+	limiter            *Limiter
+	started            time.Time // when the server started
+	commands           commandStats
+	slowLog            *SlowLog // nil unless the slow requests are logged
+	sweepDurations     histogram
+	persistDurations   histogram
 	connectionGroup    sync.WaitGroup
 }
 
@@ -28,33 +87,211 @@ func main() {
 
 	flag.StringVar(&cfg.address, "address", ":8595", "address tcp server will listen")
 	flag.BoolVar(&cfg.persist, "persist", false, "persist data on disk or not")
+	flag.StringVar(&cfg.dataDir, "data-dir", os.Getenv("CACHER_DATA_DIR"), "directory where the data is persisted. Defaults to the cacher directory in the user config directory")
+	flag.StringVar(&cfg.dumpFile, "dump-file", os.Getenv("CACHER_DUMP_FILE"), "path of the dump, relative to the data directory. Default: dump")
+	flag.IntVar(&cfg.dumpGenerations, "dump-generations", 1, "how many previous dumps to keep on disk")
+	flag.StringVar(&cfg.dumpFormat, "dump-format", string(dumpfile.FormatBinary), "format of the data persisted on disk. binary or json")
+	flag.BoolVar(&cfg.dumpCompression, "dump-compression", false, "compress the values persisted on disk in the binary format")
+	flag.StringVar(&cfg.restoreConflicts, "restore-conflicts", string(ConflictMemoryWins), "which item is kept when a restored key is already stored. dump, memory or fail")
+	flag.BoolVar(&cfg.oplog, "oplog", false, "log every operation on disk as it is applied")
+	flag.StringVar(&cfg.oplogFsync, "oplog-fsync", string(FsyncEverySec), "when to fsync the operation log. always, everysec or never")
+	flag.Int64Var(&cfg.oplogRewriteMinSize, "oplog-rewrite-min-size", 64<<20, "min size in bytes of the operation log before it's compacted")
+	flag.DurationVar(&cfg.snapshotInterval, "snapshot-interval", 0, "how often to persist the data on disk in background. 0 disables it")
+	flag.StringVar(&cfg.snapshotRules, "snapshot-rules", "", "persist the data on disk in background after CHANGES changes in SECONDS seconds. SECONDS:CHANGES[,SECONDS:CHANGES]")
+	flag.StringVar(&cfg.encryptionKeyFile, "encryption-key-file", "", "file with the keys used to encrypt the data at rest, one per line. The first one encrypts new data. Defaults to the keys in CACHER_ENCRYPTION_KEYS")
+	flag.StringVar(&cfg.replicaOf, "replica-of", os.Getenv("CACHER_REPLICA_OF"), "address of the primary to replicate in host:port format. Replicas reject writes")
+	flag.StringVar(&cfg.cluster, "cluster", os.Getenv("CACHER_CLUSTER"), "addresses of every node of the cluster in host:port format, separated by commas. Writes are committed by a majority of the nodes")
+	flag.StringVar(&cfg.clusterAddress, "cluster-address", os.Getenv("CACHER_CLUSTER_ADDRESS"), "address of the server among the nodes of the cluster")
+	flag.Int64Var(&cfg.clusterSnapshotEntries, "cluster-snapshot-entries", defaultSnapshotEntries, "entries of the cluster log that trigger a snapshot and the compaction of the log")
+	flag.StringVar(&cfg.tlsCert, "tls-cert", os.Getenv("CACHER_TLS_CERT"), "PEM encoded certificate of the server. Enables TLS with -tls-key. Reloaded on SIGHUP")
+	flag.StringVar(&cfg.tlsKey, "tls-key", os.Getenv("CACHER_TLS_KEY"), "PEM encoded private key of the certificate of the server. Reloaded on SIGHUP")
+	flag.StringVar(&cfg.tlsClientCA, "tls-client-ca", os.Getenv("CACHER_TLS_CLIENT_CA"), "PEM encoded CA that must have signed the certificates of the clients. Enables mutual TLS. Reloaded on SIGHUP")
+	flag.StringVar(&cfg.usersFile, "users-file", os.Getenv("CACHER_USERS_FILE"), "file with the users allowed to make requests, one per line as USER HASH OPERATIONS KEYS. Enables authentication")
+	flag.StringVar(&cfg.peerUser, "peer-user", os.Getenv("CACHER_PEER_USER"), "user of the requests made to other servers by replicas and nodes of a cluster")
+	flag.StringVar(&cfg.peerPassword, "peer-password", os.Getenv("CACHER_PEER_PASSWORD"), "password of -peer-user")
+	flag.StringVar(&cfg.auditFile, "audit-file", os.Getenv("CACHER_AUDIT_FILE"), "append-only file where the writes and the administrative operations are recorded, without their values")
+	flag.IntVar(&cfg.limits.MaxConnections, "max-connections", 0, "max open connections, the others are rejected. 0 disables it")
+	flag.IntVar(&cfg.limits.MaxConnectionsPerIP, "max-connections-per-ip", 0, "max open connections from an IP address. 0 disables it")
+	flag.Float64Var(&cfg.limits.RequestsPerSecond, "rate-limit", 0, "requests allowed per second from an IP address. 0 disables it")
+	flag.IntVar(&cfg.limits.Burst, "rate-burst", 1, "requests an IP address can make at once before -rate-limit applies")
+	flag.StringVar(&cfg.limitsExempt, "limits-exempt", os.Getenv("CACHER_LIMITS_EXEMPT"), "addresses of the replicas and the sentinels whose connections are never limited, separated by commas. The nodes of -cluster are always exempt")
+	flag.Int64Var(&cfg.maxKeyBytes, "max-key-bytes", 64<<10, "max size of a key in bytes. 0 disables it")
+	flag.Int64Var(&cfg.maxValueBytes, "max-value-bytes", 64<<20, "max size of a value in bytes. 0 disables it")
+	flag.Int64Var(&cfg.maxRequestBytes, "max-request-bytes", 512<<20, "max size of a request in bytes, including the snapshots sent by RESTORE and by the nodes of a cluster. 0 disables it")
+	flag.StringVar(&cfg.metricsAddress, "metrics-address", os.Getenv("CACHER_METRICS_ADDRESS"), "address of the HTTP server of the metrics in the Prometheus format, served at /metrics. Disabled by default")
+	flag.DurationVar(&cfg.slowLogThreshold, "slowlog-threshold", time.Millisecond*10, "requests that take longer are kept in the slow log. 0 disables it")
+	flag.IntVar(&cfg.slowLogSize, "slowlog-size", 128, "max number of requests kept in the slow log, the oldest ones are replaced")
+	hashPassword := flag.Bool("hash-password", false, "print the hash of the password read from the standard input for the users file and exit")
 	flag.Parse()
 
-	storage := NewInMemoryStorage()
+	if *hashPassword {
+		printPasswordHash()
+		return
+	}
+
+	systemClock := clock.New()
+	storage := NewInMemoryStorageWithClock(systemClock)
+	defer storage.Close()
 	logger := levellog.NewLogger(levellog.LevelInfo, os.Stdout)
 
-	persistanceStorage, err := NewOnDiskStorage()
+	snapshotRules, err := ParseSnapshotRules(cfg.snapshotRules)
 	if err != nil {
-		logger.Fatal("error creating the on disk persistance store: %s", levellog.Args{"err": err.Error()})
+		logger.Fatal("error parsing the snapshot rules", levellog.Args{"rules": cfg.snapshotRules, "err": err.Error()})
+	}
+	snapshots := cfg.snapshotInterval > 0 || len(snapshotRules) > 0
+
+	clustered := cfg.cluster != ""
+	var members []string
+	if clustered {
+		if cfg.replicaOf != "" || cfg.oplog {
+			logger.Fatal("the cluster mode can't be used with -replica-of or -oplog", nil)
+		}
+		members, err = ParseClusterMembers(cfg.cluster, cfg.clusterAddress)
+		if err != nil {
+			logger.Fatal("error parsing the cluster members", levellog.Args{"cluster": cfg.cluster, "err": err.Error()})
+		}
+	}
+
+	keyring, err := encryption.LoadKeyring(cfg.encryptionKeyFile, "CACHER_ENCRYPTION_KEYS")
+	if err != nil {
+		logger.Fatal("error loading the encryption keys", levellog.Args{"err": err.Error()})
 	}
 
 	app := &application{
-		config:             cfg,
-		logger:             logger,
-		storage:            storage,
-		persistanceStorage: persistanceStorage,
+		config:  cfg,
+		clock:   systemClock,
+		logger:  logger,
+		storage: storage,
+		keyring: keyring,
+		started: systemClock.Now(),
+	}
+	if cfg.tlsCert != "" || cfg.tlsKey != "" || cfg.tlsClientCA != "" {
+		app.tls, err = LoadTLSCertificates(cfg.tlsCert, cfg.tlsKey, cfg.tlsClientCA)
+		if err != nil {
+			logger.Fatal("error loading the TLS certificates", levellog.Args{"err": err.Error()})
+		}
+	}
+	app.replicator = NewReplicator(storage)
+	app.monitor = NewMonitor()
+	app.clients = NewClients(app.clock)
+	storage.ObserveSweeps(app.sweepDurations.observe)
+	if cfg.slowLogThreshold != 0 {
+		app.slowLog, err = NewSlowLog(cfg.slowLogThreshold, cfg.slowLogSize)
+		if err != nil {
+			logger.Fatal("error configuring the slow log", levellog.Args{"err": err.Error()})
+		}
+	}
+	cfg.limits.Exempt = members
+	if cfg.limitsExempt != "" {
+		for address := range strings.SplitSeq(cfg.limitsExempt, ",") {
+			cfg.limits.Exempt = append(cfg.limits.Exempt, strings.TrimSpace(address))
+		}
+	}
+	app.limiter, err = NewLimiter(cfg.limits, systemClock)
+	if err != nil {
+		logger.Fatal("error configuring the limits", levellog.Args{"err": err.Error()})
+	}
+
+	// the data directory is only created and locked if something is persisted
+	if cfg.persist || cfg.oplog || snapshots || clustered {
+		persistanceStorage, err := NewOnDiskStorage(OnDiskConfig{
+			DataDir:      cfg.dataDir,
+			DumpFile:     cfg.dumpFile,
+			Generations:  cfg.dumpGenerations,
+			Format:       dumpfile.Format(cfg.dumpFormat),
+			Compress:     cfg.dumpCompression,
+			Keyring:      keyring,
+			MaxValueSize: cfg.maxValueBytes,
+		})
+		if err != nil {
+			logger.Fatal("error creating the on disk persistance store", levellog.Args{"err": err.Error()})
+		}
+		defer persistanceStorage.Close()
+
+		app.persistanceStorage = persistanceStorage
+		persistanceStorage.ObservePersists(app.persistDurations.observe)
 	}
 
-	if app.config.persist {
+	// a node of a cluster restores its last snapshot and then the log of the cluster
+	if app.config.persist || clustered {
 		logger.Info("restoring the data from disk", nil)
 
 		ctx, cancel := context.WithTimeout(context.Background(), time.Second*15)
-		if err := persistanceStorage.Restore(ctx, app.storage); err != nil {
-			logger.Fatal("error restoring the data from disk: %s", levellog.Args{"err": err.Error()})
+		stats, err := app.persistanceStorage.Restore(ctx, app.storage, ConflictPolicy(cfg.restoreConflicts))
+		if err != nil {
+			logger.Fatal("error restoring the data from disk", levellog.Args{"err": err.Error()})
 		}
 		cancel()
 
-		logger.Info("the data has been successfully restored", nil)
+		logger.Info("the data has been successfully restored", levellog.Args{
+			"loaded":      strconv.Itoa(stats.Loaded),
+			"skipped":     strconv.Itoa(stats.Skipped),
+			"conflicting": strconv.Itoa(stats.Conflicting),
+		})
+	}
+
+	if app.config.oplog {
+		logPath := app.persistanceStorage.Path("oplog")
+		oplog, err := OpenOperationLog(logPath, FsyncPolicy(cfg.oplogFsync), cfg.oplogRewriteMinSize, keyring)
+		if err != nil {
+			logger.Fatal("error opening the operation log", levellog.Args{"path": logPath, "err": err.Error()})
+		}
+
+		applied, err := oplog.Replay(app.storage)
+		if err != nil {
+			logger.Fatal("error replaying the operation log", levellog.Args{"path": logPath, "err": err.Error()})
+		}
+		logger.Info("the operation log has been replayed", levellog.Args{"operations": strconv.Itoa(applied)})
+
+		app.storage.Observe(func(m Mutation) {
+			if err := oplog.Append(m); err != nil {
+				logger.Error("error writing to the operation log", levellog.Args{"err": err.Error()})
+			}
+		})
+		oplog.Start(app.storage, func(err error) {
+			logger.Error("error maintaining the operation log", levellog.Args{"err": err.Error()})
+		})
+		app.oplog = oplog
+	}
+
+	if snapshots {
+		app.snapshotter = NewSnapshotter(app.persistanceStorage, app.storage, cfg.snapshotInterval, snapshotRules, systemClock)
+		app.snapshotter.Start(
+			func(status PersistStatus) {
+				logger.Info("the data has been persisted in background", levellog.Args{"duration": status.Duration.String()})
+			},
+			func(err error) {
+				logger.Error("error persisting the data in background", levellog.Args{"err": err.Error()})
+			},
+		)
+	}
+
+	if cfg.usersFile != "" {
+		app.users, err = LoadUsers(cfg.usersFile)
+		if err != nil {
+			logger.Fatal("error loading the users file", levellog.Args{"path": cfg.usersFile, "err": err.Error()})
+		}
+	}
+	if cfg.auditFile != "" {
+		app.audit, err = openAuditLog(cfg.auditFile, logger)
+		if err != nil {
+			logger.Fatal("error opening the audit log", levellog.Args{"path": cfg.auditFile, "err": err.Error()})
+		}
+		defer app.audit.Close()
+	}
+	if cfg.peerUser != "" {
+		app.peerCredentials = &data.Credentials{User: cfg.peerUser, Password: cfg.peerPassword}
+	}
+
+	if cfg.replicaOf != "" {
+		app.replicate(cfg.replicaOf)
+	}
+
+	if clustered {
+		raftConfig := DefaultRaftConfig(cfg.clusterAddress, members, cfg.clusterSnapshotEntries)
+		if err := app.startCluster(raftConfig, tcpRaftTransport{timeout: time.Second, tls: app.tls.PeerConfig(), credentials: app.peerCredentials}); err != nil {
+			logger.Fatal("error starting the cluster node", levellog.Args{"err": err.Error()})
+		}
 	}
 
 	if err := app.Listen(); err != nil {
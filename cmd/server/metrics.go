@@ -0,0 +1,236 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/JorgeLNJunior/cacher/pkg/data"
+	levellog "github.com/JorgeLNJunior/cacher/pkg/logger"
+)
+
+const (
+	metricsPath        = "/metrics"
+	metricsContentType = "text/plain; version=0.0.4; charset=utf-8"
+)
+
+// durationBuckets are the upper bounds in seconds of the buckets of the duration histograms.
+var durationBuckets = []float64{0.0001, 0.00025, 0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// histogram counts durations in durationBuckets. The zero value is ready to use.
+type histogram struct {
+	counts []uint64 // observations by bucket, the last one is +Inf
+	sum    float64  // seconds
+	count  uint64
+	mu     sync.Mutex
+}
+
+// observe counts a duration.
+func (h *histogram) observe(d time.Duration) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.counts == nil {
+		h.counts = make([]uint64, len(durationBuckets)+1)
+	}
+
+	seconds := d.Seconds()
+	bucket := len(durationBuckets)
+	for i, bound := range durationBuckets {
+		if seconds <= bound {
+			bucket = i
+			break
+		}
+	}
+	h.counts[bucket]++
+	h.sum += seconds
+	h.count++
+}
+
+// cumulative returns the observations less than or equal to every bucket, as Prometheus
+// expects them, with the sum and the count of the observations.
+func (h *histogram) cumulative() ([]uint64, float64, uint64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	cumulative := make([]uint64, len(durationBuckets)+1)
+	var total uint64
+	for i := range cumulative {
+		if h.counts != nil {
+			total += h.counts[i]
+		}
+		cumulative[i] = total
+	}
+	return cumulative, h.sum, h.count
+}
+
+// observe counts how long a request of an operation took.
+func (s *commandStats) observe(op data.Operation, duration time.Duration) {
+	s.mu.Lock()
+	if s.durations == nil {
+		s.durations = make(map[data.Operation]*histogram)
+	}
+	durations, found := s.durations[op]
+	if !found {
+		durations = &histogram{}
+		s.durations[op] = durations
+	}
+	s.mu.Unlock()
+
+	durations.observe(duration)
+}
+
+// durationsOf returns the histogram of the durations of the requests of an operation,
+// or nil if there were none.
+func (s *commandStats) durationsOf(op data.Operation) *histogram {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.durations[op]
+}
+
+// serveMetrics serves the metrics in the Prometheus text format at -metrics-address
+// until the returned server is closed.
+func (app *application) serveMetrics() (*http.Server, error) {
+	listener, err := net.Listen("tcp", app.config.metricsAddress)
+	if err != nil {
+		return nil, err
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET "+metricsPath, app.handleMetrics)
+	server := &http.Server{Handler: mux, ReadHeaderTimeout: time.Second * 5}
+
+	app.logger.Info("metrics server is listening", levellog.Args{"addr": app.config.metricsAddress, "path": metricsPath})
+	go func() {
+		if err := server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			app.logger.Error("error serving the metrics", levellog.Args{"err": err.Error()})
+		}
+	}()
+
+	return server, nil
+}
+
+// handleMetrics writes the metrics in the Prometheus text format.
+func (app *application) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	var buffer bytes.Buffer
+	app.writeMetrics(&buffer)
+
+	w.Header().Set("Content-Type", metricsContentType)
+	if _, err := buffer.WriteTo(w); err != nil {
+		app.logger.Warn("error writing the metrics", levellog.Args{"addr": r.RemoteAddr, "err": err.Error()})
+	}
+}
+
+// writeMetrics writes the metrics in the Prometheus text format.
+func (app *application) writeMetrics(w io.Writer) {
+	info := app.info()
+	m := metricsWriter{w: w}
+
+	m.family("cacher_uptime_seconds", "gauge", "Seconds since the server started.")
+	m.sample("cacher_uptime_seconds", "", info.Uptime.Seconds())
+	m.family("cacher_connected_clients", "gauge", "Open client connections.")
+	m.sample("cacher_connected_clients", "", float64(info.Clients))
+	m.family("cacher_rejected_connections_total", "counter", "Connections rejected by the connection limits.")
+	m.sample("cacher_rejected_connections_total", "", float64(info.Limits.RejectedConnections))
+	m.family("cacher_throttled_requests_total", "counter", "Requests rejected by the rate limit.")
+	m.sample("cacher_throttled_requests_total", "", float64(info.Limits.ThrottledRequests))
+
+	m.family("cacher_commands_total", "counter", "Requests by operation.")
+	for _, op := range infoOperations {
+		m.sample("cacher_commands_total", operationLabel(op), float64(info.Commands[op]))
+	}
+	m.family("cacher_command_duration_seconds", "histogram", "Time taken to answer the requests by operation, except SYNC and MONITOR.")
+	for _, op := range infoOperations {
+		if durations := app.commands.durationsOf(op); durations != nil {
+			m.histogram("cacher_command_duration_seconds", operationLabel(op), durations)
+		}
+	}
+	m.family("cacher_get_hits_total", "counter", "GET requests of stored keys.")
+	m.sample("cacher_get_hits_total", "", float64(info.Hits))
+	m.family("cacher_get_misses_total", "counter", "GET requests of missing keys.")
+	m.sample("cacher_get_misses_total", "", float64(info.Misses))
+
+	m.family("cacher_keys", "gauge", "Stored keys.")
+	m.sample("cacher_keys", "", float64(info.Storage.Keys))
+	m.family("cacher_keys_with_ttl", "gauge", "Stored keys with an expiry.")
+	m.sample("cacher_keys_with_ttl", "", float64(info.Storage.Volatile))
+	m.family("cacher_expired_keys_total", "counter", "Keys removed because they expired.")
+	m.sample("cacher_expired_keys_total", "", float64(info.Storage.Expired))
+	m.family("cacher_used_memory_bytes", "gauge", "Approximate memory used by the keys and the values.")
+	m.sample("cacher_used_memory_bytes", "", float64(info.Storage.Memory))
+	m.family("cacher_expiry_sweep_duration_seconds", "histogram", "Time taken by the sweeps of the expired keys.")
+	m.histogram("cacher_expiry_sweep_duration_seconds", "", &app.sweepDurations)
+
+	if info.Snapshot != nil {
+		m.family("cacher_persist_duration_seconds", "histogram", "Time taken to persist the data on disk.")
+		m.histogram("cacher_persist_duration_seconds", "", &app.persistDurations)
+
+		var last, failed float64
+		if !info.Snapshot.Time.IsZero() {
+			last = float64(info.Snapshot.Time.Unix())
+		}
+		if info.Snapshot.Failed {
+			failed = 1
+		}
+		m.family("cacher_last_persist_timestamp_seconds", "gauge", "Unix time of the last successful persist, 0 if none.")
+		m.sample("cacher_last_persist_timestamp_seconds", "", last)
+		m.family("cacher_last_persist_failed", "gauge", "1 if a persist has failed since the last successful one.")
+		m.sample("cacher_last_persist_failed", "", failed)
+	}
+
+	m.family("cacher_goroutines", "gauge", "Goroutines that currently exist.")
+	m.sample("cacher_goroutines", "", float64(info.Goroutines))
+	m.family("cacher_heap_alloc_bytes", "gauge", "Bytes of allocated heap objects.")
+	m.sample("cacher_heap_alloc_bytes", "", float64(info.Memory.HeapAlloc))
+	m.family("cacher_sys_bytes", "gauge", "Bytes of memory obtained from the OS.")
+	m.sample("cacher_sys_bytes", "", float64(info.Memory.Sys))
+	m.family("cacher_gc_runs_total", "counter", "Completed GC cycles.")
+	m.sample("cacher_gc_runs_total", "", float64(info.Memory.NumGC))
+}
+
+func operationLabel(op data.Operation) string {
+	return `operation="` + strings.ToLower(op.String()) + `"`
+}
+
+// metricsWriter writes metrics in the Prometheus text format.
+type metricsWriter struct {
+	w io.Writer
+}
+
+// family writes the HELP and TYPE lines of a metric.
+func (m metricsWriter) family(name string, kind string, help string) {
+	fmt.Fprintf(m.w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+// sample writes a sample of a metric with the given comma separated labels.
+func (m metricsWriter) sample(name string, labels string, value float64) {
+	if labels != "" {
+		name += "{" + labels + "}"
+	}
+	fmt.Fprintf(m.w, "%s %s\n", name, strconv.FormatFloat(value, 'g', -1, 64))
+}
+
+// histogram writes the buckets, the sum and the count of a histogram.
+func (m metricsWriter) histogram(name string, labels string, h *histogram) {
+	counts, sum, count := h.cumulative()
+
+	separator := ""
+	if labels != "" {
+		separator = ","
+	}
+	for i, bound := range durationBuckets {
+		le := strconv.FormatFloat(bound, 'g', -1, 64)
+		m.sample(name+"_bucket", labels+separator+`le="`+le+`"`, float64(counts[i]))
+	}
+	m.sample(name+"_bucket", labels+separator+`le="+Inf"`, float64(counts[len(durationBuckets)]))
+	m.sample(name+"_sum", labels, sum)
+	m.sample(name+"_count", labels, float64(count))
+}
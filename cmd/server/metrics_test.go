@@ -0,0 +1,104 @@
+package main
+
+import (
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/JorgeLNJunior/cacher/pkg/client"
+	"github.com/JorgeLNJunior/cacher/pkg/data"
+)
+
+func TestHistogram(t *testing.T) {
+	t.Run("should count the durations in cumulative buckets", func(tt *testing.T) {
+		h := histogram{}
+		h.observe(time.Microsecond * 50)
+		h.observe(time.Millisecond)
+		h.observe(time.Minute)
+
+		counts, sum, count := h.cumulative()
+		if counts[0] != 1 || counts[3] != 2 || counts[len(durationBuckets)-1] != 2 || counts[len(durationBuckets)] != 3 {
+			tt.Errorf("expected cumulative buckets but got %v", counts)
+		}
+		if count != 3 || sum < 60 {
+			tt.Errorf("expected 3 observations over 60 seconds but got %d in %f", count, sum)
+		}
+	})
+
+	t.Run("should have empty buckets without observations", func(tt *testing.T) {
+		counts, _, count := (&histogram{}).cumulative()
+		if len(counts) != len(durationBuckets)+1 || counts[len(durationBuckets)] != 0 || count != 0 {
+			tt.Errorf("expected empty buckets but got %v", counts)
+		}
+	})
+}
+
+func TestMetrics(t *testing.T) {
+	sampleLine := regexp.MustCompile(`^[a-z_]+(\{[a-z]+="[^"]*"(,[a-z]+="[^"]*")*\})? \S+$`)
+
+	t.Run("should write the metrics in the Prometheus text format", func(tt *testing.T) {
+		app, addr := newTestApplication(tt, config{})
+		c := client.New(addr)
+
+		if err := c.Set("foo", "bar"); err != nil {
+			tt.Fatal(err)
+		}
+		if _, err := c.Get("foo"); err != nil {
+			tt.Fatal(err)
+		}
+		app.sweepDurations.observe(time.Millisecond)
+
+		recorder := httptest.NewRecorder()
+		app.handleMetrics(recorder, httptest.NewRequest("GET", metricsPath, nil))
+
+		if contentType := recorder.Header().Get("Content-Type"); contentType != metricsContentType {
+			tt.Errorf("expected the content type '%s' but got '%s'", metricsContentType, contentType)
+		}
+
+		body := recorder.Body.String()
+		for line := range strings.Lines(body) {
+			line = strings.TrimSuffix(line, "\n")
+			if strings.HasPrefix(line, "# HELP ") || strings.HasPrefix(line, "# TYPE ") {
+				continue
+			}
+			if !sampleLine.MatchString(line) {
+				tt.Errorf("expected a sample but got '%s'", line)
+			}
+		}
+
+		expected := []string{
+			"# TYPE cacher_commands_total counter\n",
+			`cacher_commands_total{operation="set"} 1` + "\n",
+			`cacher_command_duration_seconds_count{operation="get"} 1` + "\n",
+			`cacher_command_duration_seconds_bucket{operation="get",le="+Inf"} 1` + "\n",
+			"cacher_get_hits_total 1\n",
+			"cacher_keys 1\n",
+			"cacher_expiry_sweep_duration_seconds_count 1\n",
+		}
+		for _, sample := range expected {
+			if !strings.Contains(body, sample) {
+				tt.Errorf("expected the metrics to have '%s' but got\n%s", strings.TrimSpace(sample), body)
+			}
+		}
+		if strings.Contains(body, "cacher_persist_duration_seconds") {
+			tt.Error("expected no persistence metrics without persistance")
+		}
+	})
+
+	t.Run("should not count nor measure the denied requests", func(tt *testing.T) {
+		usersFile := writeUsersFile(tt, "admin s3cret * *")
+		app, addr := newTestApplication(tt, config{usersFile: usersFile})
+
+		sendRequest(tt, addr, []byte("SET anonymous bar"))
+		sendRequest(tt, addr, []byte("AUTH admin wrong\nSET denied bar"))
+
+		if commands, _, _ := app.commands.snapshot(); commands[data.OperationSet] != 0 {
+			tt.Errorf("expected no SET to be counted but got %d", commands[data.OperationSet])
+		}
+		if durations := app.commands.durationsOf(data.OperationSet); durations != nil {
+			tt.Error("expected no SET to be measured")
+		}
+	})
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"path"
+	"sync"
+	"time"
+
+	"github.com/JorgeLNJunior/cacher/pkg/data"
+	levellog "github.com/JorgeLNJunior/cacher/pkg/logger"
+)
+
+// A MONITOR request is answered with "OK " followed by a data.MonitorEntry per line.
+// An empty line is sent every heartbeat, since the server can only notice that a
+// client has disconnected when writing to it.
+const (
+	monitorHeartbeat    = time.Second
+	monitorValueBytes   = 64    // bytes of the values sent to the monitors
+	monitorBufferSize   = 1_000 // entries buffered per monitor before it's dropped
+	monitorWriteTimeout = time.Second * 5
+)
+
+var (
+	ErrMonitorStopped = errors.New("the monitors have been stopped")
+	ErrMonitorTooSlow = errors.New("the monitor could not keep up with the requests")
+	ErrInvalidPattern = errors.New("the key of a MONITOR must be a glob pattern, e.g. user:*")
+)
+
+// Monitor fans out the requests made to the server to the connected monitors.
+type Monitor struct {
+	mu       sync.Mutex
+	watchers map[*monitorWatcher]struct{}
+	closed   bool
+}
+
+// monitorWatcher is the queue of entries of a connected monitor.
+type monitorWatcher struct {
+	pattern string
+	user    *User // the requests the user can't see are never sent, nil sends all of them
+	entries chan data.MonitorEntry
+	dropped chan struct{} // closed when the monitor must disconnect
+	err     error         // why the monitor has been dropped
+}
+
+// NewMonitor returns a Monitor without monitors.
+func NewMonitor() *Monitor {
+	return &Monitor{watchers: make(map[*monitorWatcher]struct{})}
+}
+
+// Publish sends an authorized request made by a client to the monitors whose pattern
+// matches its key and whose user sees it. The values of AUTH requests are passwords
+// and are never sent.
+func (m *Monitor) Publish(t time.Time, addr string, req data.Request) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if len(m.watchers) == 0 {
+		return
+	}
+
+	entry := data.MonitorEntry{Time: t, Addr: addr, Operation: req.Operation, Key: req.Key}
+	if req.Operation != data.OperationAuth {
+		entry.Size = len(req.Value)
+		entry.Value = req.Value[:min(len(req.Value), monitorValueBytes)]
+	}
+	for watcher := range m.watchers {
+		if matched, _ := path.Match(watcher.pattern, req.Key); !matched || !watcher.user.Sees(req) {
+			continue
+		}
+		select {
+		case watcher.entries <- entry:
+		default:
+			// never block the requests, the client has to monitor again
+			m.drop(watcher, ErrMonitorTooSlow)
+		}
+	}
+}
+
+// watch registers a monitor of the requests whose key matches pattern that user sees.
+func (m *Monitor) watch(pattern string, user *User) (*monitorWatcher, error) {
+	if _, err := path.Match(pattern, ""); err != nil {
+		return nil, ErrInvalidPattern
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.closed {
+		return nil, ErrMonitorStopped
+	}
+
+	watcher := &monitorWatcher{
+		pattern: pattern,
+		user:    user,
+		entries: make(chan data.MonitorEntry, monitorBufferSize),
+		dropped: make(chan struct{}),
+	}
+	m.watchers[watcher] = struct{}{}
+	return watcher, nil
+}
+
+func (m *Monitor) unwatch(watcher *monitorWatcher) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.watchers, watcher)
+}
+
+func (m *Monitor) drop(watcher *monitorWatcher, err error) {
+	if _, found := m.watchers[watcher]; !found {
+		return
+	}
+
+	watcher.err = err
+	close(watcher.dropped)
+	delete(m.watchers, watcher)
+}
+
+// Close disconnects every monitor and refuses new ones.
+func (m *Monitor) Close() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.closed = true
+	for watcher := range m.watchers {
+		m.drop(watcher, ErrMonitorStopped)
+	}
+}
+
+// handleMonitor streams the requests whose key matches the pattern in the key of req
+// and that user sees until the client disconnects or falls behind.
+func (app *application) handleMonitor(conn net.Conn, req data.Request, user *User) {
+	watcher, err := app.monitor.watch(req.Key, user)
+	if err != nil {
+		app.errorResponse(conn, err)
+		return
+	}
+	defer app.monitor.unwatch(watcher)
+
+	args := levellog.Args{"addr": conn.RemoteAddr().String(), "pattern": req.Key}
+	app.logger.Info("a monitor has connected", args)
+
+	heartbeat := app.clock.NewTicker(monitorHeartbeat)
+	defer heartbeat.Stop()
+
+	out := bufio.NewWriter(conn)
+	if _, err := out.WriteString(data.ResponseStatusOK + " "); err != nil {
+		return
+	}
+	for {
+		if out.Buffered() > 0 && len(watcher.entries) == 0 {
+			if err := conn.SetWriteDeadline(time.Now().Add(monitorWriteTimeout)); err != nil {
+				return
+			}
+			if err := out.Flush(); err != nil {
+				args["err"] = err.Error()
+				app.logger.Info("a monitor has disconnected", args)
+				return
+			}
+		}
+
+		line := ""
+		select {
+		case <-watcher.dropped:
+			args["err"] = watcher.err.Error()
+			app.logger.Warn("a monitor has been disconnected", args)
+			return
+		case entry := <-watcher.entries:
+			line = entry.String()
+		case <-heartbeat.C():
+		}
+
+		if err := conn.SetWriteDeadline(time.Now().Add(monitorWriteTimeout)); err != nil {
+			return
+		}
+		if _, err := out.WriteString(line + "\n"); err != nil {
+			args["err"] = err.Error()
+			app.logger.Info("a monitor has disconnected", args)
+			return
+		}
+	}
+}
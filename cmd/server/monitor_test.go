@@ -0,0 +1,176 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/JorgeLNJunior/cacher/pkg/client"
+	"github.com/JorgeLNJunior/cacher/pkg/clock"
+	"github.com/JorgeLNJunior/cacher/pkg/data"
+)
+
+// watching returns how many monitors are connected.
+func (m *Monitor) watching() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return len(m.watchers)
+}
+
+func TestMonitor(t *testing.T) {
+	t.Run("should stream the requests whose key matches the pattern", func(tt *testing.T) {
+		app, addr := newTestApplication(tt, config{})
+		c := client.New(addr)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		entries := make(chan data.MonitorEntry, 10)
+		done := make(chan error, 1)
+		go func() {
+			done <- c.Monitor(ctx, "user:*", func(entry data.MonitorEntry) { entries <- entry })
+		}()
+		eventually(tt, "the monitor has not connected", func() bool { return app.monitor.watching() == 1 })
+
+		if err := c.Set("other", "bar"); err != nil {
+			tt.Fatal(err)
+		}
+		if err := c.Set("user:1", strings.Repeat("v", 100)); err != nil {
+			tt.Fatal(err)
+		}
+		if _, err := c.Get("user:1"); err != nil {
+			tt.Fatal(err)
+		}
+
+		set := <-entries
+		if set.Operation != data.OperationSet || set.Key != "user:1" || set.Addr == "" || !set.Time.Equal(testEpoch) {
+			tt.Errorf("expected the SET of user:1 but got %+v", set)
+		}
+		if set.Size != 100 || set.Value != strings.Repeat("v", monitorValueBytes) || !set.Truncated() {
+			tt.Errorf("expected the value to be truncated but got %+v", set)
+		}
+		if get := <-entries; get.Operation != data.OperationGet || get.Key != "user:1" {
+			tt.Errorf("expected the GET of user:1 but got %+v", get)
+		}
+
+		cancel()
+		if err := <-done; !errors.Is(err, context.Canceled) {
+			tt.Errorf("expected the monitor to be canceled but got %v", err)
+		}
+		if len(entries) != 0 {
+			tt.Errorf("expected only the requests of the pattern but got %+v", <-entries)
+		}
+		eventually(tt, "the monitor has not disconnected", func() bool {
+			app.clock.(*clock.Fake).Advance(monitorHeartbeat) // the server writes a heartbeat to notice it
+			return app.monitor.watching() == 0
+		})
+	})
+
+	t.Run("should only stream the requests that are authorized", func(tt *testing.T) {
+		usersFile := writeUsersFile(tt, "admin s3cret * *")
+		app, addr := newTestApplication(tt, config{usersFile: usersFile})
+		admin := &client.Client{Addr: addr, Timeout: client.DefaultTimeout, Credentials: &data.Credentials{User: "admin", Password: "s3cret"}}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		entries := make(chan data.MonitorEntry, 10)
+		go admin.Monitor(ctx, "*", func(entry data.MonitorEntry) { entries <- entry })
+		eventually(tt, "the monitor has not connected", func() bool { return app.monitor.watching() == 1 })
+
+		sendRequest(tt, addr, []byte("SET anonymous bar"))
+		sendRequest(tt, addr, []byte("AUTH admin wrong\nSET denied bar"))
+		if err := admin.Set("allowed", "bar"); err != nil {
+			tt.Fatal(err)
+		}
+
+		if entry := <-entries; entry.Key != "allowed" {
+			tt.Errorf("expected only the SET of allowed but got %+v", entry)
+		}
+	})
+
+	t.Run("should only stream the keys the user is allowed to access", func(tt *testing.T) {
+		usersFile := writeUsersFile(tt, "admin s3cret * *", "web hunter2 GET,SET,MONITOR session:*")
+		app, addr := newTestApplication(tt, config{usersFile: usersFile})
+		admin := &client.Client{Addr: addr, Timeout: client.DefaultTimeout, Credentials: &data.Credentials{User: "admin", Password: "s3cret"}}
+		web := &client.Client{Addr: addr, Timeout: client.DefaultTimeout, Credentials: &data.Credentials{User: "web", Password: "hunter2"}}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		entries := make(chan data.MonitorEntry, 10)
+		go web.Monitor(ctx, "*", func(entry data.MonitorEntry) { entries <- entry })
+		eventually(tt, "the monitor has not connected", func() bool { return app.monitor.watching() == 1 })
+
+		if err := admin.Set("config", "secret"); err != nil {
+			tt.Fatal(err)
+		}
+		if _, err := admin.Role(); err != nil {
+			tt.Fatal(err)
+		}
+		if err := admin.Set("session:1", "bar"); err != nil {
+			tt.Fatal(err)
+		}
+
+		if entry := <-entries; entry.Key != "session:1" {
+			tt.Errorf("expected only the SET of session:1 but got %+v", entry)
+		}
+	})
+
+	t.Run("should never stream the password of an AUTH", func(tt *testing.T) {
+		monitor := NewMonitor()
+		watcher, err := monitor.watch("*", nil)
+		if err != nil {
+			tt.Fatal(err)
+		}
+
+		monitor.Publish(testEpoch, "127.0.0.1:5000", data.Request{Operation: data.OperationAuth, Key: "alice", Value: "s3cret"})
+		if entry := <-watcher.entries; entry.Value != "" || entry.Size != 0 || entry.Key != "alice" {
+			tt.Errorf("expected an AUTH without the password but got %+v", entry)
+		}
+	})
+
+	t.Run("should drop a monitor that can't keep up", func(tt *testing.T) {
+		monitor := NewMonitor()
+		watcher, err := monitor.watch("*", nil)
+		if err != nil {
+			tt.Fatal(err)
+		}
+
+		for range monitorBufferSize + 1 {
+			monitor.Publish(testEpoch, "127.0.0.1:5000", data.Request{Operation: data.OperationGet, Key: "foo"})
+		}
+
+		select {
+		case <-watcher.dropped:
+		case <-time.After(time.Second):
+			tt.Fatal("expected the monitor to be dropped")
+		}
+		if !errors.Is(watcher.err, ErrMonitorTooSlow) || monitor.watching() != 0 {
+			tt.Errorf("expected ErrMonitorTooSlow but got %v", watcher.err)
+		}
+	})
+
+	t.Run("should disconnect the monitors when the server stops", func(tt *testing.T) {
+		app, addr := newTestApplication(tt, config{})
+
+		done := make(chan error, 1)
+		go func() {
+			done <- client.New(addr).Monitor(context.Background(), "*", func(data.MonitorEntry) {})
+		}()
+		eventually(tt, "the monitor has not connected", func() bool { return app.monitor.watching() == 1 })
+
+		app.monitor.Close()
+		if err := <-done; !errors.Is(err, client.ErrMonitorClosed) {
+			tt.Errorf("expected ErrMonitorClosed but got %v", err)
+		}
+	})
+
+	t.Run("should return an error if the pattern is invalid", func(tt *testing.T) {
+		_, addr := newTestApplication(tt, config{})
+
+		err := client.New(addr).Monitor(context.Background(), "[", func(data.MonitorEntry) {})
+		if err == nil || err.Error() != ErrInvalidPattern.Error() {
+			tt.Errorf("expected ErrInvalidPattern but got %v", err)
+		}
+	})
+}
@@ -0,0 +1,409 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/JorgeLNJunior/cacher/pkg/data"
+	"github.com/JorgeLNJunior/cacher/pkg/encryption"
+)
+
+// FsyncPolicy defines when the operation log is flushed to the disk.
+type FsyncPolicy string
+
+const (
+	FsyncAlways   FsyncPolicy = "always"   // fsync after every operation
+	FsyncEverySec FsyncPolicy = "everysec" // fsync once per second
+	FsyncNever    FsyncPolicy = "never"    // let the operating system decide
+)
+
+func (p FsyncPolicy) Valid() bool {
+	switch {
+	case p == FsyncAlways:
+		return true
+	case p == FsyncEverySec:
+		return true
+	case p == FsyncNever:
+		return true
+	default:
+		return false
+	}
+}
+
+const oplogFlushInterval = time.Second
+
+var ErrInvalidFsyncPolicy = errors.New("fsync policy must be always, everysec or never")
+
+var oplogAdditionalData = []byte("cacher oplog")
+
+// OperationLog is an append-only log of every mutation applied to a storage.
+// Each mutation is written as a JSON line, so replaying the log rebuilds the storage.
+// If the log has a keyring, each line is instead the base64 of the sealed JSON.
+type OperationLog struct {
+	path           string
+	keyring        *encryption.Keyring
+	file           *os.File
+	writer         *bufio.Writer
+	fsync          FsyncPolicy
+	size           int64 // current size of the log in bytes
+	baseSize       int64 // size of the log after the last rewrite
+	rewriteMinSize int64
+	rewriting      bool
+	rewriteBuffer  []Mutation // mutations applied while a rewrite is running
+	mu             sync.Mutex
+	stop           chan struct{}
+	background     sync.WaitGroup
+}
+
+// OpenOperationLog opens or creates the log at path. A log bigger than twice its size
+// after the last rewrite and at least rewriteMinSize bytes is rewritten in background.
+// New records are encrypted if keyring is not nil.
+func OpenOperationLog(path string, fsync FsyncPolicy, rewriteMinSize int64, keyring *encryption.Keyring) (*OperationLog, error) {
+	if !fsync.Valid() {
+		return nil, ErrInvalidFsyncPolicy
+	}
+
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o600)
+	if err != nil {
+		return nil, err
+	}
+
+	size, err := file.Seek(0, io.SeekEnd)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	return &OperationLog{
+		path:           path,
+		keyring:        keyring,
+		file:           file,
+		writer:         bufio.NewWriter(file),
+		fsync:          fsync,
+		size:           size,
+		baseSize:       size,
+		rewriteMinSize: rewriteMinSize,
+		stop:           make(chan struct{}),
+	}, nil
+}
+
+// Replay applies every mutation in the log to a storage and returns how many were applied.
+// A partially written last record, left by a crash, is discarded.
+func (l *OperationLog) Replay(storage *InMemoryStorage) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if _, err := l.file.Seek(0, io.SeekStart); err != nil {
+		return 0, err
+	}
+
+	var offset int64
+	var applied int
+	reader := bufio.NewReader(l.file)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if errors.Is(err, io.EOF) {
+			if len(line) > 0 {
+				// the last record was not completely written
+				if err := l.file.Truncate(offset); err != nil {
+					return applied, err
+				}
+			}
+			break
+		}
+		if err != nil {
+			return applied, err
+		}
+
+		m, err := l.decode(line)
+		if err != nil {
+			return applied, fmt.Errorf("corrupted operation log at offset %d: %w", offset, err)
+		}
+		applyMutation(storage, m)
+
+		offset += int64(len(line))
+		applied++
+	}
+
+	if _, err := l.file.Seek(offset, io.SeekStart); err != nil {
+		return applied, err
+	}
+	l.size = offset
+	l.baseSize = offset
+
+	return applied, nil
+}
+
+// encode returns the line of a mutation, encrypted if the log has a keyring.
+func (l *OperationLog) encode(m Mutation) ([]byte, error) {
+	line, err := json.Marshal(m)
+	if err != nil {
+		return nil, err
+	}
+
+	if l.keyring != nil {
+		sealed, err := l.keyring.Seal(line, oplogAdditionalData)
+		if err != nil {
+			return nil, err
+		}
+		line = base64.StdEncoding.AppendEncode(nil, sealed)
+	}
+
+	return append(line, '\n'), nil
+}
+
+// decode parses a line of the log. Plaintext lines are accepted even if the log
+// has a keyring, so encryption can be enabled on an existing log.
+func (l *OperationLog) decode(line []byte) (Mutation, error) {
+	m := Mutation{}
+	line = bytes.TrimSuffix(line, []byte("\n"))
+
+	if !bytes.HasPrefix(line, []byte("{")) {
+		if l.keyring == nil {
+			return m, encryption.ErrEncrypted
+		}
+
+		sealed, err := base64.StdEncoding.AppendDecode(nil, line)
+		if err != nil {
+			return m, err
+		}
+		if line, err = l.keyring.Open(sealed, oplogAdditionalData); err != nil {
+			return m, err
+		}
+	}
+
+	err := json.Unmarshal(line, &m)
+	return m, err
+}
+
+func applyMutation(storage *InMemoryStorage, m Mutation) {
+	switch m.Operation {
+	case data.OperationSet:
+		if m.Expiry.IsZero() {
+			storage.Set(m.Key, m.Value)
+		} else {
+			storage.Put(m.Key, StorageItem{Value: m.Value, Expiry: m.Expiry, TTL: m.TTL})
+		}
+	case data.OperationDel:
+		storage.Delete(m.Key)
+	case data.OperationExp:
+		storage.ExpireAt(m.Key, m.Expiry)
+	}
+}
+
+// Append writes a mutation to the log.
+func (l *OperationLog) Append(m Mutation) error {
+	line, err := l.encode(m)
+	if err != nil {
+		return err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.rewriting {
+		l.rewriteBuffer = append(l.rewriteBuffer, m)
+	}
+
+	n, err := l.writer.Write(line)
+	l.size += int64(n)
+	if err != nil {
+		return err
+	}
+
+	if l.fsync == FsyncAlways {
+		return l.sync()
+	}
+
+	return nil
+}
+
+// Start flushes the log once per second in background, fsyncing it if the policy
+// is everysec, and rewrites it from source when it grows too much. Errors are
+// reported to onError. It runs until Close is called.
+func (l *OperationLog) Start(source Storage, onError func(error)) {
+	l.background.Add(1)
+	go func() {
+		defer l.background.Done()
+
+		ticker := time.NewTicker(oplogFlushInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-l.stop:
+				return
+			case <-ticker.C:
+				if err := l.flush(); err != nil {
+					onError(err)
+				}
+				if l.shouldRewrite() {
+					l.background.Add(1)
+					go func() {
+						defer l.background.Done()
+						if err := l.Rewrite(source); err != nil {
+							onError(err)
+						}
+					}()
+				}
+			}
+		}
+	}()
+}
+
+func (l *OperationLog) flush() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.fsync == FsyncEverySec {
+		return l.sync()
+	}
+	return l.writer.Flush()
+}
+
+func (l *OperationLog) sync() error {
+	if err := l.writer.Flush(); err != nil {
+		return err
+	}
+	return l.file.Sync()
+}
+
+func (l *OperationLog) shouldRewrite() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return !l.rewriting && l.size > l.baseSize && l.size >= l.rewriteMinSize && l.size >= l.baseSize*2
+}
+
+// Rewrite compacts the log by replacing it with the minimal set of mutations that
+// rebuilds the current data of the storage, encrypting every record with the
+// current key. Writers are only blocked while the
+// mutations applied during the rewrite are copied to the new log.
+func (l *OperationLog) Rewrite(source Storage) error {
+	l.mu.Lock()
+	if l.rewriting {
+		l.mu.Unlock()
+		return nil
+	}
+	l.rewriting = true
+	l.rewriteBuffer = nil
+	l.mu.Unlock()
+
+	// mutations applied after the rewrite started and before the dump was taken
+	// are both in the dump and in the buffer, replaying them twice is harmless
+	dump := source.Dump()
+
+	tmpPath := l.path + ".rewrite"
+	tmp, err := os.OpenFile(tmpPath, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		l.abortRewrite()
+		return err
+	}
+
+	writer := bufio.NewWriter(tmp)
+	size, err := l.writeMutations(writer, dumpMutations(dump))
+	if err != nil {
+		tmp.Close()
+		os.Remove(tmpPath)
+		l.abortRewrite()
+		return err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	buffered, err := l.writeMutations(writer, l.rewriteBuffer)
+	if err == nil {
+		err = writer.Flush()
+	}
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if err == nil {
+		err = os.Rename(tmpPath, l.path)
+	}
+	l.rewriting = false
+	l.rewriteBuffer = nil
+	if err != nil {
+		tmp.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+
+	// the old file may have buffered data that is already in the new log
+	l.writer.Reset(tmp)
+	l.file.Close()
+	l.file = tmp
+	l.size = size + buffered
+	l.baseSize = l.size
+
+	return nil
+}
+
+func (l *OperationLog) abortRewrite() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.rewriting = false
+	l.rewriteBuffer = nil
+}
+
+func dumpMutations(dump map[string]StorageItem) []Mutation {
+	mutations := make([]Mutation, 0, len(dump))
+	for key, item := range dump {
+		mutations = append(mutations, Mutation{
+			Operation: data.OperationSet,
+			Key:       key,
+			Value:     item.Value,
+			Expiry:    item.Expiry,
+			TTL:       item.TTL,
+		})
+	}
+	return mutations
+}
+
+func (l *OperationLog) writeMutations(w io.Writer, mutations []Mutation) (int64, error) {
+	var size int64
+
+	for _, m := range mutations {
+		line, err := l.encode(m)
+		if err != nil {
+			return size, err
+		}
+
+		n, err := w.Write(line)
+		size += int64(n)
+		if err != nil {
+			return size, err
+		}
+	}
+
+	return size, nil
+}
+
+// Close flushes and fsyncs pending mutations and closes the log.
+func (l *OperationLog) Close() error {
+	select {
+	case <-l.stop:
+	default:
+		close(l.stop)
+	}
+	l.background.Wait()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if err := l.sync(); err != nil {
+		l.file.Close()
+		return err
+	}
+	return l.file.Close()
+}
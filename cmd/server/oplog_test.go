@@ -0,0 +1,197 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/JorgeLNJunior/cacher/pkg/clock"
+	"github.com/JorgeLNJunior/cacher/pkg/encryption"
+)
+
+func TestOperationLog(t *testing.T) {
+	t.Run("should return an error if the fsync policy is invalid", func(tt *testing.T) {
+		path := filepath.Join(tt.TempDir(), "oplog")
+
+		if _, err := OpenOperationLog(path, "sometimes", 0, nil); !errors.Is(err, ErrInvalidFsyncPolicy) {
+			tt.Fatalf("expected ErrInvalidFsyncPolicy but received '%s'", err)
+		}
+	})
+
+	t.Run("should rebuild the storage by replaying the log", func(tt *testing.T) {
+		path := filepath.Join(tt.TempDir(), "oplog")
+		clock := clock.NewFake(testEpoch)
+
+		source := newLoggedStorage(tt, clock, path)
+		source.Set("foo", "bar")
+		source.Set("baz", "qux")
+		source.Set("quux", "corge")
+		source.ExpireAt("foo", testEpoch.Add(time.Minute))
+		source.Delete("baz")
+		closeLoggedStorage(tt, source)
+
+		target := newLoggedStorage(tt, clock, path)
+		defer closeLoggedStorage(tt, target)
+
+		if value, ok := target.Get("quux"); !ok || value != "corge" {
+			tt.Fatalf("expected 'corge' but got '%s'", value)
+		}
+		if _, ok := target.Get("baz"); ok {
+			tt.Fatal("a deleted key has been restored")
+		}
+		if item := target.data["foo"]; !item.Expiry.Equal(testEpoch.Add(time.Minute)) || !item.TTL {
+			tt.Fatalf("expected the expiry set by EXP to be restored but got %+v", item)
+		}
+		if stats := target.Stats(); stats.Volatile != 1 {
+			tt.Fatalf("expected 1 key with an expiry but got %d", stats.Volatile)
+		}
+	})
+
+	t.Run("should replay the values that are not valid UTF-8", func(tt *testing.T) {
+		path := filepath.Join(tt.TempDir(), "oplog")
+		clock := clock.NewFake(testEpoch)
+		binary := "\xff\xfe\x00\x80bin"
+
+		source := newLoggedStorage(tt, clock, path)
+		source.Set("key\xc3", binary)
+		closeLoggedStorage(tt, source)
+
+		target := newLoggedStorage(tt, clock, path)
+		defer closeLoggedStorage(tt, target)
+
+		if value, ok := target.Get("key\xc3"); !ok || value != binary {
+			tt.Fatalf("expected %q but got %q", binary, value)
+		}
+	})
+
+	t.Run("should replay the records whose key and value are not base64 encoded", func(tt *testing.T) {
+		path := filepath.Join(tt.TempDir(), "oplog")
+		clock := clock.NewFake(testEpoch)
+
+		record := `{"Operation":"SET","Key":"foo","Value":"bar"}` + "\n"
+		if err := os.WriteFile(path, []byte(record), 0o600); err != nil {
+			tt.Fatal(err)
+		}
+
+		target := newLoggedStorage(tt, clock, path)
+		defer closeLoggedStorage(tt, target)
+
+		if value, ok := target.Get("foo"); !ok || value != "bar" {
+			tt.Fatalf("expected 'bar' but got '%s'", value)
+		}
+	})
+
+	t.Run("should discard a partially written last record", func(tt *testing.T) {
+		path := filepath.Join(tt.TempDir(), "oplog")
+		clock := clock.NewFake(testEpoch)
+
+		source := newLoggedStorage(tt, clock, path)
+		source.Set("foo", "bar")
+		closeLoggedStorage(tt, source)
+
+		file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0o600)
+		if err != nil {
+			tt.Fatal(err)
+		}
+		if _, err := file.WriteString(`{"Operation":"SET","Key":"ba`); err != nil {
+			tt.Fatal(err)
+		}
+		file.Close()
+
+		target := newLoggedStorage(tt, clock, path)
+		target.Set("baz", "qux")
+		closeLoggedStorage(tt, target)
+
+		restored := newLoggedStorage(tt, clock, path)
+		defer closeLoggedStorage(tt, restored)
+
+		if len(restored.data) != 2 {
+			tt.Fatalf("expected 2 restored keys but got %d", len(restored.data))
+		}
+	})
+
+	t.Run("should compact the log and keep mutations applied after the rewrite", func(tt *testing.T) {
+		path := filepath.Join(tt.TempDir(), "oplog")
+		clock := clock.NewFake(testEpoch)
+
+		source := newLoggedStorage(tt, clock, path)
+		for range 100 {
+			source.Set("foo", randomString())
+		}
+		source.Set("foo", "bar")
+
+		before := fileSize(tt, path)
+		if err := source.oplog.Rewrite(source); err != nil {
+			tt.Fatal(err)
+		}
+		if after := fileSize(tt, path); after >= before {
+			tt.Fatalf("expected the log to shrink from %d bytes but got %d bytes", before, after)
+		}
+
+		source.Set("baz", "qux")
+		closeLoggedStorage(tt, source)
+
+		target := newLoggedStorage(tt, clock, path)
+		defer closeLoggedStorage(tt, target)
+
+		if value, _ := target.Get("foo"); value != "bar" {
+			tt.Fatalf("expected 'bar' but got '%s'", value)
+		}
+		if value, _ := target.Get("baz"); value != "qux" {
+			tt.Fatalf("expected 'qux' but got '%s'", value)
+		}
+	})
+}
+
+type loggedStorage struct {
+	*InMemoryStorage
+	oplog *OperationLog
+}
+
+func newLoggedStorage(t *testing.T, c clock.Clock, path string) loggedStorage {
+	t.Helper()
+
+	return newEncryptedLoggedStorage(t, c, path, nil)
+}
+
+func newEncryptedLoggedStorage(t *testing.T, c clock.Clock, path string, keyring *encryption.Keyring) loggedStorage {
+	t.Helper()
+
+	oplog, err := OpenOperationLog(path, FsyncAlways, 0, keyring)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	storage := NewInMemoryStorageWithClock(c)
+	if _, err := oplog.Replay(storage); err != nil {
+		t.Fatal(err)
+	}
+	storage.Observe(func(m Mutation) {
+		if err := oplog.Append(m); err != nil {
+			t.Error(err)
+		}
+	})
+
+	return loggedStorage{storage, oplog}
+}
+
+func closeLoggedStorage(t *testing.T, s loggedStorage) {
+	t.Helper()
+
+	s.Close()
+	if err := s.oplog.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func fileSize(t *testing.T, path string) int64 {
+	t.Helper()
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return info.Size()
+}
@@ -1,90 +1,371 @@
 package main
 
 import (
+	"bytes"
 	"context"
-	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
 	"os"
-	"path"
 	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/JorgeLNJunior/cacher/pkg/dumpfile"
+	"github.com/JorgeLNJunior/cacher/pkg/encryption"
+)
+
+const (
+	defaultDumpFile = "dump"
+	lockFileName    = "LOCK"
+	backupsDir      = "backups"
+)
+
+var (
+	ErrDataDirLocked     = errors.New("the data directory is locked by another server")
+	ErrInvalidBackupName = errors.New("the backup name must be a file name without a path")
 )
 
 type OnDiskStorage struct {
 	dataDir      string
-	dumpFileName string
+	dumpFile     string // path of the current dump
+	lock         *lockFile
+	generations  int // how many previous dumps to keep
+	format       dumpfile.Format
+	compress     bool
+	keyring      *encryption.Keyring // encrypts the dumps if not nil
+	maxValueSize int64               // see OnDiskConfig.MaxValueSize
+	status       PersistStatus
+	observers    []func(time.Duration)
+	mu           sync.Mutex // guards status and observers
+	writeMu      sync.Mutex // serializes writes to the dump
 }
 
-// NewOnDiskStorage return a new instance of OnDiskStorage.
-func NewOnDiskStorage() (*OnDiskStorage, error) {
-	userConfigDir, err := os.UserConfigDir()
-	if err != nil {
-		return nil, err
+// PersistStatus describes the last successful Persist.
+type PersistStatus struct {
+	Time     time.Time     // when the data was persisted
+	Duration time.Duration // how long it took to persist the data
+	Failed   bool          // whether a Persist has failed since then
+}
+
+// OnDiskConfig configures where and how an OnDiskStorage writes dumps.
+type OnDiskConfig struct {
+	DataDir      string              // defaults to the cacher directory in the user config directory
+	DumpFile     string              // relative paths are relative to DataDir
+	Generations  int                 // how many previous dumps to keep besides the current one
+	Format       dumpfile.Format     // format of new dumps, existing dumps are read in any format
+	Compress     bool                // compress the values of binary dumps
+	Keyring      *encryption.Keyring // encrypts the dumps if not nil
+	MaxValueSize int64               // max size of a compressed value once decompressed, 0 disables it
+}
+
+// NewOnDiskStorage return a new instance of OnDiskStorage. It locks the data directory
+// until Close is called, so two servers can't use the same directory.
+func NewOnDiskStorage(cfg OnDiskConfig) (*OnDiskStorage, error) {
+	if !cfg.Format.Valid() {
+		return nil, dumpfile.ErrInvalidFormat
+	}
+
+	dataDir := cfg.DataDir
+	if dataDir == "" {
+		userConfigDir, err := os.UserConfigDir()
+		if err != nil {
+			return nil, err
+		}
+		dataDir = filepath.Join(userConfigDir, "cacher")
 	}
 
-	dataDir := filepath.Join(userConfigDir, "cacher")
-	dumpFileName := "dump"
+	dumpFile := cfg.DumpFile
+	if dumpFile == "" {
+		dumpFile = defaultDumpFile
+	}
+	if !filepath.IsAbs(dumpFile) {
+		dumpFile = filepath.Join(dataDir, dumpFile)
+	}
 
-	if err := os.MkdirAll(dataDir, os.ModePerm); err != nil {
+	if err := os.MkdirAll(dataDir, 0o700); err != nil {
 		return nil, err
 	}
-	file, err := os.Create(path.Join(dataDir, dumpFileName))
-	if err != nil {
+	if err := os.MkdirAll(filepath.Dir(dumpFile), 0o700); err != nil {
 		return nil, err
 	}
-	defer file.Close()
+
+	lock, err := acquireLock(filepath.Join(dataDir, lockFileName))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", dataDir, err)
+	}
 
 	return &OnDiskStorage{
 		dataDir:      dataDir,
-		dumpFileName: dumpFileName,
+		dumpFile:     dumpFile,
+		lock:         lock,
+		generations:  cfg.Generations,
+		format:       cfg.Format,
+		compress:     cfg.Compress,
+		keyring:      cfg.Keyring,
+		maxValueSize: cfg.MaxValueSize,
 	}, nil
 }
 
-// Persist persists the data from a storage on disk
-func (s OnDiskStorage) Persist(ctx context.Context, storage Storage) error {
+// Persist persists the data from a storage on disk. The storage is only locked while
+// its data is copied, so it keeps serving while the copy is encoded.
+//
+// The data is written to a temporary file that atomically replaces the current dump
+// once it's on disk, so a crash never leaves a partially written dump behind.
+func (s *OnDiskStorage) Persist(ctx context.Context, storage Storage) (err error) {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	defer func() {
+		if err != nil {
+			s.mu.Lock()
+			s.status.Failed = true
+			s.mu.Unlock()
+		}
+	}()
+
 	select {
 	case <-ctx.Done():
 		return ctx.Err()
 	default:
+		start := time.Now()
 		dump := storage.Dump()
 
-		file, err := os.OpenFile(path.Join(s.dataDir, s.dumpFileName), os.O_RDWR|os.O_TRUNC, os.ModePerm)
-		if err != nil {
+		tmpPath := s.dumpPath(0) + ".tmp"
+		defer os.Remove(tmpPath) // no-op once the file is renamed
+
+		if err := s.writeDumpFile(tmpPath, dump); err != nil {
 			return err
 		}
-		defer file.Close()
+		if err := s.rotate(); err != nil {
+			return err
+		}
+		if err := os.Rename(tmpPath, s.dumpPath(0)); err != nil {
+			return err
+		}
+		if err := syncDir(filepath.Dir(s.dumpFile)); err != nil {
+			return err
+		}
+
+		duration := time.Since(start)
+		s.mu.Lock()
+		s.status = PersistStatus{Time: start, Duration: duration}
+		observers := s.observers
+		s.mu.Unlock()
+		for _, observer := range observers {
+			observer(duration)
+		}
+
+		return nil
+	}
+}
+
+// writeDumpFile writes a dump to path and flushes it to the disk.
+func (s *OnDiskStorage) writeDumpFile(path string, dump map[string]StorageItem) error {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	if s.keyring != nil {
+		err = dumpfile.WriteEncrypted(file, dump, s.format, s.compress, s.keyring)
+	} else {
+		err = dumpfile.Write(file, dump, s.format, s.compress)
+	}
+	if err != nil {
+		return err
+	}
+	return file.Sync()
+}
+
+// rotate shifts the previous dumps by one generation, dropping the oldest, and
+// links the current dump as the first previous generation. The current dump is
+// never removed, so there is always a dump to restore from.
+func (s *OnDiskStorage) rotate() error {
+	if s.generations < 1 {
+		return nil
+	}
+	if _, err := os.Stat(s.dumpPath(0)); errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
 
-		if err := json.NewEncoder(file).Encode(dump); err != nil {
+	for gen := s.generations - 1; gen > 0; gen-- {
+		err := os.Rename(s.dumpPath(gen), s.dumpPath(gen+1))
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
 			return err
 		}
+	}
+
+	if err := os.Remove(s.dumpPath(1)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return os.Link(s.dumpPath(0), s.dumpPath(1))
+}
+
+// dumpPath returns the path of a dump generation, 0 being the current one.
+func (s *OnDiskStorage) dumpPath(generation int) string {
+	if generation == 0 {
+		return s.dumpFile
+	}
+	return fmt.Sprintf("%s.%d", s.dumpFile, generation)
+}
+
+// Path returns the path of a file in the data directory.
+func (s *OnDiskStorage) Path(name string) string {
+	return filepath.Join(s.dataDir, name)
+}
 
+// Close releases the lock on the data directory.
+func (s *OnDiskStorage) Close() error {
+	if s.lock == nil {
 		return nil
 	}
+	return s.lock.release()
 }
 
-// Restore restores the data from disk to a storage.
-func (s OnDiskStorage) Restore(ctx context.Context, storage Storage) error {
+// ObservePersists registers a function that is called with the duration of every
+// successful Persist.
+func (s *OnDiskStorage) ObservePersists(fn func(time.Duration)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.observers = append(s.observers, fn)
+}
+
+// Status returns the status of the last successful Persist.
+func (s *OnDiskStorage) Status() PersistStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.status
+}
+
+// Restore restores the data from disk to a storage. The dump is verified before
+// any data is copied into the storage, expired items are skipped and keys that are
+// already stored are handled according to policy.
+func (s *OnDiskStorage) Restore(ctx context.Context, storage Storage, policy ConflictPolicy) (RestoreStats, error) {
 	select {
 	case <-ctx.Done():
-		return ctx.Err()
+		return RestoreStats{}, ctx.Err()
 	default:
-		file, err := os.Open(path.Join(s.dataDir, s.dumpFileName))
+		file, err := os.Open(s.dumpPath(0))
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				return RestoreStats{}, nil // nothing has been persisted yet
+			}
+			return RestoreStats{}, err
+		}
+		defer file.Close()
+
+		dump, err := dumpfile.Read(file, s.keyring, s.maxValueSize)
 		if err != nil {
+			return RestoreStats{}, fmt.Errorf("%s: %w", file.Name(), err)
+		}
+
+		return storage.Restore(dump, policy)
+	}
+}
+
+// Backup writes a point-in-time snapshot of a storage to the backup called name in
+// the backups directory, replacing it if it exists.
+func (s *OnDiskStorage) Backup(ctx context.Context, storage Storage, name string) error {
+	path, err := s.backupPath(name)
+	if err != nil {
+		return err
+	}
+
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	default:
+		dump := storage.Dump()
+
+		if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+			return err
+		}
+
+		tmpPath := path + ".tmp"
+		defer os.Remove(tmpPath) // no-op once the file is renamed
+
+		if err := s.writeDumpFile(tmpPath, dump); err != nil {
+			return err
+		}
+		if err := os.Rename(tmpPath, path); err != nil {
 			return err
 		}
+		return syncDir(filepath.Dir(path))
+	}
+}
+
+// RestoreBackup restores the backup called name to a storage like Restore does.
+func (s *OnDiskStorage) RestoreBackup(ctx context.Context, storage Storage, name string, policy ConflictPolicy) (RestoreStats, error) {
+	path, err := s.backupPath(name)
+	if err != nil {
+		return RestoreStats{}, err
+	}
+
+	select {
+	case <-ctx.Done():
+		return RestoreStats{}, ctx.Err()
+	default:
+		file, err := os.Open(path)
+		if err != nil {
+			return RestoreStats{}, err
+		}
 		defer file.Close()
 
-		dump := make(map[string]StorageItem)
-		if err := json.NewDecoder(file).Decode(&dump); err != nil {
-			switch {
-			case errors.Is(err, io.EOF):
-				return nil // file is empty
-			default:
-				return err
-			}
+		dump, err := dumpfile.Read(file, s.keyring, s.maxValueSize)
+		if err != nil {
+			return RestoreStats{}, fmt.Errorf("%s: %w", name, err)
 		}
-		storage.Restore(dump)
 
-		return nil
+		return storage.Restore(dump, policy)
 	}
 }
+
+// backupPath returns the path of a backup, making sure it's in the backups directory.
+func (s *OnDiskStorage) backupPath(name string) (string, error) {
+	if name == "" || name == "." || name == ".." || filepath.Base(name) != name || strings.ContainsAny(name, `/\`) {
+		return "", ErrInvalidBackupName
+	}
+	return filepath.Join(s.dataDir, backupsDir, name), nil
+}
+
+// WriteSnapshot writes a point-in-time snapshot of a storage to w in the given format,
+// encrypted if keyring is not nil. The snapshot is encoded in memory because the dump
+// header is written last, so a plaintext snapshot never touches the disk.
+func WriteSnapshot(w io.Writer, storage Storage, format dumpfile.Format, compress bool, keyring *encryption.Keyring) error {
+	dump := storage.Dump()
+
+	if keyring != nil {
+		return dumpfile.WriteEncrypted(w, dump, format, compress, keyring)
+	}
+	return dumpfile.WriteBuffered(w, dump, format, compress)
+}
+
+// ReadSnapshot verifies and restores a snapshot written by WriteSnapshot to a storage
+// like Restore does. keyring may be nil if the snapshot is not encrypted, maxValueSize
+// bounds its decompressed values like in dumpfile.Read.
+func ReadSnapshot(snapshot []byte, storage Storage, policy ConflictPolicy, keyring *encryption.Keyring, maxValueSize int64) (RestoreStats, error) {
+	dump, err := dumpfile.Read(bytes.NewReader(snapshot), keyring, maxValueSize)
+	if err != nil {
+		return RestoreStats{}, err
+	}
+
+	return storage.Restore(dump, policy)
+}
+
+// syncDir flushes a directory entry changes, like a rename, to the disk.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+
+	return d.Sync()
+}
@@ -0,0 +1,359 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/JorgeLNJunior/cacher/pkg/clock"
+	"github.com/JorgeLNJunior/cacher/pkg/dumpfile"
+)
+
+func TestPersistExpiringKeys(t *testing.T) {
+	t.Run("should keep the expiry of persisted keys", func(tt *testing.T) {
+		clock := clock.NewFake(testEpoch)
+		disk := newTestOnDiskStorage(tt)
+
+		source := NewInMemoryStorageWithClock(clock)
+		defer source.Close()
+		source.Set("foo", "bar")
+		source.ExpireAt("foo", testEpoch.Add(time.Minute))
+
+		if err := disk.Persist(context.Background(), source); err != nil {
+			tt.Fatal(err)
+		}
+
+		target := NewInMemoryStorageWithClock(clock)
+		defer target.Close()
+		if _, err := disk.Restore(context.Background(), target, ConflictFail); err != nil {
+			tt.Fatal(err)
+		}
+
+		if value, ok := target.Get("foo"); !ok || value != "bar" {
+			tt.Fatalf("expected the restored value to be 'bar' but got '%s'", value)
+		}
+
+		clock.Advance(time.Minute + time.Second)
+		if _, ok := target.Get("foo"); ok {
+			tt.Fatal("the restored key has not kept its expiry")
+		}
+	})
+
+	t.Run("should sweep restored keys that expire", func(tt *testing.T) {
+		clock := clock.NewFake(testEpoch)
+		disk := newTestOnDiskStorage(tt)
+
+		source := NewInMemoryStorageWithClock(clock)
+		defer source.Close()
+		source.Set("foo", "bar")
+		source.ExpireAt("foo", testEpoch.Add(sweepInterval/2))
+
+		if err := disk.Persist(context.Background(), source); err != nil {
+			tt.Fatal(err)
+		}
+
+		target := NewInMemoryStorageWithClock(clock)
+		if _, err := disk.Restore(context.Background(), target, ConflictFail); err != nil {
+			tt.Fatal(err)
+		}
+
+		clock.Advance(sweepInterval)
+		target.Close()
+
+		if _, found := target.data["foo"]; found {
+			tt.Fatal("the restored key has not been swept after its expiry")
+		}
+	})
+}
+
+func newTestOnDiskStorage(t testing.TB) *OnDiskStorage {
+	t.Helper()
+
+	dataDir := t.TempDir()
+	return &OnDiskStorage{
+		dataDir:     dataDir,
+		dumpFile:    filepath.Join(dataDir, "dump"),
+		generations: 2,
+		format:      dumpfile.FormatBinary,
+		compress:    true,
+	}
+}
+
+func TestPersist(t *testing.T) {
+	t.Run("should keep the previous generations of the dump", func(tt *testing.T) {
+		disk := newTestOnDiskStorage(tt)
+		storage := NewInMemoryStorage()
+		defer storage.Close()
+
+		for _, value := range []string{"first", "second", "third", "fourth"} {
+			storage.Set("foo", value)
+			if err := disk.Persist(context.Background(), storage); err != nil {
+				tt.Fatal(err)
+			}
+		}
+
+		expected := map[int]string{0: "fourth", 1: "third", 2: "second"}
+		for gen, value := range expected {
+			file, err := os.Open(disk.dumpPath(gen))
+			if err != nil {
+				tt.Fatal(err)
+			}
+			dump, err := dumpfile.Read(file, nil, 0)
+			file.Close()
+			if err != nil {
+				tt.Fatal(err)
+			}
+			if dump["foo"].Value != value {
+				tt.Errorf("expected generation %d to have '%s' but got '%s'", gen, value, dump["foo"].Value)
+			}
+		}
+
+		if _, err := os.Stat(disk.dumpPath(3)); !errors.Is(err, os.ErrNotExist) {
+			tt.Error("expected the oldest generation to be removed")
+		}
+	})
+
+	t.Run("should report a failure until the next successful persist", func(tt *testing.T) {
+		disk := newTestOnDiskStorage(tt)
+		storage := NewInMemoryStorage()
+		defer storage.Close()
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		if err := disk.Persist(ctx, storage); err == nil {
+			tt.Fatal("expected the persist to fail")
+		}
+		if status := disk.Status(); !status.Failed || !status.Time.IsZero() {
+			tt.Errorf("expected a failure without a successful persist but got %+v", status)
+		}
+
+		if err := disk.Persist(context.Background(), storage); err != nil {
+			tt.Fatal(err)
+		}
+		if status := disk.Status(); status.Failed || status.Time.IsZero() {
+			tt.Errorf("expected a successful persist but got %+v", status)
+		}
+	})
+}
+
+func TestRestore(t *testing.T) {
+	t.Run("should not fail if nothing has been persisted", func(tt *testing.T) {
+		storage := NewInMemoryStorage()
+		defer storage.Close()
+
+		if _, err := newTestOnDiskStorage(tt).Restore(context.Background(), storage, ConflictFail); err != nil {
+			tt.Fatal(err)
+		}
+	})
+
+	t.Run("should restore a dump without header", func(tt *testing.T) {
+		disk := newTestOnDiskStorage(tt)
+		legacy := `{"foo":{"Value":"bar","Expiry":"2100-01-01T00:00:00Z"}}` + "\n"
+		if err := os.WriteFile(disk.dumpPath(0), []byte(legacy), 0o600); err != nil {
+			tt.Fatal(err)
+		}
+
+		storage := NewInMemoryStorage()
+		defer storage.Close()
+		if _, err := disk.Restore(context.Background(), storage, ConflictFail); err != nil {
+			tt.Fatal(err)
+		}
+		if value, _ := storage.Get("foo"); value != "bar" {
+			tt.Fatalf("expected 'bar' but got '%s'", value)
+		}
+	})
+
+	t.Run("should reject a corrupted dump without loading it", func(tt *testing.T) {
+		disk := persistTestDump(tt)
+
+		content, err := os.ReadFile(disk.dumpPath(0))
+		if err != nil {
+			tt.Fatal(err)
+		}
+		content[len(content)-4] ^= 0xff
+		if err := os.WriteFile(disk.dumpPath(0), content, 0o600); err != nil {
+			tt.Fatal(err)
+		}
+
+		storage := NewInMemoryStorage()
+		defer storage.Close()
+		_, err = disk.Restore(context.Background(), storage, ConflictFail)
+		if !errors.Is(err, dumpfile.ErrChecksumMismatch) {
+			tt.Fatalf("expected dumpfile.ErrChecksumMismatch but received '%s'", err)
+		}
+		if len(storage.data) != 0 {
+			tt.Fatalf("expected no restored keys but got %d", len(storage.data))
+		}
+	})
+
+	t.Run("should reject a truncated dump", func(tt *testing.T) {
+		disk := persistTestDump(tt)
+
+		info, err := os.Stat(disk.dumpPath(0))
+		if err != nil {
+			tt.Fatal(err)
+		}
+		if err := os.Truncate(disk.dumpPath(0), info.Size()-10); err != nil {
+			tt.Fatal(err)
+		}
+
+		storage := NewInMemoryStorage()
+		defer storage.Close()
+		if _, err := disk.Restore(context.Background(), storage, ConflictFail); !errors.Is(err, dumpfile.ErrLengthMismatch) {
+			tt.Fatalf("expected dumpfile.ErrLengthMismatch but received '%s'", err)
+		}
+	})
+
+	t.Run("should reject an unsupported format version", func(tt *testing.T) {
+		disk := persistTestDump(tt)
+
+		content, err := os.ReadFile(disk.dumpPath(0))
+		if err != nil {
+			tt.Fatal(err)
+		}
+		content[len(dumpfile.Magic)] = 0xff
+		if err := os.WriteFile(disk.dumpPath(0), content, 0o600); err != nil {
+			tt.Fatal(err)
+		}
+
+		storage := NewInMemoryStorage()
+		defer storage.Close()
+		if _, err := disk.Restore(context.Background(), storage, ConflictFail); !errors.Is(err, dumpfile.ErrUnsupportedVersion) {
+			tt.Fatalf("expected dumpfile.ErrUnsupportedVersion but received '%s'", err)
+		}
+	})
+
+	t.Run("should reject a compressed value larger than the max value size", func(tt *testing.T) {
+		disk := newTestOnDiskStorage(tt)
+		disk.maxValueSize = 1024
+		storage := NewInMemoryStorage()
+		defer storage.Close()
+
+		storage.Set("foo", strings.Repeat("a", 1025))
+		if err := disk.Persist(context.Background(), storage); err != nil {
+			tt.Fatal(err)
+		}
+		if _, err := disk.Restore(context.Background(), storage, ConflictDumpWins); !errors.Is(err, dumpfile.ErrValueTooLarge) {
+			tt.Fatalf("expected dumpfile.ErrValueTooLarge but received '%s'", err)
+		}
+	})
+}
+
+func persistTestDump(t *testing.T) *OnDiskStorage {
+	t.Helper()
+
+	disk := newTestOnDiskStorage(t)
+	storage := NewInMemoryStorage()
+	defer storage.Close()
+
+	for range 10 {
+		storage.Set(randomString(), randomString())
+	}
+	if err := disk.Persist(context.Background(), storage); err != nil {
+		t.Fatal(err)
+	}
+
+	return disk
+}
+
+func TestNewOnDiskStorage(t *testing.T) {
+	t.Run("should refuse to use a data directory locked by another server", func(tt *testing.T) {
+		cfg := OnDiskConfig{DataDir: tt.TempDir(), Format: dumpfile.FormatBinary}
+
+		first, err := NewOnDiskStorage(cfg)
+		if err != nil {
+			tt.Fatal(err)
+		}
+
+		if _, err := NewOnDiskStorage(cfg); !errors.Is(err, ErrDataDirLocked) {
+			tt.Fatalf("expected ErrDataDirLocked but received '%s'", err)
+		}
+
+		if err := first.Close(); err != nil {
+			tt.Fatal(err)
+		}
+		second, err := NewOnDiskStorage(cfg)
+		if err != nil {
+			tt.Fatalf("expected the data directory to be unlocked but received '%s'", err)
+		}
+		second.Close()
+	})
+
+	t.Run("should resolve the dump file relative to the data directory", func(tt *testing.T) {
+		dataDir := tt.TempDir()
+		disk, err := NewOnDiskStorage(OnDiskConfig{DataDir: dataDir, DumpFile: "snapshots/cacher.db", Format: dumpfile.FormatBinary})
+		if err != nil {
+			tt.Fatal(err)
+		}
+		defer disk.Close()
+
+		storage := NewInMemoryStorage()
+		defer storage.Close()
+		if err := disk.Persist(context.Background(), storage); err != nil {
+			tt.Fatal(err)
+		}
+
+		if _, err := os.Stat(filepath.Join(dataDir, "snapshots", "cacher.db")); err != nil {
+			tt.Fatal(err)
+		}
+	})
+}
+
+func BenchmarkPersist(b *testing.B) {
+	storage := newBenchmarkStorage(b, 1_000_000)
+	defer storage.Close()
+
+	for _, format := range []dumpfile.Format{dumpfile.FormatJSON, dumpfile.FormatBinary} {
+		b.Run(string(format), func(bb *testing.B) {
+			disk := newTestOnDiskStorage(bb)
+			disk.format = format
+			disk.compress = false
+
+			for bb.Loop() {
+				if err := disk.Persist(context.Background(), storage); err != nil {
+					bb.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkRestore(b *testing.B) {
+	storage := newBenchmarkStorage(b, 1_000_000)
+	defer storage.Close()
+
+	for _, format := range []dumpfile.Format{dumpfile.FormatJSON, dumpfile.FormatBinary} {
+		b.Run(string(format), func(bb *testing.B) {
+			disk := newTestOnDiskStorage(bb)
+			disk.format = format
+			disk.compress = false
+			if err := disk.Persist(context.Background(), storage); err != nil {
+				bb.Fatal(err)
+			}
+
+			for bb.Loop() {
+				target := NewInMemoryStorage()
+				if _, err := disk.Restore(context.Background(), target, ConflictFail); err != nil {
+					bb.Fatal(err)
+				}
+				target.Close()
+			}
+		})
+	}
+}
+
+func newBenchmarkStorage(b *testing.B, keys int) *InMemoryStorage {
+	b.Helper()
+
+	storage := NewInMemoryStorage()
+	for i := range keys {
+		storage.Set(fmt.Sprintf("key:%d", i), randomString())
+	}
+
+	return storage
+}
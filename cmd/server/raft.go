@@ -0,0 +1,760 @@
+package main
+
+import (
+	"errors"
+	"math/rand/v2"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/JorgeLNJunior/cacher/pkg/clock"
+	"github.com/JorgeLNJunior/cacher/pkg/data"
+)
+
+// RaftRole is the role of a node in a cluster.
+type RaftRole string
+
+const (
+	RaftFollower  RaftRole = "follower"
+	RaftCandidate RaftRole = "candidate"
+	RaftLeader    RaftRole = "leader"
+)
+
+const raftMaxBatch = 1000 // max entries sent to a follower per request
+
+var (
+	ErrLeadershipLost = errors.New("the leadership has been lost, the operation may not have been applied")
+	ErrRaftStopped    = errors.New("the cluster node has been stopped")
+	ErrCommitTimeout  = errors.New("timeout waiting for the cluster to commit the operation")
+)
+
+// RaftConfig configures a node of a cluster.
+type RaftConfig struct {
+	ID              string   // address of the node, used by the clients redirected to the leader
+	Members         []string // addresses of every node of the cluster, including ID
+	TickInterval    time.Duration
+	ElectionTicks   int           // ticks without a leader before an election, randomized up to twice as many
+	HeartbeatTicks  int           // ticks between the heartbeats of the leader
+	SnapshotEntries int64         // applied entries in the log that trigger a snapshot
+	CommitTimeout   time.Duration // max wait for a proposal to be applied
+}
+
+// RaftStateMachine is what the log of a cluster is applied to.
+type RaftStateMachine interface {
+	// Apply applies a committed mutation.
+	Apply(m Mutation)
+	// Snapshot persists the state machine with every mutation applied so far.
+	Snapshot() error
+	// ReadSnapshot returns the current state machine to be sent to a follower.
+	ReadSnapshot() ([]byte, error)
+	// RestoreSnapshot replaces the state machine with a snapshot sent by the leader
+	// and persists it.
+	RestoreSnapshot(snapshot []byte) error
+}
+
+// RaftTransport sends the messages of a node to the other nodes.
+type RaftTransport interface {
+	RequestVote(peer string, req voteRequest) (voteResponse, error)
+	AppendEntries(peer string, req appendRequest) (appendResponse, error)
+	InstallSnapshot(peer string, req snapshotRequest) (snapshotResponse, error)
+}
+
+type voteRequest struct {
+	Term         int64
+	Candidate    string
+	LastLogIndex int64
+	LastLogTerm  int64
+}
+
+type voteResponse struct {
+	Term    int64
+	Granted bool
+}
+
+type appendRequest struct {
+	Term         int64
+	Leader       string
+	PrevLogIndex int64
+	PrevLogTerm  int64
+	Entries      []raftEntry `json:",omitempty"`
+	LeaderCommit int64
+}
+
+type appendResponse struct {
+	Term    int64
+	Success bool
+	// LastIndex is the last entry known to match the leader on success, or a hint
+	// of where the logs diverge otherwise.
+	LastIndex int64
+}
+
+// snapshotRequest carries a snapshot of the leader. The snapshot may have mutations
+// applied after LastIndex, which is fine since the mutations are idempotent: applying
+// the entries after LastIndex again converges to the same data.
+type snapshotRequest struct {
+	Term      int64
+	Leader    string
+	LastIndex int64
+	LastTerm  int64
+	Snapshot  []byte
+}
+
+type snapshotResponse struct {
+	Term int64
+}
+
+// proposal is a client waiting for its entry to be applied.
+type proposal struct {
+	term int64
+	done chan error
+}
+
+// RaftNode replicates mutations across the nodes of a cluster with the Raft consensus
+// algorithm. A mutation is only applied once a majority of the nodes have it on disk,
+// so the cluster keeps every acknowledged write while a majority of the nodes is up.
+//
+// See https://raft.github.io/raft.pdf.
+type RaftNode struct {
+	cfg       RaftConfig
+	clock     clock.Clock
+	log       *RaftLog
+	fsm       RaftStateMachine
+	transport RaftTransport
+	peers     []string // members except the node
+
+	mu               sync.Mutex
+	state            raftState
+	entries          []raftEntry // entries after the snapshot
+	role             RaftRole
+	leader           string
+	commitIndex      int64
+	lastApplied      int64
+	electionElapsed  int
+	electionTimeout  int
+	heartbeatElapsed int
+	votes            map[string]struct{}
+	nextIndex        map[string]int64
+	matchIndex       map[string]int64
+	proposals        map[int64]proposal
+	onError          func(error)
+
+	applyMu   sync.Mutex // held while entries or snapshots are applied to the state machine
+	applyWake chan struct{}
+	triggers  map[string]chan struct{} // wake the replication to a peer
+	stop      chan struct{}
+	stopped   bool
+	wg        sync.WaitGroup
+}
+
+// NewRaftNode returns a node that starts as a follower with the state and entries
+// read from log. The state machine must already have the data of the snapshot.
+func NewRaftNode(cfg RaftConfig, c clock.Clock, log *RaftLog, state raftState, entries []raftEntry, fsm RaftStateMachine, transport RaftTransport) *RaftNode {
+	n := &RaftNode{
+		cfg:         cfg,
+		clock:       c,
+		log:         log,
+		fsm:         fsm,
+		transport:   transport,
+		state:       state,
+		entries:     entries,
+		role:        RaftFollower,
+		commitIndex: state.SnapshotIndex,
+		lastApplied: state.SnapshotIndex,
+		proposals:   make(map[int64]proposal),
+		applyWake:   make(chan struct{}, 1),
+		triggers:    make(map[string]chan struct{}),
+		stop:        make(chan struct{}),
+	}
+	for _, member := range cfg.Members {
+		if member != cfg.ID {
+			n.peers = append(n.peers, member)
+			n.triggers[member] = make(chan struct{}, 1)
+		}
+	}
+	n.resetElectionTimer()
+
+	return n
+}
+
+// Start runs the node in background until Stop is called. Errors persisting the log
+// or the snapshots are reported to onError.
+func (n *RaftNode) Start(onError func(error)) {
+	n.onError = onError
+
+	ticker := n.clock.NewTicker(n.cfg.TickInterval)
+	n.wg.Add(2 + len(n.peers))
+	go func() {
+		defer n.wg.Done()
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-n.stop:
+				return
+			case <-ticker.C():
+				n.tick()
+			}
+		}
+	}()
+	go n.applyLoop()
+	for _, peer := range n.peers {
+		go n.replicateLoop(peer)
+	}
+}
+
+// Stop stops the node and fails the pending proposals.
+func (n *RaftNode) Stop() {
+	n.mu.Lock()
+	if n.stopped {
+		n.mu.Unlock()
+		return
+	}
+	n.stopped = true
+	close(n.stop)
+	n.failProposals(ErrRaftStopped)
+	n.mu.Unlock()
+
+	n.wg.Wait()
+	n.log.Close()
+}
+
+// Status returns the role of the node, the leader it knows and its current term.
+func (n *RaftNode) Status() (RaftRole, string, int64) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	return n.role, n.leader, n.state.Term
+}
+
+// Propose appends a mutation to the log and waits until it's applied. Only the leader
+// accepts proposals, the others return a data.NotLeaderError with the leader they know.
+func (n *RaftNode) Propose(m Mutation) error {
+	n.mu.Lock()
+	if n.stopped {
+		n.mu.Unlock()
+		return ErrRaftStopped
+	}
+	if n.role != RaftLeader {
+		n.mu.Unlock()
+		return data.NotLeaderError{Leader: n.leader}
+	}
+
+	entry := raftEntry{Index: n.lastIndex() + 1, Term: n.state.Term, Mutation: &m}
+	if err := n.appendEntries(entry); err != nil {
+		n.mu.Unlock()
+		return err
+	}
+	done := make(chan error, 1)
+	n.proposals[entry.Index] = proposal{term: entry.Term, done: done}
+	n.advanceCommit()
+	n.triggerAll()
+	n.mu.Unlock()
+
+	timeout := n.clock.NewTicker(n.cfg.CommitTimeout)
+	defer timeout.Stop()
+
+	select {
+	case err := <-done:
+		return err
+	case <-timeout.C():
+		n.mu.Lock()
+		delete(n.proposals, entry.Index)
+		n.mu.Unlock()
+		return ErrCommitTimeout
+	}
+}
+
+func (n *RaftNode) tick() {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if n.role == RaftLeader {
+		n.heartbeatElapsed++
+		if n.heartbeatElapsed >= n.cfg.HeartbeatTicks {
+			n.heartbeatElapsed = 0
+			n.triggerAll()
+		}
+		return
+	}
+
+	n.electionElapsed++
+	if n.electionElapsed >= n.electionTimeout {
+		n.startElection()
+	}
+}
+
+func (n *RaftNode) resetElectionTimer() {
+	n.electionElapsed = 0
+	n.electionTimeout = n.cfg.ElectionTicks + rand.IntN(n.cfg.ElectionTicks+1)
+}
+
+func (n *RaftNode) startElection() {
+	n.resetElectionTimer()
+	n.role = RaftCandidate
+	n.leader = ""
+	n.state.Term++
+	n.state.VotedFor = n.cfg.ID
+	if err := n.log.SaveState(n.state); err != nil {
+		n.reportError(err)
+		return
+	}
+
+	n.votes = map[string]struct{}{n.cfg.ID: {}}
+	if n.hasQuorum(len(n.votes)) {
+		n.becomeLeader()
+		return
+	}
+
+	req := voteRequest{
+		Term:         n.state.Term,
+		Candidate:    n.cfg.ID,
+		LastLogIndex: n.lastIndex(),
+		LastLogTerm:  n.termAt(n.lastIndex()),
+	}
+	for _, peer := range n.peers {
+		go func() {
+			res, err := n.transport.RequestVote(peer, req)
+			if err != nil {
+				return
+			}
+
+			n.mu.Lock()
+			defer n.mu.Unlock()
+
+			if n.stopped || n.stepDown(res.Term) || n.role != RaftCandidate || n.state.Term != req.Term || !res.Granted {
+				return
+			}
+			n.votes[peer] = struct{}{}
+			if n.hasQuorum(len(n.votes)) {
+				n.becomeLeader()
+			}
+		}()
+	}
+}
+
+func (n *RaftNode) becomeLeader() {
+	n.role = RaftLeader
+	n.leader = n.cfg.ID
+	n.heartbeatElapsed = 0
+	n.nextIndex = make(map[string]int64)
+	n.matchIndex = make(map[string]int64)
+	for _, peer := range n.peers {
+		n.nextIndex[peer] = n.lastIndex() + 1
+	}
+
+	// the entries of the previous terms are only committed with an entry of the new term
+	if err := n.appendEntries(raftEntry{Index: n.lastIndex() + 1, Term: n.state.Term}); err != nil {
+		n.reportError(err)
+	}
+	n.advanceCommit()
+	n.triggerAll()
+}
+
+// stepDown turns the node into a follower if term is newer than its own and returns
+// whether it did.
+func (n *RaftNode) stepDown(term int64) bool {
+	if term <= n.state.Term {
+		return false
+	}
+
+	n.state.Term = term
+	n.state.VotedFor = ""
+	if err := n.log.SaveState(n.state); err != nil {
+		n.reportError(err)
+	}
+	n.becomeFollower("")
+	return true
+}
+
+func (n *RaftNode) becomeFollower(leader string) {
+	if n.role == RaftLeader {
+		n.failProposals(ErrLeadershipLost)
+	}
+	n.role = RaftFollower
+	n.leader = leader
+}
+
+func (n *RaftNode) hasQuorum(count int) bool {
+	return count > len(n.cfg.Members)/2
+}
+
+// HandleVote answers a candidate asking for the vote of the node.
+func (n *RaftNode) HandleVote(req voteRequest) voteResponse {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	n.stepDown(req.Term)
+	res := voteResponse{Term: n.state.Term}
+	if req.Term < n.state.Term {
+		return res
+	}
+	if n.state.VotedFor != "" && n.state.VotedFor != req.Candidate {
+		return res
+	}
+
+	// only vote for candidates with every entry the node has, so a leader never lacks committed entries
+	lastTerm := n.termAt(n.lastIndex())
+	if req.LastLogTerm < lastTerm || req.LastLogTerm == lastTerm && req.LastLogIndex < n.lastIndex() {
+		return res
+	}
+
+	n.state.VotedFor = req.Candidate
+	if err := n.log.SaveState(n.state); err != nil {
+		n.reportError(err)
+		return res
+	}
+	n.resetElectionTimer()
+	res.Granted = true
+	return res
+}
+
+// HandleAppend appends the entries sent by the leader, which are also its heartbeats.
+func (n *RaftNode) HandleAppend(req appendRequest) appendResponse {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	n.stepDown(req.Term)
+	res := appendResponse{Term: n.state.Term}
+	if req.Term < n.state.Term || n.stopped {
+		return res
+	}
+	n.becomeFollower(req.Leader)
+	n.resetElectionTimer()
+
+	if req.PrevLogIndex > n.lastIndex() {
+		res.LastIndex = n.lastIndex()
+		return res
+	}
+
+	entries := req.Entries
+	prevIndex, prevTerm := req.PrevLogIndex, req.PrevLogTerm
+	if prevIndex < n.state.SnapshotIndex {
+		// the snapshot only has committed entries, which match the leader
+		skip := min(n.state.SnapshotIndex-prevIndex, int64(len(entries)))
+		entries = entries[skip:]
+		prevIndex, prevTerm = n.state.SnapshotIndex, n.state.SnapshotTerm
+	}
+	if n.termAt(prevIndex) != prevTerm {
+		// skip the whole conflicting term instead of one entry per request
+		conflictTerm := n.termAt(prevIndex)
+		index := prevIndex
+		for index > n.state.SnapshotIndex+1 && n.termAt(index-1) == conflictTerm {
+			index--
+		}
+		res.LastIndex = index - 1
+		return res
+	}
+
+	var newEntries []raftEntry
+	for i, entry := range entries {
+		if entry.Index <= n.lastIndex() {
+			if n.termAt(entry.Index) == entry.Term {
+				continue
+			}
+			n.entries = truncateEntries(n.entries, entry.Index)
+		}
+		newEntries = entries[i:]
+		break
+	}
+	if len(newEntries) > 0 {
+		if err := n.appendEntries(newEntries...); err != nil {
+			n.reportError(err)
+			return res
+		}
+	}
+
+	lastNew := prevIndex + int64(len(entries))
+	if commit := min(req.LeaderCommit, lastNew); commit > n.commitIndex {
+		n.commitIndex = commit
+		n.wakeApply()
+	}
+
+	res.Success = true
+	res.LastIndex = lastNew
+	return res
+}
+
+// HandleSnapshot replaces the data of the node with the snapshot of the leader.
+func (n *RaftNode) HandleSnapshot(req snapshotRequest) snapshotResponse {
+	// the state machine is not changed while entries are applied
+	n.applyMu.Lock()
+	defer n.applyMu.Unlock()
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	n.stepDown(req.Term)
+	res := snapshotResponse{Term: n.state.Term}
+	if req.Term < n.state.Term || n.stopped {
+		return res
+	}
+	n.becomeFollower(req.Leader)
+	n.resetElectionTimer()
+
+	if req.LastIndex <= n.lastApplied {
+		return res
+	}
+
+	if err := n.fsm.RestoreSnapshot(req.Snapshot); err != nil {
+		n.reportError(err)
+		return res
+	}
+
+	// keep the entries after the snapshot if the log matches it
+	if req.LastIndex <= n.lastIndex() && n.termAt(req.LastIndex) == req.LastTerm {
+		n.entries = slices.Clone(n.entries[req.LastIndex-n.state.SnapshotIndex:])
+	} else {
+		n.entries = nil
+	}
+	n.state.SnapshotIndex, n.state.SnapshotTerm = req.LastIndex, req.LastTerm
+	n.commitIndex = max(n.commitIndex, req.LastIndex)
+	n.lastApplied = req.LastIndex
+	if err := n.persistCompaction(); err != nil {
+		n.reportError(err)
+	}
+	n.wakeApply()
+
+	return res
+}
+
+// replicateLoop sends the entries, snapshots and heartbeats of the leader to a peer.
+func (n *RaftNode) replicateLoop(peer string) {
+	defer n.wg.Done()
+
+	for {
+		select {
+		case <-n.stop:
+			return
+		case <-n.triggers[peer]:
+			n.replicate(peer)
+		}
+	}
+}
+
+func (n *RaftNode) replicate(peer string) {
+	n.mu.Lock()
+	if n.role != RaftLeader {
+		n.mu.Unlock()
+		return
+	}
+	term := n.state.Term
+	next := n.nextIndex[peer]
+
+	if next <= n.state.SnapshotIndex {
+		req := snapshotRequest{
+			Term:      term,
+			Leader:    n.cfg.ID,
+			LastIndex: n.state.SnapshotIndex,
+			LastTerm:  n.state.SnapshotTerm,
+		}
+		n.mu.Unlock()
+
+		snapshot, err := n.fsm.ReadSnapshot()
+		if err != nil {
+			n.reportError(err)
+			return
+		}
+		req.Snapshot = snapshot
+
+		res, err := n.transport.InstallSnapshot(peer, req)
+		if err != nil {
+			return
+		}
+
+		n.mu.Lock()
+		defer n.mu.Unlock()
+		if n.stepDown(res.Term) || n.role != RaftLeader || n.state.Term != term {
+			return
+		}
+		n.matchIndex[peer] = max(n.matchIndex[peer], req.LastIndex)
+		n.nextIndex[peer] = n.matchIndex[peer] + 1
+		n.trigger(peer)
+		return
+	}
+
+	req := appendRequest{
+		Term:         term,
+		Leader:       n.cfg.ID,
+		PrevLogIndex: next - 1,
+		PrevLogTerm:  n.termAt(next - 1),
+		Entries:      slices.Clone(n.entries[next-n.state.SnapshotIndex-1 : min(next-n.state.SnapshotIndex-1+raftMaxBatch, int64(len(n.entries)))]),
+		LeaderCommit: n.commitIndex,
+	}
+	n.mu.Unlock()
+
+	res, err := n.transport.AppendEntries(peer, req)
+	if err != nil {
+		return
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.stepDown(res.Term) || n.role != RaftLeader || n.state.Term != term {
+		return
+	}
+
+	if !res.Success {
+		n.nextIndex[peer] = max(min(res.LastIndex+1, n.nextIndex[peer]-1), 1)
+		n.trigger(peer)
+		return
+	}
+
+	n.matchIndex[peer] = max(n.matchIndex[peer], res.LastIndex)
+	n.nextIndex[peer] = n.matchIndex[peer] + 1
+	n.advanceCommit()
+	if n.nextIndex[peer] <= n.lastIndex() {
+		n.trigger(peer)
+	}
+}
+
+// advanceCommit commits the entries of the current term a majority of the nodes have.
+func (n *RaftNode) advanceCommit() {
+	matches := []int64{n.lastIndex()}
+	for _, peer := range n.peers {
+		matches = append(matches, n.matchIndex[peer])
+	}
+	slices.Sort(matches)
+	slices.Reverse(matches)
+
+	committed := matches[len(n.cfg.Members)/2]
+	if committed > n.commitIndex && n.termAt(committed) == n.state.Term {
+		n.commitIndex = committed
+		n.wakeApply()
+		n.triggerAll() // tell the followers about the new commit index
+	}
+}
+
+// applyLoop applies the committed entries to the state machine in order and takes a
+// snapshot when too many entries have been applied since the last one.
+func (n *RaftNode) applyLoop() {
+	defer n.wg.Done()
+
+	for {
+		select {
+		case <-n.stop:
+			return
+		case <-n.applyWake:
+			n.apply()
+		}
+	}
+}
+
+func (n *RaftNode) apply() {
+	n.applyMu.Lock()
+	defer n.applyMu.Unlock()
+
+	n.mu.Lock()
+	start := n.lastApplied - n.state.SnapshotIndex
+	batch := slices.Clone(n.entries[start : n.commitIndex-n.state.SnapshotIndex])
+	n.mu.Unlock()
+
+	for _, entry := range batch {
+		if entry.Mutation != nil {
+			n.fsm.Apply(*entry.Mutation)
+		}
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	for _, entry := range batch {
+		n.lastApplied = entry.Index
+		if p, found := n.proposals[entry.Index]; found {
+			delete(n.proposals, entry.Index)
+			if p.term == entry.Term {
+				p.done <- nil
+			} else {
+				p.done <- ErrLeadershipLost
+			}
+		}
+	}
+
+	if n.lastApplied-n.state.SnapshotIndex < n.cfg.SnapshotEntries {
+		return
+	}
+
+	// the state machine has exactly the applied entries while applyMu is held
+	n.mu.Unlock()
+	err := n.fsm.Snapshot()
+	n.mu.Lock()
+	if err != nil {
+		n.reportError(err)
+		return
+	}
+
+	n.state.SnapshotTerm = n.termAt(n.lastApplied)
+	n.entries = slices.Clone(n.entries[n.lastApplied-n.state.SnapshotIndex:])
+	n.state.SnapshotIndex = n.lastApplied
+	if err := n.persistCompaction(); err != nil {
+		n.reportError(err)
+	}
+}
+
+// persistCompaction persists the snapshot index before dropping the compacted
+// entries from the disk, so a crash in between only leaves extra entries behind.
+func (n *RaftNode) persistCompaction() error {
+	if err := n.log.SaveState(n.state); err != nil {
+		return err
+	}
+	return n.log.Rewrite(n.entries)
+}
+
+// appendEntries appends entries to the log and persists them.
+func (n *RaftNode) appendEntries(entries ...raftEntry) error {
+	if n.stopped {
+		return ErrRaftStopped // the log is closed
+	}
+	if err := n.log.Append(entries); err != nil {
+		return err
+	}
+	n.entries = append(n.entries, entries...)
+	return nil
+}
+
+func (n *RaftNode) lastIndex() int64 {
+	return n.state.SnapshotIndex + int64(len(n.entries))
+}
+
+// termAt returns the term of an entry, or -1 if it's compacted or not in the log.
+func (n *RaftNode) termAt(index int64) int64 {
+	if index == n.state.SnapshotIndex {
+		return n.state.SnapshotTerm
+	}
+	if index < n.state.SnapshotIndex || index > n.lastIndex() {
+		return -1
+	}
+	return n.entries[index-n.state.SnapshotIndex-1].Term
+}
+
+func (n *RaftNode) trigger(peer string) {
+	select {
+	case n.triggers[peer] <- struct{}{}:
+	default: // a replication is already pending
+	}
+}
+
+func (n *RaftNode) triggerAll() {
+	for _, peer := range n.peers {
+		n.trigger(peer)
+	}
+}
+
+func (n *RaftNode) wakeApply() {
+	select {
+	case n.applyWake <- struct{}{}:
+	default:
+	}
+}
+
+func (n *RaftNode) failProposals(err error) {
+	for index, p := range n.proposals {
+		p.done <- err
+		delete(n.proposals, index)
+	}
+}
+
+func (n *RaftNode) reportError(err error) {
+	if n.onError != nil {
+		n.onError(err)
+	}
+}
@@ -0,0 +1,256 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/JorgeLNJunior/cacher/pkg/encryption"
+)
+
+const (
+	raftStateFile = "state"
+	raftLogFile   = "log"
+)
+
+var raftLogAdditionalData = []byte("cacher raft log")
+
+// raftEntry is an entry of the log of a cluster. Entries without a mutation are
+// appended by new leaders to commit the entries of the previous terms.
+type raftEntry struct {
+	Index    int64
+	Term     int64
+	Mutation *Mutation `json:",omitempty"`
+}
+
+// raftState is what a node must remember across restarts besides its log.
+type raftState struct {
+	Term          int64
+	VotedFor      string `json:",omitempty"`
+	SnapshotIndex int64  // index of the last entry covered by the snapshot
+	SnapshotTerm  int64
+}
+
+// RaftLog persists the state and the log of a cluster node in a directory. The
+// entries are appended to a file as JSON lines, or the base64 of the sealed JSON
+// if the log has a keyring, and flushed to the disk before Append returns.
+type RaftLog struct {
+	dir     string
+	keyring *encryption.Keyring
+	file    *os.File
+}
+
+// OpenRaftLog opens or creates the log in dir and returns the persisted state and the
+// entries after the snapshot. A partially written last entry, left by a crash, is
+// discarded.
+func OpenRaftLog(dir string, keyring *encryption.Keyring) (*RaftLog, raftState, []raftEntry, error) {
+	state := raftState{}
+
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, state, nil, err
+	}
+
+	payload, err := os.ReadFile(filepath.Join(dir, raftStateFile))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, state, nil, err
+	}
+	if err == nil {
+		if err := json.Unmarshal(payload, &state); err != nil {
+			return nil, state, nil, err
+		}
+	}
+
+	l := &RaftLog{dir: dir, keyring: keyring}
+
+	file, err := os.OpenFile(filepath.Join(dir, raftLogFile), os.O_RDWR|os.O_CREATE, 0o600)
+	if err != nil {
+		return nil, state, nil, err
+	}
+
+	entries := make([]raftEntry, 0)
+	reader := bufio.NewReader(file)
+	var valid int64 // size of the complete entries
+	for {
+		line, err := reader.ReadBytes('\n')
+		if errors.Is(err, io.EOF) {
+			break // a line without \n is a partial write
+		}
+		if err != nil {
+			file.Close()
+			return nil, state, nil, err
+		}
+
+		entry, err := l.decode(line)
+		if err != nil {
+			file.Close()
+			return nil, state, nil, err
+		}
+		valid += int64(len(line))
+
+		if entry.Index <= state.SnapshotIndex {
+			continue // compacted, left by a crash before the log was rewritten
+		}
+		// entries overwritten by a new leader are followed by their replacement
+		entries = truncateEntries(entries, entry.Index)
+		entries = append(entries, entry)
+	}
+
+	if err := file.Truncate(valid); err != nil {
+		file.Close()
+		return nil, state, nil, err
+	}
+	if _, err := file.Seek(valid, io.SeekStart); err != nil {
+		file.Close()
+		return nil, state, nil, err
+	}
+	l.file = file
+
+	return l, state, entries, nil
+}
+
+// truncateEntries drops the entries from index onwards.
+func truncateEntries(entries []raftEntry, index int64) []raftEntry {
+	for len(entries) > 0 && entries[len(entries)-1].Index >= index {
+		entries = entries[:len(entries)-1]
+	}
+	return entries
+}
+
+// encode returns the line of an entry, encrypted if the log has a keyring.
+func (l *RaftLog) encode(entry raftEntry) ([]byte, error) {
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return nil, err
+	}
+
+	if l.keyring != nil {
+		sealed, err := l.keyring.Seal(line, raftLogAdditionalData)
+		if err != nil {
+			return nil, err
+		}
+		line = base64.StdEncoding.AppendEncode(nil, sealed)
+	}
+
+	return append(line, '\n'), nil
+}
+
+// decode parses a line of the log. Like the operation log, plaintext lines are
+// accepted even if the log has a keyring.
+func (l *RaftLog) decode(line []byte) (raftEntry, error) {
+	entry := raftEntry{}
+	line = bytes.TrimSuffix(line, []byte("\n"))
+
+	if !bytes.HasPrefix(line, []byte("{")) {
+		if l.keyring == nil {
+			return entry, encryption.ErrEncrypted
+		}
+
+		sealed, err := base64.StdEncoding.AppendDecode(nil, line)
+		if err != nil {
+			return entry, err
+		}
+		if line, err = l.keyring.Open(sealed, raftLogAdditionalData); err != nil {
+			return entry, err
+		}
+	}
+
+	err := json.Unmarshal(line, &entry)
+	return entry, err
+}
+
+// SaveState atomically replaces the persisted state.
+func (l *RaftLog) SaveState(state raftState) error {
+	payload, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+
+	path := filepath.Join(l.dir, raftStateFile)
+	tmpPath := path + ".tmp"
+	defer os.Remove(tmpPath) // no-op once the file is renamed
+
+	if err := writeSyncedFile(tmpPath, payload); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		return err
+	}
+	return syncDir(l.dir)
+}
+
+// Append writes entries to the end of the log. Replacing entries is done by appending
+// them again, the last entry with an index wins when the log is read.
+func (l *RaftLog) Append(entries []raftEntry) error {
+	buffer := bytes.NewBuffer(nil)
+	for _, entry := range entries {
+		line, err := l.encode(entry)
+		if err != nil {
+			return err
+		}
+		buffer.Write(line)
+	}
+
+	if _, err := l.file.Write(buffer.Bytes()); err != nil {
+		return err
+	}
+	return l.file.Sync()
+}
+
+// Rewrite atomically replaces the log with entries, dropping the compacted and
+// overwritten entries.
+func (l *RaftLog) Rewrite(entries []raftEntry) error {
+	buffer := bytes.NewBuffer(nil)
+	for _, entry := range entries {
+		line, err := l.encode(entry)
+		if err != nil {
+			return err
+		}
+		buffer.Write(line)
+	}
+
+	path := filepath.Join(l.dir, raftLogFile)
+	tmpPath := path + ".tmp"
+	defer os.Remove(tmpPath) // no-op once the file is renamed
+
+	if err := writeSyncedFile(tmpPath, buffer.Bytes()); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		return err
+	}
+	if err := syncDir(l.dir); err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_APPEND, 0o600)
+	if err != nil {
+		return err
+	}
+	l.file.Close()
+	l.file = file
+	return nil
+}
+
+// Close closes the log file.
+func (l *RaftLog) Close() error {
+	return l.file.Close()
+}
+
+// writeSyncedFile writes payload to path and flushes it to the disk.
+func writeSyncedFile(path string, payload []byte) error {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	if _, err := file.Write(payload); err != nil {
+		return err
+	}
+	return file.Sync()
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/JorgeLNJunior/cacher/pkg/data"
+	"github.com/JorgeLNJunior/cacher/pkg/encryption"
+)
+
+func openTestRaftLog(t *testing.T, dir string, keyring *encryption.Keyring) (*RaftLog, raftState, []raftEntry) {
+	t.Helper()
+
+	log, state, entries, err := OpenRaftLog(dir, keyring)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return log, state, entries
+}
+
+func testRaftEntry(index int64, term int64, key string) raftEntry {
+	return raftEntry{Index: index, Term: term, Mutation: &Mutation{Operation: data.OperationSet, Key: key, Value: "value"}}
+}
+
+func TestRaftLog(t *testing.T) {
+	t.Run("should read the state and the entries back", func(tt *testing.T) {
+		dir := tt.TempDir()
+
+		log, _, _ := openTestRaftLog(tt, dir, nil)
+		if err := log.SaveState(raftState{Term: 2, VotedFor: "127.0.0.1:1"}); err != nil {
+			tt.Fatal(err)
+		}
+		if err := log.Append([]raftEntry{testRaftEntry(1, 1, "foo"), testRaftEntry(2, 2, "bar")}); err != nil {
+			tt.Fatal(err)
+		}
+		log.Close()
+
+		log, state, entries := openTestRaftLog(tt, dir, nil)
+		defer log.Close()
+
+		if state.Term != 2 || state.VotedFor != "127.0.0.1:1" {
+			tt.Errorf("expected term 2 and a vote for 127.0.0.1:1 but got %+v", state)
+		}
+		if len(entries) != 2 || entries[1].Mutation.Key != "bar" {
+			tt.Errorf("expected the 2 entries but got %+v", entries)
+		}
+	})
+
+	t.Run("should read back the values that are not valid UTF-8", func(tt *testing.T) {
+		dir := tt.TempDir()
+		binary := "\xff\xfe\x00\x80bin"
+
+		log, _, _ := openTestRaftLog(tt, dir, nil)
+		entry := raftEntry{Index: 1, Term: 1, Mutation: &Mutation{Operation: data.OperationSet, Key: "key\xc3", Value: binary}}
+		if err := log.Append([]raftEntry{entry}); err != nil {
+			tt.Fatal(err)
+		}
+		log.Close()
+
+		log, _, entries := openTestRaftLog(tt, dir, nil)
+		defer log.Close()
+
+		if len(entries) != 1 || entries[0].Mutation.Key != "key\xc3" || entries[0].Mutation.Value != binary {
+			tt.Errorf("expected the SET of %q but got %+v", binary, entries)
+		}
+	})
+
+	t.Run("should replace the entries appended again", func(tt *testing.T) {
+		dir := tt.TempDir()
+
+		log, _, _ := openTestRaftLog(tt, dir, nil)
+		log.Append([]raftEntry{testRaftEntry(1, 1, "foo"), testRaftEntry(2, 1, "bar"), testRaftEntry(3, 1, "baz")})
+		log.Append([]raftEntry{testRaftEntry(2, 2, "qux")})
+		log.Close()
+
+		log, _, entries := openTestRaftLog(tt, dir, nil)
+		defer log.Close()
+
+		if len(entries) != 2 || entries[1].Term != 2 || entries[1].Mutation.Key != "qux" {
+			tt.Errorf("expected the entries after 1 to be replaced but got %+v", entries)
+		}
+	})
+
+	t.Run("should skip the compacted entries", func(tt *testing.T) {
+		dir := tt.TempDir()
+
+		log, _, _ := openTestRaftLog(tt, dir, nil)
+		log.Append([]raftEntry{testRaftEntry(1, 1, "foo"), testRaftEntry(2, 1, "bar")})
+		// a crash after the snapshot index is saved leaves the compacted entries behind
+		log.SaveState(raftState{Term: 1, SnapshotIndex: 1, SnapshotTerm: 1})
+		log.Close()
+
+		log, state, entries := openTestRaftLog(tt, dir, nil)
+		defer log.Close()
+
+		if state.SnapshotIndex != 1 || len(entries) != 1 || entries[0].Index != 2 {
+			tt.Errorf("expected only the entry after the snapshot but got %+v", entries)
+		}
+	})
+
+	t.Run("should discard a partially written last entry", func(tt *testing.T) {
+		dir := tt.TempDir()
+
+		log, _, _ := openTestRaftLog(tt, dir, nil)
+		log.Append([]raftEntry{testRaftEntry(1, 1, "foo")})
+		log.Close()
+
+		file, err := os.OpenFile(filepath.Join(dir, raftLogFile), os.O_WRONLY|os.O_APPEND, 0o600)
+		if err != nil {
+			tt.Fatal(err)
+		}
+		file.WriteString(`{"Index":2,"Ter`)
+		file.Close()
+
+		log, _, _ = openTestRaftLog(tt, dir, nil)
+		log.Append([]raftEntry{testRaftEntry(2, 1, "bar")})
+		log.Close()
+
+		log, _, entries := openTestRaftLog(tt, dir, nil)
+		defer log.Close()
+
+		if len(entries) != 2 || entries[1].Mutation.Key != "bar" {
+			tt.Errorf("expected the partial entry to be discarded but got %+v", entries)
+		}
+	})
+
+	t.Run("should encrypt the entries", func(tt *testing.T) {
+		dir := tt.TempDir()
+		keyring := newTestKeyring(tt, testEncryptionKey)
+
+		log, _, _ := openTestRaftLog(tt, dir, keyring)
+		log.Append([]raftEntry{testRaftEntry(1, 1, "secret")})
+		log.Close()
+
+		payload, err := os.ReadFile(filepath.Join(dir, raftLogFile))
+		if err != nil {
+			tt.Fatal(err)
+		}
+		if len(payload) == 0 || payload[0] == '{' {
+			tt.Fatal("expected the entries to be encrypted")
+		}
+
+		if _, _, _, err := OpenRaftLog(dir, nil); !errors.Is(err, encryption.ErrEncrypted) {
+			tt.Errorf("expected ErrEncrypted but got %v", err)
+		}
+
+		log, _, entries := openTestRaftLog(tt, dir, keyring)
+		defer log.Close()
+		if len(entries) != 1 || entries[0].Mutation.Key != "secret" {
+			tt.Errorf("expected the entry to be decrypted but got %+v", entries)
+		}
+	})
+}
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/JorgeLNJunior/cacher/pkg/client"
+	"github.com/JorgeLNJunior/cacher/pkg/clock"
+	"github.com/JorgeLNJunior/cacher/pkg/data"
+	"github.com/JorgeLNJunior/cacher/pkg/dumpfile"
+)
+
+const replicaRetryDelay = time.Second // wait before reconnecting to the primary
+
+// ReplicaState is the state of the link between a replica and its primary.
+type ReplicaState string
+
+const (
+	ReplicaConnecting ReplicaState = "connecting"
+	ReplicaSyncing    ReplicaState = "syncing"   // receiving the snapshot
+	ReplicaStreaming  ReplicaState = "streaming" // applying the mutations
+)
+
+// ReplicaStatus describes how far a replica is from its primary.
+type ReplicaStatus struct {
+	State         ReplicaState
+	Offset        int64     // offset of the last applied mutation
+	PrimaryOffset int64     // last offset announced by the primary
+	LastContact   time.Time // when the last frame was received from the primary
+}
+
+// Behind returns how many mutations of the primary have not been applied yet.
+func (s ReplicaStatus) Behind() int64 {
+	return max(s.PrimaryOffset-s.Offset, 0)
+}
+
+// Lag returns how long it has been since the primary was last heard from.
+func (s ReplicaStatus) Lag(now time.Time) time.Duration {
+	return now.Sub(s.LastContact)
+}
+
+// Replica keeps a storage in sync with a primary server. It performs a full sync
+// every time it connects and then applies the mutations streamed by the primary.
+type Replica struct {
+	primary string
+	storage *InMemoryStorage
+	clock   clock.Clock
+	tls     *tls.Config       // nil for plain tcp
+	auth    *data.Credentials // nil if the primary doesn't authenticate the requests
+	status  ReplicaStatus
+	mu      sync.Mutex // guards status
+	ctx     context.Context
+	cancel  context.CancelFunc
+	done    chan struct{}
+}
+
+// NewReplica returns a Replica of the server listening at primary, connecting over TLS
+// if tlsConfig is not nil and authenticating with credentials if they are not nil.
+func NewReplica(primary string, storage *InMemoryStorage, c clock.Clock, tlsConfig *tls.Config, credentials *data.Credentials) *Replica {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	return &Replica{
+		primary: primary,
+		storage: storage,
+		clock:   c,
+		tls:     tlsConfig,
+		auth:    credentials,
+		status:  ReplicaStatus{State: ReplicaConnecting, LastContact: c.Now()},
+		ctx:     ctx,
+		cancel:  cancel,
+		done:    make(chan struct{}),
+	}
+}
+
+// Start replicates the primary in background until Stop is called. onSync is
+// called after every full sync and onError every time the link is lost.
+func (r *Replica) Start(onSync func(RestoreStats), onError func(error)) {
+	go func() {
+		defer close(r.done)
+
+		for {
+			err := r.replicate(onSync)
+			if r.ctx.Err() != nil {
+				return
+			}
+			onError(err)
+
+			r.mu.Lock()
+			r.status.State = ReplicaConnecting
+			r.mu.Unlock()
+
+			select {
+			case <-r.ctx.Done():
+				return
+			case <-time.After(replicaRetryDelay):
+			}
+		}
+	}()
+}
+
+// Stop disconnects from the primary.
+func (r *Replica) Stop() {
+	r.cancel()
+	<-r.done
+}
+
+// Primary returns the address of the primary.
+func (r *Replica) Primary() string {
+	return r.primary
+}
+
+// Status returns the status of the replication.
+func (r *Replica) Status() ReplicaStatus {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.status
+}
+
+// replicate syncs with the primary and applies its mutations until the link is lost.
+func (r *Replica) replicate(onSync func(RestoreStats)) error {
+	conn, err := client.Dial(r.ctx, r.primary, replicationTimeout, r.tls)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	stop := context.AfterFunc(r.ctx, func() { conn.Close() })
+	defer stop()
+
+	req := data.Request{Operation: data.OperationSync, Key: data.StreamKey, Credentials: r.auth}
+	payload, err := req.Marshal()
+	if err != nil {
+		return err
+	}
+	if _, err := conn.Write(payload); err != nil {
+		return err
+	}
+
+	in := bufio.NewReader(conn)
+	if err := conn.SetReadDeadline(time.Now().Add(replicationTimeout)); err != nil {
+		return err
+	}
+	status, err := in.ReadString(' ')
+	if err != nil {
+		return err
+	}
+	if status != data.ResponseStatusOK+" " {
+		message, _ := io.ReadAll(in)
+		return errors.New(strings.TrimSpace(string(message)))
+	}
+
+	frame, err := r.readFrame(conn, in)
+	if err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	r.status.State = ReplicaSyncing
+	r.mu.Unlock()
+
+	snapshot := make([]byte, frame.Snapshot)
+	if err := conn.SetReadDeadline(time.Now().Add(adminTimeout)); err != nil {
+		return err
+	}
+	if _, err := io.ReadFull(in, snapshot); err != nil {
+		return err
+	}
+	dump, err := dumpfile.Read(bytes.NewReader(snapshot), nil, 0) // the snapshots are not compressed
+	if err != nil {
+		return err
+	}
+	onSync(r.storage.Replace(dump))
+
+	r.mu.Lock()
+	r.status.State = ReplicaStreaming
+	r.status.Offset = frame.Offset
+	r.status.PrimaryOffset = frame.Offset
+	r.mu.Unlock()
+
+	for {
+		frame, err := r.readFrame(conn, in)
+		if err != nil {
+			return err
+		}
+
+		if frame.Mutation != nil {
+			applyMutation(r.storage, *frame.Mutation)
+		}
+
+		r.mu.Lock()
+		if frame.Mutation != nil {
+			r.status.Offset = frame.Offset
+		}
+		r.status.PrimaryOffset = max(r.status.PrimaryOffset, frame.Offset)
+		r.mu.Unlock()
+	}
+}
+
+// readFrame reads the next frame, failing if the primary is silent for too long.
+func (r *Replica) readFrame(conn net.Conn, in *bufio.Reader) (replicationFrame, error) {
+	frame := replicationFrame{}
+	if err := conn.SetReadDeadline(time.Now().Add(replicationTimeout)); err != nil {
+		return frame, err
+	}
+
+	line, err := in.ReadBytes('\n')
+	if err != nil {
+		return frame, err
+	}
+	if err := json.Unmarshal(line, &frame); err != nil {
+		return frame, err
+	}
+
+	r.mu.Lock()
+	r.status.LastContact = r.clock.Now()
+	r.mu.Unlock()
+
+	return frame, nil
+}
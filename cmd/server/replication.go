@@ -0,0 +1,284 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/JorgeLNJunior/cacher/pkg/data"
+	"github.com/JorgeLNJunior/cacher/pkg/dumpfile"
+	levellog "github.com/JorgeLNJunior/cacher/pkg/logger"
+)
+
+// A SYNC request is answered with "OK " followed by a stream of JSON frames, one
+// per line. The first frame has the offset and the length of a snapshot, whose
+// bytes follow the frame. Every other frame has a mutation applied after the
+// snapshot or, every heartbeat, only the current offset of the primary.
+const (
+	replicationHeartbeat  = time.Second
+	replicationTimeout    = replicationHeartbeat * 5 // max wait for a frame or a write
+	replicationBufferSize = 10_000                   // mutations buffered per replica before it's dropped
+)
+
+var (
+	ErrReadOnlyReplica    = errors.New("the server is a read-only replica")
+	ErrReplicationStopped = errors.New("the replication has been stopped")
+	ErrReplicaTooSlow     = errors.New("the replica could not keep up with the mutations")
+	ErrInvalidSyncKey     = errors.New("SYNC expects - as the key")
+)
+
+type replicationFrame struct {
+	Offset   int64     // offset of the primary once the frame is applied
+	Snapshot int       `json:",omitempty"` // length of the snapshot that follows the frame
+	Mutation *Mutation `json:",omitempty"`
+}
+
+// Replicator numbers the mutations applied to a storage and fans them out to the
+// connected replicas. The offset of a mutation is how many mutations were applied
+// before it, plus one, since the server started.
+type Replicator struct {
+	mu       sync.Mutex
+	offset   int64
+	replicas map[*replicaStream]struct{}
+	closed   bool
+}
+
+// replicaStream is the queue of frames of a connected replica.
+type replicaStream struct {
+	frames  chan replicationFrame
+	dropped chan struct{} // closed when the replica must disconnect
+	err     error         // why the replica has been dropped
+}
+
+// NewReplicator returns a Replicator observing the mutations of source.
+func NewReplicator(source *InMemoryStorage) *Replicator {
+	r := &Replicator{replicas: make(map[*replicaStream]struct{})}
+	source.Observe(r.observe)
+	return r
+}
+
+func (r *Replicator) observe(m Mutation) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.offset++
+	frame := replicationFrame{Offset: r.offset, Mutation: &m}
+	for stream := range r.replicas {
+		select {
+		case stream.frames <- frame:
+		default:
+			// never block the storage, the replica resyncs once it reconnects
+			r.drop(stream, ErrReplicaTooSlow)
+		}
+	}
+}
+
+// subscribe registers a replica and returns its stream and the current offset. Every
+// mutation after the offset is sent to the stream, so a snapshot taken after subscribe
+// plus the mutations of the stream always converge to the data of the primary.
+func (r *Replicator) subscribe() (*replicaStream, int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.closed {
+		return nil, 0, ErrReplicationStopped
+	}
+
+	stream := &replicaStream{
+		frames:  make(chan replicationFrame, replicationBufferSize),
+		dropped: make(chan struct{}),
+	}
+	r.replicas[stream] = struct{}{}
+	return stream, r.offset, nil
+}
+
+func (r *Replicator) unsubscribe(stream *replicaStream) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.replicas, stream)
+}
+
+func (r *Replicator) drop(stream *replicaStream, err error) {
+	if _, found := r.replicas[stream]; !found {
+		return
+	}
+
+	stream.err = err
+	close(stream.dropped)
+	delete(r.replicas, stream)
+}
+
+// Offset returns the offset of the last applied mutation.
+func (r *Replicator) Offset() int64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.offset
+}
+
+// Replicas returns how many replicas are connected.
+func (r *Replicator) Replicas() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return len(r.replicas)
+}
+
+// Close disconnects every replica and refuses new ones.
+func (r *Replicator) Close() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.closed = true
+	for stream := range r.replicas {
+		r.drop(stream, ErrReplicationStopped)
+	}
+}
+
+// handleSync sends a snapshot of the storage to a replica and then streams the
+// mutations until the replica disconnects or falls behind.
+func (app *application) handleSync(conn net.Conn, req data.Request) {
+	if req.Key != data.StreamKey {
+		app.errorResponse(conn, ErrInvalidSyncKey)
+		return
+	}
+
+	stream, offset, err := app.replicator.subscribe()
+	if err != nil {
+		app.errorResponse(conn, err)
+		return
+	}
+	defer app.replicator.unsubscribe(stream)
+
+	snapshot := &bytes.Buffer{}
+	if err := WriteSnapshot(snapshot, app.storage, dumpfile.FormatBinary, false, nil); err != nil {
+		app.logger.Error("error writing a replication snapshot", levellog.Args{"err": err.Error()})
+		app.errorResponse(conn, err)
+		return
+	}
+
+	args := levellog.Args{"replica": conn.RemoteAddr().String()}
+	app.logger.Info("a replica has started syncing", args)
+
+	out := bufio.NewWriter(conn)
+	encoder := json.NewEncoder(out)
+	write := func(frame replicationFrame, payload []byte) error {
+		if err := conn.SetWriteDeadline(time.Now().Add(replicationTimeout)); err != nil {
+			return err
+		}
+		if err := encoder.Encode(frame); err != nil {
+			return err
+		}
+		if _, err := out.Write(payload); err != nil {
+			return err
+		}
+		if len(stream.frames) > 0 {
+			return nil // flushed with the next frames
+		}
+		return out.Flush()
+	}
+
+	if _, err := out.WriteString(data.ResponseStatusOK + " "); err != nil {
+		return
+	}
+	if err := write(replicationFrame{Offset: offset, Snapshot: snapshot.Len()}, snapshot.Bytes()); err != nil {
+		args["err"] = err.Error()
+		app.logger.Error("error sending the replication snapshot", args)
+		return
+	}
+
+	heartbeat := app.clock.NewTicker(replicationHeartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		var frame replicationFrame
+		select {
+		case <-stream.dropped:
+			args["err"] = stream.err.Error()
+			app.logger.Warn("a replica has been disconnected", args)
+			return
+		case frame = <-stream.frames:
+		case <-heartbeat.C():
+			frame = replicationFrame{Offset: app.replicator.Offset()}
+		}
+
+		if err := write(frame, nil); err != nil {
+			args["err"] = err.Error()
+			app.logger.Warn("a replica has been disconnected", args)
+			return
+		}
+	}
+}
+
+// handleRole describes the replication role of the server.
+func (app *application) handleRole(conn net.Conn) {
+	replica := app.replica.Load()
+	if replica == nil {
+		app.okResponse(conn, fmt.Sprintf(
+			"%s offset=%d replicas=%d",
+			data.RolePrimary, app.replicator.Offset(), app.replicator.Replicas(),
+		))
+		return
+	}
+
+	status := replica.Status()
+	app.okResponse(conn, fmt.Sprintf(
+		"%s primary=%s state=%s offset=%d behind=%d lag=%s",
+		data.RoleReplica, replica.Primary(), status.State, status.Offset, status.Behind(), status.Lag(app.clock.Now()),
+	))
+}
+
+// handleReplicaOf makes the server a replica of another server or a primary.
+func (app *application) handleReplicaOf(conn net.Conn, req data.Request) {
+	if app.raft != nil {
+		app.errorResponse(conn, ErrClusterMode)
+		return
+	}
+
+	app.replicate(req.Key)
+
+	if req.Key == data.NoPrimary {
+		app.logger.Info("the server has been promoted to primary", nil)
+		app.okResponse(conn, "the server is now a primary")
+		return
+	}
+
+	app.logger.Info("the server has become a replica", levellog.Args{"primary": req.Key})
+	app.okResponse(conn, "the server is now a replica of "+req.Key)
+}
+
+// replicate makes the server a replica of primary, or a primary if primary is data.NoPrimary.
+// The data is kept when a replica is promoted, so a standby can take over its primary.
+func (app *application) replicate(primary string) {
+	app.replicaMu.Lock()
+	defer app.replicaMu.Unlock()
+
+	if previous := app.replica.Swap(nil); previous != nil {
+		previous.Stop()
+	}
+	if primary == data.NoPrimary {
+		return
+	}
+
+	replica := NewReplica(primary, app.storage, app.clock, app.tls.PeerConfig(), app.peerCredentials)
+	replica.Start(
+		func(stats RestoreStats) {
+			app.logger.Info("the data has been synced from the primary", levellog.Args{
+				"primary": primary,
+				"loaded":  strconv.Itoa(stats.Loaded),
+				"skipped": strconv.Itoa(stats.Skipped),
+			})
+		},
+		func(err error) {
+			app.logger.Warn("the link with the primary has been lost", levellog.Args{"primary": primary, "err": err.Error()})
+		},
+	)
+	app.replica.Store(replica)
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/JorgeLNJunior/cacher/pkg/clock"
+	"github.com/JorgeLNJunior/cacher/pkg/data"
+)
+
+// eventually fails the test if condition is not true within a few seconds.
+func eventually(t *testing.T, message string, condition func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(time.Second * 5)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatal(message)
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+}
+
+func TestReplication(t *testing.T) {
+	t.Run("should sync the data and stream the mutations of the primary", func(tt *testing.T) {
+		primary, primaryAddr := newTestApplication(tt, config{})
+		primary.storage.Set("foo", "bar")
+		primary.storage.Set("baz", "qux")
+
+		replica, _ := newTestApplication(tt, config{replicaOf: primaryAddr})
+		eventually(tt, "the replica has not synced", func() bool {
+			value, _ := replica.storage.Get("foo")
+			return value == "bar"
+		})
+
+		primary.storage.Set("quux", "corge")
+		primary.storage.ExpireAt("foo", testEpoch.Add(time.Hour))
+		primary.storage.Delete("baz")
+
+		eventually(tt, "the mutations have not been replicated", func() bool {
+			_, found := replica.storage.Get("baz")
+			return !found
+		})
+		if value, _ := replica.storage.Get("quux"); value != "corge" {
+			tt.Fatalf("expected 'corge' but got '%s'", value)
+		}
+		if item := replica.storage.Dump()["foo"]; !item.Expiry.Equal(testEpoch.Add(time.Hour)) {
+			tt.Fatalf("expected the expiry to be replicated but got '%s'", item.Expiry)
+		}
+
+		status := replica.replica.Load().Status()
+		if status.State != ReplicaStreaming || status.Offset != primary.replicator.Offset() {
+			tt.Fatalf("expected the replica to be streaming at offset %d but got %+v", primary.replicator.Offset(), status)
+		}
+	})
+
+	t.Run("should stream the values that are not valid UTF-8", func(tt *testing.T) {
+		primary, primaryAddr := newTestApplication(tt, config{})
+		replica, _ := newTestApplication(tt, config{replicaOf: primaryAddr})
+		eventually(tt, "the replica has not synced", func() bool {
+			return replica.replica.Load().Status().State == ReplicaStreaming
+		})
+
+		binary := "\xff\xfe\x00\x80bin"
+		primary.storage.Set("key\xc3", binary)
+
+		eventually(tt, "the mutation has not been replicated", func() bool {
+			_, found := replica.storage.Get("key\xc3")
+			return found
+		})
+		if value, _ := replica.storage.Get("key\xc3"); value != binary {
+			tt.Fatalf("expected %q but got %q", binary, value)
+		}
+	})
+
+	t.Run("should never write the snapshot to the temporary directory", func(tt *testing.T) {
+		primary, primaryAddr := newTestApplication(tt, config{})
+		primary.storage.Set("secret", "value")
+		// any temporary file would fail the SYNC
+		tt.Setenv("TMPDIR", filepath.Join(tt.TempDir(), "missing"))
+
+		replica, _ := newTestApplication(tt, config{replicaOf: primaryAddr})
+		eventually(tt, "the replica has not synced", func() bool {
+			_, found := replica.storage.Get("secret")
+			return found
+		})
+	})
+
+	t.Run("should replicate the keys removed by the sweeper", func(tt *testing.T) {
+		primary, primaryAddr := newTestApplication(tt, config{})
+		primary.storage.Set("foo", "bar")
+		primary.storage.ExpireAt("foo", testEpoch.Add(time.Minute))
+
+		replica, _ := newTestApplication(tt, config{replicaOf: primaryAddr})
+		eventually(tt, "the replica has not synced", func() bool {
+			_, found := replica.storage.Get("foo")
+			return found
+		})
+
+		primary.clock.(*clock.Fake).Advance(time.Minute + sweepInterval)
+
+		// the clock of the replica has not moved, so only the primary could remove the key
+		eventually(tt, "the expired key has not been removed from the replica", func() bool {
+			_, found := replica.storage.Get("foo")
+			return !found
+		})
+	})
+
+	t.Run("should reject writes on a replica", func(tt *testing.T) {
+		_, primaryAddr := newTestApplication(tt, config{})
+		_, replicaAddr := newTestApplication(tt, config{replicaOf: primaryAddr})
+
+		res := sendRequest(tt, replicaAddr, []byte("SET foo bar"))
+		if res.Status != data.ResponseStatusError || !strings.HasPrefix(res.Message, ErrReadOnlyReplica.Error()) {
+			tt.Fatalf("expected a read-only replica error but got '%s'", res)
+		}
+	})
+
+	t.Run("should describe the role of the servers", func(tt *testing.T) {
+		primary, primaryAddr := newTestApplication(tt, config{})
+		replica, replicaAddr := newTestApplication(tt, config{replicaOf: primaryAddr})
+		primary.storage.Set("foo", "bar")
+
+		eventually(tt, "the replica has not synced", func() bool {
+			_, found := replica.storage.Get("foo")
+			return found
+		})
+
+		res := sendRequest(tt, primaryAddr, []byte("ROLE"))
+		if res.Message != "primary offset=1 replicas=1" {
+			tt.Fatalf("expected the primary role but got '%s'", res)
+		}
+
+		res = sendRequest(tt, replicaAddr, []byte("ROLE"))
+		expected := "replica primary=" + primaryAddr + " state=streaming offset=1 behind=0"
+		if !strings.HasPrefix(res.Message, expected) {
+			tt.Fatalf("expected '%s' but got '%s'", expected, res)
+		}
+	})
+
+	t.Run("should promote a replica and make a primary a replica", func(tt *testing.T) {
+		primary, primaryAddr := newTestApplication(tt, config{})
+		replica, replicaAddr := newTestApplication(tt, config{replicaOf: primaryAddr})
+		primary.storage.Set("foo", "bar")
+		eventually(tt, "the replica has not synced", func() bool {
+			_, found := replica.storage.Get("foo")
+			return found
+		})
+
+		if res := sendRequest(tt, replicaAddr, []byte("REPLICAOF "+data.NoPrimary)); res.Status != data.ResponseStatusOK {
+			tt.Fatalf("expected an OK response but got '%s'", res)
+		}
+		if res := sendRequest(tt, replicaAddr, []byte("SET baz qux")); res.Status != data.ResponseStatusOK {
+			tt.Fatalf("expected the promoted replica to accept writes but got '%s'", res)
+		}
+
+		if res := sendRequest(tt, primaryAddr, []byte("REPLICAOF "+replicaAddr)); res.Status != data.ResponseStatusOK {
+			tt.Fatalf("expected an OK response but got '%s'", res)
+		}
+		eventually(tt, "the former primary has not synced from the promoted replica", func() bool {
+			value, _ := primary.storage.Get("baz")
+			return value == "qux"
+		})
+		if value, _ := primary.storage.Get("foo"); value != "bar" {
+			tt.Fatalf("expected the promoted replica to keep its data but got '%s'", value)
+		}
+	})
+
+	t.Run("should drop a replica that can't keep up", func(tt *testing.T) {
+		storage := NewInMemoryStorage()
+		defer storage.Close()
+		replicator := NewReplicator(storage)
+
+		stream, _, err := replicator.subscribe()
+		if err != nil {
+			tt.Fatal(err)
+		}
+		for range replicationBufferSize + 1 {
+			storage.Set("foo", "bar")
+		}
+
+		select {
+		case <-stream.dropped:
+		default:
+			tt.Fatal("the replica has not been dropped")
+		}
+		if !errors.Is(stream.err, ErrReplicaTooSlow) {
+			tt.Fatalf("expected ErrReplicaTooSlow but got '%v'", stream.err)
+		}
+	})
+}
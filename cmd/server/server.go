@@ -3,10 +3,14 @@ package main
 import (
 	"bytes"
 	"context"
+	"crypto/tls"
 	"errors"
+	"fmt"
+	"io"
 	"net"
 	"os"
 	"os/signal"
+	"strconv"
 	"syscall"
 	"time"
 
@@ -14,7 +18,17 @@ import (
 	levellog "github.com/JorgeLNJunior/cacher/pkg/logger"
 )
 
-const maxChunckSize = 4096
+const (
+	maxChunckSize = 4096
+	maxHeadBytes  = 64 << 10 // bytes besides the key searched for the start of a request
+)
+
+// writeMessages are the messages of the responses to successful writes.
+var writeMessages = map[data.Operation]string{
+	data.OperationSet: "the value has been inserted successfully",
+	data.OperationDel: "the value has been deleted successfully",
+	data.OperationExp: "the expiry has been set successfully",
+}
 
 // Listen starts a tcp server.
 func (app *application) Listen() error {
@@ -24,7 +38,20 @@ func (app *application) Listen() error {
 	}
 	defer listener.Close()
 
-	app.logger.Info("tcp server is listening", levellog.Args{"addr": app.config.address})
+	if app.tls != nil {
+		listener = tls.NewListener(listener, app.tls.ServerConfig())
+		go app.reloadCertificates()
+	}
+
+	app.logger.Info("tcp server is listening", levellog.Args{"addr": app.config.address, "tls": strconv.FormatBool(app.tls != nil)})
+
+	if app.config.metricsAddress != "" {
+		metrics, err := app.serveMetrics()
+		if err != nil {
+			return err
+		}
+		defer metrics.Close()
+	}
 
 	shutdownErr := make(chan error)
 	go func() {
@@ -34,6 +61,14 @@ func (app *application) Listen() error {
 
 		app.logger.Info("started shutting down the server", nil)
 
+		// pending cluster writes and replication connections stay open until they are stopped
+		if app.raft != nil {
+			app.raft.Stop()
+		}
+		app.replicate(data.NoPrimary)
+		app.replicator.Close()
+		app.monitor.Close()
+
 		c := make(chan int)
 		go func() {
 			defer close(c)
@@ -63,7 +98,7 @@ func (app *application) Listen() error {
 				continue
 			}
 
-			go app.handleConnection(conn)
+			app.acceptConnection(conn)
 		}
 	}()
 
@@ -72,6 +107,16 @@ func (app *application) Listen() error {
 		app.logger.Error("error shuting down the server", levellog.Args{"err": err.Error()})
 	}
 
+	if app.snapshotter != nil {
+		app.snapshotter.Stop()
+	}
+
+	if app.oplog != nil {
+		if err := app.oplog.Close(); err != nil {
+			app.logger.Error("error closing the operation log", levellog.Args{"err": err.Error()})
+		}
+	}
+
 	if app.config.persist {
 		app.logger.Info("started persisting the data on disk", nil)
 		ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
@@ -87,6 +132,23 @@ func (app *application) Listen() error {
 	return nil
 }
 
+// reloadCertificates reads the TLS certificates again every time the server receives
+// a SIGHUP.
+func (app *application) reloadCertificates() {
+	hangup := make(chan os.Signal, 1)
+	signal.Notify(hangup, syscall.SIGHUP)
+
+	for range hangup {
+		if err := app.tls.Reload(); err != nil {
+			app.logger.Warn("error reloading the TLS certificates, the previous ones are kept", levellog.Args{"err": err.Error()})
+			continue
+		}
+		app.logger.Info("the TLS certificates have been reloaded", nil)
+	}
+}
+
+// handleConnection answers the request of a connection. connectionGroup must be
+// incremented before it's called.
 func (app *application) handleConnection(conn net.Conn) {
 	defer app.connectionGroup.Done()
 	defer conn.Close()
@@ -96,8 +158,6 @@ func (app *application) handleConnection(conn net.Conn) {
 		}
 	}()
 
-	app.connectionGroup.Add(1)
-
 	if err := conn.SetWriteDeadline(time.Now().Add(time.Second * 5)); err != nil {
 		app.logger.Error("error setting write timeout", levellog.Args{"err": err.Error()})
 		return
@@ -108,21 +168,74 @@ func (app *application) handleConnection(conn net.Conn) {
 	defer cancel()
 
 	if err := app.readDataCtx(ctx, conn, buffer); err != nil {
+		if errors.Is(err, data.ErrTooLarge) {
+			app.rejectTooLarge(conn, err)
+			return
+		}
 		app.logger.Error("error reading data from a connection", levellog.Args{"err": err.Error()})
 		app.errorResponse(conn, err)
 		return
 	}
 
 	req := data.Request{}
-	if err := req.Unmarshal(buffer.Bytes()); err != nil {
+	if err := req.UnmarshalWithClock(buffer.Bytes(), app.clock); err != nil {
 		app.errorResponse(conn, err)
 		return
 	}
+	raw := buffer.Bytes()
+	if req.Credentials != nil {
+		_, raw, _ = bytes.Cut(raw, []byte("\n")) // the request without the AUTH line
+	}
+	if req.ClientName != "" {
+		_, raw, _ = bytes.Cut(raw, []byte("\n")) // the request without the CLIENT SETNAME line
+	}
+	if err := req.CheckSize(app.config.maxKeyBytes, app.config.maxValueBytes); err != nil {
+		app.rejectTooLarge(conn, err)
+		return
+	}
+
+	start := time.Now()
+	var user *User
+	if app.users != nil {
+		authorized, err := app.authorize(conn, req)
+		if err != nil {
+			app.errorResponse(conn, err)
+			return
+		}
+		user = authorized
+	}
+	// only the authorized requests are counted and measured
+	app.commands.count(req.Operation)
+	if !isStream(req.Operation) {
+		defer app.requestDone(conn, req, start)
+	}
+	if client, ok := conn.(*clientConn); ok {
+		client.request(req) // after the authorization, a denied request must not name the connection
+	}
+	app.monitor.Publish(app.clock.Now(), conn.RemoteAddr().String(), req)
+
+	if replica := app.replica.Load(); replica != nil && isWrite(req.Operation) {
+		app.errorResponse(conn, fmt.Errorf("%w of %s", ErrReadOnlyReplica, replica.Primary()))
+		return
+	}
+
+	if app.audit != nil && audited(req) {
+		if err := app.recordAudit(conn, req, user); err != nil {
+			app.errorResponse(conn, err)
+			return
+		}
+	}
+
+	if app.raft != nil && isWrite(req.Operation) {
+		app.handleClusterWrite(conn, req)
+		return
+	}
 
 	if req.Operation == data.OperationGet {
 		value, ok := app.storage.Get(req.Key)
+		app.commands.lookup(ok)
 		if !ok {
-			app.errorResponse(conn, errors.New("key not found"))
+			app.errorResponse(conn, data.ErrKeyNotFound)
 			return
 		}
 		app.okResponse(conn, value)
@@ -130,39 +243,130 @@ func (app *application) handleConnection(conn net.Conn) {
 	}
 	if req.Operation == data.OperationSet {
 		app.storage.Set(req.Key, req.Value)
-		app.okResponse(conn, "the value has been inserted successfully")
+		app.okResponse(conn, writeMessages[data.OperationSet])
 		return
 	}
 	if req.Operation == data.OperationDel {
 		app.storage.Delete(req.Key)
-		app.okResponse(conn, "the value has been deleted successfully")
+		app.okResponse(conn, writeMessages[data.OperationDel])
 		return
 	}
 	if req.Operation == data.OperationExp {
 		app.storage.ExpireAt(req.Key, req.Expiry)
-		app.okResponse(conn, "the expiry has been set successfully")
+		app.okResponse(conn, writeMessages[data.OperationExp])
+		return
+	}
+	if req.Operation == data.OperationBackup {
+		app.handleBackup(conn, req)
+		return
+	}
+	if req.Operation == data.OperationRestore {
+		app.handleRestore(conn, req, raw)
+		return
+	}
+	if req.Operation == data.OperationSync {
+		app.handleSync(conn, req)
+		return
+	}
+	if req.Operation == data.OperationRole {
+		app.handleRole(conn)
+		return
+	}
+	if req.Operation == data.OperationReplicaOf {
+		app.handleReplicaOf(conn, req)
+		return
+	}
+	if req.Operation == data.OperationRaft {
+		app.handleRaft(conn, req, raw)
+		return
+	}
+	if req.Operation == data.OperationInfo {
+		app.okResponse(conn, app.info().String())
+		return
+	}
+	if req.Operation == data.OperationMonitor {
+		app.handleMonitor(conn, req, user)
+		return
+	}
+	if req.Operation == data.OperationSlowLog {
+		app.handleSlowLog(conn, req)
+		return
+	}
+	if req.Operation == data.OperationClient {
+		app.handleClient(conn, req)
+		return
+	}
+	if req.Operation == data.OperationAuth {
+		if app.users == nil {
+			app.errorResponse(conn, ErrAuthDisabled)
+			return
+		}
+		app.okResponse(conn, "the user has been authenticated")
 		return
 	}
 
 	app.errorResponse(conn, errors.New("unknown error"))
 }
 
+// requestDone measures how long a request started at start took to be answered,
+// for the metrics and the slow log. It's only called for the authorized requests, so
+// the slow log never shows a key to a user who isn't allowed to access it.
+func (app *application) requestDone(conn net.Conn, req data.Request, start time.Time) {
+	duration := time.Since(start)
+	app.commands.observe(req.Operation, duration)
+	app.slowLog.Record(data.SlowLogEntry{
+		Time:      app.clock.Now(),
+		Duration:  duration,
+		Addr:      conn.RemoteAddr().String(),
+		Operation: req.Operation,
+		Key:       req.Key,
+	})
+}
+
+// isStream returns whether the connections of an operation stay open until the client
+// disconnects, so they are not measured.
+func isStream(op data.Operation) bool {
+	return op == data.OperationSync || op == data.OperationMonitor
+}
+
+// isWrite returns whether an operation changes the data.
+func isWrite(op data.Operation) bool {
+	return op == data.OperationSet || op == data.OperationDel || op == data.OperationExp || op == data.OperationRestore
+}
+
+// readData reads a request into to. A request over -max-request-bytes, or whose key or
+// SET value is over -max-key-bytes or -max-value-bytes, is rejected with a
+// data.TooLargeError as soon as it's known, before the rest is read.
 func (app *application) readData(conn net.Conn, to *bytes.Buffer) error {
 	var received int
+	var head data.RequestHead
+	parsed := false
 
 	// see: https://mostafa.dev/why-do-tcp-connections-in-go-get-stuck-reading-large-amounts-of-data-f490a26a605e
 	for {
 		chunck := make([]byte, maxChunckSize)
 
 		read, err := conn.Read(chunck)
+		if errors.Is(err, io.EOF) && received > 0 {
+			break // the client closed its side of the connection after sending the request
+		}
 		if err != nil {
 			return err
 		}
 		received += read
+		if limit := app.config.maxRequestBytes; limit > 0 && int64(received) > limit {
+			return data.TooLargeError{Part: data.SizeRequest, Limit: limit} // before buffering the chunk
+		}
 
 		if _, err := to.Write(chunck[:read]); err != nil {
 			return err
 		}
+		if !parsed && received <= maxHeadBytes+int(app.config.maxKeyBytes) {
+			head, parsed = data.ParseHead(to.Bytes())
+		}
+		if err := app.checkHead(head, parsed, to.Bytes()); err != nil {
+			return err
+		}
 
 		if read == 0 || read < maxChunckSize {
 			break
@@ -172,6 +376,52 @@ func (app *application) readData(conn net.Conn, to *bytes.Buffer) error {
 	return nil
 }
 
+// checkHead returns a data.TooLargeError if the key or the SET value of a request being
+// read are already over the limits. parsed is whether head is complete.
+func (app *application) checkHead(head data.RequestHead, parsed bool, received []byte) error {
+	if limit := app.config.maxKeyBytes; limit > 0 && int64(len(head.Key)) > limit {
+		return data.TooLargeError{Part: data.SizeKey, Limit: limit}
+	}
+	if !parsed || head.Operation != data.OperationSet {
+		return nil
+	}
+
+	value := bytes.TrimSuffix(received[head.Size:], []byte("\n"))
+	if limit := app.config.maxValueBytes; limit > 0 && int64(len(value)) > limit {
+		return data.TooLargeError{Part: data.SizeValue, Limit: limit}
+	}
+	return nil
+}
+
+// readRest reads the rest of a request until the client closes its side of the
+// connection. received is the size of the request read before.
+func (app *application) readRest(conn net.Conn, received int) ([]byte, error) {
+	limit := app.config.maxRequestBytes
+	if limit <= 0 {
+		return io.ReadAll(conn)
+	}
+
+	rest, err := io.ReadAll(io.LimitReader(conn, limit-int64(received)+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(received+len(rest)) > limit {
+		return nil, data.TooLargeError{Part: data.SizeRequest, Limit: limit}
+	}
+	return rest, nil
+}
+
+// rejectTooLarge answers a request over a size limit. The rest of the request is
+// discarded so the client receives the response instead of a reset connection.
+func (app *application) rejectTooLarge(conn net.Conn, err error) {
+	app.logger.Warn("a request has been rejected", levellog.Args{"addr": conn.RemoteAddr().String(), "err": err.Error()})
+	app.errorResponse(conn, err)
+
+	if err := conn.SetReadDeadline(time.Now().Add(rejectTimeout)); err == nil {
+		io.Copy(io.Discard, conn)
+	}
+}
+
 func (app *application) readDataCtx(ctx context.Context, conn net.Conn, to *bytes.Buffer) error {
 	select {
 	case <-ctx.Done():
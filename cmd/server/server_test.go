@@ -0,0 +1,220 @@
+package main
+
+import (
+	"crypto/tls"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/JorgeLNJunior/cacher/pkg/client"
+	"github.com/JorgeLNJunior/cacher/pkg/clock"
+	"github.com/JorgeLNJunior/cacher/pkg/data"
+	"github.com/JorgeLNJunior/cacher/pkg/dumpfile"
+	levellog "github.com/JorgeLNJunior/cacher/pkg/logger"
+)
+
+// newTestApplication returns an application serving connections on a random port.
+func newTestApplication(t *testing.T, cfg config) (*application, string) {
+	t.Helper()
+
+	if cfg.dumpFormat == "" {
+		cfg.dumpFormat = string(dumpfile.FormatBinary)
+	}
+	if cfg.restoreConflicts == "" {
+		cfg.restoreConflicts = string(ConflictMemoryWins)
+	}
+
+	c := clock.NewFake(testEpoch)
+	app := &application{
+		config:  cfg,
+		clock:   c,
+		logger:  levellog.NewLogger(levellog.LevelFatal, io.Discard),
+		storage: NewInMemoryStorageWithClock(c),
+		started: c.Now(),
+	}
+	app.replicator = NewReplicator(app.storage)
+	app.monitor = NewMonitor()
+	app.clients = NewClients(app.clock)
+	limiter, err := NewLimiter(cfg.limits, c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	app.limiter = limiter
+	if cfg.slowLogThreshold != 0 {
+		slowLog, err := NewSlowLog(cfg.slowLogThreshold, cfg.slowLogSize)
+		if err != nil {
+			t.Fatal(err)
+		}
+		app.slowLog = slowLog
+	}
+	if cfg.tlsCert != "" {
+		certificates, err := LoadTLSCertificates(cfg.tlsCert, cfg.tlsKey, cfg.tlsClientCA)
+		if err != nil {
+			t.Fatal(err)
+		}
+		app.tls = certificates
+	}
+	if cfg.usersFile != "" {
+		users, err := LoadUsers(cfg.usersFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		app.users = users
+	}
+	if cfg.peerUser != "" {
+		app.peerCredentials = &data.Credentials{User: cfg.peerUser, Password: cfg.peerPassword}
+	}
+	if cfg.auditFile != "" {
+		log, err := openAuditLog(cfg.auditFile, app.logger)
+		if err != nil {
+			t.Fatal(err)
+		}
+		app.audit = log
+	}
+	if cfg.replicaOf != "" {
+		app.replicate(cfg.replicaOf)
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if app.tls != nil {
+		listener = tls.NewListener(listener, app.tls.ServerConfig())
+	}
+	serving := make(chan struct{})
+	t.Cleanup(func() {
+		listener.Close()
+		<-serving
+		app.replicate(data.NoPrimary)
+		app.replicator.Close()
+		app.monitor.Close()
+		app.connectionGroup.Wait()
+		app.storage.Close()
+		if app.audit != nil {
+			app.audit.Close()
+		}
+	})
+
+	go func() {
+		defer close(serving)
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			app.acceptConnection(conn)
+		}
+	}()
+
+	return app, listener.Addr().String()
+}
+
+// sendRequest sends a raw request and returns the response. The connection is
+// closed for writing once the request is sent.
+func sendRequest(t *testing.T, addr string, request []byte) data.Response {
+	t.Helper()
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	if _, err := conn.Write(request); err != nil {
+		t.Fatal(err)
+	}
+	if err := conn.(*net.TCPConn).CloseWrite(); err != nil {
+		t.Fatal(err)
+	}
+
+	payload, err := io.ReadAll(conn)
+	if err != nil && !errors.Is(err, io.EOF) {
+		t.Fatal(err)
+	}
+
+	res := data.Response{}
+	if err := res.Unmarshal(payload); err != nil {
+		t.Fatalf("invalid response '%s': %s", payload, err)
+	}
+	return res
+}
+
+func TestSizeLimits(t *testing.T) {
+	cfg := config{maxKeyBytes: 8, maxValueBytes: 16, maxRequestBytes: 64 << 10}
+
+	t.Run("should reject the keys and values over the limits", func(tt *testing.T) {
+		app, addr := newTestApplication(tt, cfg)
+		c := client.New(addr)
+
+		var tooLarge data.TooLargeError
+		if err := c.Set("toolongkey", "bar"); !errors.As(err, &tooLarge) || tooLarge != (data.TooLargeError{Part: data.SizeKey, Limit: 8}) {
+			tt.Errorf("expected the key to be too large but got %v", err)
+		}
+		if err := c.Set("foo", strings.Repeat("v", 17)); !errors.As(err, &tooLarge) || tooLarge.Part != data.SizeValue {
+			tt.Errorf("expected the value to be too large but got %v", err)
+		}
+		if _, found := app.storage.Get("foo"); found {
+			tt.Error("expected the value not to be stored")
+		}
+
+		if err := c.Set("foo", strings.Repeat("v", 16)); err != nil {
+			tt.Errorf("expected a value at the limit to be stored but got %v", err)
+		}
+	})
+
+	t.Run("should reject a request over the limit while reading it", func(tt *testing.T) {
+		_, addr := newTestApplication(tt, config{maxRequestBytes: 64 << 10})
+
+		err := client.New(addr).Set("foo", strings.Repeat("v", 1<<20))
+		if !errors.Is(err, data.ErrTooLarge) || err.Error() != (data.TooLargeError{Part: data.SizeRequest, Limit: 64 << 10}).Error() {
+			tt.Errorf("expected the request to be too large but got %v", err)
+		}
+	})
+
+	t.Run("should reject a value over the limit before reading the whole request", func(tt *testing.T) {
+		_, addr := newTestApplication(tt, config{maxValueBytes: 16, maxRequestBytes: 64 << 20})
+
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			tt.Fatal(err)
+		}
+		defer conn.Close()
+
+		// whole chunks of the request are sent but never the rest of the value, so the
+		// server can't wait for it
+		if _, err := conn.Write([]byte("SET foo " + strings.Repeat("v", 4*maxChunckSize-len("SET foo ")))); err != nil {
+			tt.Fatal(err)
+		}
+		if err := conn.SetReadDeadline(time.Now().Add(time.Second * 3)); err != nil {
+			tt.Fatal(err)
+		}
+		payload, err := io.ReadAll(conn)
+		if err != nil {
+			tt.Fatalf("expected a response before the request is complete but got %v", err)
+		}
+
+		res := data.Response{}
+		if err := res.Unmarshal(payload); err != nil {
+			tt.Fatal(err)
+		}
+		if tooLarge, ok := data.ParseTooLarge(res.Message); !ok || tooLarge.Part != data.SizeValue {
+			tt.Errorf("expected the value to be too large but got '%s'", res)
+		}
+	})
+
+	t.Run("should reject a streamed snapshot over the limit", func(tt *testing.T) {
+		_, addr := newTestApplication(tt, config{maxRequestBytes: 64 << 10})
+
+		res, err := client.New(addr).Do(data.Request{Operation: data.OperationRestore, Key: data.StreamKey, Value: strings.Repeat("s", 1<<20)})
+		if err != nil {
+			tt.Fatal(err)
+		}
+		if _, ok := data.ParseTooLarge(res.Message); res.Status != data.ResponseStatusError || !ok {
+			tt.Errorf("expected the request to be too large but got '%s'", res)
+		}
+	})
+}
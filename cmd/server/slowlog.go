@@ -0,0 +1,128 @@
+package main
+
+import (
+	"errors"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/JorgeLNJunior/cacher/pkg/data"
+)
+
+const defaultSlowLogEntries = 10 // entries returned by a SLOWLOG GET without a count
+
+var ErrInvalidSlowLog = errors.New("the slow log threshold and size must be positive")
+
+// SlowLog keeps the last requests that took longer than a threshold in a ring buffer.
+// A nil SlowLog records nothing.
+type SlowLog struct {
+	threshold time.Duration
+	entries   []data.SlowLogEntry // ring buffer, the oldest entry is at start once it's full
+	start     int
+	nextID    int64
+	mu        sync.Mutex
+}
+
+// NewSlowLog returns a SlowLog keeping the last size requests slower than threshold,
+// or ErrInvalidSlowLog.
+func NewSlowLog(threshold time.Duration, size int) (*SlowLog, error) {
+	if threshold <= 0 || size <= 0 {
+		return nil, ErrInvalidSlowLog
+	}
+	return &SlowLog{threshold: threshold, entries: make([]data.SlowLogEntry, 0, size)}, nil
+}
+
+// Record adds an entry if its duration reaches the threshold, replacing the oldest
+// entry if the slow log is full.
+func (l *SlowLog) Record(entry data.SlowLogEntry) {
+	if l == nil || entry.Duration < l.threshold {
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.nextID++
+	entry.ID = l.nextID
+	if len(l.entries) < cap(l.entries) {
+		l.entries = append(l.entries, entry)
+		return
+	}
+	l.entries[l.start] = entry
+	l.start = (l.start + 1) % len(l.entries)
+}
+
+// Newest returns up to n entries, the newest first.
+func (l *SlowLog) Newest(n int) []data.SlowLogEntry {
+	if l == nil {
+		return nil
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	n = min(n, len(l.entries))
+	newest := make([]data.SlowLogEntry, 0, n)
+	for i := range n {
+		newest = append(newest, l.entries[(l.start+len(l.entries)-1-i)%len(l.entries)])
+	}
+	return newest
+}
+
+// Len returns the number of entries.
+func (l *SlowLog) Len() int {
+	if l == nil {
+		return 0
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return len(l.entries)
+}
+
+// Reset removes every entry. The IDs keep increasing.
+func (l *SlowLog) Reset() {
+	if l == nil {
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.entries = l.entries[:0]
+	l.start = 0
+}
+
+// handleSlowLog answers the SLOWLOG requests.
+func (app *application) handleSlowLog(conn net.Conn, req data.Request) {
+	switch req.Key {
+	case data.SlowLogGet:
+		count := defaultSlowLogEntries
+		if req.Value != "" {
+			n, err := strconv.Atoi(req.Value)
+			if err != nil || n < 1 {
+				app.errorResponse(conn, data.ErrInvalidSlowLogCount)
+				return
+			}
+			count = n
+		}
+
+		// the count comes from the client, Newest bounds it by the entries stored
+		entries := app.slowLog.Newest(count)
+		lines := make([]string, 0, len(entries))
+		for _, entry := range entries {
+			lines = append(lines, entry.String())
+		}
+		app.okResponse(conn, strings.Join(lines, "\n"))
+	case data.SlowLogLen:
+		app.okResponse(conn, strconv.Itoa(app.slowLog.Len()))
+	case data.SlowLogReset:
+		app.slowLog.Reset()
+		app.okResponse(conn, "the slow log has been reset")
+	default:
+		app.errorResponse(conn, data.ErrInvalidSlowLogCommand)
+	}
+}
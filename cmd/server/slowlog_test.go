@@ -0,0 +1,163 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/JorgeLNJunior/cacher/pkg/client"
+	"github.com/JorgeLNJunior/cacher/pkg/data"
+)
+
+func TestSlowLog(t *testing.T) {
+	t.Run("should keep the last slow requests, the newest first", func(tt *testing.T) {
+		slowLog, err := NewSlowLog(time.Millisecond, 3)
+		if err != nil {
+			tt.Fatal(err)
+		}
+
+		slowLog.Record(data.SlowLogEntry{Duration: time.Microsecond, Key: "fast"})
+		for _, key := range []string{"a", "b", "c", "d", "e"} {
+			slowLog.Record(data.SlowLogEntry{Duration: time.Millisecond, Key: key})
+		}
+
+		if slowLog.Len() != 3 {
+			tt.Fatalf("expected 3 entries but got %d", slowLog.Len())
+		}
+		entries := slowLog.Newest(10)
+		if len(entries) != 3 || entries[0].Key != "e" || entries[1].Key != "d" || entries[2].Key != "c" {
+			tt.Fatalf("expected the entries e, d and c but got %+v", entries)
+		}
+		if entries[0].ID != 5 {
+			tt.Errorf("expected the newest entry to have the ID 5 but got %d", entries[0].ID)
+		}
+		if entries := slowLog.Newest(1); len(entries) != 1 || entries[0].Key != "e" {
+			tt.Errorf("expected only the newest entry but got %+v", entries)
+		}
+	})
+
+	t.Run("should remove every entry on reset", func(tt *testing.T) {
+		slowLog, err := NewSlowLog(time.Millisecond, 2)
+		if err != nil {
+			tt.Fatal(err)
+		}
+
+		slowLog.Record(data.SlowLogEntry{Duration: time.Second, Key: "a"})
+		slowLog.Record(data.SlowLogEntry{Duration: time.Second, Key: "b"})
+		slowLog.Record(data.SlowLogEntry{Duration: time.Second, Key: "c"})
+		slowLog.Reset()
+		if slowLog.Len() != 0 {
+			tt.Fatalf("expected no entries but got %d", slowLog.Len())
+		}
+
+		slowLog.Record(data.SlowLogEntry{Duration: time.Second, Key: "d"})
+		if entries := slowLog.Newest(10); len(entries) != 1 || entries[0].Key != "d" || entries[0].ID != 4 {
+			tt.Errorf("expected only the entry d with the ID 4 but got %+v", entries)
+		}
+	})
+
+	t.Run("should record nothing if it's disabled", func(tt *testing.T) {
+		var slowLog *SlowLog
+		slowLog.Record(data.SlowLogEntry{Duration: time.Hour})
+
+		if slowLog.Len() != 0 || len(slowLog.Newest(10)) != 0 {
+			tt.Error("expected a disabled slow log to be empty")
+		}
+	})
+
+	t.Run("should return an error if the threshold or the size are not positive", func(tt *testing.T) {
+		if _, err := NewSlowLog(-time.Second, 10); !errors.Is(err, ErrInvalidSlowLog) {
+			tt.Errorf("expected ErrInvalidSlowLog but got %v", err)
+		}
+		if _, err := NewSlowLog(time.Second, 0); !errors.Is(err, ErrInvalidSlowLog) {
+			tt.Errorf("expected ErrInvalidSlowLog but got %v", err)
+		}
+	})
+}
+
+func TestSlowLogRequests(t *testing.T) {
+	t.Run("should answer the SLOWLOG requests", func(tt *testing.T) {
+		_, addr := newTestApplication(tt, config{slowLogThreshold: time.Nanosecond, slowLogSize: 10})
+		c := client.New(addr)
+
+		if err := c.Set("foo", "bar"); err != nil {
+			tt.Fatal(err)
+		}
+		if _, err := c.Get("foo"); err != nil {
+			tt.Fatal(err)
+		}
+
+		entries, err := c.SlowLog(10)
+		if err != nil {
+			tt.Fatal(err)
+		}
+		if len(entries) != 2 || entries[0].Operation != data.OperationGet || entries[1].Operation != data.OperationSet {
+			tt.Fatalf("expected the GET and the SET but got %+v", entries)
+		}
+		if entries[0].Key != "foo" || !entries[0].Time.Equal(testEpoch) || entries[0].Addr == "" {
+			tt.Errorf("expected the key, the time and the client of the request but got %+v", entries[0])
+		}
+
+		if n, err := c.SlowLogLen(); err != nil || n != 3 {
+			tt.Errorf("expected 3 entries but got %d, %v", n, err)
+		}
+		if err := c.SlowLogReset(); err != nil {
+			tt.Fatal(err)
+		}
+		if n, err := c.SlowLogLen(); err != nil || n != 1 {
+			tt.Errorf("expected only the RESET but got %d, %v", n, err)
+		}
+	})
+
+	t.Run("should return an error if the command or the count are invalid", func(tt *testing.T) {
+		_, addr := newTestApplication(tt, config{})
+		c := client.New(addr)
+
+		res, err := c.Do(data.Request{Operation: data.OperationSlowLog, Key: "FLUSH"})
+		if err != nil {
+			tt.Fatal(err)
+		}
+		if res.Status != data.ResponseStatusError || res.Message != data.ErrInvalidSlowLogCommand.Error() {
+			tt.Errorf("expected ErrInvalidSlowLogCommand but got '%s'", res)
+		}
+
+		for _, n := range []int{0, -1} {
+			if _, err := c.SlowLog(n); err == nil || err.Error() != data.ErrInvalidSlowLogCount.Error() {
+				tt.Errorf("expected ErrInvalidSlowLogCount for %d but got %v", n, err)
+			}
+		}
+	})
+
+	t.Run("should only record the authorized requests", func(tt *testing.T) {
+		usersFile := writeUsersFile(tt, "admin s3cret * *")
+		app, addr := newTestApplication(tt, config{slowLogThreshold: time.Nanosecond, slowLogSize: 10, usersFile: usersFile})
+
+		sendRequest(tt, addr, []byte("GET secret:anonymous"))
+		sendRequest(tt, addr, []byte("AUTH admin wrong\nGET secret:denied"))
+		admin := &client.Client{Addr: addr, Timeout: client.DefaultTimeout, Credentials: &data.Credentials{User: "admin", Password: "s3cret"}}
+		if err := admin.Set("foo", "bar"); err != nil {
+			tt.Fatal(err)
+		}
+
+		if entries := app.slowLog.Newest(10); len(entries) != 1 || entries[0].Key != "foo" {
+			tt.Errorf("expected only the SET of foo but got %+v", entries)
+		}
+	})
+
+	t.Run("should bound a huge count by the entries stored", func(tt *testing.T) {
+		_, addr := newTestApplication(tt, config{slowLogThreshold: time.Nanosecond, slowLogSize: 10})
+		c := client.New(addr)
+
+		if err := c.Set("foo", "bar"); err != nil {
+			tt.Fatal(err)
+		}
+
+		entries, err := c.SlowLog(1_000_000_000_000)
+		if err != nil {
+			tt.Fatal(err)
+		}
+		if len(entries) != 1 || entries[0].Operation != data.OperationSet {
+			tt.Errorf("expected only the SET but got %+v", entries)
+		}
+	})
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/JorgeLNJunior/cacher/pkg/clock"
+)
+
+const (
+	snapshotCheckInterval = time.Second
+	snapshotRetryDelay    = time.Second * 5 // wait before retrying a failed snapshot
+)
+
+var ErrInvalidSnapshotRule = errors.New("snapshot rules must be in the SECONDS:CHANGES[,SECONDS:CHANGES] format")
+
+// SnapshotRule triggers a snapshot when at least Changes mutations have been applied
+// and Period has elapsed since the last snapshot.
+type SnapshotRule struct {
+	Period  time.Duration
+	Changes int64
+}
+
+// ParseSnapshotRules parses rules in the SECONDS:CHANGES[,SECONDS:CHANGES] format.
+// E.g. "900:1,60:10000" snapshots after 15 minutes if a key changed or after
+// a minute if 10000 keys changed.
+func ParseSnapshotRules(value string) ([]SnapshotRule, error) {
+	if value == "" {
+		return nil, nil
+	}
+
+	rules := make([]SnapshotRule, 0)
+	for rule := range strings.SplitSeq(value, ",") {
+		seconds, changes, found := strings.Cut(strings.TrimSpace(rule), ":")
+		if !found {
+			return nil, ErrInvalidSnapshotRule
+		}
+
+		s, err := strconv.ParseInt(seconds, 10, 64)
+		if err != nil || s < 1 {
+			return nil, ErrInvalidSnapshotRule
+		}
+		c, err := strconv.ParseInt(changes, 10, 64)
+		if err != nil || c < 1 {
+			return nil, ErrInvalidSnapshotRule
+		}
+
+		rules = append(rules, SnapshotRule{Period: time.Duration(s) * time.Second, Changes: c})
+	}
+
+	return rules, nil
+}
+
+// Snapshotter persists a storage on disk in background every interval or when one
+// of its rules is satisfied.
+type Snapshotter struct {
+	disk         *OnDiskStorage
+	source       *InMemoryStorage
+	interval     time.Duration
+	rules        []SnapshotRule
+	clock        clock.Clock
+	changes      atomic.Int64 // mutations applied since the last snapshot
+	lastSnapshot time.Time    // when the last snapshot started
+	lastFailure  time.Time
+	onError      func(error)
+	onSnapshot   func(PersistStatus)
+	ctx          context.Context
+	cancel       context.CancelFunc
+	done         chan struct{}
+}
+
+// NewSnapshotter returns a Snapshotter. An interval of zero disables periodic snapshots.
+func NewSnapshotter(
+	disk *OnDiskStorage,
+	source *InMemoryStorage,
+	interval time.Duration,
+	rules []SnapshotRule,
+	c clock.Clock,
+) *Snapshotter {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	snapshotter := &Snapshotter{
+		disk:         disk,
+		source:       source,
+		interval:     interval,
+		rules:        rules,
+		clock:        c,
+		lastSnapshot: c.Now(),
+		onError:      func(error) {},
+		onSnapshot:   func(PersistStatus) {},
+		ctx:          ctx,
+		cancel:       cancel,
+		done:         make(chan struct{}),
+	}
+	source.Observe(func(Mutation) {
+		snapshotter.changes.Add(1)
+	})
+
+	return snapshotter
+}
+
+// Start checks once per second whether a snapshot is due until Stop is called.
+// onSnapshot is called after every successful snapshot and onError after every failure.
+func (s *Snapshotter) Start(onSnapshot func(PersistStatus), onError func(error)) {
+	s.onSnapshot = onSnapshot
+	s.onError = onError
+
+	ticker := s.clock.NewTicker(snapshotCheckInterval)
+	go func() {
+		defer close(s.done)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-s.ctx.Done():
+				return
+			case <-ticker.C():
+				if s.due() {
+					s.snapshot()
+				}
+			}
+		}
+	}()
+}
+
+// Stop stops the snapshotter, canceling a snapshot if one is in progress.
+func (s *Snapshotter) Stop() {
+	s.cancel()
+	<-s.done
+}
+
+func (s *Snapshotter) due() bool {
+	now := s.clock.Now()
+	if now.Sub(s.lastFailure) < snapshotRetryDelay {
+		return false
+	}
+
+	elapsed := now.Sub(s.lastSnapshot)
+	if s.interval > 0 && elapsed >= s.interval {
+		return true
+	}
+
+	changes := s.changes.Load()
+	for _, rule := range s.rules {
+		if changes >= rule.Changes && elapsed >= rule.Period {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (s *Snapshotter) snapshot() {
+	start := s.clock.Now()
+	changes := s.changes.Load()
+
+	if err := s.disk.Persist(s.ctx, s.source); err != nil {
+		s.lastFailure = start
+		s.onError(err)
+		return
+	}
+
+	// mutations applied during the snapshot may not be in it, so they still count
+	s.changes.Add(-changes)
+	s.lastSnapshot = start
+	s.onSnapshot(s.disk.Status())
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/JorgeLNJunior/cacher/pkg/clock"
+)
+
+func TestParseSnapshotRules(t *testing.T) {
+	t.Run("should parse the rules", func(tt *testing.T) {
+		rules, err := ParseSnapshotRules("900:1, 60:10000")
+		if err != nil {
+			tt.Fatal(err)
+		}
+
+		expected := []SnapshotRule{
+			{Period: time.Second * 900, Changes: 1},
+			{Period: time.Second * 60, Changes: 10000},
+		}
+		if len(rules) != len(expected) {
+			tt.Fatalf("expected %d rules but got %d", len(expected), len(rules))
+		}
+		for i := range expected {
+			if rules[i] != expected[i] {
+				tt.Errorf("expected rule %d to be %+v but got %+v", i, expected[i], rules[i])
+			}
+		}
+	})
+
+	t.Run("should return an error if a rule is invalid", func(tt *testing.T) {
+		for _, value := range []string{"900", "900:", "a:1", "0:1", "60:-1"} {
+			if _, err := ParseSnapshotRules(value); !errors.Is(err, ErrInvalidSnapshotRule) {
+				tt.Errorf("expected ErrInvalidSnapshotRule for '%s' but received '%s'", value, err)
+			}
+		}
+	})
+}
+
+func TestSnapshotter(t *testing.T) {
+	t.Run("should snapshot every interval", func(tt *testing.T) {
+		clock := clock.NewFake(testEpoch)
+		storage := NewInMemoryStorageWithClock(clock)
+		defer storage.Close()
+
+		snapshotter := NewSnapshotter(newTestOnDiskStorage(tt), storage, time.Minute, nil, clock)
+
+		clock.Advance(time.Second * 59)
+		if snapshotter.due() {
+			tt.Fatal("the snapshot is due before the interval elapsed")
+		}
+		clock.Advance(time.Second)
+		if !snapshotter.due() {
+			tt.Fatal("the snapshot is not due after the interval elapsed")
+		}
+	})
+
+	t.Run("should snapshot after enough changes in the period", func(tt *testing.T) {
+		clock := clock.NewFake(testEpoch)
+		storage := NewInMemoryStorageWithClock(clock)
+		defer storage.Close()
+
+		rules := []SnapshotRule{{Period: time.Second * 10, Changes: 2}}
+		snapshotter := NewSnapshotter(newTestOnDiskStorage(tt), storage, 0, rules, clock)
+
+		storage.Set("foo", "bar")
+		clock.Advance(time.Second * 10)
+		if snapshotter.due() {
+			tt.Fatal("the snapshot is due without enough changes")
+		}
+
+		storage.Set("baz", "qux")
+		if !snapshotter.due() {
+			tt.Fatal("the snapshot is not due after enough changes")
+		}
+	})
+
+	t.Run("should persist a point-in-time copy and reset the changes", func(tt *testing.T) {
+		clock := clock.NewFake(testEpoch)
+		storage := NewInMemoryStorageWithClock(clock)
+		defer storage.Close()
+
+		disk := newTestOnDiskStorage(tt)
+		rules := []SnapshotRule{{Period: time.Second, Changes: 1}}
+		snapshotter := NewSnapshotter(disk, storage, 0, rules, clock)
+
+		storage.Set("foo", "bar")
+		clock.Advance(time.Second)
+		snapshotter.snapshot()
+
+		if snapshotter.due() {
+			tt.Fatal("the snapshot is still due after a snapshot")
+		}
+		if disk.Status().Time.IsZero() {
+			tt.Fatal("the snapshot status has not been updated")
+		}
+
+		restored := NewInMemoryStorageWithClock(clock)
+		defer restored.Close()
+		if _, err := disk.Restore(context.Background(), restored, ConflictFail); err != nil {
+			tt.Fatal(err)
+		}
+		if value, _ := restored.Get("foo"); value != "bar" {
+			tt.Fatalf("expected 'bar' but got '%s'", value)
+		}
+	})
+}
@@ -1,54 +1,196 @@
 package main
 
 import (
+	"encoding/json"
+	"errors"
+	"fmt"
 	"maps"
 	"sync"
 	"time"
+
+	"github.com/JorgeLNJunior/cacher/pkg/clock"
+	"github.com/JorgeLNJunior/cacher/pkg/data"
+	"github.com/JorgeLNJunior/cacher/pkg/dumpfile"
 )
 
-var oneYear = time.Now().Add(time.Hour * 24 * 365)
+const (
+	defaultExpiry = time.Hour * 24 * 365 // keys without an explicit expiry live for one year
+	sweepInterval = time.Millisecond * 100
+	maxSweepBatch = 1000 // max number of keys removed per sweep tick
+	itemOverhead  = 160  // approximate bytes used to store a key besides the key and the value
+)
 
 type Storage interface {
-	Restore(data map[string]StorageItem)
+	Restore(data map[string]StorageItem, policy ConflictPolicy) (RestoreStats, error)
 	Dump() map[string]StorageItem
 }
 
+// ConflictPolicy defines what happens when restored data has a key that is already stored.
+type ConflictPolicy string
+
+const (
+	ConflictDumpWins   ConflictPolicy = "dump"   // the restored item replaces the stored one
+	ConflictMemoryWins ConflictPolicy = "memory" // the stored item is kept
+	ConflictFail       ConflictPolicy = "fail"   // nothing is restored
+)
+
+func (p ConflictPolicy) Valid() bool {
+	switch {
+	case p == ConflictDumpWins:
+		return true
+	case p == ConflictMemoryWins:
+		return true
+	case p == ConflictFail:
+		return true
+	default:
+		return false
+	}
+}
+
+var (
+	ErrInvalidConflictPolicy = errors.New("conflict policy must be dump, memory or fail")
+	ErrRestoreConflict       = errors.New("the restored data has keys that are already stored")
+)
+
+// RestoreStats reports what happened to each restored item.
+type RestoreStats struct {
+	Loaded      int // items copied into the storage
+	Skipped     int // items that have expired
+	Conflicting int // items whose key was already stored
+}
+
 type InMemoryStorage struct {
-	data map[string]StorageItem
-	mu   sync.Mutex
+	data        map[string]StorageItem
+	expiries    map[string]*expiryEntry
+	expiryIndex expiryIndex
+	clock       clock.Clock
+	mu          sync.Mutex
+	stop        chan struct{}
+	sweepDone   chan struct{}
+	closeOnce   sync.Once
+	observers   []func(Mutation)
+	sweeps      []func(time.Duration)
+	volatile    int   // keys whose expiry has been set explicitly
+	expired     int64 // keys removed because they expired
+	memory      int64 // approximate bytes used by the data
 }
 
-type StorageItem struct {
-	Value  string
-	Expiry time.Time
+// StorageStats describes the data of an InMemoryStorage.
+type StorageStats struct {
+	Keys     int
+	Volatile int   // keys whose expiry has been set explicitly, by an EXP
+	Expired  int64 // keys removed since the storage was created because they expired
+	Memory   int64 // approximate bytes used by the keys and the values
 }
 
-// Expired returns whether an item is expired.
-func (i StorageItem) Expired() bool {
-	return time.Now().After(i.Expiry)
+// Mutation is a change applied to an InMemoryStorage. It's written to the operation
+// log, the replication stream and the Raft log as JSON, see mutationJSON.
+type Mutation struct {
+	Operation data.Operation
+	Key       string
+	Value     string
+	Expiry    time.Time
+	TTL       bool // whether the expiry of a SET has been set explicitly, see StorageItem.TTL
 }
 
+// mutationJSON is the JSON form of a Mutation. The key and the value are base64
+// encoded, since encoding/json replaces the bytes that are not valid UTF-8 of a
+// string. Key and Value are the plain strings of the records written before and are
+// only read.
+type mutationJSON struct {
+	Operation data.Operation
+	Key       string    `json:",omitempty"`
+	Value     string    `json:",omitempty"`
+	RawKey    []byte    `json:",omitempty"`
+	RawValue  []byte    `json:",omitempty"`
+	Expiry    time.Time `json:",omitzero"`
+	TTL       bool      `json:",omitempty"`
+}
+
+func (m Mutation) MarshalJSON() ([]byte, error) {
+	return json.Marshal(mutationJSON{
+		Operation: m.Operation,
+		RawKey:    []byte(m.Key),
+		RawValue:  []byte(m.Value),
+		Expiry:    m.Expiry,
+		TTL:       m.TTL,
+	})
+}
+
+func (m *Mutation) UnmarshalJSON(b []byte) error {
+	var record mutationJSON
+	if err := json.Unmarshal(b, &record); err != nil {
+		return err
+	}
+
+	*m = Mutation{Operation: record.Operation, Key: record.Key, Value: record.Value, Expiry: record.Expiry, TTL: record.TTL}
+	if record.RawKey != nil {
+		m.Key = string(record.RawKey)
+	}
+	if record.RawValue != nil {
+		m.Value = string(record.RawValue)
+	}
+	return nil
+}
+
+// StorageItem is a stored value, in the same shape it's persisted on disk.
+type StorageItem = dumpfile.Item
+
 // NewInMemoryStorage returns a InMemoryStorage instance.
 func NewInMemoryStorage() *InMemoryStorage {
+	return NewInMemoryStorageWithClock(clock.New())
+}
+
+// NewInMemoryStorageWithClock returns a InMemoryStorage instance that uses c to decide
+// when keys expire.
+func NewInMemoryStorageWithClock(c clock.Clock) *InMemoryStorage {
 	store := &InMemoryStorage{
-		data: make(map[string]StorageItem),
+		clock:     c,
+		data:      make(map[string]StorageItem),
+		expiries:  make(map[string]*expiryEntry),
+		stop:      make(chan struct{}),
+		sweepDone: make(chan struct{}),
 	}
 
-	go func() {
-		for range time.Tick(time.Second * 5) {
-			store.mu.Lock()
-			for key, item := range store.data {
-				if item.Expired() {
-					delete(store.data, key)
-				}
-			}
-			store.mu.Unlock()
-		}
-	}()
+	// the ticker is created before the sweeper starts so no tick is missed
+	go store.sweep(c.NewTicker(sweepInterval))
 
 	return store
 }
 
+// Close stops the background expiry sweeper.
+func (s *InMemoryStorage) Close() {
+	s.closeOnce.Do(func() {
+		close(s.stop)
+	})
+	<-s.sweepDone
+}
+
+// Observe registers a function that is called with every mutation applied to the storage.
+// Observers are called in the order mutations are applied while the storage is locked,
+// so they must not block nor call the storage back.
+func (s *InMemoryStorage) Observe(fn func(Mutation)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.observers = append(s.observers, fn)
+}
+
+// ObserveSweeps registers a function that is called with the duration of every sweep
+// of the expired keys.
+func (s *InMemoryStorage) ObserveSweeps(fn func(time.Duration)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.sweeps = append(s.sweeps, fn)
+}
+
+func (s *InMemoryStorage) notify(m Mutation) {
+	for _, observer := range s.observers {
+		observer(m)
+	}
+}
+
 // Get returns if the key is stored or not and its value.
 func (s *InMemoryStorage) Get(key string) (string, bool) {
 	s.mu.Lock()
@@ -59,8 +201,10 @@ func (s *InMemoryStorage) Get(key string) (string, bool) {
 		return "", false
 	}
 
-	if item.Expired() {
-		delete(s.data, key)
+	if item.ExpiredAt(s.clock.Now()) {
+		s.remove(key)
+		s.expired++
+		s.notify(Mutation{Operation: data.OperationDel, Key: key})
 		return "", false
 	}
 
@@ -74,10 +218,20 @@ func (s *InMemoryStorage) Set(key string, value string) {
 
 	item := StorageItem{
 		Value:  value,
-		Expiry: oneYear,
+		Expiry: s.clock.Now().Add(defaultExpiry),
 	}
 
-	s.data[key] = item
+	s.put(key, item)
+	s.notify(Mutation{Operation: data.OperationSet, Key: key, Value: value, Expiry: item.Expiry})
+}
+
+// Put stores an item as it is, with its expiry, e.g. an item replayed or replicated.
+func (s *InMemoryStorage) Put(key string, item StorageItem) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.put(key, item)
+	s.notify(Mutation{Operation: data.OperationSet, Key: key, Value: item.Value, Expiry: item.Expiry, TTL: item.TTL})
 }
 
 // Delete removes a key and its value from the storage.
@@ -85,7 +239,12 @@ func (s *InMemoryStorage) Delete(key string) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	delete(s.data, key)
+	if _, found := s.data[key]; !found {
+		return
+	}
+
+	s.remove(key)
+	s.notify(Mutation{Operation: data.OperationDel, Key: key})
 }
 
 // ExpireAt sets the expiration date of an item.
@@ -99,7 +258,71 @@ func (s *InMemoryStorage) ExpireAt(key string, t time.Time) {
 	}
 
 	item.Expiry = t
+	item.TTL = true
+	s.put(key, item)
+	s.notify(Mutation{Operation: data.OperationExp, Key: key, Expiry: t})
+}
+
+// Replace atomically replaces the data of the storage with the items that have not expired.
+func (s *InMemoryStorage) Replace(items map[string]StorageItem) RestoreStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stats := RestoreStats{}
+	now := s.clock.Now()
+
+	for key := range s.data {
+		if item, found := items[key]; !found || item.ExpiredAt(now) {
+			s.remove(key)
+			s.notify(Mutation{Operation: data.OperationDel, Key: key})
+		}
+	}
+
+	for k, v := range items {
+		if v.ExpiredAt(now) {
+			stats.Skipped++
+			continue
+		}
+
+		s.put(k, v)
+		s.notify(Mutation{Operation: data.OperationSet, Key: k, Value: v.Value, Expiry: v.Expiry, TTL: v.TTL})
+		stats.Loaded++
+	}
+
+	return stats
+}
+
+// Stats describes the data of the storage.
+func (s *InMemoryStorage) Stats() StorageStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return StorageStats{Keys: len(s.data), Volatile: s.volatile, Expired: s.expired, Memory: s.memory}
+}
+
+// put stores an item and tracks its expiry.
+func (s *InMemoryStorage) put(key string, item StorageItem) {
+	if stored, found := s.data[key]; found {
+		s.memory -= int64(len(stored.Value))
+	} else {
+		s.memory += int64(len(key)) + itemOverhead
+	}
+	s.memory += int64(len(item.Value))
+
 	s.data[key] = item
+	s.track(key, item.Expiry, item.TTL)
+}
+
+// remove deletes an item and stops tracking its expiry.
+func (s *InMemoryStorage) remove(key string) {
+	item, found := s.data[key]
+	if !found {
+		return
+	}
+	s.memory -= int64(len(key)+len(item.Value)) + itemOverhead
+
+	delete(s.data, key)
+	s.untrack(key)
 }
 
 // Dump returns a copy of all data in the storage.
@@ -113,14 +336,46 @@ func (s *InMemoryStorage) Dump() map[string]StorageItem {
 	return dump
 }
 
-// Restore copies data into the storage.
-func (s *InMemoryStorage) Restore(data map[string]StorageItem) {
+// Restore copies data into the storage, skipping the items that have expired.
+// Keys that are already stored are handled according to policy.
+func (s *InMemoryStorage) Restore(items map[string]StorageItem, policy ConflictPolicy) (RestoreStats, error) {
+	if !policy.Valid() {
+		return RestoreStats{}, ErrInvalidConflictPolicy
+	}
+
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	for k, v := range data {
-		if _, found := s.data[k]; !found {
-			s.data[k] = v
+	stats := RestoreStats{}
+	now := s.clock.Now()
+
+	if policy == ConflictFail {
+		for k, v := range items {
+			if _, found := s.data[k]; found && !v.ExpiredAt(now) {
+				stats.Conflicting++
+			}
+		}
+		if stats.Conflicting > 0 {
+			return stats, fmt.Errorf("%w: %d conflicting keys", ErrRestoreConflict, stats.Conflicting)
 		}
 	}
+
+	for k, v := range items {
+		if v.ExpiredAt(now) {
+			stats.Skipped++
+			continue
+		}
+		if _, found := s.data[k]; found {
+			stats.Conflicting++
+			if policy == ConflictMemoryWins {
+				continue
+			}
+		}
+
+		s.put(k, v)
+		s.notify(Mutation{Operation: data.OperationSet, Key: k, Value: v.Value, Expiry: v.Expiry, TTL: v.TTL})
+		stats.Loaded++
+	}
+
+	return stats, nil
 }
@@ -3,11 +3,16 @@ package main
 import (
 	"crypto/rand"
 	"encoding/base32"
+	"errors"
 	"testing"
+	"time"
+
+	"github.com/JorgeLNJunior/cacher/pkg/clock"
 )
 
 func TestSet(t *testing.T) {
 	storage := NewInMemoryStorage()
+	defer storage.Close()
 	expectedKeys := 100
 
 	for range expectedKeys {
@@ -23,6 +28,7 @@ func TestSet(t *testing.T) {
 
 func TestGet(t *testing.T) {
 	storage := NewInMemoryStorage()
+	defer storage.Close()
 
 	key := "foo"
 	value := "bar"
@@ -36,6 +42,7 @@ func TestGet(t *testing.T) {
 
 func TestDelete(t *testing.T) {
 	storage := NewInMemoryStorage()
+	defer storage.Close()
 	key := "foo"
 
 	storage.Set(key, randomString())
@@ -46,8 +53,134 @@ func TestDelete(t *testing.T) {
 	}
 }
 
+func TestStorageStats(t *testing.T) {
+	t.Run("should count the keys, the keys with an expiry and the expired keys", func(tt *testing.T) {
+		clock := clock.NewFake(testEpoch)
+		storage := NewInMemoryStorageWithClock(clock)
+		storage.Close() // sweep by hand
+
+		storage.Set("foo", "bar")
+		storage.Set("baz", "qux")
+		storage.Set("quux", "corge")
+		storage.ExpireAt("foo", testEpoch.Add(time.Second))
+		storage.ExpireAt("baz", testEpoch.Add(time.Minute))
+		storage.Set("baz", "qux") // a SET clears the expiry
+
+		if stats := storage.Stats(); stats.Keys != 3 || stats.Volatile != 1 || stats.Expired != 0 {
+			tt.Fatalf("expected 3 keys and 1 with an expiry but got %+v", stats)
+		}
+
+		clock.Advance(time.Second * 2)
+		storage.deleteExpired(maxSweepBatch)
+
+		if stats := storage.Stats(); stats.Keys != 2 || stats.Volatile != 0 || stats.Expired != 1 {
+			tt.Fatalf("expected 2 keys and 1 expired key but got %+v", stats)
+		}
+	})
+
+	t.Run("should count the keys by whether EXP set their expiry, not by how close it is", func(tt *testing.T) {
+		storage := NewInMemoryStorageWithClock(clock.NewFake(testEpoch))
+		storage.Close() // sweep by hand
+
+		storage.Set("foo", "bar")
+		storage.ExpireAt("foo", testEpoch.Add(defaultExpiry))
+		_, err := storage.Restore(map[string]StorageItem{
+			"baz": {Value: "qux", Expiry: testEpoch.Add(time.Minute)},
+			"ttl": {Value: "qux", Expiry: testEpoch.Add(time.Minute), TTL: true},
+		}, ConflictDumpWins)
+		if err != nil {
+			tt.Fatal(err)
+		}
+
+		if stats := storage.Stats(); stats.Keys != 3 || stats.Volatile != 2 {
+			tt.Fatalf("expected 3 keys and 2 with an expiry but got %+v", stats)
+		}
+	})
+
+	t.Run("should approximate the memory used by the keys and the values", func(tt *testing.T) {
+		storage := NewInMemoryStorage()
+		defer storage.Close()
+
+		storage.Set("foo", "bar")
+		storage.Set("foo", "longer")
+		if memory := storage.Stats().Memory; memory != 9+itemOverhead {
+			tt.Fatalf("expected %d bytes but got %d", 9+itemOverhead, memory)
+		}
+
+		storage.Delete("foo")
+		if memory := storage.Stats().Memory; memory != 0 {
+			tt.Fatalf("expected 0 bytes but got %d", memory)
+		}
+	})
+}
+
+func TestStorageRestore(t *testing.T) {
+	dump := map[string]StorageItem{
+		"foo":     {Value: "from dump", Expiry: testEpoch.Add(time.Hour)},
+		"bar":     {Value: "from dump", Expiry: testEpoch.Add(time.Hour)},
+		"expired": {Value: "from dump", Expiry: testEpoch.Add(-time.Second)},
+	}
+
+	for _, tc := range []struct {
+		policy      ConflictPolicy
+		expected    RestoreStats
+		expectedFoo string
+	}{
+		{ConflictDumpWins, RestoreStats{Loaded: 2, Skipped: 1, Conflicting: 1}, "from dump"},
+		{ConflictMemoryWins, RestoreStats{Loaded: 1, Skipped: 1, Conflicting: 1}, "from memory"},
+	} {
+		t.Run("should restore the data when the policy is "+string(tc.policy), func(tt *testing.T) {
+			storage := NewInMemoryStorageWithClock(clock.NewFake(testEpoch))
+			defer storage.Close()
+			storage.Set("foo", "from memory")
+
+			stats, err := storage.Restore(dump, tc.policy)
+			if err != nil {
+				tt.Fatal(err)
+			}
+
+			if stats != tc.expected {
+				tt.Errorf("expected %+v but got %+v", tc.expected, stats)
+			}
+			if value, _ := storage.Get("foo"); value != tc.expectedFoo {
+				tt.Errorf("expected '%s' but got '%s'", tc.expectedFoo, value)
+			}
+			if _, ok := storage.Get("expired"); ok {
+				tt.Error("an expired item has been restored")
+			}
+		})
+	}
+
+	t.Run("should not restore anything if a key conflicts and the policy is fail", func(tt *testing.T) {
+		storage := NewInMemoryStorageWithClock(clock.NewFake(testEpoch))
+		defer storage.Close()
+		storage.Set("foo", "from memory")
+
+		stats, err := storage.Restore(dump, ConflictFail)
+		if !errors.Is(err, ErrRestoreConflict) {
+			tt.Fatalf("expected ErrRestoreConflict but received '%s'", err)
+		}
+		if stats.Conflicting != 1 {
+			tt.Errorf("expected 1 conflicting key but got %d", stats.Conflicting)
+		}
+		if _, ok := storage.Get("bar"); ok {
+			tt.Error("a key has been restored despite the conflict")
+		}
+	})
+
+	t.Run("should return an error if the policy is invalid", func(tt *testing.T) {
+		storage := NewInMemoryStorage()
+		defer storage.Close()
+
+		if _, err := storage.Restore(dump, "newest"); !errors.Is(err, ErrInvalidConflictPolicy) {
+			tt.Fatalf("expected ErrInvalidConflictPolicy but received '%s'", err)
+		}
+	})
+}
+
 func BenchmarkSet(b *testing.B) {
 	storage := NewInMemoryStorage()
+	defer storage.Close()
 	for b.Loop() {
 		storage.Set(randomString(), randomString())
 	}
@@ -55,6 +188,7 @@ func BenchmarkSet(b *testing.B) {
 
 func BenchmarkGet(b *testing.B) {
 	storage := NewInMemoryStorage()
+	defer storage.Close()
 
 	key := randomString()
 	storage.Set(key, randomString())
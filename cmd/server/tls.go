@@ -0,0 +1,114 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"sync/atomic"
+
+	"github.com/JorgeLNJunior/cacher/pkg/client"
+)
+
+var ErrIncompleteTLS = errors.New("both -tls-cert and -tls-key are required to use TLS")
+
+// TLSCertificates holds the certificate of the server and the CA of its clients. They are
+// read again from disk by Reload, so they can be rotated without restarting the server.
+type TLSCertificates struct {
+	certFile     string
+	keyFile      string
+	clientCAFile string
+	loaded       atomic.Pointer[loadedCertificates]
+}
+
+type loadedCertificates struct {
+	certificate tls.Certificate
+	clientCAs   *x509.CertPool // nil if the clients are not verified
+}
+
+// LoadTLSCertificates loads the certificate of the server and, if clientCAFile is not
+// empty, the CA that must have signed the certificates of the clients.
+func LoadTLSCertificates(certFile string, keyFile string, clientCAFile string) (*TLSCertificates, error) {
+	if certFile == "" || keyFile == "" {
+		return nil, ErrIncompleteTLS
+	}
+
+	c := &TLSCertificates{certFile: certFile, keyFile: keyFile, clientCAFile: clientCAFile}
+	if err := c.Reload(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Reload reads the files again. The certificates loaded before are kept if any of them
+// is invalid. Open connections are not affected.
+func (c *TLSCertificates) Reload() error {
+	certificate, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+	if err != nil {
+		return err
+	}
+
+	loaded := &loadedCertificates{certificate: certificate}
+	if c.clientCAFile != "" {
+		if loaded.clientCAs, err = client.LoadCertPool(c.clientCAFile); err != nil {
+			return err
+		}
+	}
+
+	c.loaded.Store(loaded)
+	return nil
+}
+
+// ServerConfig returns the configuration of the listener, which uses the certificates
+// loaded last on every handshake.
+func (c *TLSCertificates) ServerConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			loaded := c.loaded.Load()
+			config := &tls.Config{
+				MinVersion:   tls.VersionTLS12,
+				Certificates: []tls.Certificate{loaded.certificate},
+			}
+			if loaded.clientCAs != nil {
+				config.ClientCAs = loaded.clientCAs
+				config.ClientAuth = tls.RequireAndVerifyClientCert
+			}
+			return config, nil
+		},
+	}
+}
+
+// PeerConfig returns the configuration of the connections to other servers, made by
+// replicas and nodes of a cluster. The server sends its own certificate and verifies
+// the others with the client CA, or with the CAs of the system if there's none. It
+// returns nil if c is nil, so the connections use plain tcp.
+func (c *TLSCertificates) PeerConfig() *tls.Config {
+	if c == nil {
+		return nil
+	}
+
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetClientCertificate: func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			return &c.loaded.Load().certificate, nil
+		},
+		// the default verification can't use a reloaded CA, VerifyConnection does it instead
+		InsecureSkipVerify: true,
+		VerifyConnection: func(state tls.ConnectionState) error {
+			if len(state.PeerCertificates) == 0 {
+				return errors.New("the server has not sent a certificate")
+			}
+
+			intermediates := x509.NewCertPool()
+			for _, certificate := range state.PeerCertificates[1:] {
+				intermediates.AddCert(certificate)
+			}
+			_, err := state.PeerCertificates[0].Verify(x509.VerifyOptions{
+				DNSName:       state.ServerName,
+				Roots:         c.loaded.Load().clientCAs,
+				Intermediates: intermediates,
+			})
+			return err
+		},
+	}
+}
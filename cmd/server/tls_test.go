@@ -0,0 +1,251 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/JorgeLNJunior/cacher/pkg/client"
+	"github.com/JorgeLNJunior/cacher/pkg/data"
+)
+
+// testCA signs throwaway certificates valid for 127.0.0.1.
+type testCA struct {
+	certificate *x509.Certificate
+	key         *ecdsa.PrivateKey
+	file        string // PEM encoded certificate of the CA
+}
+
+func newTestCA(t *testing.T) *testCA {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "cacher test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certificate, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	file := filepath.Join(t.TempDir(), "ca.pem")
+	writePEM(t, file, "CERTIFICATE", der)
+	return &testCA{certificate: certificate, key: key, file: file}
+}
+
+// issue writes a certificate signed by the CA and its key, and returns their paths.
+// The certificate authenticates both servers and clients.
+func (ca *testCA) issue(t *testing.T, name string, serial int64) (string, string) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.certificate, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, name+".pem"), filepath.Join(dir, name+"-key.pem")
+	writePEM(t, certFile, "CERTIFICATE", der)
+	writePEM(t, keyFile, "EC PRIVATE KEY", keyDer)
+	return certFile, keyFile
+}
+
+func writePEM(t *testing.T, file string, kind string, der []byte) {
+	t.Helper()
+
+	if err := os.WriteFile(file, pem.EncodeToMemory(&pem.Block{Type: kind, Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// serverSerial returns the serial number of the certificate presented by a server.
+func serverSerial(t *testing.T, addr string, config *tls.Config) int64 {
+	t.Helper()
+
+	conn, err := client.Dial(context.Background(), addr, time.Second, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	return conn.(*tls.Conn).ConnectionState().PeerCertificates[0].SerialNumber.Int64()
+}
+
+func TestTLS(t *testing.T) {
+	t.Run("should answer the requests over TLS", func(tt *testing.T) {
+		ca := newTestCA(tt)
+		certFile, keyFile := ca.issue(tt, "server", 2)
+		_, addr := newTestApplication(tt, config{tlsCert: certFile, tlsKey: keyFile})
+
+		tlsConfig, err := client.LoadTLSConfig(ca.file, "", "")
+		if err != nil {
+			tt.Fatal(err)
+		}
+		c := &client.Client{Addr: addr, Timeout: time.Second, TLSConfig: tlsConfig}
+		if err := c.Set("foo", "bar"); err != nil {
+			tt.Fatal(err)
+		}
+		if value, err := c.Get("foo"); err != nil || value != "bar" {
+			tt.Errorf("expected 'bar' but got '%s' and %v", value, err)
+		}
+
+		if _, err := client.New(addr).Get("foo"); err == nil {
+			tt.Error("expected a plain tcp request to fail")
+		}
+	})
+
+	t.Run("should reject the clients without a certificate signed by the client CA", func(tt *testing.T) {
+		ca := newTestCA(tt)
+		certFile, keyFile := ca.issue(tt, "server", 2)
+		_, addr := newTestApplication(tt, config{tlsCert: certFile, tlsKey: keyFile, tlsClientCA: ca.file})
+
+		anonymous, err := client.LoadTLSConfig(ca.file, "", "")
+		if err != nil {
+			tt.Fatal(err)
+		}
+		if _, err := (&client.Client{Addr: addr, Timeout: time.Second, TLSConfig: anonymous}).Role(); err == nil {
+			tt.Error("expected a client without a certificate to be rejected")
+		}
+
+		otherCertFile, otherKeyFile := newTestCA(tt).issue(tt, "client", 3)
+		other, err := client.LoadTLSConfig(ca.file, otherCertFile, otherKeyFile)
+		if err != nil {
+			tt.Fatal(err)
+		}
+		if _, err := (&client.Client{Addr: addr, Timeout: time.Second, TLSConfig: other}).Role(); err == nil {
+			tt.Error("expected a client signed by another CA to be rejected")
+		}
+
+		clientCertFile, clientKeyFile := ca.issue(tt, "client", 4)
+		authenticated, err := client.LoadTLSConfig(ca.file, clientCertFile, clientKeyFile)
+		if err != nil {
+			tt.Fatal(err)
+		}
+		role, err := (&client.Client{Addr: addr, Timeout: time.Second, TLSConfig: authenticated}).Role()
+		if err != nil || role.Name != data.RolePrimary {
+			tt.Errorf("expected the primary role but got %+v and %v", role, err)
+		}
+	})
+
+	t.Run("should present the reloaded certificate", func(tt *testing.T) {
+		ca := newTestCA(tt)
+		certFile, keyFile := ca.issue(tt, "server", 2)
+		app, addr := newTestApplication(tt, config{tlsCert: certFile, tlsKey: keyFile})
+
+		tlsConfig, err := client.LoadTLSConfig(ca.file, "", "")
+		if err != nil {
+			tt.Fatal(err)
+		}
+		if serial := serverSerial(tt, addr, tlsConfig); serial != 2 {
+			tt.Fatalf("expected the serial 2 but got %d", serial)
+		}
+
+		renewedCertFile, renewedKeyFile := ca.issue(tt, "server", 3)
+		for _, files := range [][2]string{{renewedCertFile, certFile}, {renewedKeyFile, keyFile}} {
+			payload, err := os.ReadFile(files[0])
+			if err != nil {
+				tt.Fatal(err)
+			}
+			if err := os.WriteFile(files[1], payload, 0o600); err != nil {
+				tt.Fatal(err)
+			}
+		}
+		if err := app.tls.Reload(); err != nil {
+			tt.Fatal(err)
+		}
+		if serial := serverSerial(tt, addr, tlsConfig); serial != 3 {
+			tt.Errorf("expected the serial 3 but got %d", serial)
+		}
+	})
+
+	t.Run("should keep the previous certificate if the reload fails", func(tt *testing.T) {
+		ca := newTestCA(tt)
+		certFile, keyFile := ca.issue(tt, "server", 2)
+		app, addr := newTestApplication(tt, config{tlsCert: certFile, tlsKey: keyFile})
+
+		if err := os.WriteFile(keyFile, []byte("invalid"), 0o600); err != nil {
+			tt.Fatal(err)
+		}
+		if err := app.tls.Reload(); err == nil {
+			tt.Fatal("expected the reload to fail")
+		}
+
+		tlsConfig, err := client.LoadTLSConfig(ca.file, "", "")
+		if err != nil {
+			tt.Fatal(err)
+		}
+		if serial := serverSerial(tt, addr, tlsConfig); serial != 2 {
+			tt.Errorf("expected the serial 2 but got %d", serial)
+		}
+	})
+
+	t.Run("should replicate over mutual TLS", func(tt *testing.T) {
+		ca := newTestCA(tt)
+		primaryCertFile, primaryKeyFile := ca.issue(tt, "primary", 2)
+		primary, primaryAddr := newTestApplication(tt, config{tlsCert: primaryCertFile, tlsKey: primaryKeyFile, tlsClientCA: ca.file})
+		primary.storage.Set("foo", "bar")
+
+		replicaCertFile, replicaKeyFile := ca.issue(tt, "replica", 3)
+		replica, _ := newTestApplication(tt, config{
+			replicaOf:   primaryAddr,
+			tlsCert:     replicaCertFile,
+			tlsKey:      replicaKeyFile,
+			tlsClientCA: ca.file,
+		})
+		eventually(tt, "the replica has not synced", func() bool {
+			value, _ := replica.storage.Get("foo")
+			return value == "bar"
+		})
+	})
+
+	t.Run("should require both the certificate and the key", func(tt *testing.T) {
+		ca := newTestCA(tt)
+		certFile, _ := ca.issue(tt, "server", 2)
+
+		if _, err := LoadTLSCertificates(certFile, "", ca.file); !errors.Is(err, ErrIncompleteTLS) {
+			tt.Errorf("expected ErrIncompleteTLS but got %v", err)
+		}
+	})
+}
@@ -0,0 +1,236 @@
+// Package audit writes and verifies a tamper-evident audit log. Every entry is a JSON
+// line holding the hash of the previous entry chained with its own fields, so changing,
+// removing or reordering entries breaks the chain from that entry on.
+package audit
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+var (
+	ErrBrokenChain     = errors.New("the hash of the entry doesn't match, the log has been changed")
+	ErrInvalidEntry    = errors.New("the entry is not valid JSON")
+	ErrIncompleteEntry = errors.New("the last entry has no line break, it has been partially written")
+
+	// errTornEntry and errMissingLineBreak are the incomplete last entries left by a
+	// write interrupted by a crash: an invalid entry, or a valid one without its line break.
+	errTornEntry        = fmt.Errorf("%w", ErrIncompleteEntry)
+	errMissingLineBreak = fmt.Errorf("%w", ErrIncompleteEntry)
+)
+
+// Entry records a request. It never has the value of a key.
+type Entry struct {
+	Time      time.Time `json:"time"`
+	Addr      string    `json:"addr"`           // address of the client
+	User      string    `json:"user,omitempty"` // empty if the server doesn't authenticate the requests
+	Operation string    `json:"operation"`
+	Key       string    `json:"key,omitempty"`
+	Hash      string    `json:"hash"` // hash of the previous entry and this one
+}
+
+// chain returns the hash of the entry following the entry with the previous hash.
+func (e Entry) chain(previous string) (string, error) {
+	e.Hash = ""
+	payload, err := json.Marshal(e)
+	if err != nil {
+		return "", err
+	}
+
+	hash := sha256.New()
+	hash.Write([]byte(previous))
+	hash.Write([]byte{'\n'})
+	hash.Write(payload)
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// Log appends entries to an audit log file.
+type Log struct {
+	file      *os.File
+	head      string     // hash of the last entry
+	truncated int64      // bytes of a torn last entry removed by Open
+	mu        sync.Mutex // guards file and head
+}
+
+// Open verifies the audit log at path and opens it to append entries, creating it if
+// it doesn't exist. It fails if the log has been changed. A last entry partially
+// written by a crash is truncated, see Truncated, or completed if only its line break
+// is missing, so the server can restart without changing the log by hand.
+func Open(path string) (*Log, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return nil, err
+	}
+
+	_, head, size, err := verify(file, nil)
+	var truncated int64
+	switch {
+	case errors.Is(err, errTornEntry):
+		truncated, err = truncate(file, size)
+	case errors.Is(err, errMissingLineBreak):
+		err = appendLineBreak(file)
+	}
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	return &Log{file: file, head: head, truncated: truncated}, nil
+}
+
+// truncate removes what follows the first size bytes of a log and returns how many
+// bytes have been removed.
+func truncate(file *os.File, size int64) (int64, error) {
+	info, err := file.Stat()
+	if err != nil {
+		return 0, err
+	}
+	if err := file.Truncate(size); err != nil {
+		return 0, err
+	}
+	if err := file.Sync(); err != nil {
+		return 0, err
+	}
+	return info.Size() - size, nil
+}
+
+// appendLineBreak completes a last entry written without its line break.
+func appendLineBreak(file *os.File) error {
+	if _, err := file.Write([]byte{'\n'}); err != nil {
+		return err
+	}
+	return file.Sync()
+}
+
+// Truncated returns how many bytes of a torn last entry Open removed, 0 if the log
+// was complete.
+func (l *Log) Truncated() int64 {
+	return l.truncated
+}
+
+// Record chains an entry to the log and writes it. The entry is synced to disk before
+// Record returns, since it's recorded before the request is made.
+func (l *Log) Record(e Entry) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	e.Time = e.Time.UTC()
+	hash, err := e.chain(l.head)
+	if err != nil {
+		return err
+	}
+	e.Hash = hash
+
+	line, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	if _, err := l.file.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	if err := l.file.Sync(); err != nil {
+		return err
+	}
+
+	l.head = hash
+	return nil
+}
+
+// Head returns the hash of the last entry, empty if the log has no entry. A log
+// verified later must still have it, so it can be kept elsewhere to detect the
+// removal of the last entries.
+func (l *Log) Head() string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.head
+}
+
+// Close syncs the log to disk and closes it.
+func (l *Log) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if err := l.file.Sync(); err != nil {
+		l.file.Close()
+		return err
+	}
+	return l.file.Close()
+}
+
+// Verify reads an audit log checking the chain of hashes, and calls visit with every
+// verified entry if it's not nil. It returns how many entries the log has and the hash
+// of the last one.
+func Verify(r io.Reader, visit func(Entry)) (int, string, error) {
+	count, head, _, err := verify(r, visit)
+	if err != nil {
+		return 0, "", err
+	}
+	return count, head, nil
+}
+
+// verify works like Verify and also returns the size in bytes of the complete entries.
+// An incomplete last entry is reported with errTornEntry along with the count and the
+// hash of the complete entries, or with errMissingLineBreak and the ones including it
+// if it's a valid entry.
+func verify(r io.Reader, visit func(Entry)) (int, string, int64, error) {
+	in := bufio.NewReader(r)
+	head := ""
+	var size int64
+
+	for line := 1; ; line++ {
+		payload, err := in.ReadBytes('\n')
+		if errors.Is(err, io.EOF) && len(payload) == 0 {
+			return line - 1, head, size, nil
+		}
+		if errors.Is(err, io.EOF) {
+			e, err := verifyEntry(payload, head)
+			if err != nil {
+				return line - 1, head, size, fmt.Errorf("line %d: %w", line, errTornEntry)
+			}
+			return line, e.Hash, size, fmt.Errorf("line %d: %w", line, errMissingLineBreak)
+		}
+		if err != nil {
+			return 0, "", size, err
+		}
+
+		e, err := verifyEntry(payload, head)
+		if err != nil {
+			return 0, "", size, fmt.Errorf("line %d: %w", line, err)
+		}
+		head = e.Hash
+		size += int64(len(payload))
+
+		if visit != nil {
+			visit(e)
+		}
+	}
+}
+
+// verifyEntry decodes an entry and checks that it follows the entry with the previous hash.
+func verifyEntry(payload []byte, previous string) (Entry, error) {
+	e := Entry{}
+	decoder := json.NewDecoder(bytes.NewReader(payload))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&e); err != nil {
+		return Entry{}, ErrInvalidEntry
+	}
+
+	hash, err := e.chain(previous)
+	if err != nil {
+		return Entry{}, err
+	}
+	if hash != e.Hash {
+		return Entry{}, ErrBrokenChain
+	}
+	return e, nil
+}
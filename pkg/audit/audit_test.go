@@ -0,0 +1,196 @@
+package audit
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+var testEpoch = time.Unix(1_700_000_000, 0)
+
+// writeTestLog writes a log with the entries and returns its path.
+func writeTestLog(t *testing.T, entries ...Entry) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "audit.log")
+	log, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range entries {
+		if err := log.Record(e); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := log.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func testEntries() []Entry {
+	return []Entry{
+		{Time: testEpoch, Addr: "127.0.0.1:50000", User: "alice", Operation: "SET", Key: "foo"},
+		{Time: testEpoch.Add(time.Second), Addr: "127.0.0.1:50001", Operation: "DEL", Key: "bar"},
+		{Time: testEpoch.Add(time.Minute), Addr: "127.0.0.1:50002", User: "admin", Operation: "BACKUP", Key: "nightly"},
+	}
+}
+
+func TestLog(t *testing.T) {
+	t.Run("should verify the recorded entries", func(tt *testing.T) {
+		path := writeTestLog(tt, testEntries()...)
+
+		file, err := os.Open(path)
+		if err != nil {
+			tt.Fatal(err)
+		}
+		defer file.Close()
+
+		visited := make([]Entry, 0)
+		entries, head, err := Verify(file, func(e Entry) { visited = append(visited, e) })
+		if err != nil {
+			tt.Fatal(err)
+		}
+		if entries != 3 || head != visited[2].Hash {
+			tt.Errorf("expected 3 entries and the hash of the last one but got %d and '%s'", entries, head)
+		}
+		if visited[1].Key != "bar" || !visited[2].Time.Equal(testEpoch.Add(time.Minute)) {
+			tt.Errorf("expected the entries to be read back but got %+v", visited)
+		}
+	})
+
+	t.Run("should chain the entries recorded after reopening the log", func(tt *testing.T) {
+		path := writeTestLog(tt, testEntries()[:2]...)
+
+		log, err := Open(path)
+		if err != nil {
+			tt.Fatal(err)
+		}
+		if err := log.Record(testEntries()[2]); err != nil {
+			tt.Fatal(err)
+		}
+		head := log.Head()
+		log.Close()
+
+		file, err := os.Open(path)
+		if err != nil {
+			tt.Fatal(err)
+		}
+		defer file.Close()
+		if entries, last, err := Verify(file, nil); err != nil || entries != 3 || last != head {
+			tt.Errorf("expected 3 entries ending with '%s' but got %d, '%s' and %v", head, entries, last, err)
+		}
+	})
+
+	t.Run("should detect a changed entry", func(tt *testing.T) {
+		payload, err := os.ReadFile(writeTestLog(tt, testEntries()...))
+		if err != nil {
+			tt.Fatal(err)
+		}
+		payload = bytes.Replace(payload, []byte(`"key":"bar"`), []byte(`"key":"baz"`), 1)
+
+		if _, _, err := Verify(bytes.NewReader(payload), nil); !errors.Is(err, ErrBrokenChain) || !strings.HasPrefix(err.Error(), "line 2") {
+			tt.Errorf("expected ErrBrokenChain at line 2 but got %v", err)
+		}
+	})
+
+	t.Run("should detect a removed entry", func(tt *testing.T) {
+		payload, err := os.ReadFile(writeTestLog(tt, testEntries()...))
+		if err != nil {
+			tt.Fatal(err)
+		}
+		lines := strings.SplitAfter(string(payload), "\n")
+		payload = []byte(lines[0] + lines[2])
+
+		if _, _, err := Verify(bytes.NewReader(payload), nil); !errors.Is(err, ErrBrokenChain) {
+			tt.Errorf("expected ErrBrokenChain but got %v", err)
+		}
+	})
+
+	t.Run("should refuse to open a changed log", func(tt *testing.T) {
+		path := writeTestLog(tt, testEntries()...)
+		payload, err := os.ReadFile(path)
+		if err != nil {
+			tt.Fatal(err)
+		}
+		if err := os.WriteFile(path, bytes.Replace(payload, []byte("alice"), []byte("mallory"), 1), 0o600); err != nil {
+			tt.Fatal(err)
+		}
+
+		if _, err := Open(path); !errors.Is(err, ErrBrokenChain) {
+			tt.Errorf("expected ErrBrokenChain but got %v", err)
+		}
+	})
+
+	t.Run("should detect a partially written entry", func(tt *testing.T) {
+		payload, err := os.ReadFile(writeTestLog(tt, testEntries()...))
+		if err != nil {
+			tt.Fatal(err)
+		}
+
+		if _, _, err := Verify(bytes.NewReader(payload[:len(payload)-1]), nil); !errors.Is(err, ErrIncompleteEntry) {
+			tt.Errorf("expected ErrIncompleteEntry but got %v", err)
+		}
+	})
+
+	t.Run("should truncate a torn last entry when opening the log", func(tt *testing.T) {
+		entries := testEntries()
+		path := writeTestLog(tt, entries[:2]...)
+		complete, err := os.ReadFile(path)
+		if err != nil {
+			tt.Fatal(err)
+		}
+		if err := os.WriteFile(path, append(complete, `{"time":"2023-11-14T22:`...), 0o600); err != nil {
+			tt.Fatal(err)
+		}
+
+		log, err := Open(path)
+		if err != nil {
+			tt.Fatal(err)
+		}
+		if log.Truncated() != int64(len(`{"time":"2023-11-14T22:`)) {
+			tt.Errorf("expected the torn entry to be truncated but got %d bytes", log.Truncated())
+		}
+		if err := log.Record(entries[2]); err != nil {
+			tt.Fatal(err)
+		}
+		if err := log.Close(); err != nil {
+			tt.Fatal(err)
+		}
+
+		payload, err := os.ReadFile(path)
+		if err != nil {
+			tt.Fatal(err)
+		}
+		if count, _, err := Verify(bytes.NewReader(payload), nil); err != nil || count != 3 {
+			tt.Errorf("expected 3 entries but got %d and %v", count, err)
+		}
+	})
+
+	t.Run("should complete a last entry written without its line break", func(tt *testing.T) {
+		path := writeTestLog(tt, testEntries()...)
+		payload, err := os.ReadFile(path)
+		if err != nil {
+			tt.Fatal(err)
+		}
+		if err := os.WriteFile(path, payload[:len(payload)-1], 0o600); err != nil {
+			tt.Fatal(err)
+		}
+
+		log, err := Open(path)
+		if err != nil {
+			tt.Fatal(err)
+		}
+		defer log.Close()
+		if log.Truncated() != 0 {
+			tt.Errorf("expected nothing to be truncated but got %d bytes", log.Truncated())
+		}
+		if completed, err := os.ReadFile(path); err != nil || !bytes.Equal(completed, payload) {
+			tt.Errorf("expected the line break to be appended but got %q", completed)
+		}
+	})
+}
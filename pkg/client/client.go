@@ -0,0 +1,243 @@
+// Package client sends requests to cacher servers and sentinels, and spreads keys
+// across several servers.
+package client
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/JorgeLNJunior/cacher/pkg/data"
+)
+
+const (
+	DefaultTimeout = time.Second * 5
+	maxRedirects   = 3 // writes redirected from a node of a cluster to its leader
+)
+
+var ErrNoPrimary = errors.New("no sentinel knows the primary")
+
+// Client sends requests to a server. The server closes the connection after every
+// response, so each request uses a new connection. Writes sent to a node of a cluster
+// that is not the leader are sent again to the leader.
+type Client struct {
+	Addr      string
+	Timeout   time.Duration // max duration of a request, including the connection
+	TLSConfig *tls.Config   // nil for plain tcp
+
+	// Credentials authenticate the requests that have none if not nil.
+	Credentials *data.Credentials
+	// Name names the connections of the requests that have none in CLIENT LIST if not empty.
+	Name string
+}
+
+// New returns a Client of the server at addr using DefaultTimeout.
+func New(addr string) *Client {
+	return &Client{Addr: addr, Timeout: DefaultTimeout}
+}
+
+// Do sends a request and returns the response.
+func (c *Client) Do(req data.Request) (data.Response, error) {
+	res := data.Response{}
+
+	if req.Credentials == nil && req.Operation != data.OperationAuth {
+		req.Credentials = c.Credentials
+	}
+	if req.ClientName == "" {
+		req.ClientName = c.Name
+	}
+
+	payload, err := req.Marshal()
+	if err != nil {
+		return res, err
+	}
+
+	conn, err := Dial(context.Background(), c.Addr, c.Timeout, c.TLSConfig)
+	if err != nil {
+		return res, err
+	}
+	defer conn.Close()
+
+	if err := conn.SetDeadline(time.Now().Add(c.Timeout)); err != nil {
+		return res, err
+	}
+	if _, err := conn.Write(payload); err != nil {
+		return res, err
+	}
+	// the server stops reading a request once the connection is closed for writing
+	if err := CloseWrite(conn); err != nil {
+		return res, err
+	}
+
+	response, err := io.ReadAll(conn)
+	if err != nil {
+		return res, err
+	}
+	if err := res.Unmarshal(response); err != nil {
+		return res, err
+	}
+
+	return res, nil
+}
+
+// send sends a request and returns the message of an OK response. An ERROR response
+// is returned as an error, data.ErrKeyNotFound if the key is not stored, a
+// data.ThrottledError if the request hit a limit, a data.TooLargeError if it's too
+// large and a data.NotLeaderError if no leader could be reached.
+func (c *Client) send(req data.Request) (string, error) {
+	for redirects := 0; ; redirects++ {
+		res, err := c.Do(req)
+		if err != nil {
+			return "", err
+		}
+		if res.Status == data.ResponseStatusOK {
+			return res.Message, nil
+		}
+
+		if res.Message == data.ErrKeyNotFound.Error() {
+			return "", data.ErrKeyNotFound
+		}
+		if tooLarge, ok := data.ParseTooLarge(res.Message); ok {
+			return "", tooLarge
+		}
+		if throttled, ok := data.ParseThrottled(res.Message); ok {
+			return "", throttled
+		}
+		if notLeader, ok := data.ParseNotLeader(res.Message); ok {
+			if notLeader.Leader == "" || redirects == maxRedirects {
+				return "", notLeader
+			}
+			c = &Client{Addr: notLeader.Leader, Timeout: c.Timeout, TLSConfig: c.TLSConfig, Credentials: c.Credentials, Name: c.Name}
+			continue
+		}
+		return "", errors.New(res.Message)
+	}
+}
+
+// Get returns the value of a key or data.ErrKeyNotFound.
+func (c *Client) Get(key string) (string, error) {
+	return c.send(data.Request{Operation: data.OperationGet, Key: key})
+}
+
+// Set stores a key-value pair.
+func (c *Client) Set(key string, value string) error {
+	_, err := c.send(data.Request{Operation: data.OperationSet, Key: key, Value: value})
+	return err
+}
+
+// Del deletes a key.
+func (c *Client) Del(key string) error {
+	_, err := c.send(data.Request{Operation: data.OperationDel, Key: key})
+	return err
+}
+
+// ExpireAt sets when a key expires.
+func (c *Client) ExpireAt(key string, expiry time.Time) error {
+	_, err := c.send(data.Request{Operation: data.OperationExp, Key: key, Expiry: expiry})
+	return err
+}
+
+// Auth checks the password of a user.
+func (c *Client) Auth(user string, password string) error {
+	_, err := c.send(data.Request{Operation: data.OperationAuth, Key: user, Value: password})
+	return err
+}
+
+// Role returns the role of the server.
+func (c *Client) Role() (data.Role, error) {
+	message, err := c.send(data.Request{Operation: data.OperationRole})
+	if err != nil {
+		return data.Role{}, err
+	}
+
+	return data.ParseRole(message)
+}
+
+// Info returns the KEY=VALUE fields describing the state of the server.
+func (c *Client) Info() (map[string]string, error) {
+	message, err := c.send(data.Request{Operation: data.OperationInfo})
+	if err != nil {
+		return nil, err
+	}
+
+	return data.ParseInfo(message)
+}
+
+// SlowLog returns up to n of the newest requests in the slow log of the server.
+func (c *Client) SlowLog(n int) ([]data.SlowLogEntry, error) {
+	message, err := c.send(data.Request{Operation: data.OperationSlowLog, Key: data.SlowLogGet, Value: strconv.Itoa(n)})
+	if err != nil {
+		return nil, err
+	}
+
+	return data.ParseSlowLog(message)
+}
+
+// SlowLogLen returns the number of requests in the slow log of the server.
+func (c *Client) SlowLogLen() (int, error) {
+	message, err := c.send(data.Request{Operation: data.OperationSlowLog, Key: data.SlowLogLen})
+	if err != nil {
+		return 0, err
+	}
+
+	return strconv.Atoi(message)
+}
+
+// SlowLogReset clears the slow log of the server.
+func (c *Client) SlowLogReset() error {
+	_, err := c.send(data.Request{Operation: data.OperationSlowLog, Key: data.SlowLogReset})
+	return err
+}
+
+// ClientList returns the connections open on the server.
+func (c *Client) ClientList() ([]data.ClientInfo, error) {
+	message, err := c.send(data.Request{Operation: data.OperationClient, Key: data.ClientList})
+	if err != nil {
+		return nil, err
+	}
+
+	return data.ParseClientList(message)
+}
+
+// ClientKill closes the connection of the server whose id or address is target.
+func (c *Client) ClientKill(target string) error {
+	_, err := c.send(data.Request{Operation: data.OperationClient, Key: data.ClientKill, Value: target})
+	return err
+}
+
+// DiscoverPrimary asks sentinels which server is the primary. Sentinels that can't be
+// reached are skipped and the answer of the sentinel with the highest epoch wins.
+func DiscoverPrimary(sentinels []string, timeout time.Duration) (string, error) {
+	primary := ""
+	epoch := int64(-1)
+	errs := make([]error, 0)
+
+	for _, addr := range sentinels {
+		role, err := (&Client{Addr: addr, Timeout: timeout}).Role()
+		if err == nil && role.Name != data.RoleSentinel {
+			err = fmt.Errorf("the server is a %s", role.Name)
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", addr, err))
+			continue
+		}
+
+		sentinelEpoch, err := strconv.ParseInt(role.Fields["epoch"], 10, 64)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", addr, data.ErrInvalidRole))
+			continue
+		}
+		if sentinelEpoch > epoch && role.Fields["primary"] != "" {
+			primary, epoch = role.Fields["primary"], sentinelEpoch
+		}
+	}
+
+	if primary == "" {
+		return "", errors.Join(append([]error{ErrNoPrimary}, errs...)...)
+	}
+	return primary, nil
+}
@@ -0,0 +1,82 @@
+package client
+
+import (
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/JorgeLNJunior/cacher/pkg/data"
+)
+
+// newTestServer returns the address of a server answering every request with message.
+func newTestServer(t *testing.T, message string) string {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				if _, err := io.ReadAll(conn); err != nil {
+					return
+				}
+				res, _ := data.NewResponse(data.ResponseStatusOK, message).Marshal()
+				conn.Write(res)
+			}()
+		}
+	}()
+
+	return listener.Addr().String()
+}
+
+// closedAddress returns an address nothing listens.
+func closedAddress(t *testing.T) string {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	listener.Close()
+	return listener.Addr().String()
+}
+
+func TestDiscoverPrimary(t *testing.T) {
+	t.Run("should return the primary of the sentinel with the highest epoch", func(tt *testing.T) {
+		sentinels := []string{
+			newTestServer(tt, "sentinel primary=127.0.0.1:1 standby=127.0.0.1:2 epoch=1 down=false"),
+			newTestServer(tt, "sentinel primary=127.0.0.1:2 standby=127.0.0.1:1 epoch=2 down=false"),
+			closedAddress(tt),
+		}
+
+		primary, err := DiscoverPrimary(sentinels, time.Second)
+		if err != nil {
+			tt.Fatal(err)
+		}
+		if primary != "127.0.0.1:2" {
+			tt.Errorf("expected the primary to be 127.0.0.1:2 but got %s", primary)
+		}
+	})
+
+	t.Run("should return ErrNoPrimary if no sentinel answers", func(tt *testing.T) {
+		sentinels := []string{
+			closedAddress(tt),
+			newTestServer(tt, "primary offset=0 replicas=0"),
+		}
+
+		if _, err := DiscoverPrimary(sentinels, time.Second); !errors.Is(err, ErrNoPrimary) {
+			tt.Errorf("expected ErrNoPrimary but got %v", err)
+		}
+	})
+}
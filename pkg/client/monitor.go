@@ -0,0 +1,82 @@
+package client
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"time"
+
+	"github.com/JorgeLNJunior/cacher/pkg/data"
+)
+
+var ErrMonitorClosed = errors.New("the server has closed the monitor")
+
+// Monitor streams the requests made to the server whose key matches pattern, a glob
+// pattern such as user:*, calling fn with every one of them until ctx is done or the
+// server closes the connection. The error is ctx.Err() if ctx is done.
+func (c *Client) Monitor(ctx context.Context, pattern string, fn func(data.MonitorEntry)) error {
+	req := data.Request{Operation: data.OperationMonitor, Key: pattern, Credentials: c.Credentials, ClientName: c.Name}
+	payload, err := req.Marshal()
+	if err != nil {
+		return err
+	}
+
+	conn, err := Dial(ctx, c.Addr, c.Timeout, c.TLSConfig)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+
+	if err := conn.SetDeadline(time.Now().Add(c.Timeout)); err != nil {
+		return err
+	}
+	if _, err := conn.Write(payload); err != nil {
+		return err
+	}
+	if err := CloseWrite(conn); err != nil {
+		return err
+	}
+
+	in := bufio.NewReader(conn)
+	status, err := in.ReadString(' ')
+	if err != nil {
+		return err
+	}
+	if status != data.ResponseStatusOK+" " {
+		message, err := io.ReadAll(in)
+		if err != nil {
+			return err
+		}
+		return errors.New(string(message))
+	}
+
+	// the stream has no deadline, the server may have nothing to send for a long time
+	if err := conn.SetDeadline(time.Time{}); err != nil {
+		return err
+	}
+	for {
+		line, err := in.ReadString('\n')
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if errors.Is(err, io.EOF) {
+			return ErrMonitorClosed
+		}
+		if err != nil {
+			return err
+		}
+
+		if line == "\n" {
+			continue // a heartbeat
+		}
+
+		entry, err := data.ParseMonitorEntry(line)
+		if err != nil {
+			return err
+		}
+		fn(entry)
+	}
+}
@@ -0,0 +1,125 @@
+package client
+
+import (
+	"hash/fnv"
+	"slices"
+	"strconv"
+	"sync"
+)
+
+// DefaultVirtualNodes is how many points a node has on a Ring by default. More points
+// spread the keys more evenly at the cost of memory.
+const DefaultVirtualNodes = 160
+
+// Ring is a consistent hash ring. Every node owns many points of the ring, its virtual
+// nodes, and a key belongs to the node of the first point after the hash of the key.
+// Adding or removing a node only moves the keys of the points it owns. The placement
+// only depends on the addresses of the nodes, so every client agrees on it.
+type Ring struct {
+	mu           sync.RWMutex
+	virtualNodes int
+	points       []uint64          // sorted hashes of the virtual nodes
+	owners       map[uint64]string // node of each point
+	nodes        map[string]struct{}
+}
+
+// NewRing returns an empty Ring giving virtualNodes points to every node, or
+// DefaultVirtualNodes if virtualNodes is not positive.
+func NewRing(virtualNodes int) *Ring {
+	if virtualNodes < 1 {
+		virtualNodes = DefaultVirtualNodes
+	}
+
+	return &Ring{
+		virtualNodes: virtualNodes,
+		owners:       make(map[uint64]string),
+		nodes:        make(map[string]struct{}),
+	}
+}
+
+// Add adds nodes to the ring. Nodes already in the ring are ignored.
+func (r *Ring) Add(nodes ...string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, node := range nodes {
+		if _, found := r.nodes[node]; found {
+			continue
+		}
+		r.nodes[node] = struct{}{}
+
+		for i := range r.virtualNodes {
+			point := hashKey(node + "#" + strconv.Itoa(i))
+			if _, taken := r.owners[point]; taken {
+				continue // a collision keeps the first owner so every client agrees
+			}
+			r.owners[point] = node
+			r.points = append(r.points, point)
+		}
+	}
+	slices.Sort(r.points)
+}
+
+// Remove removes a node from the ring.
+func (r *Ring) Remove(node string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, found := r.nodes[node]; !found {
+		return
+	}
+	delete(r.nodes, node)
+
+	r.points = slices.DeleteFunc(r.points, func(point uint64) bool {
+		if r.owners[point] != node {
+			return false
+		}
+		delete(r.owners, point)
+		return true
+	})
+}
+
+// Get returns the node of a key, or false if the ring is empty.
+func (r *Ring) Get(key string) (string, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if len(r.points) == 0 {
+		return "", false
+	}
+
+	hash := hashKey(key)
+	i, _ := slices.BinarySearch(r.points, hash)
+	if i == len(r.points) {
+		i = 0 // wrap around the ring
+	}
+	return r.owners[r.points[i]], true
+}
+
+// Nodes returns the nodes of the ring, sorted.
+func (r *Ring) Nodes() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	nodes := make([]string, 0, len(r.nodes))
+	for node := range r.nodes {
+		nodes = append(nodes, node)
+	}
+	slices.Sort(nodes)
+	return nodes
+}
+
+// hashKey hashes a key with FNV-1a followed by the finalizer of MurmurHash3, since
+// FNV alone places similar keys, such as the virtual nodes of a node, too close.
+func hashKey(key string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+
+	x := h.Sum64()
+	x ^= x >> 33
+	x *= 0xff51afd7ed558ccd
+	x ^= x >> 33
+	x *= 0xc4ceb9fe1a85ec53
+	x ^= x >> 33
+	return x
+}
@@ -0,0 +1,96 @@
+package client
+
+import (
+	"strconv"
+	"testing"
+)
+
+func TestRing(t *testing.T) {
+	nodes := []string{"127.0.0.1:8595", "127.0.0.1:8596", "127.0.0.1:8597", "127.0.0.1:8598"}
+	keys := make([]string, 10_000)
+	for i := range keys {
+		keys[i] = "key:" + strconv.Itoa(i)
+	}
+
+	placement := func(r *Ring) map[string]string {
+		nodeOf := make(map[string]string, len(keys))
+		for _, key := range keys {
+			node, _ := r.Get(key)
+			nodeOf[key] = node
+		}
+		return nodeOf
+	}
+
+	t.Run("should return false if the ring is empty", func(tt *testing.T) {
+		if _, ok := NewRing(0).Get("foo"); ok {
+			tt.Error("expected an empty ring to have no node")
+		}
+	})
+
+	t.Run("should place keys regardless of the order of the nodes", func(tt *testing.T) {
+		first := NewRing(0)
+		first.Add(nodes...)
+		second := NewRing(0)
+		for i := len(nodes) - 1; i >= 0; i-- {
+			second.Add(nodes[i])
+		}
+
+		firstPlacement, secondPlacement := placement(first), placement(second)
+		for _, key := range keys {
+			if firstPlacement[key] != secondPlacement[key] {
+				tt.Fatalf("expected %s to be on %s but got %s", key, firstPlacement[key], secondPlacement[key])
+			}
+		}
+	})
+
+	t.Run("should spread keys evenly", func(tt *testing.T) {
+		r := NewRing(0)
+		r.Add(nodes...)
+
+		counts := make(map[string]int)
+		for _, node := range placement(r) {
+			counts[node]++
+		}
+
+		expected := len(keys) / len(nodes)
+		for _, node := range nodes {
+			if counts[node] < expected*3/4 || counts[node] > expected*5/4 {
+				tt.Errorf("expected %s to have about %d keys but got %d", node, expected, counts[node])
+			}
+		}
+	})
+
+	t.Run("should only move the keys of an added or removed node", func(tt *testing.T) {
+		r := NewRing(0)
+		r.Add(nodes[:3]...)
+		before := placement(r)
+
+		r.Add(nodes[3])
+		after := placement(r)
+
+		moved := 0
+		for _, key := range keys {
+			if before[key] == after[key] {
+				continue
+			}
+			if after[key] != nodes[3] {
+				tt.Fatalf("expected %s to move to %s but got %s", key, nodes[3], after[key])
+			}
+			moved++
+		}
+		if moved > len(keys)/3 {
+			tt.Errorf("expected about a quarter of the keys to move but %d did", moved)
+		}
+
+		r.Remove(nodes[3])
+		restored := placement(r)
+		for _, key := range keys {
+			if restored[key] != before[key] {
+				tt.Fatalf("expected %s to be back on %s but got %s", key, before[key], restored[key])
+			}
+		}
+		if len(r.Nodes()) != 3 {
+			tt.Errorf("expected 3 nodes but got %d", len(r.Nodes()))
+		}
+	})
+}
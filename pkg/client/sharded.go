@@ -0,0 +1,182 @@
+package client
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/JorgeLNJunior/cacher/pkg/data"
+)
+
+var ErrNoNodes = errors.New("the sharded client has no nodes")
+
+// Sharded spreads keys across several servers with a consistent hash Ring, so every
+// server stores part of the data and adding or removing a server moves few keys. The
+// keys are not copied between servers when the nodes change, the keys that moved are
+// missed until they are set again.
+type Sharded struct {
+	ring      *Ring
+	Timeout   time.Duration // max duration of a request to a server
+	TLSConfig *tls.Config   // nil for plain tcp
+
+	// Credentials authenticate the requests to every server if not nil.
+	Credentials *data.Credentials
+}
+
+// NewSharded returns a Sharded client of nodes giving virtualNodes points of the
+// ring to every node, see NewRing.
+func NewSharded(nodes []string, virtualNodes int) *Sharded {
+	ring := NewRing(virtualNodes)
+	ring.Add(nodes...)
+	return &Sharded{ring: ring, Timeout: DefaultTimeout}
+}
+
+// AddNode adds a server to the ring.
+func (s *Sharded) AddNode(addr string) {
+	s.ring.Add(addr)
+}
+
+// RemoveNode removes a server from the ring.
+func (s *Sharded) RemoveNode(addr string) {
+	s.ring.Remove(addr)
+}
+
+// Nodes returns the servers of the ring, sorted.
+func (s *Sharded) Nodes() []string {
+	return s.ring.Nodes()
+}
+
+// Node returns the server of a key.
+func (s *Sharded) Node(key string) (string, error) {
+	node, ok := s.ring.Get(key)
+	if !ok {
+		return "", ErrNoNodes
+	}
+	return node, nil
+}
+
+func (s *Sharded) client(key string) (*Client, error) {
+	node, err := s.Node(key)
+	if err != nil {
+		return nil, err
+	}
+	return &Client{Addr: node, Timeout: s.Timeout, TLSConfig: s.TLSConfig, Credentials: s.Credentials}, nil
+}
+
+// Get returns the value of a key or data.ErrKeyNotFound.
+func (s *Sharded) Get(key string) (string, error) {
+	c, err := s.client(key)
+	if err != nil {
+		return "", err
+	}
+	return c.Get(key)
+}
+
+// Set stores a key-value pair.
+func (s *Sharded) Set(key string, value string) error {
+	c, err := s.client(key)
+	if err != nil {
+		return err
+	}
+	return c.Set(key, value)
+}
+
+// Del deletes a key.
+func (s *Sharded) Del(key string) error {
+	c, err := s.client(key)
+	if err != nil {
+		return err
+	}
+	return c.Del(key)
+}
+
+// ExpireAt sets when a key expires.
+func (s *Sharded) ExpireAt(key string, expiry time.Time) error {
+	c, err := s.client(key)
+	if err != nil {
+		return err
+	}
+	return c.ExpireAt(key, expiry)
+}
+
+// GetMulti returns the values of the stored keys. The values found are returned
+// even if some servers fail.
+func (s *Sharded) GetMulti(keys []string) (map[string]string, error) {
+	values := make(map[string]string, len(keys))
+	var mu sync.Mutex
+
+	err := s.fanOut(keys, func(c *Client, key string) error {
+		value, err := c.Get(key)
+		if errors.Is(err, data.ErrKeyNotFound) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		mu.Lock()
+		values[key] = value
+		mu.Unlock()
+		return nil
+	})
+	return values, err
+}
+
+// SetMulti stores key-value pairs.
+func (s *Sharded) SetMulti(items map[string]string) error {
+	keys := make([]string, 0, len(items))
+	for key := range items {
+		keys = append(keys, key)
+	}
+
+	return s.fanOut(keys, func(c *Client, key string) error {
+		return c.Set(key, items[key])
+	})
+}
+
+// DelMulti deletes keys.
+func (s *Sharded) DelMulti(keys []string) error {
+	return s.fanOut(keys, func(c *Client, key string) error {
+		return c.Del(key)
+	})
+}
+
+// fanOut groups keys by server and calls do for the keys of every server
+// concurrently. A server stops at its first error, the others are not affected.
+func (s *Sharded) fanOut(keys []string, do func(c *Client, key string) error) error {
+	shards := make(map[string][]string)
+	for _, key := range keys {
+		node, err := s.Node(key)
+		if err != nil {
+			return err
+		}
+		shards[node] = append(shards[node], key)
+	}
+
+	errs := make(chan error, len(shards))
+	wg := sync.WaitGroup{}
+	for node, shardKeys := range shards {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			c := &Client{Addr: node, Timeout: s.Timeout, TLSConfig: s.TLSConfig, Credentials: s.Credentials}
+			for _, key := range shardKeys {
+				if err := do(c, key); err != nil {
+					errs <- fmt.Errorf("%s: %w", node, err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	joined := make([]error, 0, len(errs))
+	for err := range errs {
+		joined = append(joined, err)
+	}
+	return errors.Join(joined...)
+}
@@ -0,0 +1,187 @@
+package client
+
+import (
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/JorgeLNJunior/cacher/pkg/data"
+)
+
+// fakeStore is a server storing key-value pairs in a map.
+type fakeStore struct {
+	mu    sync.Mutex
+	items map[string]string
+}
+
+func newFakeStore(t *testing.T) (*fakeStore, string) {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	s := &fakeStore{items: make(map[string]string)}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go s.handle(conn)
+		}
+	}()
+
+	return s, listener.Addr().String()
+}
+
+func (s *fakeStore) handle(conn net.Conn) {
+	defer conn.Close()
+
+	payload, err := io.ReadAll(conn)
+	if err != nil {
+		return
+	}
+	req := data.Request{}
+	if err := req.Unmarshal(payload); err != nil {
+		return
+	}
+
+	s.mu.Lock()
+	res := data.NewResponse(data.ResponseStatusOK, "")
+	switch req.Operation {
+	case data.OperationGet:
+		value, found := s.items[req.Key]
+		res.Message = value
+		if !found {
+			res = data.NewResponse(data.ResponseStatusError, data.ErrKeyNotFound.Error())
+		}
+	case data.OperationSet:
+		s.items[req.Key] = req.Value
+	case data.OperationDel:
+		delete(s.items, req.Key)
+	}
+	s.mu.Unlock()
+
+	payload, _ = res.Marshal()
+	conn.Write(payload)
+}
+
+func (s *fakeStore) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return len(s.items)
+}
+
+func TestSharded(t *testing.T) {
+	t.Run("should store every key on its node", func(tt *testing.T) {
+		first, firstAddr := newFakeStore(tt)
+		second, secondAddr := newFakeStore(tt)
+		s := NewSharded([]string{firstAddr, secondAddr}, 0)
+
+		for i := range 100 {
+			key := "key:" + strconv.Itoa(i)
+			if err := s.Set(key, "value"); err != nil {
+				tt.Fatal(err)
+			}
+
+			node, _ := s.Node(key)
+			if _, err := (&Client{Addr: node, Timeout: DefaultTimeout}).Get(key); err != nil {
+				tt.Fatalf("expected %s to be stored on %s but got %s", key, node, err)
+			}
+		}
+
+		if first.Len()+second.Len() != 100 || first.Len() == 0 || second.Len() == 0 {
+			tt.Errorf("expected 100 keys spread across both nodes but got %d and %d", first.Len(), second.Len())
+		}
+	})
+
+	t.Run("should fan out multi-key requests", func(tt *testing.T) {
+		nodes := make([]string, 3)
+		for i := range nodes {
+			_, nodes[i] = newFakeStore(tt)
+		}
+		s := NewSharded(nodes, 0)
+
+		items := make(map[string]string)
+		keys := make([]string, 0)
+		for i := range 50 {
+			key := "key:" + strconv.Itoa(i)
+			items[key] = "value:" + strconv.Itoa(i)
+			keys = append(keys, key)
+		}
+		if err := s.SetMulti(items); err != nil {
+			tt.Fatal(err)
+		}
+
+		values, err := s.GetMulti(append(keys, "missing"))
+		if err != nil {
+			tt.Fatal(err)
+		}
+		if len(values) != len(items) {
+			tt.Fatalf("expected %d values but got %d", len(items), len(values))
+		}
+		for key, value := range items {
+			if values[key] != value {
+				tt.Errorf("expected %s to be %s but got %s", key, value, values[key])
+			}
+		}
+
+		if err := s.DelMulti(keys[:25]); err != nil {
+			tt.Fatal(err)
+		}
+		values, err = s.GetMulti(keys)
+		if err != nil {
+			tt.Fatal(err)
+		}
+		if len(values) != 25 {
+			tt.Errorf("expected 25 values but got %d", len(values))
+		}
+	})
+
+	t.Run("should return the values of the nodes that answer", func(tt *testing.T) {
+		_, up := newFakeStore(tt)
+		down := closedAddress(tt)
+		s := NewSharded([]string{up, down}, 0)
+
+		keys := make([]string, 0)
+		for i := range 50 {
+			key := "key:" + strconv.Itoa(i)
+			keys = append(keys, key)
+			if node, _ := s.Node(key); node == up {
+				if err := s.Set(key, "value"); err != nil {
+					tt.Fatal(err)
+				}
+			}
+		}
+
+		values, err := s.GetMulti(keys)
+		if err == nil {
+			tt.Error("expected an error from the node that is down")
+		}
+		if len(values) == 0 {
+			tt.Error("expected the values of the node that is up")
+		}
+	})
+
+	t.Run("should return ErrNoNodes without nodes", func(tt *testing.T) {
+		s := NewSharded(nil, 0)
+		if err := s.Set("foo", "bar"); !errors.Is(err, ErrNoNodes) {
+			tt.Errorf("expected ErrNoNodes but got %v", err)
+		}
+	})
+
+	t.Run("should return ErrKeyNotFound for a missing key", func(tt *testing.T) {
+		_, addr := newFakeStore(tt)
+		s := NewSharded([]string{addr}, 0)
+		if _, err := s.Get("foo"); !errors.Is(err, data.ErrKeyNotFound) {
+			tt.Errorf("expected ErrKeyNotFound but got %v", err)
+		}
+	})
+}
@@ -0,0 +1,78 @@
+package client
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"net"
+	"os"
+	"time"
+)
+
+var (
+	ErrInvalidCA      = errors.New("the CA file has no PEM encoded certificate")
+	ErrIncompleteCert = errors.New("a client certificate needs both the certificate and the key files")
+)
+
+// LoadTLSConfig returns the configuration of a TLS connection to a server. The server
+// is verified with the CA in caFile, or with the CAs of the system if it's empty. The
+// client certificate is only sent if certFile and keyFile are provided.
+func LoadTLSConfig(caFile string, certFile string, keyFile string) (*tls.Config, error) {
+	config := &tls.Config{MinVersion: tls.VersionTLS12}
+
+	if caFile != "" {
+		pool, err := LoadCertPool(caFile)
+		if err != nil {
+			return nil, err
+		}
+		config.RootCAs = pool
+	}
+
+	if certFile != "" || keyFile != "" {
+		if certFile == "" || keyFile == "" {
+			return nil, ErrIncompleteCert
+		}
+		certificate, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{certificate}
+	}
+
+	return config, nil
+}
+
+// LoadCertPool returns a pool with the PEM encoded certificates of a file.
+func LoadCertPool(file string) (*x509.CertPool, error) {
+	payload, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(payload) {
+		return nil, ErrInvalidCA
+	}
+	return pool, nil
+}
+
+// Dial connects to a server, over TLS if config is not nil. The timeout includes the
+// TLS handshake.
+func Dial(ctx context.Context, addr string, timeout time.Duration, config *tls.Config) (net.Conn, error) {
+	dialer := &net.Dialer{Timeout: timeout}
+	if config == nil {
+		return dialer.DialContext(ctx, "tcp", addr)
+	}
+
+	return (&tls.Dialer{NetDialer: dialer, Config: config}).DialContext(ctx, "tcp", addr)
+}
+
+// CloseWrite closes the writing side of a tcp or TLS connection, which tells the server
+// the whole request has been sent.
+func CloseWrite(conn net.Conn) error {
+	if closer, ok := conn.(interface{ CloseWrite() error }); ok {
+		return closer.CloseWrite()
+	}
+	return nil
+}
@@ -0,0 +1,37 @@
+package client
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadTLSConfig(t *testing.T) {
+	t.Run("should verify the server with the CAs of the system by default", func(tt *testing.T) {
+		config, err := LoadTLSConfig("", "", "")
+		if err != nil {
+			tt.Fatal(err)
+		}
+		if config.RootCAs != nil || len(config.Certificates) != 0 {
+			tt.Errorf("expected no CA and no client certificate but got %+v", config)
+		}
+	})
+
+	t.Run("should require both the certificate and the key", func(tt *testing.T) {
+		if _, err := LoadTLSConfig("", "client.pem", ""); !errors.Is(err, ErrIncompleteCert) {
+			tt.Errorf("expected ErrIncompleteCert but got %v", err)
+		}
+	})
+
+	t.Run("should return an error if the CA file has no certificate", func(tt *testing.T) {
+		file := filepath.Join(tt.TempDir(), "ca.pem")
+		if err := os.WriteFile(file, []byte("not a certificate"), 0o600); err != nil {
+			tt.Fatal(err)
+		}
+
+		if _, err := LoadTLSConfig(file, "", ""); !errors.Is(err, ErrInvalidCA) {
+			tt.Errorf("expected ErrInvalidCA but got %v", err)
+		}
+	})
+}
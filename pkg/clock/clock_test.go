@@ -0,0 +1,54 @@
+package clock
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFake(t *testing.T) {
+	t.Run("should only move forward when advanced", func(tt *testing.T) {
+		start := time.Unix(1000, 0)
+		clock := NewFake(start)
+
+		if !clock.Now().Equal(start) {
+			tt.Fatalf("expected '%s' but got '%s'", start, clock.Now())
+		}
+
+		clock.Advance(time.Minute)
+		if expected := start.Add(time.Minute); !clock.Now().Equal(expected) {
+			tt.Fatalf("expected '%s' but got '%s'", expected, clock.Now())
+		}
+	})
+
+	t.Run("should fire a ticker once its interval has elapsed", func(tt *testing.T) {
+		clock := NewFake(time.Unix(1000, 0))
+		ticker := clock.NewTicker(time.Second)
+		defer ticker.Stop()
+
+		ticks := make(chan time.Time, 2)
+		go func() {
+			for tick := range ticker.C() {
+				ticks <- tick
+			}
+		}()
+
+		clock.Advance(time.Millisecond * 500)
+		if len(ticks) != 0 {
+			tt.Fatal("the ticker fired before its interval elapsed")
+		}
+
+		clock.Advance(time.Millisecond * 500)
+		if tick := <-ticks; !tick.Equal(clock.Now()) {
+			tt.Fatalf("expected a tick at '%s' but got '%s'", clock.Now(), tick)
+		}
+	})
+
+	t.Run("should not block advancing a stopped ticker", func(tt *testing.T) {
+		clock := NewFake(time.Unix(1000, 0))
+		ticker := clock.NewTicker(time.Second)
+		ticker.Stop()
+		ticker.Stop()
+
+		clock.Advance(time.Second)
+	})
+}
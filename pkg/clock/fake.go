@@ -0,0 +1,101 @@
+package clock
+
+import (
+	"sync"
+	"time"
+)
+
+// Fake is a Clock that only moves forward when Advance is called.
+type Fake struct {
+	now     time.Time
+	tickers []*fakeTicker
+	mu      sync.Mutex
+}
+
+// NewFake returns a Fake clock set to t.
+func NewFake(t time.Time) *Fake {
+	return &Fake{now: t}
+}
+
+func (f *Fake) Now() time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.now
+}
+
+func (f *Fake) NewTicker(d time.Duration) Ticker {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	ticker := &fakeTicker{
+		clock:    f,
+		c:        make(chan time.Time),
+		interval: d,
+		next:     f.now.Add(d),
+		stop:     make(chan struct{}),
+	}
+	f.tickers = append(f.tickers, ticker)
+
+	return ticker
+}
+
+// Advance moves the clock forward by d and fires the tickers whose interval has elapsed.
+// Like time.Ticker, a ticker fires at most once per call even if several intervals
+// have elapsed. Unlike time.Ticker, Advance blocks until every fired tick has been
+// received, so the caller knows the consumers have observed the new time.
+func (f *Fake) Advance(d time.Duration) {
+	f.mu.Lock()
+	f.now = f.now.Add(d)
+	now := f.now
+
+	fired := make([]*fakeTicker, 0, len(f.tickers))
+	for _, ticker := range f.tickers {
+		if now.Before(ticker.next) {
+			continue
+		}
+		missed := now.Sub(ticker.next) / ticker.interval
+		ticker.next = ticker.next.Add((missed + 1) * ticker.interval)
+		fired = append(fired, ticker)
+	}
+	f.mu.Unlock()
+
+	for _, ticker := range fired {
+		select {
+		case ticker.c <- now:
+		case <-ticker.stop:
+		}
+	}
+}
+
+func (f *Fake) removeTicker(t *fakeTicker) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for i, ticker := range f.tickers {
+		if ticker == t {
+			f.tickers = append(f.tickers[:i], f.tickers[i+1:]...)
+			return
+		}
+	}
+}
+
+type fakeTicker struct {
+	clock    *Fake
+	c        chan time.Time
+	interval time.Duration
+	next     time.Time
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+func (t *fakeTicker) C() <-chan time.Time {
+	return t.c
+}
+
+func (t *fakeTicker) Stop() {
+	t.stopOnce.Do(func() {
+		t.clock.removeTicker(t)
+		close(t.stop)
+	})
+}
@@ -0,0 +1,38 @@
+package data
+
+import (
+	"errors"
+	"strings"
+)
+
+// Credentials authenticate a request. They are sent in an AUTH line before it.
+type Credentials struct {
+	User     string
+	Password string
+}
+
+var ErrInvalidCredentialsFormat = errors.New("the user must not be empty nor have spaces and the password must not have line breaks")
+
+func (c Credentials) valid() bool {
+	return c.User != "" && !strings.ContainsAny(c.User, " \n") && !strings.Contains(c.Password, "\n")
+}
+
+// authLine returns the AUTH line sent before a request made with the credentials.
+func (c Credentials) authLine() string {
+	return OperationAuth.String() + " " + c.User + " " + c.Password + "\n"
+}
+
+// cutAuthLine splits the AUTH line from the request that follows it. It returns false
+// if the message is not an AUTH line followed by a request.
+func cutAuthLine(message string) (Credentials, string, bool) {
+	line, request, found := strings.Cut(message, "\n")
+	if !found || request == "" {
+		return Credentials{}, message, false
+	}
+
+	fields := strings.SplitN(line, " ", maxParameters)
+	if fields[0] != OperationAuth.String() || len(fields) < 3 {
+		return Credentials{}, message, false
+	}
+	return Credentials{User: fields[1], Password: fields[2]}, request, true
+}
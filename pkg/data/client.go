@@ -0,0 +1,99 @@
+package data
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The keys of the CLIENT requests.
+const (
+	ClientList    = "LIST"    // describes the open connections
+	ClientKill    = "KILL"    // closes the connection whose id or address is the value
+	ClientSetName = "SETNAME" // names the connection of the request with the value
+)
+
+var (
+	ErrInvalidClientCommand = errors.New("the key of a CLIENT must be LIST, KILL or SETNAME")
+	ErrInvalidClientName    = errors.New("the client name must not be empty nor have spaces")
+	ErrInvalidClientInfo    = errors.New("a client must be id=ID addr=ADDR name=NAME age=SECONDS idle=SECONDS cmd=OPERATION in=BYTES out=BYTES")
+)
+
+// ValidClientName returns whether name can name a connection.
+func ValidClientName(name string) bool {
+	return name != "" && !strings.ContainsAny(name, " \t\r\n")
+}
+
+// nameLine returns the CLIENT SETNAME line sent before a request made by a named client.
+func nameLine(name string) string {
+	return OperationClient.String() + " " + ClientSetName + " " + name + "\n"
+}
+
+// cutNameLine splits the CLIENT SETNAME line from the request that follows it. It
+// returns false if the message is not a CLIENT SETNAME line followed by a request.
+func cutNameLine(message string) (string, string, bool) {
+	line, request, found := strings.Cut(message, "\n")
+	if !found || request == "" {
+		return "", message, false
+	}
+
+	name, found := strings.CutPrefix(line, OperationClient.String()+" "+ClientSetName+" ")
+	if !found || !ValidClientName(name) {
+		return "", message, false
+	}
+	return name, request, true
+}
+
+// ClientInfo describes an open connection, as a line of a CLIENT LIST response.
+type ClientInfo struct {
+	ID          int64
+	Addr        string // address of the client
+	Name        string // set by CLIENT SETNAME, empty if none
+	Age         time.Duration
+	Idle        time.Duration // since the last byte was read or written
+	LastCommand Operation     // empty until the request has been read
+	BytesIn     int64
+	BytesOut    int64
+}
+
+// String returns the info as a line of a CLIENT LIST response. The durations are
+// truncated to seconds.
+func (c ClientInfo) String() string {
+	return fmt.Sprintf("id=%d addr=%s name=%s age=%d idle=%d cmd=%s in=%d out=%d",
+		c.ID, c.Addr, c.Name, int64(c.Age.Seconds()), int64(c.Idle.Seconds()), c.LastCommand, c.BytesIn, c.BytesOut)
+}
+
+// ParseClientList parses the message of a CLIENT LIST response, a client per line.
+func ParseClientList(message string) ([]ClientInfo, error) {
+	clients := make([]ClientInfo, 0)
+	for line := range strings.Lines(message) {
+		fields, ok := parseFields(strings.Fields(line))
+		if !ok {
+			return nil, ErrInvalidClientInfo
+		}
+
+		numbers := make(map[string]int64, 5)
+		for _, key := range []string{"id", "age", "idle", "in", "out"} {
+			n, err := strconv.ParseInt(fields[key], 10, 64)
+			if err != nil {
+				return nil, ErrInvalidClientInfo
+			}
+			numbers[key] = n
+		}
+
+		clients = append(clients, ClientInfo{
+			ID:          numbers["id"],
+			Addr:        fields["addr"],
+			Name:        fields["name"],
+			Age:         time.Duration(numbers["age"]) * time.Second,
+			Idle:        time.Duration(numbers["idle"]) * time.Second,
+			LastCommand: Operation(fields["cmd"]),
+			BytesIn:     numbers["in"],
+			BytesOut:    numbers["out"],
+		})
+	}
+
+	return clients, nil
+}
@@ -0,0 +1,76 @@
+package data
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestClientInfo(t *testing.T) {
+	t.Run("should parse the clients it formats", func(tt *testing.T) {
+		clients := []ClientInfo{
+			{ID: 1, Addr: "127.0.0.1:5000", Name: "worker-1", Age: time.Minute, Idle: time.Second, LastCommand: OperationMonitor, BytesIn: 12, BytesOut: 4096},
+			{ID: 7, Addr: "[::1]:5000"},
+		}
+
+		message := clients[0].String() + "\n" + clients[1].String()
+		parsed, err := ParseClientList(message)
+		if err != nil {
+			tt.Fatal(err)
+		}
+
+		if len(parsed) != len(clients) {
+			tt.Fatalf("expected %d clients but got %d", len(clients), len(parsed))
+		}
+		for i := range clients {
+			if parsed[i] != clients[i] {
+				tt.Errorf("expected %+v but got %+v", clients[i], parsed[i])
+			}
+		}
+	})
+
+	t.Run("should return an error if a client is incomplete", func(tt *testing.T) {
+		if _, err := ParseClientList("id=1 addr=127.0.0.1:5000 name="); !errors.Is(err, ErrInvalidClientInfo) {
+			tt.Fatalf("expected ErrInvalidClientInfo but received '%v'", err)
+		}
+	})
+}
+
+func TestClientName(t *testing.T) {
+	t.Run("should send the name of the client before the request", func(tt *testing.T) {
+		req := Request{Operation: OperationGet, Key: "foo", ClientName: "worker-1", Credentials: &Credentials{User: "alice", Password: "s3cret"}}
+
+		payload, err := req.Marshal()
+		if err != nil {
+			tt.Fatal(err)
+		}
+		if string(payload) != "AUTH alice s3cret\nCLIENT SETNAME worker-1\nGET foo" {
+			tt.Errorf("expected the AUTH and the CLIENT SETNAME lines before the request but got '%s'", payload)
+		}
+
+		result := Request{}
+		if err := result.Unmarshal(payload); err != nil {
+			tt.Fatal(err)
+		}
+		if result.ClientName != "worker-1" || result.Credentials == nil || result.Operation != OperationGet || result.Key != "foo" {
+			tt.Errorf("expected a GET of foo made by worker-1 but got %+v", result)
+		}
+	})
+
+	t.Run("should unmarshal a CLIENT SETNAME without a request as a CLIENT operation", func(tt *testing.T) {
+		result := Request{}
+		if err := result.Unmarshal([]byte("CLIENT SETNAME worker-1\n")); err != nil {
+			tt.Fatal(err)
+		}
+		if result.ClientName != "" || result.Operation != OperationClient || result.Key != ClientSetName || result.Value != "worker-1" {
+			tt.Errorf("expected 'CLIENT SETNAME worker-1' but got %+v", result)
+		}
+	})
+
+	t.Run("should return an error if the name has spaces", func(tt *testing.T) {
+		req := Request{Operation: OperationRole, ClientName: "worker 1"}
+		if _, err := req.Marshal(); !errors.Is(err, ErrInvalidClientName) {
+			tt.Fatalf("expected ErrInvalidClientName but received '%v'", err)
+		}
+	})
+}
@@ -0,0 +1,34 @@
+package data
+
+import "strings"
+
+const notLeaderMessage = "the server is not the leader of the cluster"
+
+// NotLeaderError is the error of a write sent to a node of a cluster that is not
+// the leader. Leader is empty if the node doesn't know the leader, e.g. during an
+// election.
+type NotLeaderError struct {
+	Leader string
+}
+
+func (e NotLeaderError) Error() string {
+	if e.Leader == "" {
+		return notLeaderMessage + ", no leader is known"
+	}
+	return notLeaderMessage + ", leader=" + e.Leader
+}
+
+// ParseNotLeader parses the message of an ERROR response to a write sent to a node
+// that is not the leader.
+func ParseNotLeader(message string) (NotLeaderError, bool) {
+	rest, found := strings.CutPrefix(message, notLeaderMessage)
+	if !found {
+		return NotLeaderError{}, false
+	}
+
+	leader, _ := strings.CutPrefix(rest, ", leader=")
+	if leader == rest {
+		leader = ""
+	}
+	return NotLeaderError{Leader: leader}, true
+}
@@ -0,0 +1,31 @@
+package data
+
+import "testing"
+
+func TestParseNotLeader(t *testing.T) {
+	t.Run("should parse the leader", func(tt *testing.T) {
+		err, ok := ParseNotLeader(NotLeaderError{Leader: "127.0.0.1:8595"}.Error())
+		if !ok {
+			tt.Fatal("expected the message to be parsed")
+		}
+		if err.Leader != "127.0.0.1:8595" {
+			tt.Errorf("expected the leader to be 127.0.0.1:8595 but got %s", err.Leader)
+		}
+	})
+
+	t.Run("should parse an unknown leader", func(tt *testing.T) {
+		err, ok := ParseNotLeader(NotLeaderError{}.Error())
+		if !ok {
+			tt.Fatal("expected the message to be parsed")
+		}
+		if err.Leader != "" {
+			tt.Errorf("expected no leader but got %s", err.Leader)
+		}
+	})
+
+	t.Run("should not parse other messages", func(tt *testing.T) {
+		if _, ok := ParseNotLeader(ErrKeyNotFound.Error()); ok {
+			tt.Error("expected the message not to be parsed")
+		}
+	})
+}
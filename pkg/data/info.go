@@ -0,0 +1,19 @@
+package data
+
+import (
+	"errors"
+	"strings"
+)
+
+var ErrInvalidInfo = errors.New("the info must be KEY=VALUE fields")
+
+// ParseInfo parses the message of an INFO response, KEY=VALUE fields separated by
+// spaces, e.g. "uptime_seconds=42 keys=10".
+func ParseInfo(message string) (map[string]string, error) {
+	info, ok := parseFields(strings.Fields(message))
+	if !ok {
+		return nil, ErrInvalidInfo
+	}
+
+	return info, nil
+}
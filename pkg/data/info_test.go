@@ -0,0 +1,25 @@
+package data
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestParseInfo(t *testing.T) {
+	t.Run("should parse the fields of an info", func(tt *testing.T) {
+		info, err := ParseInfo("uptime_seconds=42 keys=10 go_version=go1.24")
+		if err != nil {
+			tt.Fatal(err)
+		}
+
+		if info["uptime_seconds"] != "42" || info["keys"] != "10" || info["go_version"] != "go1.24" {
+			tt.Errorf("expected the fields to be parsed but got %v", info)
+		}
+	})
+
+	t.Run("should return an error if a field has no value", func(tt *testing.T) {
+		if _, err := ParseInfo("uptime_seconds=42 keys"); !errors.Is(err, ErrInvalidInfo) {
+			tt.Fatalf("expected ErrInvalidInfo but received '%v'", err)
+		}
+	})
+}
@@ -0,0 +1,67 @@
+package data
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var ErrInvalidMonitorEntry = errors.New("a monitor entry must be TIME ADDR OPERATION KEY SIZE VALUE")
+
+// MonitorEntry is a request streamed by MONITOR.
+type MonitorEntry struct {
+	Time      time.Time
+	Addr      string // address of the client
+	Operation Operation
+	Key       string
+	Size      int    // size of the value in bytes
+	Value     string // the value, or its beginning if it's shorter than Size
+}
+
+// Truncated returns whether Value is only the beginning of the value.
+func (e MonitorEntry) Truncated() bool {
+	return len(e.Value) < e.Size
+}
+
+// String returns the entry as a line of a MONITOR stream, without the line break. The
+// key and the value are quoted, so they can be empty or have any character.
+func (e MonitorEntry) String() string {
+	return fmt.Sprintf("%s %s %s %s %d %s",
+		e.Time.UTC().Format(time.RFC3339Nano), e.Addr, e.Operation, strconv.Quote(e.Key), e.Size, strconv.Quote(e.Value))
+}
+
+// ParseMonitorEntry parses a line of a MONITOR stream.
+func ParseMonitorEntry(line string) (MonitorEntry, error) {
+	fields := strings.SplitN(strings.TrimSuffix(line, "\n"), " ", 4)
+	if len(fields) != 4 {
+		return MonitorEntry{}, ErrInvalidMonitorEntry
+	}
+
+	t, err := time.Parse(time.RFC3339Nano, fields[0])
+	if err != nil {
+		return MonitorEntry{}, ErrInvalidMonitorEntry
+	}
+
+	quotedKey, err := strconv.QuotedPrefix(fields[3])
+	if err != nil {
+		return MonitorEntry{}, ErrInvalidMonitorEntry
+	}
+	key, _ := strconv.Unquote(quotedKey)
+
+	size, quotedValue, found := strings.Cut(strings.TrimPrefix(fields[3][len(quotedKey):], " "), " ")
+	if !found {
+		return MonitorEntry{}, ErrInvalidMonitorEntry
+	}
+	n, err := strconv.Atoi(size)
+	if err != nil {
+		return MonitorEntry{}, ErrInvalidMonitorEntry
+	}
+	value, err := strconv.Unquote(quotedValue)
+	if err != nil {
+		return MonitorEntry{}, ErrInvalidMonitorEntry
+	}
+
+	return MonitorEntry{Time: t, Addr: fields[1], Operation: Operation(fields[2]), Key: key, Size: n, Value: value}, nil
+}
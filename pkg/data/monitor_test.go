@@ -0,0 +1,42 @@
+package data
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestMonitorEntry(t *testing.T) {
+	t.Run("should parse the entries it formats", func(tt *testing.T) {
+		entries := []MonitorEntry{
+			{Time: time.Unix(1700000000, 500).UTC(), Addr: "127.0.0.1:5000", Operation: OperationSet, Key: "foo \"bar\"", Size: 11, Value: "hello\nworld"},
+			{Time: time.Unix(1700000000, 0).UTC(), Addr: "[::1]:5000", Operation: OperationInfo},
+			{Time: time.Unix(1700000000, 0).UTC(), Addr: "[::1]:5000", Operation: OperationSet, Key: "big", Size: 1000, Value: "abc"},
+		}
+
+		for _, entry := range entries {
+			parsed, err := ParseMonitorEntry(entry.String() + "\n")
+			if err != nil {
+				tt.Fatalf("'%s': %s", entry, err)
+			}
+			if parsed != entry {
+				tt.Errorf("expected %+v but got %+v", entry, parsed)
+			}
+		}
+		if entries[0].Truncated() || !entries[2].Truncated() {
+			tt.Error("expected only the value shorter than its size to be truncated")
+		}
+	})
+
+	t.Run("should return an error if an entry is incomplete", func(tt *testing.T) {
+		for _, line := range []string{
+			"2023-11-14T22:13:20Z 127.0.0.1:5000 GET",
+			`2023-11-14T22:13:20Z 127.0.0.1:5000 GET "foo"`,
+			`2023-11-14T22:13:20Z 127.0.0.1:5000 GET "foo" 3 "bar`,
+		} {
+			if _, err := ParseMonitorEntry(line); !errors.Is(err, ErrInvalidMonitorEntry) {
+				tt.Errorf("expected ErrInvalidMonitorEntry for '%s' but received '%v'", line, err)
+			}
+		}
+	})
+}
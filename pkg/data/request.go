@@ -5,13 +5,17 @@ import (
 	"strconv"
 	"strings"
 	"time"
+
+	"github.com/JorgeLNJunior/cacher/pkg/clock"
 )
 
 type Request struct {
-	Operation Operation
-	Key       string
-	Value     string
-	Expiry    time.Time
+	Operation   Operation
+	Key         string
+	Value       string
+	Expiry      time.Time
+	Credentials *Credentials // authenticate the request if not nil
+	ClientName  string       // names the connection of the request if not empty
 }
 
 type Operation string
@@ -26,6 +30,28 @@ func (o Operation) Valid() bool {
 		return true
 	case o == OperationExp:
 		return true
+	case o == OperationBackup:
+		return true
+	case o == OperationRestore:
+		return true
+	case o == OperationSync:
+		return true
+	case o == OperationRole:
+		return true
+	case o == OperationReplicaOf:
+		return true
+	case o == OperationRaft:
+		return true
+	case o == OperationAuth:
+		return true
+	case o == OperationInfo:
+		return true
+	case o == OperationSlowLog:
+		return true
+	case o == OperationMonitor:
+		return true
+	case o == OperationClient:
+		return true
 	default:
 		return false
 	}
@@ -35,17 +61,65 @@ func (o Operation) String() string {
 	return string(o)
 }
 
+// HasKey returns whether requests of the operation have a key.
+func (o Operation) HasKey() bool {
+	return o != OperationRole && o != OperationInfo
+}
+
 const (
 	OperationGet Operation = "GET"
 	OperationSet Operation = "SET"
 	OperationDel Operation = "DEL"
 	OperationExp Operation = "EXP"
+
+	// OperationBackup writes a snapshot of the data to the backup named by the key,
+	// or streams it back if the key is StreamKey.
+	OperationBackup Operation = "BACKUP"
+	// OperationRestore loads the backup named by the key, or the snapshot in the value
+	// if the key is StreamKey.
+	OperationRestore Operation = "RESTORE"
+
+	// OperationSync starts the replication of the data to a replica. The key must be
+	// StreamKey and the connection stays open while the mutations are streamed.
+	OperationSync Operation = "SYNC"
+	// OperationRole describes the replication role of the server. It has no key.
+	OperationRole Operation = "ROLE"
+	// OperationReplicaOf makes the server a replica of the primary in the key, or a
+	// primary if the key is NoPrimary.
+	OperationReplicaOf Operation = "REPLICAOF"
+
+	// OperationRaft carries a message between the nodes of a cluster. The key is the
+	// kind of the message and the value its JSON.
+	OperationRaft Operation = "RAFT"
+
+	// OperationAuth checks the password in the value of the user in the key. An AUTH
+	// line can also precede any other request, which is then made as that user.
+	OperationAuth Operation = "AUTH"
+
+	// OperationInfo describes the state of the server as KEY=VALUE fields. It has no key.
+	OperationInfo Operation = "INFO"
+	// OperationSlowLog reads or clears the slow log. The key is SlowLogGet, SlowLogLen or
+	// SlowLogReset and the value of a SlowLogGet is the max number of entries.
+	OperationSlowLog Operation = "SLOWLOG"
+	// OperationMonitor streams every request made to the server whose key matches the
+	// glob pattern in the key, as MonitorEntry lines, until the client disconnects.
+	OperationMonitor Operation = "MONITOR"
+	// OperationClient lists, kills or names the connections. The key is ClientList,
+	// ClientKill or ClientSetName. A CLIENT SETNAME line can also precede any other
+	// request, after the AUTH line if any, to name its connection.
+	OperationClient Operation = "CLIENT"
 )
 
+// StreamKey is the key of BACKUP and RESTORE requests whose snapshot is sent over the connection.
+const StreamKey = "-"
+
+// NoPrimary is the key of REPLICAOF requests that turn a replica into a primary.
+const NoPrimary = "-"
+
 const maxParameters = 3
 
 var (
-	ErrInvalidOperation     = errors.New("operation must be GET, SET, DEL or EXP")
+	ErrInvalidOperation     = errors.New("operation must be GET, SET, DEL, EXP, BACKUP, RESTORE, SYNC, ROLE, REPLICAOF, RAFT, AUTH, INFO, SLOWLOG, MONITOR or CLIENT")
 	ErrInvalidFormat        = errors.New("message format does not complain")
 	ErrNoKey                = errors.New("should provide a key")
 	ErrNoValue              = errors.New("should provide a value when operation is SET")
@@ -56,15 +130,38 @@ func (r *Request) Marshal() ([]byte, error) {
 	if !r.Operation.Valid() {
 		return nil, ErrInvalidOperation
 	}
-	if r.Key == "" {
+	if r.Key == "" && r.Operation.HasKey() {
 		return nil, ErrNoKey
 	}
 	if r.Operation == OperationSet && len(r.Value) < 1 {
 		return nil, ErrNoValue
 	}
+	if r.Operation == OperationAuth && r.Credentials != nil {
+		return nil, ErrInvalidFormat // the credentials are already the key and the value
+	}
+
+	data := ""
+	if r.Credentials != nil {
+		if !r.Credentials.valid() {
+			return nil, ErrInvalidCredentialsFormat
+		}
+		data = r.Credentials.authLine()
+	}
+	if r.ClientName != "" {
+		if !ValidClientName(r.ClientName) {
+			return nil, ErrInvalidClientName
+		}
+		data += nameLine(r.ClientName)
+	}
+
+	if !r.Operation.HasKey() {
+		return []byte(data + r.Operation.String()), nil
+	}
 
-	data := r.Operation.String() + " " + r.Key
-	if r.Operation == OperationSet {
+	data += r.Operation.String() + " " + r.Key
+	if r.Operation == OperationSet || r.Operation == OperationAuth ||
+		(r.Operation == OperationRestore || r.Operation == OperationRaft || r.Operation == OperationSlowLog ||
+			r.Operation == OperationClient) && len(r.Value) > 0 {
 		data += " " + r.Value
 	}
 	if r.Operation == OperationExp {
@@ -75,18 +172,40 @@ func (r *Request) Marshal() ([]byte, error) {
 }
 
 func (r *Request) Unmarshal(data []byte) error {
+	return r.UnmarshalWithClock(data, clock.New())
+}
+
+// UnmarshalWithClock works like Unmarshal but validates expiry dates against c.
+func (r *Request) UnmarshalWithClock(data []byte, c clock.Clock) error {
 	trimData := strings.TrimSuffix(string(data), "\n") // messages are ending with a \n and we should remove it
+	if credentials, request, found := cutAuthLine(trimData); found {
+		r.Credentials = &credentials
+		trimData = request
+	}
+	if name, request, found := cutNameLine(trimData); found {
+		r.ClientName = name
+		trimData = request
+	}
 	splitData := strings.SplitN(trimData, " ", maxParameters)
+
+	operation := Operation(splitData[0])
+	if operation == OperationRole || operation == OperationInfo {
+		r.Operation = operation
+		return nil
+	}
+
 	if len(splitData) < 2 {
 		return ErrInvalidFormat
 	}
-
-	operation := Operation(splitData[0])
 	if !operation.Valid() {
 		return ErrInvalidOperation
 	}
 
-	if operation == OperationSet {
+	if operation == OperationAuth && r.Credentials != nil {
+		return ErrInvalidFormat
+	}
+
+	if operation == OperationSet || operation == OperationAuth {
 		if len(splitData) < 3 {
 			return ErrInvalidFormat
 		}
@@ -97,12 +216,23 @@ func (r *Request) Unmarshal(data []byte) error {
 		return nil
 	}
 
-	if operation == OperationGet || operation == OperationDel {
+	if operation == OperationGet || operation == OperationDel || operation == OperationBackup || operation == OperationSync ||
+		operation == OperationReplicaOf || operation == OperationMonitor {
 		r.Operation = operation
 		r.Key = splitData[1]
 		return nil
 	}
 
+	if operation == OperationRestore || operation == OperationRaft || operation == OperationSlowLog ||
+		operation == OperationClient {
+		r.Operation = operation
+		r.Key = splitData[1]
+		if len(splitData) > 2 {
+			r.Value = splitData[2]
+		}
+		return nil
+	}
+
 	if operation == OperationExp {
 		if len(splitData) < 3 {
 			return ErrInvalidFormat
@@ -117,7 +247,7 @@ func (r *Request) Unmarshal(data []byte) error {
 		}
 
 		exp := time.Unix(seconds, 0)
-		if time.Now().After(exp) {
+		if c.Now().After(exp) {
 			return ErrInvalidUnixTimestamp
 		}
 		r.Expiry = exp
@@ -129,8 +259,12 @@ func (r *Request) Unmarshal(data []byte) error {
 }
 
 func (r Request) String() string {
+	if !r.Operation.HasKey() {
+		return string(r.Operation)
+	}
+
 	v := string(r.Operation) + " " + r.Key
-	if len(r.Value) > 0 {
+	if len(r.Value) > 0 && r.Operation != OperationAuth { // never shows the password
 		v += " " + r.Value
 	}
 	return v
@@ -2,8 +2,12 @@ package data
 
 import (
 	"errors"
+	"strconv"
 	"strings"
 	"testing"
+	"time"
+
+	"github.com/JorgeLNJunior/cacher/pkg/clock"
 )
 
 func TestMarshal(t *testing.T) {
@@ -64,6 +68,34 @@ func TestMarshal(t *testing.T) {
 			tt.Errorf("expected key to be '%s' but got '%s'", req.Key, key)
 		}
 	})
+
+	t.Run("should marshal the credentials in an AUTH line before the request", func(tt *testing.T) {
+		req := Request{
+			Operation:   OperationGet,
+			Key:         "foo",
+			Credentials: &Credentials{User: "alice", Password: "s3cret pass"},
+		}
+
+		data, err := req.Marshal()
+		if err != nil {
+			tt.Fatal(err)
+		}
+
+		if string(data) != "AUTH alice s3cret pass\nGET foo" {
+			tt.Errorf("expected 'AUTH alice s3cret pass\\nGET foo' but got '%s'", data)
+		}
+	})
+
+	t.Run("should return an error if the user has spaces", func(tt *testing.T) {
+		req := Request{
+			Operation:   OperationRole,
+			Credentials: &Credentials{User: "alice smith", Password: "s3cret"},
+		}
+
+		if _, err := req.Marshal(); !errors.Is(err, ErrInvalidCredentialsFormat) {
+			tt.Fatalf("expected 'ErrInvalidCredentialsFormat' but received '%s'", err)
+		}
+	})
 }
 
 func TestUnmarshal(t *testing.T) {
@@ -136,4 +168,126 @@ func TestUnmarshal(t *testing.T) {
 			tt.Errorf("expected value to be '%s' but got '%s'", value, result.Value)
 		}
 	})
+
+	t.Run("should return an error if the expiry is in the past", func(tt *testing.T) {
+		now := time.Unix(1_700_000_000, 0)
+		bytes := []byte("EXP foo " + strconv.FormatInt(now.Add(-time.Second).Unix(), 10))
+
+		result := Request{}
+		if err := result.UnmarshalWithClock(bytes, clock.NewFake(now)); !errors.Is(err, ErrInvalidUnixTimestamp) {
+			tt.Errorf("expected ErrInvalidUnixTimestamp but received %s", err)
+		}
+	})
+
+	t.Run("should unmarshal an EXP operation", func(tt *testing.T) {
+		now := time.Unix(1_700_000_000, 0)
+		expiry := now.Add(time.Minute)
+		bytes := []byte("EXP foo " + strconv.FormatInt(expiry.Unix(), 10))
+
+		result := Request{}
+		if err := result.UnmarshalWithClock(bytes, clock.NewFake(now)); err != nil {
+			tt.Fatal(err)
+		}
+
+		if !result.Expiry.Equal(expiry) {
+			tt.Errorf("expected expiry to be '%s' but got '%s'", expiry, result.Expiry)
+		}
+	})
+
+	t.Run("should unmarshal a RESTORE operation with a snapshot", func(tt *testing.T) {
+		bytes := []byte("RESTORE " + StreamKey + " CACHERDB \x00 data")
+
+		result := Request{}
+		if err := result.Unmarshal(bytes); err != nil {
+			tt.Fatal(err)
+		}
+
+		if result.Key != StreamKey {
+			tt.Errorf("expected key to be '%s' but got '%s'", StreamKey, result.Key)
+		}
+		if result.Value != "CACHERDB \x00 data" {
+			tt.Errorf("expected the value to be the snapshot but got '%s'", result.Value)
+		}
+	})
+
+	t.Run("should unmarshal a BACKUP operation", func(tt *testing.T) {
+		bytes := []byte("BACKUP nightly")
+
+		result := Request{}
+		if err := result.Unmarshal(bytes); err != nil {
+			tt.Fatal(err)
+		}
+
+		if result.Operation != OperationBackup || result.Key != "nightly" {
+			tt.Errorf("expected 'BACKUP nightly' but got '%s'", result)
+		}
+	})
+
+	t.Run("should unmarshal a ROLE operation without a key", func(tt *testing.T) {
+		result := Request{}
+		if err := result.Unmarshal([]byte("ROLE\n")); err != nil {
+			tt.Fatal(err)
+		}
+
+		if result.Operation != OperationRole || result.Key != "" {
+			tt.Errorf("expected 'ROLE' but got '%s'", result)
+		}
+	})
+
+	t.Run("should unmarshal an INFO operation without a key", func(tt *testing.T) {
+		result := Request{}
+		if err := result.Unmarshal([]byte("INFO\n")); err != nil {
+			tt.Fatal(err)
+		}
+
+		if result.Operation != OperationInfo || result.Key != "" {
+			tt.Errorf("expected 'INFO' but got '%s'", result)
+		}
+	})
+
+	t.Run("should unmarshal a SLOWLOG operation with an optional count", func(tt *testing.T) {
+		result := Request{}
+		if err := result.Unmarshal([]byte("SLOWLOG GET 5\n")); err != nil {
+			tt.Fatal(err)
+		}
+		if result.Operation != OperationSlowLog || result.Key != SlowLogGet || result.Value != "5" {
+			tt.Errorf("expected 'SLOWLOG GET 5' but got '%s'", result)
+		}
+
+		result = Request{}
+		if err := result.Unmarshal([]byte("SLOWLOG LEN\n")); err != nil {
+			tt.Fatal(err)
+		}
+		if result.Operation != OperationSlowLog || result.Key != SlowLogLen || result.Value != "" {
+			tt.Errorf("expected 'SLOWLOG LEN' but got '%s'", result)
+		}
+	})
+
+	t.Run("should unmarshal the credentials before a request", func(tt *testing.T) {
+		result := Request{}
+		if err := result.Unmarshal([]byte("AUTH alice s3cret pass\nSET foo bar\nbaz")); err != nil {
+			tt.Fatal(err)
+		}
+
+		if result.Credentials == nil || *result.Credentials != (Credentials{User: "alice", Password: "s3cret pass"}) {
+			tt.Errorf("expected the credentials of alice but got %+v", result.Credentials)
+		}
+		if result.Operation != OperationSet || result.Key != "foo" || result.Value != "bar\nbaz" {
+			tt.Errorf("expected 'SET foo bar\\nbaz' but got '%s'", result)
+		}
+	})
+
+	t.Run("should unmarshal an AUTH operation", func(tt *testing.T) {
+		result := Request{}
+		if err := result.Unmarshal([]byte("AUTH alice s3cret\n")); err != nil {
+			tt.Fatal(err)
+		}
+
+		if result.Operation != OperationAuth || result.Key != "alice" || result.Value != "s3cret" || result.Credentials != nil {
+			tt.Errorf("expected 'AUTH alice' with the password but got %+v", result)
+		}
+		if result.String() != "AUTH alice" {
+			tt.Errorf("expected the password to be hidden but got '%s'", result)
+		}
+	})
 }
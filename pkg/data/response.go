@@ -16,7 +16,10 @@ const (
 	ResponseStatusError = "ERROR"
 )
 
-var ErrInvalidResponseStatus = errors.New("status must be OK or ERROR")
+var (
+	ErrInvalidResponseStatus = errors.New("status must be OK or ERROR")
+	ErrKeyNotFound           = errors.New("key not found") // message of a GET of a key that is not stored
+)
 
 type Response struct {
 	Status  ResponseStatus
@@ -34,13 +37,9 @@ func (r Response) Marshal() ([]byte, error) {
 		return nil, ErrInvalidResponseStatus
 	}
 
-	for _, v := range r.Status {
-		data = append(data, byte(v))
-	}
+	data = append(data, r.Status...)
 	data = append(data, byte(' '))
-	for _, b := range r.Message {
-		data = append(data, byte(b))
-	}
+	data = append(data, r.Message...)
 
 	return data, nil
 }
@@ -31,6 +31,22 @@ func TestResponseMarshal(t *testing.T) {
 			tt.Errorf("expect a valid response but got '%s'", data)
 		}
 	})
+
+	t.Run("should marshal a message with non-ASCII bytes as it is", func(tt *testing.T) {
+		res := Response{
+			Status:  ResponseStatusOK,
+			Message: "caf\u00e9 \xff\x00",
+		}
+
+		data, err := res.Marshal()
+		if err != nil {
+			tt.Error(err)
+		}
+
+		if string(data) != res.String() {
+			tt.Errorf("expect '%q' but got '%q'", res.String(), data)
+		}
+	})
 }
 
 func TestResponseUnmarshal(t *testing.T) {
@@ -0,0 +1,50 @@
+package data
+
+import (
+	"errors"
+	"strings"
+)
+
+const (
+	RolePrimary  = "primary"
+	RoleReplica  = "replica"
+	RoleSentinel = "sentinel"
+)
+
+var ErrInvalidRole = errors.New("the role must be a name followed by KEY=VALUE fields")
+
+// Role is the message of a ROLE response: the name of the role followed by
+// KEY=VALUE fields, e.g. "replica primary=127.0.0.1:8595 state=streaming".
+type Role struct {
+	Name   string
+	Fields map[string]string
+}
+
+// ParseRole parses the message of a ROLE response.
+func ParseRole(message string) (Role, error) {
+	fields := strings.Fields(message)
+	if len(fields) == 0 {
+		return Role{}, ErrInvalidRole
+	}
+
+	parsed, ok := parseFields(fields[1:])
+	if !ok {
+		return Role{}, ErrInvalidRole
+	}
+
+	return Role{Name: fields[0], Fields: parsed}, nil
+}
+
+// parseFields parses KEY=VALUE fields.
+func parseFields(fields []string) (map[string]string, bool) {
+	parsed := make(map[string]string, len(fields))
+	for _, field := range fields {
+		key, value, found := strings.Cut(field, "=")
+		if !found || key == "" {
+			return nil, false
+		}
+		parsed[key] = value
+	}
+
+	return parsed, true
+}
@@ -0,0 +1,31 @@
+package data
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestParseRole(t *testing.T) {
+	t.Run("should parse the name and the fields of a role", func(tt *testing.T) {
+		role, err := ParseRole("replica primary=127.0.0.1:8595 state=streaming offset=10")
+		if err != nil {
+			tt.Fatal(err)
+		}
+
+		if role.Name != RoleReplica {
+			tt.Errorf("expected the name to be '%s' but got '%s'", RoleReplica, role.Name)
+		}
+		if role.Fields["primary"] != "127.0.0.1:8595" || role.Fields["offset"] != "10" {
+			tt.Errorf("expected the fields to be parsed but got %v", role.Fields)
+		}
+	})
+
+	t.Run("should return an error if a field has no value", func(tt *testing.T) {
+		if _, err := ParseRole("primary offset"); !errors.Is(err, ErrInvalidRole) {
+			tt.Fatalf("expected ErrInvalidRole but received '%v'", err)
+		}
+		if _, err := ParseRole(""); !errors.Is(err, ErrInvalidRole) {
+			tt.Fatalf("expected ErrInvalidRole but received '%v'", err)
+		}
+	})
+}
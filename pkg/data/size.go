@@ -0,0 +1,101 @@
+package data
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Parts of a request limited by the size of a server.
+const (
+	SizeKey     = "key"
+	SizeValue   = "value"
+	SizeRequest = "request"
+)
+
+// ErrTooLarge is matched by every TooLargeError.
+var ErrTooLarge = errors.New("too large")
+
+// TooLargeError is the error of a key, a value or a whole request larger than the
+// limit of a server, in bytes.
+type TooLargeError struct {
+	Part  string // SizeKey, SizeValue or SizeRequest
+	Limit int64
+}
+
+func (e TooLargeError) Error() string {
+	return fmt.Sprintf("the %s is too large, max=%d bytes", e.Part, e.Limit)
+}
+
+func (e TooLargeError) Is(target error) bool {
+	return target == ErrTooLarge
+}
+
+// ParseTooLarge parses the message of an ERROR response to a request over a limit.
+func ParseTooLarge(message string) (TooLargeError, bool) {
+	rest, found := strings.CutPrefix(message, "the ")
+	if !found {
+		return TooLargeError{}, false
+	}
+	part, limit, found := strings.Cut(rest, " is too large, max=")
+	if !found || (part != SizeKey && part != SizeValue && part != SizeRequest) {
+		return TooLargeError{}, false
+	}
+	bytes, err := strconv.ParseInt(strings.TrimSuffix(limit, " bytes"), 10, 64)
+	if err != nil {
+		return TooLargeError{}, false
+	}
+
+	return TooLargeError{Part: part, Limit: bytes}, true
+}
+
+// CheckSize returns a TooLargeError if the key or the value of a SET is larger than
+// the limits. A zero limit is no limit.
+func (r Request) CheckSize(maxKeyBytes int64, maxValueBytes int64) error {
+	if maxKeyBytes > 0 && int64(len(r.Key)) > maxKeyBytes {
+		return TooLargeError{Part: SizeKey, Limit: maxKeyBytes}
+	}
+	if maxValueBytes > 0 && r.Operation == OperationSet && int64(len(r.Value)) > maxValueBytes {
+		return TooLargeError{Part: SizeValue, Limit: maxValueBytes}
+	}
+	return nil
+}
+
+// RequestHead is the start of a request up to its value.
+type RequestHead struct {
+	Operation Operation
+	Key       []byte
+	Size      int // bytes before the value, the AUTH and CLIENT SETNAME lines included
+}
+
+// ParseHead parses the start of a request that is still being received, so its key and
+// value can be checked before the whole request is. It returns false until the key is
+// followed by a space, with the part of the key received so far if any.
+func ParseHead(prefix []byte) (RequestHead, bool) {
+	request := prefix
+	for _, line := range []string{OperationAuth.String() + " ", OperationClient.String() + " " + ClientSetName + " "} {
+		if !bytes.HasPrefix(request, []byte(line)) {
+			continue
+		}
+		_, rest, found := bytes.Cut(request, []byte("\n"))
+		if !found {
+			return RequestHead{}, false // the line or the request is still being received
+		}
+		request = rest
+	}
+
+	operation, rest, found := bytes.Cut(request, []byte(" "))
+	if !found {
+		return RequestHead{}, false
+	}
+	head := RequestHead{Operation: Operation(operation)}
+	head.Key, _, found = bytes.Cut(rest, []byte(" "))
+	if !found {
+		head.Key = bytes.TrimSuffix(head.Key, []byte("\n"))
+		return head, false
+	}
+	head.Size = len(prefix) - len(rest) + len(head.Key) + 1
+	return head, true
+}
@@ -0,0 +1,79 @@
+package data
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestParseTooLarge(t *testing.T) {
+	t.Run("should parse the message of a TooLargeError", func(tt *testing.T) {
+		err := TooLargeError{Part: SizeValue, Limit: 1024}
+
+		parsed, ok := ParseTooLarge(err.Error())
+		if !ok || parsed != err {
+			tt.Errorf("expected %+v but got %+v", err, parsed)
+		}
+		if !errors.Is(parsed, ErrTooLarge) {
+			tt.Error("expected the error to be ErrTooLarge")
+		}
+	})
+
+	t.Run("should not parse other messages", func(tt *testing.T) {
+		for _, message := range []string{ErrInvalidFormat.Error(), "the file is too large, max=10 bytes", "the key is too large, max=ten bytes"} {
+			if _, ok := ParseTooLarge(message); ok {
+				tt.Errorf("expected '%s' not to be parsed", message)
+			}
+		}
+	})
+}
+
+func TestCheckSize(t *testing.T) {
+	t.Run("should return an error if the key is too large", func(tt *testing.T) {
+		req := Request{Operation: OperationGet, Key: strings.Repeat("k", 11)}
+
+		var tooLarge TooLargeError
+		if err := req.CheckSize(10, 10); !errors.As(err, &tooLarge) || tooLarge.Part != SizeKey {
+			tt.Errorf("expected the key to be too large but got %v", err)
+		}
+	})
+
+	t.Run("should return an error if the value of a SET is too large", func(tt *testing.T) {
+		req := Request{Operation: OperationSet, Key: "foo", Value: strings.Repeat("v", 11)}
+
+		var tooLarge TooLargeError
+		if err := req.CheckSize(10, 10); !errors.As(err, &tooLarge) || tooLarge.Part != SizeValue {
+			tt.Errorf("expected the value to be too large but got %v", err)
+		}
+	})
+
+	t.Run("should not limit the snapshot of a RESTORE nor disabled limits", func(tt *testing.T) {
+		if err := (Request{Operation: OperationRestore, Key: StreamKey, Value: strings.Repeat("v", 11)}).CheckSize(10, 10); err != nil {
+			tt.Errorf("expected no error but got %v", err)
+		}
+		if err := (Request{Operation: OperationSet, Key: strings.Repeat("k", 11), Value: "bar"}).CheckSize(0, 0); err != nil {
+			tt.Errorf("expected no error but got %v", err)
+		}
+	})
+}
+
+func TestParseHead(t *testing.T) {
+	t.Run("should parse the operation, the key and where the value starts", func(tt *testing.T) {
+		for _, prefix := range []string{"SET foo bar", "AUTH alice s3cret\nSET foo bar", "AUTH alice s3cret\nCLIENT SETNAME worker\nSET foo b"} {
+			head, complete := ParseHead([]byte(prefix))
+			if !complete || head.Operation != OperationSet || string(head.Key) != "foo" || prefix[head.Size] != 'b' {
+				tt.Errorf("expected the head of a SET of foo in '%s' but got %+v, %t", prefix, head, complete)
+			}
+		}
+	})
+
+	t.Run("should return the part of the key received so far", func(tt *testing.T) {
+		head, complete := ParseHead([]byte("GET fo"))
+		if complete || head.Operation != OperationGet || string(head.Key) != "fo" {
+			tt.Errorf("expected an incomplete head with the key fo but got %+v, %t", head, complete)
+		}
+		if _, complete := ParseHead([]byte("AUTH alice s3")); complete {
+			tt.Error("expected the head to be incomplete until the AUTH line is received")
+		}
+	})
+}
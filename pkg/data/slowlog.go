@@ -0,0 +1,78 @@
+package data
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The keys of the SLOWLOG requests.
+const (
+	SlowLogGet   = "GET"   // returns the newest entries
+	SlowLogLen   = "LEN"   // returns the number of entries
+	SlowLogReset = "RESET" // removes every entry
+)
+
+var (
+	ErrInvalidSlowLogCommand = errors.New("the key of a SLOWLOG must be GET, LEN or RESET")
+	ErrInvalidSlowLogCount   = errors.New("the number of slow log entries must be a positive integer")
+	ErrInvalidSlowLogEntry   = errors.New("a slow log entry must be ID TIME DURATION ADDR OPERATION KEY")
+)
+
+// SlowLogEntry is a request that took longer than the slow log threshold.
+type SlowLogEntry struct {
+	ID        int64 // increasing number of the entry
+	Time      time.Time
+	Duration  time.Duration
+	Addr      string // address of the client
+	Operation Operation
+	Key       string
+}
+
+// String returns the entry as a line of a SLOWLOG GET response. The key is quoted, so
+// it can be empty or have any character.
+func (e SlowLogEntry) String() string {
+	return fmt.Sprintf("%d %s %s %s %s %s",
+		e.ID, e.Time.UTC().Format(time.RFC3339Nano), e.Duration, e.Addr, e.Operation, strconv.Quote(e.Key))
+}
+
+// ParseSlowLog parses the message of a SLOWLOG GET response, an entry per line.
+func ParseSlowLog(message string) ([]SlowLogEntry, error) {
+	entries := make([]SlowLogEntry, 0)
+	for line := range strings.Lines(message) {
+		fields := strings.SplitN(strings.TrimSuffix(line, "\n"), " ", 6)
+		if len(fields) != 6 {
+			return nil, ErrInvalidSlowLogEntry
+		}
+
+		id, err := strconv.ParseInt(fields[0], 10, 64)
+		if err != nil {
+			return nil, ErrInvalidSlowLogEntry
+		}
+		t, err := time.Parse(time.RFC3339Nano, fields[1])
+		if err != nil {
+			return nil, ErrInvalidSlowLogEntry
+		}
+		duration, err := time.ParseDuration(fields[2])
+		if err != nil {
+			return nil, ErrInvalidSlowLogEntry
+		}
+		key, err := strconv.Unquote(fields[5])
+		if err != nil {
+			return nil, ErrInvalidSlowLogEntry
+		}
+
+		entries = append(entries, SlowLogEntry{
+			ID:        id,
+			Time:      t,
+			Duration:  duration,
+			Addr:      fields[3],
+			Operation: Operation(fields[4]),
+			Key:       key,
+		})
+	}
+
+	return entries, nil
+}
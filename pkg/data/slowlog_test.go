@@ -0,0 +1,51 @@
+package data
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSlowLogEntry(t *testing.T) {
+	t.Run("should parse the entries it formats", func(tt *testing.T) {
+		entries := []SlowLogEntry{
+			{ID: 2, Time: time.Unix(1700000000, 500).UTC(), Duration: time.Millisecond * 15, Addr: "127.0.0.1:5000", Operation: OperationGet, Key: "foo \"bar\""},
+			{ID: 1, Time: time.Unix(1700000000, 0).UTC(), Duration: time.Second, Addr: "[::1]:5000", Operation: OperationInfo},
+		}
+
+		lines := make([]string, 0, len(entries))
+		for _, entry := range entries {
+			lines = append(lines, entry.String())
+		}
+		parsed, err := ParseSlowLog(strings.Join(lines, "\n"))
+		if err != nil {
+			tt.Fatal(err)
+		}
+
+		if len(parsed) != len(entries) {
+			tt.Fatalf("expected %d entries but got %d", len(entries), len(parsed))
+		}
+		for i := range entries {
+			if parsed[i] != entries[i] {
+				tt.Errorf("expected %+v but got %+v", entries[i], parsed[i])
+			}
+		}
+	})
+
+	t.Run("should parse an empty slow log", func(tt *testing.T) {
+		entries, err := ParseSlowLog("")
+		if err != nil {
+			tt.Fatal(err)
+		}
+		if len(entries) != 0 {
+			tt.Errorf("expected no entries but got %d", len(entries))
+		}
+	})
+
+	t.Run("should return an error if an entry is incomplete", func(tt *testing.T) {
+		if _, err := ParseSlowLog("1 2023-11-14T22:13:20Z 1s 127.0.0.1:5000 GET"); !errors.Is(err, ErrInvalidSlowLogEntry) {
+			tt.Fatalf("expected ErrInvalidSlowLogEntry but received '%v'", err)
+		}
+	})
+}
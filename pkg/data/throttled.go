@@ -0,0 +1,44 @@
+package data
+
+import "errors"
+
+// Limits of a server that throttle the clients.
+const (
+	LimitConnections   = "connections"    // -max-connections
+	LimitIPConnections = "ip-connections" // -max-connections-per-ip
+	LimitRate          = "rate"           // -rate-limit
+)
+
+// ErrThrottled is matched by every ThrottledError.
+var ErrThrottled = errors.New("throttled")
+
+// throttledMessages describe the limits in the messages of the ERROR responses.
+var throttledMessages = map[string]string{
+	LimitConnections:   "the server has too many connections",
+	LimitIPConnections: "too many connections from the address",
+	LimitRate:          "too many requests from the address, retry later",
+}
+
+// ThrottledError is the error of a request rejected by a connection or rate limit of
+// a server.
+type ThrottledError struct {
+	Limit string // LimitConnections, LimitIPConnections or LimitRate
+}
+
+func (e ThrottledError) Error() string {
+	return ErrThrottled.Error() + ": " + throttledMessages[e.Limit]
+}
+
+func (e ThrottledError) Is(target error) bool {
+	return target == ErrThrottled
+}
+
+// ParseThrottled parses the message of an ERROR response to a throttled request.
+func ParseThrottled(message string) (ThrottledError, bool) {
+	for limit := range throttledMessages {
+		if throttled := (ThrottledError{Limit: limit}); message == throttled.Error() {
+			return throttled, true
+		}
+	}
+	return ThrottledError{}, false
+}
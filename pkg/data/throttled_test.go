@@ -0,0 +1,30 @@
+package data
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestParseThrottled(t *testing.T) {
+	t.Run("should parse the message of a ThrottledError", func(tt *testing.T) {
+		for _, limit := range []string{LimitConnections, LimitIPConnections, LimitRate} {
+			err := ThrottledError{Limit: limit}
+
+			parsed, ok := ParseThrottled(err.Error())
+			if !ok || parsed != err {
+				tt.Errorf("expected %+v but got %+v", err, parsed)
+			}
+			if !errors.Is(parsed, ErrThrottled) {
+				tt.Error("expected the error to be ErrThrottled")
+			}
+		}
+	})
+
+	t.Run("should not parse other messages", func(tt *testing.T) {
+		for _, message := range []string{ErrKeyNotFound.Error(), "throttled: too many cats", "throttled requests are not allowed"} {
+			if _, ok := ParseThrottled(message); ok {
+				tt.Errorf("expected '%s' not to be parsed", message)
+			}
+		}
+	})
+}
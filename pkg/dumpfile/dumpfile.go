@@ -0,0 +1,473 @@
+// Package dumpfile reads and writes the dumps cacher persists on disk.
+package dumpfile
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"hash/crc32"
+	"io"
+	"time"
+
+	"github.com/JorgeLNJunior/cacher/pkg/encryption"
+)
+
+// A dump starts with a fixed size header followed by the encoded data:
+//
+//	magic    [8]byte "CACHERDB"
+//	version  uint8   format of the data, see Format
+//	checksum uint32  CRC-32C of the data
+//	length   uint64  length of the data in bytes
+//
+// The binary format is a sequence of records:
+//
+//	flags  uint8   see the record flag constants
+//	key    uvarint length followed by the key bytes
+//	value  uvarint length followed by the value bytes, deflated if recordCompressed is set
+//	expiry varint  unix nanoseconds, absent if recordNoExpiry is set
+const (
+	Magic          = "CACHERDB"
+	dumpHeaderSize = len(Magic) + 1 + 4 + 8
+
+	dumpVersionJSON   uint8 = 1
+	dumpVersionBinary uint8 = 2
+
+	recordCompressed uint8 = 1 << 0
+	recordNoExpiry   uint8 = 1 << 1
+	recordTTL        uint8 = 1 << 2 // the expiry has been set explicitly, see Item.TTL
+
+	minCompressedValueSize = 64 // smaller values rarely shrink
+)
+
+// Item is a value stored in a dump.
+type Item struct {
+	Value  string
+	Expiry time.Time
+	TTL    bool `json:",omitempty"` // whether the expiry has been set by an EXP instead of defaulted
+}
+
+// ExpiredAt returns whether an item is expired at the given time.
+func (i Item) ExpiredAt(t time.Time) bool {
+	return t.After(i.Expiry)
+}
+
+// Format is the encoding of the data in a dump.
+type Format string
+
+const (
+	FormatBinary Format = "binary"
+	FormatJSON   Format = "json" // slower and bigger, meant to be exported
+)
+
+func (f Format) Valid() bool {
+	switch {
+	case f == FormatBinary:
+		return true
+	case f == FormatJSON:
+		return true
+	default:
+		return false
+	}
+}
+
+func (f Format) version() uint8 {
+	if f == FormatJSON {
+		return dumpVersionJSON
+	}
+	return dumpVersionBinary
+}
+
+var (
+	ErrInvalidFormat      = errors.New("dump format must be binary or json")
+	ErrInvalidHeader      = errors.New("the dump header is invalid")
+	ErrUnsupportedVersion = errors.New("the dump format version is not supported")
+	ErrChecksumMismatch   = errors.New("the dump checksum does not match its data, the file is corrupted")
+	ErrLengthMismatch     = errors.New("the dump length does not match its header, the file is truncated")
+	ErrInvalidRecord      = errors.New("the dump has an invalid record")
+	ErrValueTooLarge      = errors.New("the dump has a value larger than the max value size")
+
+	errDumpWithoutHeader = errors.New("the dump has no header")
+	crc32cTable          = crc32.MakeTable(crc32.Castagnoli)
+)
+
+type dumpHeader struct {
+	Version  uint8
+	Checksum uint32
+	Length   uint64
+}
+
+// Write writes the header and the data to w. The header is written last,
+// once the checksum is known, so w must be positioned at the start of the file.
+// Compression only applies to the binary format.
+func Write(w io.WriteSeeker, dump map[string]Item, format Format, compress bool) error {
+	if _, err := w.Seek(int64(dumpHeaderSize), io.SeekStart); err != nil {
+		return err
+	}
+
+	hash := crc32.New(crc32cTable)
+	counter := &countingWriter{}
+	out := bufio.NewWriter(io.MultiWriter(w, hash, counter))
+
+	var err error
+	if format == FormatJSON {
+		err = json.NewEncoder(out).Encode(dump)
+	} else {
+		err = encodeDumpRecords(out, dump, compress)
+	}
+	if err != nil {
+		return err
+	}
+	if err := out.Flush(); err != nil {
+		return err
+	}
+
+	if _, err := w.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	header := dumpHeader{Version: format.version(), Checksum: hash.Sum32(), Length: counter.n}
+	if _, err := w.Write([]byte(Magic)); err != nil {
+		return err
+	}
+	return binary.Write(w, binary.BigEndian, header)
+}
+
+// WriteBuffered works like Write but encodes the dump in memory before writing it, so
+// w doesn't need to be seekable and the dump never touches the disk.
+func WriteBuffered(w io.Writer, dump map[string]Item, format Format, compress bool) error {
+	buffer := &seekBuffer{}
+	if err := Write(buffer, dump, format, compress); err != nil {
+		return err
+	}
+
+	_, err := w.Write(buffer.data)
+	return err
+}
+
+func encodeDumpRecords(w *bufio.Writer, dump map[string]Item, compress bool) error {
+	varint := make([]byte, binary.MaxVarintLen64)
+	compressed := bytes.NewBuffer(nil)
+	compressor, err := flate.NewWriter(compressed, flate.BestSpeed)
+	if err != nil {
+		return err
+	}
+
+	writeString := func(s string) error {
+		n := binary.PutUvarint(varint, uint64(len(s)))
+		if _, err := w.Write(varint[:n]); err != nil {
+			return err
+		}
+		_, err := w.WriteString(s)
+		return err
+	}
+
+	for key, item := range dump {
+		var flags uint8
+		value := item.Value
+
+		if compress && len(value) >= minCompressedValueSize {
+			compressed.Reset()
+			compressor.Reset(compressed)
+			if _, err := compressor.Write([]byte(value)); err != nil {
+				return err
+			}
+			if err := compressor.Close(); err != nil {
+				return err
+			}
+			if compressed.Len() < len(value) {
+				flags |= recordCompressed
+				value = compressed.String()
+			}
+		}
+		if item.Expiry.IsZero() {
+			flags |= recordNoExpiry
+		}
+		if item.TTL {
+			flags |= recordTTL
+		}
+
+		if err := w.WriteByte(flags); err != nil {
+			return err
+		}
+		if err := writeString(key); err != nil {
+			return err
+		}
+		if err := writeString(value); err != nil {
+			return err
+		}
+		if flags&recordNoExpiry == 0 {
+			n := binary.PutVarint(varint, item.Expiry.UnixNano())
+			if _, err := w.Write(varint[:n]); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// readPlain verifies a dump and then reads it, so a corrupted dump is never decoded.
+// Dumps written before the header was introduced are plain JSON and are read as they are.
+func readPlain(r io.ReadSeeker, maxValueSize int64) (map[string]Item, error) {
+	header, body, err := readDumpHeader(r)
+	if errors.Is(err, errDumpWithoutHeader) {
+		return decodeDumpJSON(body)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	hash := crc32.New(crc32cTable)
+	read, err := io.Copy(hash, io.LimitReader(r, int64(header.Length)))
+	if err != nil {
+		return nil, err
+	}
+	if uint64(read) != header.Length {
+		return nil, ErrLengthMismatch
+	}
+	if hash.Sum32() != header.Checksum {
+		return nil, ErrChecksumMismatch
+	}
+
+	if _, err := r.Seek(int64(dumpHeaderSize), io.SeekStart); err != nil {
+		return nil, err
+	}
+	data := &io.LimitedReader{R: r, N: int64(header.Length)}
+	if header.Version == dumpVersionJSON {
+		return decodeDumpJSON(bufio.NewReader(data))
+	}
+	return decodeDumpRecords(data, maxValueSize)
+}
+
+// readDumpHeader reads the header of a dump. If the dump has no header it returns
+// errDumpWithoutHeader and a reader with the whole dump.
+func readDumpHeader(r io.Reader) (dumpHeader, io.Reader, error) {
+	magic := make([]byte, len(Magic))
+	n, err := io.ReadFull(r, magic)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+		return dumpHeader{}, nil, err
+	}
+	if string(magic[:n]) != Magic {
+		return dumpHeader{}, io.MultiReader(bytes.NewReader(magic[:n]), r), errDumpWithoutHeader
+	}
+
+	header := dumpHeader{}
+	if err := binary.Read(r, binary.BigEndian, &header); err != nil {
+		return dumpHeader{}, nil, ErrInvalidHeader
+	}
+	if header.Version != dumpVersionJSON && header.Version != dumpVersionBinary {
+		return dumpHeader{}, nil, ErrUnsupportedVersion
+	}
+
+	return header, r, nil
+}
+
+func decodeDumpJSON(r io.Reader) (map[string]Item, error) {
+	dump := make(map[string]Item)
+	if err := json.NewDecoder(r).Decode(&dump); err != nil {
+		switch {
+		case errors.Is(err, io.EOF):
+			return dump, nil // file is empty
+		case errors.Is(err, io.ErrUnexpectedEOF):
+			return nil, ErrLengthMismatch
+		default:
+			return nil, err
+		}
+	}
+	return dump, nil
+}
+
+// decodeDumpRecords decodes the records of a binary dump. The lengths in the records
+// are checked against the bytes left before allocating, since a dump with a valid
+// checksum can still be crafted by a client and sent with RESTORE.
+func decodeDumpRecords(data *io.LimitedReader, maxValueSize int64) (map[string]Item, error) {
+	dump := make(map[string]Item)
+	r := bufio.NewReader(data)
+	var decompressor io.ReadCloser
+
+	readBytes := func() ([]byte, error) {
+		length, err := binary.ReadUvarint(r)
+		if err != nil {
+			return nil, ErrInvalidRecord
+		}
+		if left := uint64(data.N) + uint64(r.Buffered()); length > left {
+			return nil, ErrInvalidRecord
+		}
+		b := make([]byte, length)
+		if _, err := io.ReadFull(r, b); err != nil {
+			return nil, ErrInvalidRecord
+		}
+		return b, nil
+	}
+
+	for {
+		flags, err := r.ReadByte()
+		if errors.Is(err, io.EOF) {
+			return dump, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		key, err := readBytes()
+		if err != nil {
+			return nil, err
+		}
+		value, err := readBytes()
+		if err != nil {
+			return nil, err
+		}
+
+		if flags&recordCompressed != 0 {
+			if decompressor == nil {
+				decompressor = flate.NewReader(bytes.NewReader(value))
+			} else if err := decompressor.(flate.Resetter).Reset(bytes.NewReader(value), nil); err != nil {
+				return nil, err
+			}
+			if value, err = readValue(decompressor, maxValueSize); err != nil {
+				return nil, err
+			}
+		}
+
+		item := Item{Value: string(value), TTL: flags&recordTTL != 0}
+		if flags&recordNoExpiry == 0 {
+			nanos, err := binary.ReadVarint(r)
+			if err != nil {
+				return nil, ErrInvalidRecord
+			}
+			item.Expiry = time.Unix(0, nanos)
+		}
+
+		dump[string(key)] = item
+	}
+}
+
+// readValue reads a decompressed value, refusing the ones larger than maxValueSize.
+func readValue(decompressor io.Reader, maxValueSize int64) ([]byte, error) {
+	if maxValueSize > 0 {
+		decompressor = io.LimitReader(decompressor, maxValueSize+1)
+	}
+	value, err := io.ReadAll(decompressor)
+	if err != nil {
+		return nil, ErrInvalidRecord
+	}
+	if maxValueSize > 0 && int64(len(value)) > maxValueSize {
+		return nil, ErrValueTooLarge
+	}
+	return value, nil
+}
+
+type countingWriter struct {
+	n uint64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	w.n += uint64(len(p))
+	return len(p), nil
+}
+
+// An encrypted dump is a whole dump sealed by a Keyring:
+//
+//	magic   [8]byte "CACHEREN"
+//	version uint8
+//	sealed  the key id, the nonce and the encrypted dump
+//
+// The dump is encoded in memory before being encrypted so it never touches the disk in plaintext.
+const (
+	encryptedDumpMagic   = "CACHEREN"
+	encryptedDumpVersion = 1
+)
+
+// WriteEncrypted encrypts a dump with the first key of the keyring and writes it to w.
+func WriteEncrypted(w io.Writer, dump map[string]Item, format Format, compress bool, keyring *encryption.Keyring) error {
+	plaintext := &seekBuffer{}
+	if err := Write(plaintext, dump, format, compress); err != nil {
+		return err
+	}
+
+	header := append([]byte(encryptedDumpMagic), encryptedDumpVersion)
+	sealed, err := keyring.Seal(plaintext.data, header)
+	if err != nil {
+		return err
+	}
+
+	if _, err := w.Write(header); err != nil {
+		return err
+	}
+	_, err = w.Write(sealed)
+	return err
+}
+
+// Read verifies and reads a dump written by Write, WriteBuffered or WriteEncrypted. keyring may be nil
+// if the dump is not encrypted. A compressed value larger than maxValueSize bytes once decompressed
+// is refused with ErrValueTooLarge, so a decompression bomb is never inflated. 0 disables it.
+func Read(r io.ReadSeeker, keyring *encryption.Keyring, maxValueSize int64) (map[string]Item, error) {
+	magic := make([]byte, len(encryptedDumpMagic))
+	n, err := io.ReadFull(r, magic)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	if string(magic[:n]) != encryptedDumpMagic {
+		return readPlain(r, maxValueSize)
+	}
+
+	if keyring == nil {
+		return nil, encryption.ErrEncrypted
+	}
+
+	content, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	headerSize := len(encryptedDumpMagic) + 1
+	if len(content) < headerSize {
+		return nil, ErrInvalidHeader
+	}
+	if content[len(encryptedDumpMagic)] != encryptedDumpVersion {
+		return nil, ErrUnsupportedVersion
+	}
+
+	plaintext, err := keyring.Open(content[headerSize:], content[:headerSize])
+	if err != nil {
+		return nil, err
+	}
+	return readPlain(bytes.NewReader(plaintext), maxValueSize)
+}
+
+// seekBuffer is an in-memory io.WriteSeeker.
+type seekBuffer struct {
+	data   []byte
+	offset int
+}
+
+func (b *seekBuffer) Write(p []byte) (int, error) {
+	if end := b.offset + len(p); end > len(b.data) {
+		b.data = append(b.data, make([]byte, end-len(b.data))...)
+	}
+	n := copy(b.data[b.offset:], p)
+	b.offset += n
+	return n, nil
+}
+
+func (b *seekBuffer) Seek(offset int64, whence int) (int64, error) {
+	var next int64
+	switch whence {
+	case io.SeekStart:
+		next = offset
+	case io.SeekCurrent:
+		next = int64(b.offset) + offset
+	case io.SeekEnd:
+		next = int64(len(b.data)) + offset
+	}
+	if next < 0 {
+		return 0, errors.New("seek before the start of the buffer")
+	}
+
+	b.offset = int(next)
+	return next, nil
+}
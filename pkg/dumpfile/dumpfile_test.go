@@ -0,0 +1,154 @@
+package dumpfile
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+)
+
+var testEpoch = time.Unix(1_700_000_000, 0)
+
+func TestWriteAndRead(t *testing.T) {
+	dump := map[string]Item{
+		"foo":          {Value: "bar", Expiry: testEpoch, TTL: true},
+		"compressible": {Value: strings.Repeat("a", 1024), Expiry: testEpoch},
+		"binary\xff":   {Value: "\x00\xfe\xff", Expiry: testEpoch.Add(time.Nanosecond)},
+		"no expiry":    {Value: "baz"},
+	}
+
+	for _, tc := range []struct {
+		format   Format
+		compress bool
+	}{
+		{FormatBinary, false},
+		{FormatBinary, true},
+		{FormatJSON, false},
+	} {
+		t.Run(fmt.Sprintf("should round trip a %s dump with compression %t", tc.format, tc.compress), func(tt *testing.T) {
+			file, err := os.Create(filepath.Join(tt.TempDir(), "dump"))
+			if err != nil {
+				tt.Fatal(err)
+			}
+			defer file.Close()
+
+			if err := Write(file, dump, tc.format, tc.compress); err != nil {
+				tt.Fatal(err)
+			}
+			if _, err := file.Seek(0, 0); err != nil {
+				tt.Fatal(err)
+			}
+			restored, err := Read(file, nil, 0)
+			if err != nil {
+				tt.Fatal(err)
+			}
+
+			if len(restored) != len(dump) {
+				tt.Fatalf("expected %d keys but got %d", len(dump), len(restored))
+			}
+			for key, item := range dump {
+				// JSON can't represent invalid UTF-8
+				if tc.format == FormatJSON && (!utf8.ValidString(key) || !utf8.ValidString(item.Value)) {
+					continue
+				}
+
+				got, found := restored[key]
+				if !found {
+					tt.Fatalf("the key '%q' has not been restored", key)
+				}
+				if got.Value != item.Value {
+					tt.Errorf("expected '%q' to have value '%q' but got '%q'", key, item.Value, got.Value)
+				}
+				if !got.Expiry.Equal(item.Expiry) {
+					tt.Errorf("expected '%q' to expire at '%s' but got '%s'", key, item.Expiry, got.Expiry)
+				}
+				if got.TTL != item.TTL {
+					tt.Errorf("expected '%q' to have TTL %t but got %t", key, item.TTL, got.TTL)
+				}
+			}
+		})
+	}
+
+	t.Run("should round trip a dump buffered in memory", func(tt *testing.T) {
+		buffer := &bytes.Buffer{}
+		if err := WriteBuffered(buffer, dump, FormatBinary, true); err != nil {
+			tt.Fatal(err)
+		}
+
+		restored, err := Read(bytes.NewReader(buffer.Bytes()), nil, 0)
+		if err != nil {
+			tt.Fatal(err)
+		}
+		if len(restored) != len(dump) || restored["binary\xff"].Value != dump["binary\xff"].Value {
+			tt.Fatalf("expected %+v but got %+v", dump, restored)
+		}
+	})
+
+	t.Run("should compress values that shrink", func(tt *testing.T) {
+		dir := tt.TempDir()
+		sizes := make(map[bool]int64)
+
+		for _, compress := range []bool{false, true} {
+			file, err := os.Create(filepath.Join(dir, fmt.Sprint(compress)))
+			if err != nil {
+				tt.Fatal(err)
+			}
+			if err := Write(file, dump, FormatBinary, compress); err != nil {
+				tt.Fatal(err)
+			}
+			info, err := file.Stat()
+			if err != nil {
+				tt.Fatal(err)
+			}
+			file.Close()
+			sizes[compress] = info.Size()
+		}
+
+		if sizes[true] >= sizes[false] {
+			tt.Fatalf("expected the compressed dump to be smaller than %d bytes but got %d", sizes[false], sizes[true])
+		}
+	})
+}
+
+// checksummedDump returns a dump of binary records with a valid header.
+func checksummedDump(t *testing.T, records []byte) []byte {
+	t.Helper()
+
+	header := dumpHeader{Version: dumpVersionBinary, Checksum: crc32.Checksum(records, crc32cTable), Length: uint64(len(records))}
+	dump := bytes.NewBufferString(Magic)
+	if err := binary.Write(dump, binary.BigEndian, header); err != nil {
+		t.Fatal(err)
+	}
+	dump.Write(records)
+	return dump.Bytes()
+}
+
+func TestReadUntrusted(t *testing.T) {
+	t.Run("should refuse a length larger than the records", func(tt *testing.T) {
+		records := []byte{recordNoExpiry, 3, 'f', 'o', 'o'}
+		records = binary.AppendUvarint(records, 1<<50)
+
+		if _, err := Read(bytes.NewReader(checksummedDump(tt, records)), nil, 0); !errors.Is(err, ErrInvalidRecord) {
+			tt.Errorf("expected ErrInvalidRecord but got %v", err)
+		}
+	})
+
+	t.Run("should refuse a compressed value larger than the max value size", func(tt *testing.T) {
+		buffer := &bytes.Buffer{}
+		bomb := map[string]Item{"bomb": {Value: strings.Repeat("a", 1025)}}
+		if err := WriteBuffered(buffer, bomb, FormatBinary, true); err != nil {
+			tt.Fatal(err)
+		}
+
+		if _, err := Read(bytes.NewReader(buffer.Bytes()), nil, 1024); !errors.Is(err, ErrValueTooLarge) {
+			tt.Errorf("expected ErrValueTooLarge but got %v", err)
+		}
+	})
+}
@@ -0,0 +1,155 @@
+// Package encryption encrypts the data cacher stores at rest.
+package encryption
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+const (
+	encryptionKeySize   = 32 // AES-256
+	encryptionKeyIDSize = 4
+)
+
+var (
+	ErrInvalidEncryptionKey = errors.New("encryption keys must be 32 bytes encoded in hex or base64")
+	ErrNoEncryptionKey      = errors.New("no encryption key has been provided")
+	ErrUnknownEncryptionKey = errors.New("the data has been encrypted with a key that is not in the keyring")
+	ErrDecryptionFailed     = errors.New("the data could not be decrypted, it has been tampered with or the key is wrong")
+	ErrEncrypted            = errors.New("the data is encrypted but no encryption key has been provided")
+)
+
+// Keyring holds the AES-GCM keys used to encrypt the data at rest. The first key
+// encrypts new data and every key can decrypt, so a key is rotated by adding a new
+// first key and keeping the old ones until everything has been written again.
+type Keyring struct {
+	keys []encryptionKey
+}
+
+type encryptionKey struct {
+	id   [encryptionKeyIDSize]byte
+	aead cipher.AEAD
+}
+
+// LoadKeyring reads a keyring from a file if path is not empty or from the keys
+// in the environment variable env otherwise. It returns nil if neither is set.
+func LoadKeyring(path string, env string) (*Keyring, error) {
+	if path != "" {
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		return ParseKeyring(string(content))
+	}
+
+	if keys := os.Getenv(env); keys != "" {
+		return ParseKeyring(keys)
+	}
+
+	return nil, nil
+}
+
+// ParseKeyring parses keys separated by new lines or commas, each encoded in hex
+// or base64. The first key is the one used to encrypt.
+func ParseKeyring(text string) (*Keyring, error) {
+	keyring := &Keyring{}
+
+	fields := strings.FieldsFunc(text, func(r rune) bool {
+		return r == '\n' || r == '\r' || r == ','
+	})
+	for _, field := range fields {
+		field = strings.TrimSpace(field)
+		if field == "" || strings.HasPrefix(field, "#") {
+			continue
+		}
+
+		secret, err := decodeEncryptionKey(field)
+		if err != nil {
+			return nil, err
+		}
+
+		block, err := aes.NewCipher(secret)
+		if err != nil {
+			return nil, err
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, err
+		}
+
+		key := encryptionKey{aead: aead}
+		sum := sha256.Sum256(secret)
+		copy(key.id[:], sum[:])
+		keyring.keys = append(keyring.keys, key)
+	}
+
+	if len(keyring.keys) == 0 {
+		return nil, ErrNoEncryptionKey
+	}
+
+	return keyring, nil
+}
+
+func decodeEncryptionKey(encoded string) ([]byte, error) {
+	if secret, err := hex.DecodeString(encoded); err == nil && len(secret) == encryptionKeySize {
+		return secret, nil
+	}
+	if secret, err := base64.StdEncoding.DecodeString(encoded); err == nil && len(secret) == encryptionKeySize {
+		return secret, nil
+	}
+	return nil, ErrInvalidEncryptionKey
+}
+
+// Seal encrypts and authenticates plaintext and the additional data with the
+// first key. The result holds the key id, the nonce and the ciphertext.
+func (k *Keyring) Seal(plaintext []byte, additionalData []byte) ([]byte, error) {
+	key := k.keys[0]
+
+	nonceSize := key.aead.NonceSize()
+	sealed := make([]byte, encryptionKeyIDSize+nonceSize, encryptionKeyIDSize+nonceSize+len(plaintext)+key.aead.Overhead())
+	copy(sealed, key.id[:])
+
+	nonce := sealed[encryptionKeyIDSize:]
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+
+	return key.aead.Seal(sealed, nonce, plaintext, additionalData), nil
+}
+
+// Open decrypts and authenticates data sealed by Seal with any key of the keyring.
+func (k *Keyring) Open(sealed []byte, additionalData []byte) ([]byte, error) {
+	if len(sealed) < encryptionKeyIDSize {
+		return nil, ErrDecryptionFailed
+	}
+
+	for _, key := range k.keys {
+		if !bytes.Equal(key.id[:], sealed[:encryptionKeyIDSize]) {
+			continue
+		}
+
+		nonceSize := key.aead.NonceSize()
+		if len(sealed) < encryptionKeyIDSize+nonceSize {
+			return nil, ErrDecryptionFailed
+		}
+		nonce := sealed[encryptionKeyIDSize : encryptionKeyIDSize+nonceSize]
+
+		plaintext, err := key.aead.Open(nil, nonce, sealed[encryptionKeyIDSize+nonceSize:], additionalData)
+		if err != nil {
+			return nil, ErrDecryptionFailed
+		}
+		return plaintext, nil
+	}
+
+	return nil, fmt.Errorf("%w: key id %x", ErrUnknownEncryptionKey, sealed[:encryptionKeyIDSize])
+}
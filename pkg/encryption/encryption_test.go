@@ -0,0 +1,108 @@
+package encryption
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+const (
+	testEncryptionKey = "000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f"
+	testRotatedKey    = "1f1e1d1c1b1a191817161514131211100f0e0d0c0b0a09080706050403020100"
+)
+
+func newTestKeyring(t *testing.T, keys ...string) *Keyring {
+	t.Helper()
+
+	keyring, err := ParseKeyring(strings.Join(keys, "\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return keyring
+}
+
+func TestKeyring(t *testing.T) {
+	t.Run("should parse keys in hex and base64 ignoring comments", func(tt *testing.T) {
+		keyring, err := ParseKeyring("# current\n" + testEncryptionKey + ",AAECAwQFBgcICQoLDA0ODxAREhMUFRYXGBkaGxwdHh8=\n")
+		if err != nil {
+			tt.Fatal(err)
+		}
+		if len(keyring.keys) != 2 {
+			tt.Fatalf("expected 2 keys but got %d", len(keyring.keys))
+		}
+	})
+
+	t.Run("should reject an invalid key", func(tt *testing.T) {
+		if _, err := ParseKeyring("0001"); !errors.Is(err, ErrInvalidEncryptionKey) {
+			tt.Fatalf("expected ErrInvalidEncryptionKey but got '%v'", err)
+		}
+		if _, err := ParseKeyring("# no keys"); !errors.Is(err, ErrNoEncryptionKey) {
+			tt.Fatalf("expected ErrNoEncryptionKey but got '%v'", err)
+		}
+	})
+
+	t.Run("should load the keys from the environment if there is no file", func(tt *testing.T) {
+		tt.Setenv("CACHER_TEST_KEYS", testEncryptionKey)
+
+		keyring, err := LoadKeyring("", "CACHER_TEST_KEYS")
+		if err != nil || keyring == nil {
+			tt.Fatalf("expected a keyring but got '%v'", err)
+		}
+
+		if keyring, err := LoadKeyring("", "CACHER_TEST_NO_KEYS"); keyring != nil || err != nil {
+			tt.Fatalf("expected no keyring but got '%v'", err)
+		}
+	})
+
+	t.Run("should decrypt data encrypted with a rotated key", func(tt *testing.T) {
+		sealed, err := newTestKeyring(tt, testEncryptionKey).Seal([]byte("foo"), nil)
+		if err != nil {
+			tt.Fatal(err)
+		}
+
+		rotated := newTestKeyring(tt, testRotatedKey, testEncryptionKey)
+		plaintext, err := rotated.Open(sealed, nil)
+		if err != nil {
+			tt.Fatal(err)
+		}
+		if string(plaintext) != "foo" {
+			tt.Fatalf("expected 'foo' but got '%s'", plaintext)
+		}
+
+		resealed, err := rotated.Seal(plaintext, nil)
+		if err != nil {
+			tt.Fatal(err)
+		}
+		if _, err := newTestKeyring(tt, testRotatedKey).Open(resealed, nil); err != nil {
+			tt.Fatalf("expected new data to be encrypted with the first key but got '%s'", err)
+		}
+	})
+
+	t.Run("should fail to decrypt with an unknown key", func(tt *testing.T) {
+		sealed, err := newTestKeyring(tt, testEncryptionKey).Seal([]byte("foo"), nil)
+		if err != nil {
+			tt.Fatal(err)
+		}
+
+		if _, err := newTestKeyring(tt, testRotatedKey).Open(sealed, nil); !errors.Is(err, ErrUnknownEncryptionKey) {
+			tt.Fatalf("expected ErrUnknownEncryptionKey but got '%v'", err)
+		}
+	})
+
+	t.Run("should fail to decrypt tampered data", func(tt *testing.T) {
+		keyring := newTestKeyring(tt, testEncryptionKey)
+		sealed, err := keyring.Seal([]byte("foo"), []byte("bar"))
+		if err != nil {
+			tt.Fatal(err)
+		}
+
+		if _, err := keyring.Open(sealed, []byte("baz")); !errors.Is(err, ErrDecryptionFailed) {
+			tt.Fatalf("expected ErrDecryptionFailed but got '%v'", err)
+		}
+
+		sealed[len(sealed)-1] ^= 0xff
+		if _, err := keyring.Open(sealed, []byte("bar")); !errors.Is(err, ErrDecryptionFailed) {
+			tt.Fatalf("expected ErrDecryptionFailed but got '%v'", err)
+		}
+	})
+}